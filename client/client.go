@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/laonix/pow-word-of-wisdom/pow"
+	"github.com/laonix/pow-word-of-wisdom/protocol"
+	"github.com/laonix/pow-word-of-wisdom/tcp"
+)
+
+// FetchMany requests n quotes from the server.
+//
+// It negotiates batch mode (see protocol.CapabilityBatch), solves all the challenges with the parallel solver
+// (see pow.CalculateParallel), and submits their results at once.
+// If the server issues fewer challenges than requested, the remaining quotes are requested over the next connections,
+// so a server not supporting batch mode results in n sequential single fetches.
+// Connections are established by the dialer of the client (see Settings.Dialer), so they share its TLS and timeout.
+func (c *Client) FetchMany(ctx context.Context, addr string, n int) ([]string, error) {
+	quotes := make([]string, 0, n)
+	for len(quotes) < n {
+		batch, err := c.fetchBatch(ctx, addr, n-len(quotes))
+		if err != nil {
+			return quotes, err
+		}
+		quotes = append(quotes, batch...)
+	}
+
+	return quotes, nil
+}
+
+// fetchBatch requests up to count quotes over a single connection.
+func (c *Client) fetchBatch(ctx context.Context, addr string, count int) ([]string, error) {
+	netConn, err := c.dialer.Dial(ctx, addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial TCP: %w", err)
+	}
+	conn := tcp.NewConnWrapper(netConn)
+	defer conn.Close()
+
+	// once the context is done the connection is closed, so pending reads and writes are released
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-stop:
+		}
+	}()
+
+	quotes, err := exchange(ctx, conn, count)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return quotes, err
+}
+
+// exchange performs the PoW flow in batch mode or, if the server doesn't support it, in single mode.
+func exchange(ctx context.Context, conn tcp.Conn, count int) ([]string, error) {
+	request, err := json.Marshal(protocol.Request{
+		Capabilities: []protocol.Capability{protocol.CapabilityBatch},
+		Count:        count,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	if err := tcp.WriteFrame(conn, request); err != nil {
+		return nil, fmt.Errorf("send request: %w", err)
+	}
+
+	message, err := tcp.ReadFrame(conn)
+	if err != nil {
+		return nil, fmt.Errorf("read PoW challenge: %w", err)
+	}
+
+	batch, ok := protocol.ParseBatchChallenge(message)
+	if !ok {
+		// the server doesn't support batch mode, so the message is a single challenge header
+		quote, err := exchangeSingle(ctx, conn, string(message))
+		if err != nil {
+			return nil, err
+		}
+
+		return []string{quote}, nil
+	}
+
+	proofs := make([]string, 0, len(batch.Challenges))
+	for _, challenge := range batch.Challenges {
+		proof, err := solve(ctx, challenge)
+		if err != nil {
+			return nil, err
+		}
+		proofs = append(proofs, proof)
+	}
+
+	b, err := json.Marshal(protocol.BatchProof{Proofs: proofs})
+	if err != nil {
+		return nil, fmt.Errorf("marshal PoW results: %w", err)
+	}
+
+	if err := tcp.WriteFrame(conn, b); err != nil {
+		return nil, fmt.Errorf("send PoW results: %w", err)
+	}
+
+	message, err = tcp.ReadFrame(conn)
+	if err != nil {
+		return nil, fmt.Errorf("read quotes: %w", err)
+	}
+
+	response, ok := protocol.ParseBatchResponse(message)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response: %s", message)
+	}
+
+	return response.Quotes, nil
+}
+
+// exchangeSingle solves a single challenge, submits its result and returns a received quote.
+func exchangeSingle(ctx context.Context, conn tcp.Conn, challenge string) (string, error) {
+	proof, err := solve(ctx, challenge)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tcp.WriteFrame(conn, []byte(proof)); err != nil {
+		return "", fmt.Errorf("send PoW result: %w", err)
+	}
+
+	quote, err := tcp.ReadFrame(conn)
+	if err != nil {
+		return "", fmt.Errorf("read quote: %w", err)
+	}
+
+	return quoteOf(quote)
+}
+
+// solve calculates PoW result for a challenge unless the context is done.
+func solve(ctx context.Context, challenge string) (string, error) {
+	result, err := pow.CalculateParallelContext(ctx, challenge, pow.SolverSettings{})
+	if err != nil && ctx.Err() == nil {
+		return "", fmt.Errorf("calculate PoW result: %w", err)
+	}
+
+	return result, err
+}
@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/laonix/pow-word-of-wisdom/config"
+	"github.com/laonix/pow-word-of-wisdom/handler"
+	"github.com/laonix/pow-word-of-wisdom/handler/mocks"
+	"github.com/laonix/pow-word-of-wisdom/logger"
+	"github.com/laonix/pow-word-of-wisdom/pow"
+	"github.com/laonix/pow-word-of-wisdom/service"
+	"github.com/laonix/pow-word-of-wisdom/tcp"
+)
+
+// countingHandler counts served connections.
+type countingHandler struct {
+	tcp.Handler
+	conns int32
+}
+
+func (h *countingHandler) ServeTCP(ctx context.Context, conn tcp.Conn) {
+	atomic.AddInt32(&h.conns, 1)
+	h.Handler.ServeTCP(ctx, conn)
+}
+
+// startServer starts a loopback server performing the full PoW flow and returns its address.
+func startServer(t *testing.T, maxBatch int) (string, *countingHandler) {
+	return startQuoteServer(t, maxBatch, "random quote")
+}
+
+// startQuoteServer starts a loopback server performing the full PoW flow serving the quote and returns its address.
+func startQuoteServer(t *testing.T, maxBatch int, quote string) (string, *countingHandler) {
+	svc := mocks.NewWordOfWisdom(t)
+	svc.On("Quote").Return(quote, nil).Maybe()
+
+	return startServiceServer(t, maxBatch, svc)
+}
+
+// startServiceServer starts a loopback server performing the full PoW flow serving quotes of the service
+// and returns its address.
+func startServiceServer(t *testing.T, maxBatch int, svc service.WordOfWisdom) (string, *countingHandler) {
+	return startSettingsServer(t, maxBatch, svc, handler.WordOfWisdomSettings{})
+}
+
+// startSettingsServer starts a loopback server performing the full PoW flow serving quotes of the service
+// with the word of wisdom handler settings and returns its address.
+func startSettingsServer(t *testing.T, maxBatch int, svc service.WordOfWisdom,
+	wowSettings handler.WordOfWisdomSettings) (string, *countingHandler) {
+	settings := handler.ProofOfWorkSettings{
+		Challenge:  pow.Challenge,
+		Verify:     pow.Verify,
+		Complexity: 12,
+		WaitPOW:    1 * time.Minute,
+		MaxBatch:   maxBatch,
+	}
+
+	return startPOWServer(t, settings, svc, wowSettings, 0)
+}
+
+// startPOWServer starts a loopback server performing the full PoW flow with the settings, serving quotes
+// of the service, and accepting client messages up to maxMessageSize (tcp.DefaultMaxMessageSize if it's zero).
+// It returns the address of the server.
+func startPOWServer(t *testing.T, settings handler.ProofOfWorkSettings, svc service.WordOfWisdom,
+	wowSettings handler.WordOfWisdomSettings, maxMessageSize int) (string, *countingHandler) {
+	l, err := net.Listen(tcp.NetworkTcp, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	log := logger.NewNopLogger()
+
+	wordOfWisdom := handler.NewWordOfWisdomHandler(svc, wowSettings, log)
+	powHandler, err := handler.NewProofOfWork(wordOfWisdom, settings, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counting := &countingHandler{Handler: powHandler}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	server := tcp.NewServer(addr, counting, log)
+	server.MaxMessageSize = maxMessageSize
+
+	go func() {
+		_ = server.ListenAndServe(ctx)
+	}()
+
+	// wait for the server to start up
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial(tcp.NetworkTcp, addr)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	// the probing connection above doesn't count
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&counting.conns) == 1 }, time.Second, time.Millisecond)
+	atomic.StoreInt32(&counting.conns, 0)
+
+	return addr, counting
+}
+
+func TestFetchMany_batch(t *testing.T) {
+	addr, counting := startServer(t, 5)
+
+	quotes, err := NewClient(Settings{}, logger.NewNopLogger()).FetchMany(context.Background(), addr, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"random quote", "random quote", "random quote"}, quotes)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&counting.conns))
+}
+
+func TestFetchMany_batch_limited(t *testing.T) {
+	addr, counting := startServer(t, 2)
+
+	// the server issues up to 2 challenges per connection
+	quotes, err := NewClient(Settings{}, logger.NewNopLogger()).FetchMany(context.Background(), addr, 5)
+	assert.Nil(t, err)
+	assert.Len(t, quotes, 5)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&counting.conns))
+}
+
+func TestFetchMany_default_config(t *testing.T) {
+	var cfg config.ServerParameters
+	if err := env.Parse(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, cfg.Validate())
+
+	// a full batch of the results of challenges issued as the server does fits the default message size limit
+	algorithm := pow.AlgorithmOf(cfg.HashAlgorithm)
+	settings := handler.ProofOfWorkSettings{
+		Challenge: func(bits uint, resource string) (string, error) {
+			return pow.ChallengeWithAlgorithm(bits, resource, algorithm)
+		},
+		Verify:     pow.Verify,
+		Algorithm:  algorithm,
+		Complexity: 12,
+		WaitPOW:    1 * time.Minute,
+		MaxBatch:   cfg.MaxBatch,
+	}
+	svc := mocks.NewWordOfWisdom(t)
+	svc.On("Quote").Return("random quote", nil)
+	addr, counting := startPOWServer(t, settings, svc, handler.WordOfWisdomSettings{}, cfg.MaxMessageSize)
+
+	quotes, err := NewClient(Settings{}, logger.NewNopLogger()).FetchMany(context.Background(), addr, cfg.MaxBatch)
+	assert.Nil(t, err)
+	assert.Len(t, quotes, cfg.MaxBatch)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&counting.conns))
+}
+
+func TestFetchMany_dialer(t *testing.T) {
+	addr, _ := startServer(t, 2)
+
+	// every batch is fetched over a connection established by the dialer of the client
+	dialer := &countingDialer{}
+	quotes, err := NewClient(Settings{Dialer: dialer}, logger.NewNopLogger()).FetchMany(context.Background(), addr, 5)
+	assert.Nil(t, err)
+	assert.Len(t, quotes, 5)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&dialer.dials))
+}
+
+func TestFetchMany_no_batch(t *testing.T) {
+	addr, counting := startServer(t, 0)
+
+	quotes, err := NewClient(Settings{}, logger.NewNopLogger()).FetchMany(context.Background(), addr, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"random quote", "random quote", "random quote"}, quotes)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&counting.conns))
+}
+
+func TestFetchMany_context_cancelled(t *testing.T) {
+	addr, _ := startServer(t, 5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := NewClient(Settings{}, logger.NewNopLogger()).FetchMany(ctx, addr, 3)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestFetchMany_utf8(t *testing.T) {
+	quote := "Curiouser and curiouser! 🐇 — Алиса"
+
+	for _, maxBatch := range []int{0, 5} {
+		addr, _ := startQuoteServer(t, maxBatch, quote)
+
+		quotes, err := NewClient(Settings{}, logger.NewNopLogger()).FetchMany(context.Background(), addr, 2)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{quote, quote}, quotes, "max batch %d", maxBatch)
+	}
+}
@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/laonix/pow-word-of-wisdom/tcp"
+)
+
+// DefaultDialTimeout is the longest time NetDialer waits for a connection to be established,
+// unless its Timeout is set.
+const DefaultDialTimeout = 10 * time.Second
+
+// Dialer is a contract to connect to the server.
+type Dialer interface {
+	Dial(ctx context.Context, addr string) (net.Conn, error)
+}
+
+// NetDialer is an implementation of Dialer establishing TCP connections, optionally over TLS.
+type NetDialer struct {
+	// Timeout is the longest time to wait for a connection to be established (including TLS handshake);
+	// DefaultDialTimeout is used if it's not positive.
+	Timeout time.Duration
+	// TLSConfig enables TLS if it's set.
+	TLSConfig *tls.Config
+}
+
+// Dial connects to the address within Timeout, unless the context is done earlier.
+func (d *NetDialer) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDialTimeout
+	}
+
+	netDialer := &net.Dialer{Timeout: timeout}
+
+	if d.TLSConfig != nil {
+		tlsDialer := tls.Dialer{NetDialer: netDialer, Config: d.TLSConfig}
+		return tlsDialer.DialContext(ctx, tcp.NetworkTcp, addr)
+	}
+
+	return netDialer.DialContext(ctx, tcp.NetworkTcp, addr)
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/laonix/pow-word-of-wisdom/handler"
+	"github.com/laonix/pow-word-of-wisdom/handler/mocks"
+	"github.com/laonix/pow-word-of-wisdom/logger"
+	"github.com/laonix/pow-word-of-wisdom/tcp"
+)
+
+// countingDialer counts dialed connections.
+type countingDialer struct {
+	NetDialer
+	dials int32
+}
+
+func (d *countingDialer) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	atomic.AddInt32(&d.dials, 1)
+	return d.NetDialer.Dial(ctx, addr)
+}
+
+func TestNetDialer_Dial_timeout(t *testing.T) {
+	// the server accepts TCP connections but never responds to TLS handshake
+	l, err := net.Listen(tcp.NetworkTcp, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	d := &NetDialer{Timeout: 50 * time.Millisecond, TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+
+	start := time.Now()
+	_, err = d.Dial(context.Background(), l.Addr().String())
+	assert.NotNil(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestClient_Exchange_session(t *testing.T) {
+	svc := mocks.NewWordOfWisdom(t)
+	svc.On("Quote").Return("random quote", nil)
+
+	addr, counting := startSettingsServer(t, 0, svc, handler.WordOfWisdomSettings{MaxQuotesPerSession: 3})
+
+	dialer := &countingDialer{}
+	c := NewClient(Settings{Dialer: dialer, Session: true}, logger.NewNopLogger())
+	defer c.Close()
+
+	// the first three quotes are served over a single session, which the server ends then
+	for i := 0; i < 5; i++ {
+		quote, err := c.RequestQuote(context.Background(), addr)
+		assert.Nil(t, err)
+		assert.Equal(t, "random quote", quote)
+	}
+
+	assert.EqualValues(t, 2, atomic.LoadInt32(&dialer.dials))
+	assert.EqualValues(t, 2, atomic.LoadInt32(&counting.conns))
+	svc.AssertNumberOfCalls(t, "Quote", 5)
+}
+
+func TestClient_Exchange_no_session(t *testing.T) {
+	svc := mocks.NewWordOfWisdom(t)
+	svc.On("Quote").Return("random quote", nil)
+
+	addr, counting := startSettingsServer(t, 0, svc, handler.WordOfWisdomSettings{MaxQuotesPerSession: 3})
+
+	dialer := &countingDialer{}
+	c := NewClient(Settings{Dialer: dialer}, logger.NewNopLogger())
+
+	// every quote is served over a new connection unless session mode is enabled
+	for i := 0; i < 3; i++ {
+		_, err := c.RequestQuote(context.Background(), addr)
+		assert.Nil(t, err)
+	}
+
+	assert.EqualValues(t, 3, atomic.LoadInt32(&dialer.dials))
+	assert.EqualValues(t, 3, atomic.LoadInt32(&counting.conns))
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"sync"
+
+	"github.com/laonix/pow-word-of-wisdom/tcp"
+)
+
+// DefaultMaxIdleConns is the most idle session connections kept per server address,
+// unless Settings.MaxIdleConns is set.
+const DefaultMaxIdleConns = 1
+
+// connPool keeps idle connections of open sessions (see protocol.CapabilitySession) by server addresses for reuse.
+type connPool struct {
+	mu   sync.Mutex
+	max  int
+	idle map[string][]tcp.Conn
+}
+
+func newConnPool(max int) *connPool {
+	if max <= 0 {
+		max = DefaultMaxIdleConns
+	}
+
+	return &connPool{max: max, idle: make(map[string][]tcp.Conn)}
+}
+
+// get takes the most recently used idle connection to the address out of the pool.
+func (p *connPool) get(addr string) (tcp.Conn, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := p.idle[addr]
+	if len(conns) == 0 {
+		return nil, false
+	}
+
+	conn := conns[len(conns)-1]
+	p.idle[addr] = conns[:len(conns)-1]
+
+	return conn, true
+}
+
+// put returns a connection to the address to the pool.
+//
+// It returns false if the pool is full, so the caller should close the connection.
+func (p *connPool) put(addr string, conn tcp.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle[addr]) >= p.max {
+		return false
+	}
+
+	p.idle[addr] = append(p.idle[addr], conn)
+
+	return true
+}
+
+// drain takes all the idle connections out of the pool.
+func (p *connPool) drain() []tcp.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var conns []tcp.Conn
+	for addr, idle := range p.idle {
+		conns = append(conns, idle...)
+		delete(p.idle, addr)
+	}
+
+	return conns
+}
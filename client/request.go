@@ -0,0 +1,457 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"unicode/utf8"
+
+	"github.com/laonix/pow-word-of-wisdom/logger"
+	"github.com/laonix/pow-word-of-wisdom/pow"
+	"github.com/laonix/pow-word-of-wisdom/protocol"
+	"github.com/laonix/pow-word-of-wisdom/tcp"
+)
+
+// ErrInterrupted flags that the server has interrupted the flow while PoW result was being calculated.
+var ErrInterrupted = errors.New("interrupted by server")
+
+// ErrVersionMismatch flags that the server speaks another protocol version (see protocol.Version).
+var ErrVersionMismatch = errors.New("protocol version mismatch")
+
+// ServerError is an error reported by the server in a protocol.Response envelope.
+type ServerError struct {
+	// Code is a machine-readable code of the error.
+	Code protocol.ErrorCode
+	// Message is a human-readable message of the error.
+	Message string
+
+	// err classifies the error: it's either ErrInterrupted, ErrVersionMismatch or nil.
+	err error
+}
+
+// Error implements error interface.
+func (e *ServerError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("%s: %s (%s)", e.err, e.Message, e.Code)
+	}
+
+	return fmt.Sprintf("server error: %s (%s)", e.Message, e.Code)
+}
+
+// Unwrap returns the error classifying the server error, so it's matched with errors.Is.
+func (e *ServerError) Unwrap() error {
+	return e.err
+}
+
+// Settings holds Client settings.
+type Settings struct {
+	// Request is the initial request sent by RequestQuote: it declares client capabilities
+	// (e.g. protocol.CapabilityHex), an API key or the proposed difficulty.
+	// The protocol version, protocol.CapabilityHello and protocol.CapabilityEnvelope are always declared on top of it,
+	// and protocol.CapabilitySession is declared in session mode.
+	Request protocol.Request
+
+	// Calculate calculates PoW results for received challenges.
+	// If it's not set, pow.CalculateParallel with the default solver settings is used.
+	Calculate pow.CalculateFunc
+
+	// TLSConfig enables TLS if it's set. It's ignored if Dialer is set.
+	TLSConfig *tls.Config
+
+	// Dialer connects to the server. If it's not set, NetDialer with DefaultDialTimeout and TLSConfig is used.
+	Dialer Dialer
+
+	// Session flags to keep a connection open once a quote is received (see protocol.CapabilitySession),
+	// so the next quotes are requested over it without solving a challenge again, as long as the server keeps
+	// the session. Once the server ends the session, the next quote is requested over a new connection.
+	Session bool
+	// MaxIdleConns is the most open session connections kept for reuse per server address;
+	// DefaultMaxIdleConns is used if it's not positive.
+	MaxIdleConns int
+}
+
+// Client requests quotes from the server passing PoW verification.
+type Client struct {
+	request   protocol.Request
+	calculate pow.CalculateFunc
+	dialer    Dialer
+
+	session bool
+	pool    *connPool
+
+	log logger.Logger
+}
+
+// NewClient returns a new instance of Client.
+func NewClient(settings Settings, log logger.Logger) *Client {
+	c := &Client{
+		request:   settings.Request,
+		calculate: settings.Calculate,
+		dialer:    settings.Dialer,
+		session:   settings.Session,
+		pool:      newConnPool(settings.MaxIdleConns),
+		log:       log,
+	}
+
+	if c.dialer == nil {
+		c.dialer = &NetDialer{TLSConfig: settings.TLSConfig}
+	}
+
+	if c.calculate == nil {
+		c.calculate = func(challenge string) (string, error) {
+			return pow.CalculateParallel(challenge, pow.SolverSettings{})
+		}
+	}
+
+	return c
+}
+
+// RequestQuote connects to the server, solves a received challenge, submits its result and returns a received quote.
+//
+// The initial request is the one set in Settings; if the server responds with extras
+// (see protocol.QuoteResponse), only the quote is returned.
+// An error reported by the server is returned as *ServerError.
+// If the context is done before the quote is received, the connection is closed, and the context error is returned.
+func (c *Client) RequestQuote(ctx context.Context, serverAddr string) (string, error) {
+	message, err := c.Exchange(ctx, serverAddr, c.request)
+	if err != nil {
+		return "", err
+	}
+
+	if response, ok := protocol.ParseQuoteResponse([]byte(message)); ok {
+		return response.Quote, nil
+	}
+
+	return message, nil
+}
+
+// Exchange connects to the server and passes PoW verification to get a message with a quote,
+// which is either a plain quote or a JSON protocol.QuoteResponse, depending on the declared capabilities.
+//
+// If the request holds a PoW result calculated in advance, it's verified by the server right away.
+// Otherwise, the result is calculated for a challenge received from the server.
+// In session mode (see Settings.Session), the message is requested over an open session connection if there's one,
+// and the connection is kept open for the next request once the message is received.
+// An error reported by the server is returned as *ServerError.
+// If the context is done before the message is received, the connection is closed, and the context error is returned.
+func (c *Client) Exchange(ctx context.Context, serverAddr string, request protocol.Request) (string, error) {
+	// a PoW result calculated in advance is submitted over a new connection, since it's verified only at its start
+	if c.session && request.Proof == "" {
+		if conn, ok := c.pool.get(serverAddr); ok {
+			message, err := c.withContext(ctx, conn, func() (string, error) { return c.next(conn, request) })
+			if err == nil {
+				c.release(serverAddr, conn)
+				return message, nil
+			}
+
+			c.closeConn(conn)
+			if !errors.Is(err, errSessionOver) {
+				return "", err
+			}
+
+			c.log.Debug("session is over, reconnect", "server", serverAddr, "reason", err.Error())
+		}
+	}
+
+	// get connection with server
+	netConn, err := c.dialer.Dial(ctx, serverAddr)
+	if err != nil {
+		return "", fmt.Errorf("dial TCP: %w", err)
+	}
+	conn := tcp.NewConnWrapper(netConn)
+
+	message, err := c.withContext(ctx, conn, func() (string, error) { return c.exchange(ctx, conn, request) })
+	if err != nil || !c.session {
+		c.closeConn(conn)
+		return message, err
+	}
+
+	c.release(serverAddr, conn)
+
+	return message, nil
+}
+
+// Close closes the open session connections kept for reuse.
+func (c *Client) Close() {
+	for _, conn := range c.pool.drain() {
+		c.closeConn(conn)
+	}
+}
+
+// withContext runs an exchange over a connection, which is closed once the context is done,
+// so pending reads and writes are released. If the context is done, its error is returned.
+func (c *Client) withContext(ctx context.Context, conn tcp.Conn, exchange func() (string, error)) (string, error) {
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-stop:
+		}
+	}()
+
+	message, err := exchange()
+	if err != nil && ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	return message, err
+}
+
+// release keeps a session connection for reuse, or closes it if there are enough idle connections already.
+func (c *Client) release(serverAddr string, conn tcp.Conn) {
+	if !c.pool.put(serverAddr, conn) {
+		c.closeConn(conn)
+	}
+}
+
+// errSessionOver flags that the server has ended a session, so the connection cannot be reused.
+var errSessionOver = errors.New("session is over")
+
+// next requests the next quote of a session over an open connection.
+//
+// It fails with errSessionOver if the server has closed the connection or ended the session
+// (e.g. it has served the most quotes per session, or the session has timed out).
+func (c *Client) next(conn tcp.Conn, request protocol.Request) (string, error) {
+	next, err := json.Marshal(protocol.Request{Category: request.Category})
+	if err != nil {
+		return "", fmt.Errorf("marshal request: %w", err)
+	}
+
+	c.log.Info("request next quote", "server", tcp.RemoteAddr(conn), "message", string(next))
+
+	if err := tcp.WriteFrame(conn, next); err != nil {
+		return "", fmt.Errorf("%w: request next quote: %v", errSessionOver, err)
+	}
+
+	quote, err := c.read(conn)
+	var serverErr *ServerError
+	switch {
+	case errors.Is(err, tcp.ErrConnClosed):
+		return "", fmt.Errorf("%w: read quote: %v", errSessionOver, err)
+	case errors.As(err, &serverErr) &&
+		(serverErr.Code == protocol.CodeTimeout || serverErr.Code == protocol.CodeShuttingDown):
+		return "", fmt.Errorf("%w: %v", errSessionOver, err)
+	case err != nil:
+		return "", fmt.Errorf("read quote: %w", err)
+	}
+
+	return quoteOf(quote)
+}
+
+// exchange performs the PoW flow over an established connection.
+//
+// The client declares its protocol version and validates the one of the server (see protocol.Hello).
+// It also asks the server to wrap its messages in a protocol.Response, so errors are told apart from quotes.
+func (c *Client) exchange(ctx context.Context, conn tcp.Conn, request protocol.Request) (string, error) {
+	request.Version = protocol.Version
+	request.Capabilities = append(request.Capabilities[:len(request.Capabilities):len(request.Capabilities)],
+		protocol.CapabilityHello, protocol.CapabilityEnvelope)
+	if c.session {
+		request.Capabilities = append(request.Capabilities, protocol.CapabilitySession)
+	}
+
+	// send initial message to server to initiate interaction
+	initial, err := json.Marshal(request)
+	if err != nil {
+		return "", fmt.Errorf("marshal request: %w", err)
+	}
+
+	c.log.Info("ping server", "server", tcp.RemoteAddr(conn), "message", protocol.RedactedMessage(initial))
+
+	if err := tcp.WriteFrame(conn, initial); err != nil {
+		return "", fmt.Errorf("ping server: %w", err)
+	}
+
+	if request.Proof != "" {
+		// read a word of wisdom from server
+		quote, err := c.readAfterHello(conn)
+		if err != nil {
+			return "", fmt.Errorf("read quote: %w", err)
+		}
+
+		return quoteOf(quote)
+	}
+
+	// receive PoW challenge header from server
+	challenge, err := c.readAfterHello(conn)
+	if err != nil {
+		return "", fmt.Errorf("read PoW challenge: %w", err)
+	}
+
+	// an authenticated client might skip PoW, so it gets a quote right away
+	if request.APIKey != "" && !isChallenge(challenge) {
+		return quoteOf(challenge)
+	}
+
+	// a server supporting negotiation delivers the challenge along with the agreed difficulty
+	if negotiated, ok := protocol.ParseNegotiatedChallenge(challenge); ok {
+		c.log.Info("negotiated PoW difficulty", "proposed", request.Bits, "agreed", negotiated.Bits,
+			"accepted", negotiated.Accepted)
+		challenge = []byte(negotiated.Challenge)
+	}
+
+	c.log.Info("got PoW challenge", "challenge", string(challenge), "server", tcp.RemoteAddr(conn))
+
+	return c.submit(ctx, conn, string(challenge))
+}
+
+// readAfterHello validates the protocol version of the server greeting the client with protocol.Hello
+// and returns the message following it.
+//
+// A server predating Hello sends the message right away, so it's returned as is.
+// A server rejecting the client version makes it fail with ErrVersionMismatch.
+func (c *Client) readAfterHello(conn tcp.Conn) ([]byte, error) {
+	message, err := c.read(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	hello, ok := protocol.ParseHello(message)
+	if !ok {
+		return message, nil
+	}
+	if hello.Version != protocol.Version {
+		return nil, fmt.Errorf("%w: server speaks version %d, client speaks version %d",
+			ErrVersionMismatch, hello.Version, protocol.Version)
+	}
+
+	c.log.Debug("got hello", "version", hello.Version, "algorithm", hello.Algorithm)
+
+	return c.read(conn)
+}
+
+// read reads a message from the server and returns its payload.
+//
+// A message wrapped in a protocol.Response is unwrapped, and an error response makes it fail with *ServerError.
+// A server predating protocol.CapabilityEnvelope sends plain messages, so they're returned as is,
+// unless it's the rejection of the client version.
+func (c *Client) read(conn tcp.Conn) ([]byte, error) {
+	message, err := tcp.ReadFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	response, ok := protocol.ParseResponse(message)
+	if !ok {
+		if bytes.HasPrefix(message, []byte("unsupported protocol version")) {
+			return nil, fmt.Errorf("%w: %s", ErrVersionMismatch, message)
+		}
+
+		return message, nil
+	}
+
+	if response.Type != protocol.ResponseError {
+		return response.Payload(), nil
+	}
+
+	serverErr := &ServerError{Code: response.Code, Message: response.Message}
+	if response.Code == protocol.CodeUnsupportedVersion {
+		serverErr.err = ErrVersionMismatch
+	}
+
+	return nil, serverErr
+}
+
+// submit calculates PoW result for a challenge, sends it to the server and returns a received quote.
+func (c *Client) submit(ctx context.Context, conn tcp.Conn, challenge string) (string, error) {
+	type calcResult struct {
+		result string
+		err    error
+	}
+
+	type readResult struct {
+		message []byte
+		err     error
+	}
+
+	// start PoW result calculation
+	// the channel is buffered so the calculating goroutine never blocks on sending a result nobody waits for
+	powResChan := make(chan calcResult, 1)
+
+	go func() {
+		res, err := c.calculate(challenge)
+		powResChan <- calcResult{result: res, err: err}
+	}()
+
+	// the next message from server is read in background:
+	// it's either a quote (once PoW result is sent) or an internal error
+	// or context cancellation message (when calculation lasts longer than server waiting time)
+	messages := make(chan readResult, 1)
+
+	go func() {
+		message, err := c.read(conn)
+		messages <- readResult{message: message, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case res := <-powResChan: // waiting for PoW calculation result
+		if res.err != nil {
+			return "", fmt.Errorf("calculate PoW result: %w", res.err)
+		}
+
+		// send PoW calculation result to server
+		c.log.Info("PoW result calculated", "result", res.result)
+
+		if err := tcp.WriteFrame(conn, []byte(res.result)); err != nil {
+			return "", fmt.Errorf("send PoW result: %w", err)
+		}
+	case res := <-messages: // waiting for messages from server during PoW calculation
+		// an error reported during PoW calculation interrupts the flow
+		var serverErr *ServerError
+		if errors.As(res.err, &serverErr) {
+			serverErr.err = ErrInterrupted
+			return "", serverErr
+		}
+		if res.err != nil {
+			return "", fmt.Errorf("read while calculating PoW result: %w", res.err)
+		}
+
+		c.log.Info("got a message from server", "message", string(res.message))
+
+		// a message from server received during PoW calculation flags us to wrap up the flow as we are done here
+		return "", fmt.Errorf("%w: %s", ErrInterrupted, res.message)
+	}
+
+	// read a word of wisdom from server
+	res := <-messages
+	if res.err != nil {
+		return "", fmt.Errorf("read quote: %w", res.err)
+	}
+
+	return quoteOf(res.message)
+}
+
+func (c *Client) closeConn(conn tcp.Conn) {
+	c.log.Debug("close TCP connection")
+	if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		c.log.Error(err, "action", "close TCP connection")
+	}
+}
+
+// quoteOf returns a quote message received from server, which must be valid UTF-8.
+func quoteOf(message []byte) (string, error) {
+	if !utf8.Valid(message) {
+		return "", fmt.Errorf("quote is not valid UTF-8: %q", message)
+	}
+
+	return string(message), nil
+}
+
+// isChallenge checks if a message received from the server is a PoW challenge (rather than a quote).
+func isChallenge(message []byte) bool {
+	if _, ok := protocol.ParseNegotiatedChallenge(message); ok {
+		return true
+	}
+
+	_, err := pow.ParseHeaderString(string(message))
+	return err == nil
+}
@@ -0,0 +1,266 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/laonix/pow-word-of-wisdom/logger"
+	"github.com/laonix/pow-word-of-wisdom/protocol"
+	"github.com/laonix/pow-word-of-wisdom/service"
+	"github.com/laonix/pow-word-of-wisdom/tcp"
+)
+
+func TestClient_RequestQuote(t *testing.T) {
+	getter := service.NewFileGetter()
+	addr, counting := startServiceServer(t, 0, service.NewWordOfWisdomService(getter, service.MathRNG{}))
+
+	quotes := make(map[string]bool)
+	for _, id := range getter.GetIds() {
+		quotes[getter.Get(id)] = true
+	}
+
+	c := NewClient(Settings{}, logger.NewNopLogger())
+
+	quote, err := c.RequestQuote(context.Background(), addr)
+	assert.Nil(t, err)
+	assert.True(t, quotes[quote], "unknown quote %q", quote)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&counting.conns))
+}
+
+func TestClient_RequestQuote_long_quote(t *testing.T) {
+	// a quote is framed with its length, so it's received intact regardless of the reads it takes
+	quote := strings.Repeat("The only true wisdom is in knowing you know nothing. ", 50)
+	assert.Greater(t, len(quote), 2048)
+
+	addr, _ := startQuoteServer(t, 0, quote)
+
+	c := NewClient(Settings{}, logger.NewNopLogger())
+
+	got, err := c.RequestQuote(context.Background(), addr)
+	assert.Nil(t, err)
+	assert.Equal(t, quote, got)
+}
+
+func TestClient_RequestQuote_category(t *testing.T) {
+	getter := service.NewFileGetter()
+	addr, _ := startServiceServer(t, 0, service.NewWordOfWisdomService(getter, service.MathRNG{}))
+
+	categories := getter.Categories()
+	if !assert.NotEmpty(t, categories) {
+		return
+	}
+	category := categories[0]
+
+	quotes := make(map[string]bool)
+	for _, id := range getter.GetIdsByCategory(category) {
+		quotes[getter.Get(id)] = true
+	}
+
+	c := NewClient(Settings{Request: protocol.Request{Category: category}}, logger.NewNopLogger())
+
+	// every quote served is of the requested category
+	for i := 0; i < 5; i++ {
+		quote, err := c.RequestQuote(context.Background(), addr)
+		assert.Nil(t, err)
+		assert.True(t, quotes[quote], "quote %q is not of category %q", quote, category)
+	}
+
+	// a category the server doesn't serve is reported to the client
+	c = NewClient(Settings{Request: protocol.Request{Category: "no such category"}}, logger.NewNopLogger())
+
+	_, err := c.RequestQuote(context.Background(), addr)
+	var serverErr *ServerError
+	if assert.ErrorAs(t, err, &serverErr) {
+		assert.Equal(t, protocol.CodeUnknownCategory, serverErr.Code)
+	}
+}
+
+func TestClient_RequestQuote_server_error(t *testing.T) {
+	addr, _ := startServer(t, 0)
+
+	// a wrong result is reported as an error rather than delivered as a quote
+	c := NewClient(Settings{
+		Calculate: func(challenge string) (string, error) {
+			return challenge, nil
+		},
+	}, logger.NewNopLogger())
+
+	_, err := c.RequestQuote(context.Background(), addr)
+	var serverErr *ServerError
+	if assert.ErrorAs(t, err, &serverErr) {
+		assert.Equal(t, protocol.CodeVerificationFailed, serverErr.Code)
+		assert.Equal(t, "PoW verification failed", serverErr.Message)
+	}
+	assert.NotErrorIs(t, err, ErrInterrupted)
+}
+
+func TestClient_RequestQuote_interrupted(t *testing.T) {
+	l, err := net.Listen(tcp.NetworkTcp, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the server runs out of time while the client is calculating the result
+	go func() {
+		netConn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn := tcp.NewConnWrapper(netConn)
+		defer conn.Close()
+
+		_, _ = tcp.ReadFrame(conn)
+		for _, response := range []protocol.Response{
+			protocol.NewMessage(protocol.ResponseChallenge, "1:12:2208082121:resource::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="),
+			protocol.NewError(protocol.CodeTimeout, "context done"),
+		} {
+			b, _ := json.Marshal(response)
+			_ = tcp.WriteFrame(conn, b)
+		}
+	}()
+
+	over := make(chan struct{})
+	defer close(over)
+
+	c := NewClient(Settings{
+		Calculate: func(challenge string) (string, error) {
+			<-over
+			return "", errors.New("calculation aborted")
+		},
+	}, logger.NewNopLogger())
+
+	_, err = c.RequestQuote(context.Background(), l.Addr().String())
+	assert.ErrorIs(t, err, ErrInterrupted)
+
+	var serverErr *ServerError
+	if assert.ErrorAs(t, err, &serverErr) {
+		assert.Equal(t, protocol.CodeTimeout, serverErr.Code)
+	}
+}
+
+func TestClient_RequestQuote_capabilities(t *testing.T) {
+	addr, _ := startServer(t, 0)
+
+	// the declared capabilities are sent along with the protocol version, and the challenge is delivered hex-encoded
+	c := NewClient(Settings{
+		Request: protocol.Request{Capabilities: []protocol.Capability{protocol.CapabilityHex}},
+	}, logger.NewNopLogger())
+
+	quote, err := c.RequestQuote(context.Background(), addr)
+	assert.Nil(t, err)
+	assert.Equal(t, "random quote", quote)
+}
+
+func TestClient_RequestQuote_context_cancelled(t *testing.T) {
+	addr, _ := startServer(t, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the calculation lasts until the test is over, so only the context cancellation makes the client return
+	calculating, over := make(chan struct{}), make(chan struct{})
+	defer close(over)
+
+	c := NewClient(Settings{
+		Calculate: func(challenge string) (string, error) {
+			close(calculating)
+			<-over
+			return "", errors.New("calculation aborted")
+		},
+	}, logger.NewNopLogger())
+
+	go func() {
+		<-calculating
+		cancel()
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.RequestQuote(ctx, addr)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("RequestQuote hasn't returned on context cancellation")
+	}
+}
+
+func TestClient_RequestQuote_version_mismatch(t *testing.T) {
+	l, err := net.Listen(tcp.NetworkTcp, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the server greets the client with a version it doesn't speak
+	go func() {
+		netConn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn := tcp.NewConnWrapper(netConn)
+		defer conn.Close()
+
+		_, _ = tcp.ReadFrame(conn)
+		hello, _ := json.Marshal(protocol.Hello{Version: protocol.Version + 1, Algorithm: "sha256"})
+		_ = tcp.WriteFrame(conn, hello)
+	}()
+
+	c := NewClient(Settings{}, logger.NewNopLogger())
+
+	_, err = c.RequestQuote(context.Background(), l.Addr().String())
+	assert.ErrorIs(t, err, ErrVersionMismatch)
+}
+
+// printingLogger prints the messages logged with their key-value pairs.
+type printingLogger struct {
+	mu  sync.Mutex
+	out strings.Builder
+}
+
+func (l *printingLogger) print(msg string, kvs []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	fmt.Fprintln(&l.out, msg, kvs)
+}
+
+func (l *printingLogger) Debug(msg string, kvs ...any) { l.print(msg, kvs) }
+func (l *printingLogger) Info(msg string, kvs ...any)  { l.print(msg, kvs) }
+func (l *printingLogger) Warn(msg string, kvs ...any)  { l.print(msg, kvs) }
+func (l *printingLogger) Error(err error, kvs ...any)  { l.print(err.Error(), kvs) }
+
+func (l *printingLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.out.String()
+}
+
+func TestClient_RequestQuote_api_key_not_logged(t *testing.T) {
+	addr, _ := startServer(t, 0)
+
+	log := &printingLogger{}
+	c := NewClient(Settings{Request: protocol.Request{APIKey: "s3cr3t-key"}}, log)
+
+	_, err := c.RequestQuote(context.Background(), addr)
+	assert.Nil(t, err)
+
+	// the initial request is logged with the key redacted
+	assert.Contains(t, log.String(), protocol.RedactedAPIKey)
+	assert.NotContains(t, log.String(), "s3cr3t-key")
+}
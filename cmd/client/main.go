@@ -1,146 +1,173 @@
 package main
 
 import (
-	"math/rand"
-	"net"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
-	"time"
-
-	"github.com/caarlos0/env/v6"
 
+	"github.com/laonix/pow-word-of-wisdom/client"
 	"github.com/laonix/pow-word-of-wisdom/config"
 	"github.com/laonix/pow-word-of-wisdom/logger"
 	"github.com/laonix/pow-word-of-wisdom/pow"
+	"github.com/laonix/pow-word-of-wisdom/protocol"
 )
 
 func main() {
-	// client setup
-	cfg := initConfig()
-	rand.Seed(time.Now().UnixNano())
-
-	log := logger.NewZapLogger(logger.LevelOf(cfg.LoggingLevel))
-
-	log.Info("client settings", "server", cfg.ServerAddr)
-
-	// resolve server address
-	tcpAddr, err := net.ResolveTCPAddr("tcp", cfg.ServerAddr)
-	if err != nil {
-		log.Error(err, "action", "resolve TCP address")
-		os.Exit(1)
+	// a challenge is solved or a result is verified right away without connecting to the server
+	flags, err := parseOfflineFlags(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
-
-	// get connection with server
-	conn, err := net.DialTCP("tcp", nil, tcpAddr)
 	if err != nil {
-		log.Error(err, "action", "dial TCP")
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-
-	// send 'ping' message to server to initiate interaction
-	log.Info("ping server", "server", conn.RemoteAddr())
-
-	_, err = conn.Write([]byte("ping"))
-	if err != nil {
-		log.Error(err, "action", "ping server", "server", conn.RemoteAddr())
-		closeConn(conn, log)
-		os.Exit(1)
+	if flags.enabled() {
+		if err := runOffline(flags, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	// receive PoW challenge header from server
-	readBuffer := make([]byte, 1024)
-
-	n, err := conn.Read(readBuffer)
+	// client setup
+	cfg, err := initConfig()
 	if err != nil {
-		log.Error(err, "action", "read PoW challenge", "server", conn.RemoteAddr())
-		closeConn(conn, log)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	log.Info("got PoW challenge", "challenge", string(readBuffer[:n]), "server", conn.RemoteAddr())
-
-	// start PoW result calculation
-	powResChan := make(chan calcResult, 1)
-	var powResult string
-
-	go func() {
-		res, err := pow.Calculate(string(readBuffer[:n]))
-		powResChan <- calcResult{
-			result: res,
-			err:    err,
-		}
-	}()
-
-	// while client calculates PoW result it might receive internal error
-	// or context cancellation message (when calculation lasts longer than server waiting time) from server
-calcLoop:
-	for {
-		select {
-		case res := <-powResChan: // waiting for PoW calculation result
-			{
-				if res.err != nil {
-					log.Error(err, "action", "calculate PoW result")
-					closeConn(conn, log)
-					os.Exit(1)
-				}
+	log := logger.New(cfg.LoggingBackend, logger.LevelOf(cfg.LoggingLevel), logger.EncodingOf(cfg.LogEncoding))
 
-				powResult = res.result
+	log.Info("client settings", "server", cfg.ServerAddr, "quotes", cfg.Quotes, "next challenge", cfg.NextChallenge,
+		"difficulty token", cfg.DifficultyToken, "batch", cfg.Batch, "bits", cfg.Bits, "hex", cfg.Hex,
+		"solver workers", cfg.SolverWorkers, "solver lock OS thread", cfg.SolverLockOSThread, "tls", cfg.TLS)
 
-				// unset connection read deadline to proceed with the flow
-				if err := conn.SetReadDeadline(time.Time{}); err != nil {
-					log.Error(err, "action", "set connection read deadline")
+	solverSettings := pow.SolverSettings{
+		Workers:      cfg.SolverWorkers,
+		LockOSThread: cfg.SolverLockOSThread,
+	}
+	calculate := func(challenge string) (string, error) {
+		return pow.CalculateParallel(challenge, solverSettings)
+	}
+	// a single-threaded solver reports the work done, so it can be correlated with the challenge bits
+	if cfg.SolverWorkers == 1 {
+		calculate = func(challenge string) (string, error) {
+			result, iterations, err := pow.CalculateWithStats(challenge)
+			if err == nil {
+				if header, err := pow.ParseHeaderString(challenge); err == nil {
+					log.Info("PoW work done", "bits", header.Bits(), "iterations", iterations,
+						"expected attempts", pow.ExpectedAttempts(header.Bits()))
 				}
-
-				break calcLoop
 			}
-		default: // waiting for messages from server during PoW calculation
-			{
-				// to loop over we set a short read deadline to connection
-				if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
-					log.Error(err, "action", "set connection read deadline")
-				}
+			return result, err
+		}
+	}
 
-				n, err = conn.Read(readBuffer)
-				if err != nil {
-					// if the error is connected with reaching a read deadline we loop over
-					if os.IsTimeout(err) {
-						continue
-					}
+	dialer := &client.NetDialer{Timeout: cfg.DialTimeout}
+	if cfg.TLS {
+		tlsConfig, err := newTLSConfig(cfg)
+		if err != nil {
+			log.Error(err, "action", "set up TLS")
+			os.Exit(1)
+		}
 
-					log.Error(err, "action", "read while calculating PoW result", "server", conn.RemoteAddr())
-					closeConn(conn, log)
-					os.Exit(1)
-				}
+		dialer.TLSConfig = tlsConfig
+	}
+	quoteClient := client.NewClient(client.Settings{Calculate: calculate, Dialer: dialer, Session: cfg.Session}, log)
+	defer quoteClient.Close()
+
+	// in batch mode all the quotes are fetched at once
+	if cfg.Batch {
+		quotes, err := quoteClient.FetchMany(context.Background(), cfg.ServerAddr, cfg.Quotes)
+		for _, quote := range quotes {
+			log.Info("got a word of wisdom", "quote", quote)
+		}
+		if err != nil {
+			log.Error(err, "action", "fetch quotes")
+			os.Exit(1)
+		}
+		return
+	}
 
-				log.Info("got a message from server", "message", string(readBuffer[:n]))
+	request := protocol.Request{}
+	if cfg.NextChallenge {
+		request.Capabilities = append(request.Capabilities, protocol.CapabilityNextChallenge)
+	}
+	if cfg.DifficultyToken {
+		request.Capabilities = append(request.Capabilities, protocol.CapabilityDifficultyToken)
+	}
+	request.APIKey = cfg.APIKey
+	request.Category = cfg.Category
+	if cfg.Bits > 0 {
+		request.Capabilities = append(request.Capabilities, protocol.CapabilityNegotiation)
+		request.Bits = cfg.Bits
+	}
+	if cfg.Hex {
+		request.Capabilities = append(request.Capabilities, protocol.CapabilityHex)
+	}
 
-				// a message from server received during PoW calculation flags us to wrap up the flow as we are done here
-				closeConn(conn, log)
-				return
+	// a next challenge received along with a quote is solved in advance while we're done with the quote
+	var next string
+	var solvedInAdvance chan calcResult
+
+	for i := 0; i < cfg.Quotes; i++ {
+		req := request
+		if solvedInAdvance != nil {
+			res := <-solvedInAdvance
+			solvedInAdvance = nil
+
+			if res.err != nil {
+				log.Error(res.err, "action", "calculate PoW result in advance")
+			} else {
+				req.Challenge = next
+				req.Proof = res.result
 			}
 		}
-	}
 
-	// send PoW calculation result to server
-	log.Info("PoW result calculated", "result", powResult)
+		message, err := quoteClient.Exchange(context.Background(), cfg.ServerAddr, req)
+		var serverErr *client.ServerError
+		if req.Proof != "" && errors.As(err, &serverErr) {
+			// the result calculated in advance is rejected, so we start over with a fresh challenge
+			log.Warn("PoW result calculated in advance rejected", "code", serverErr.Code, "message", serverErr.Message)
+			i--
+			continue
+		}
+		if err != nil {
+			log.Error(err, "action", "request quote")
+			os.Exit(1)
+		}
 
-	_, err = conn.Write([]byte(powResult))
-	if err != nil {
-		log.Error(err, "action", "send PoW result", "server", conn.RemoteAddr())
-		closeConn(conn, log)
-		os.Exit(1)
-	}
+		response, ok := protocol.ParseQuoteResponse([]byte(message))
+		if !ok {
+			log.Info("got a word of wisdom", "quote", message)
+			continue
+		}
 
-	// read a word of wisdom from server
-	n, err = conn.Read(readBuffer)
-	if err != nil {
-		log.Error(err, "action", "read quote", "server", conn.RemoteAddr())
-		closeConn(conn, log)
-		os.Exit(1)
-	}
+		log.Info("got a word of wisdom", "quote", response.Quote)
+
+		// a difficulty token is presented on the next request to get a reduced-difficulty challenge
+		request.Token = response.Token
 
-	log.Info("got a word of wisdom", "quote", string(readBuffer[:n]))
+		if response.NextChallenge != "" && i+1 < cfg.Quotes {
+			log.Info("got next PoW challenge", "challenge", response.NextChallenge)
 
-	closeConn(conn, log)
+			next = response.NextChallenge
+			solvedInAdvance = make(chan calcResult, 1)
+
+			go func(challenge string, c chan calcResult) {
+				res, err := calculate(challenge)
+				c <- calcResult{
+					result: res,
+					err:    err,
+				}
+			}(next, solvedInAdvance)
+		}
+	}
 }
 
 type calcResult struct {
@@ -148,18 +175,39 @@ type calcResult struct {
 	err    error
 }
 
-func initConfig() *config.ClientParameters {
-	params := config.ClientParameters{}
-	if err := env.Parse(&params); err != nil {
-		panic(err)
+// newTLSConfig returns a client TLS config trusting a CA certificate from the configured file,
+// or the system CA certificates if there's none.
+func newTLSConfig(cfg *config.ClientParameters) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: cfg.TLSServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cfg.TLSCAFile != "" {
+		ca, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA certificate: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no CA certificates found in %s", cfg.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
 	}
 
-	return &params
+	return tlsConfig, nil
 }
 
-func closeConn(conn net.Conn, log logger.Logger) {
-	log.Debug("close TCP connection")
-	if err := conn.Close(); err != nil {
-		log.Error(err, "action", "close TCP connection")
+// initConfig parses the settings from the environment and the CONFIG_FILE file, if it's set, and validates them.
+func initConfig() (*config.ClientParameters, error) {
+	params, err := config.LoadClientConfig(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	if err := params.Validate(); err != nil {
+		return nil, err
 	}
+
+	return params, nil
 }
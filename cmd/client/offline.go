@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/laonix/pow-word-of-wisdom/pow"
+)
+
+// errInvalidResult flags that a PoW result doesn't satisfy its challenge.
+var errInvalidResult = errors.New("PoW result is not valid")
+
+// offlineFlags holds the command line flags of the offline modes, which don't connect to the server:
+// solving a challenge and verifying a PoW result.
+type offlineFlags struct {
+	solve     string
+	challenge string
+	result    string
+}
+
+// parseOfflineFlags parses the command line arguments of the offline modes.
+func parseOfflineFlags(args []string, output io.Writer) (offlineFlags, error) {
+	var flags offlineFlags
+
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&flags.solve, "solve", "", "solve a PoW challenge header, print the result and exit")
+	fs.StringVar(&flags.challenge, "verify", "", "verify a PoW result (see -result) against a challenge header and exit")
+	fs.StringVar(&flags.result, "result", "", "PoW result header to verify")
+
+	if err := fs.Parse(args); err != nil {
+		return offlineFlags{}, err
+	}
+	if flags.challenge != "" && flags.result == "" {
+		return offlineFlags{}, errors.New("-verify requires -result")
+	}
+
+	return flags, nil
+}
+
+// enabled checks if an offline mode has been requested.
+func (f offlineFlags) enabled() bool {
+	return f.solve != "" || f.challenge != ""
+}
+
+// runOffline runs the requested offline mode, writing its outcome to the output.
+func runOffline(flags offlineFlags, output io.Writer) error {
+	if flags.solve != "" {
+		return solve(flags.solve, output)
+	}
+
+	return verify(flags.challenge, flags.result, output)
+}
+
+// solve calculates a PoW result for a challenge, verifies it and writes it to the output.
+func solve(challenge string, output io.Writer) error {
+	result, err := pow.Calculate(challenge)
+	if err != nil {
+		return fmt.Errorf("calculate PoW result: %w", err)
+	}
+
+	// the result is verified as well, so solving and verifying implementations are checked against each other
+	if err := verify(challenge, result, io.Discard); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(output, result)
+	return err
+}
+
+// verify checks if a PoW result satisfies a challenge and writes the outcome to the output.
+func verify(challenge, result string, output io.Writer) error {
+	ok, err := pow.Verify(result, challenge)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidResult, err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %v", errInvalidResult, pow.ErrInsufficientBits)
+	}
+
+	_, err = fmt.Fprintln(output, "PoW result is valid")
+	return err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// knownChallenge is a challenge of 12 bits, so it is solved in a moment.
+const knownChallenge = "1:12:2208082121:resource::cmFuZG9tMTIzNA==:MA=="
+
+func TestRunOffline_solve_and_verify(t *testing.T) {
+	flags, err := parseOfflineFlags([]string{"-solve", knownChallenge}, &bytes.Buffer{})
+	if !assert.Nil(t, err) || !assert.True(t, flags.enabled()) {
+		return
+	}
+
+	var solved bytes.Buffer
+	if !assert.Nil(t, runOffline(flags, &solved)) {
+		return
+	}
+	result := strings.TrimSpace(solved.String())
+
+	flags, err = parseOfflineFlags([]string{"-verify", knownChallenge, "-result", result}, &bytes.Buffer{})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	var verified bytes.Buffer
+	assert.Nil(t, runOffline(flags, &verified))
+	assert.Equal(t, "PoW result is valid\n", verified.String())
+}
+
+func TestRunOffline_verify_invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		result string
+	}{
+		{name: "challenge as is", result: knownChallenge},
+		{name: "another challenge", result: "1:12:2208082121:another::cmFuZG9tMTIzNA==:MA=="},
+		{name: "malformed", result: "result"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			err := runOffline(offlineFlags{challenge: knownChallenge, result: test.result}, &output)
+			assert.ErrorIs(t, err, errInvalidResult)
+			assert.Empty(t, output.String())
+		})
+	}
+}
+
+func TestParseOfflineFlags(t *testing.T) {
+	flags, err := parseOfflineFlags(nil, &bytes.Buffer{})
+	assert.Nil(t, err)
+	assert.False(t, flags.enabled())
+
+	_, err = parseOfflineFlags([]string{"-verify", knownChallenge}, &bytes.Buffer{})
+	assert.NotNil(t, err)
+
+	_, err = parseOfflineFlags([]string{"-unknown"}, &bytes.Buffer{})
+	assert.NotNil(t, err)
+}
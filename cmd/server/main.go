@@ -2,17 +2,27 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
 	"math/rand"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/caarlos0/env/v6"
+	_ "github.com/lib/pq"
 
 	"github.com/laonix/pow-word-of-wisdom/config"
+	"github.com/laonix/pow-word-of-wisdom/control"
 	"github.com/laonix/pow-word-of-wisdom/handler"
+	"github.com/laonix/pow-word-of-wisdom/httpapi"
 	"github.com/laonix/pow-word-of-wisdom/logger"
+	"github.com/laonix/pow-word-of-wisdom/metrics"
 	"github.com/laonix/pow-word-of-wisdom/pow"
 	"github.com/laonix/pow-word-of-wisdom/service"
 	"github.com/laonix/pow-word-of-wisdom/tcp"
@@ -20,32 +30,347 @@ import (
 
 func main() {
 	// sever setup
-	cfg := initConfig()
+	cfg, err := initConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// the global source picks challenge bits, while quotes are picked and pow seeds with their own sources
 	rand.Seed(time.Now().UnixNano())
 
-	log := logger.NewZapLogger(logger.LevelOf(cfg.LoggingLevel))
+	log := logger.New(cfg.LoggingBackend, logger.LevelOf(cfg.LoggingLevel), logger.EncodingOf(cfg.LogEncoding))
 
 	// initiate a word of wisdom handler
-	quoteGetter := service.NewFileGetter()
-	wordOfWisdomSrv := service.NewWordOfWisdomService(quoteGetter)
+	var quoteGetter service.Getter = service.NewFileGetter()
+	var fileGetter *service.ReloadableFileGetter
+	var quotesDB *sql.DB
+
+	// quotes are embedded, unless they're read from a file on the filesystem, fetched from a remote corpus,
+	// or queried from a database
+	switch {
+	case cfg.QuotesFile != "":
+		// quotes read from a file on the filesystem are reloaded on SIGHUP
+		fileGetter, err = service.NewReloadableFileGetter(cfg.QuotesFile)
+		if err != nil {
+			log.Error(err, "action", "load quotes file")
+			os.Exit(1)
+		}
+		quoteGetter = fileGetter
+	case cfg.QuotesURL != "":
+		httpGetter, err := service.NewHTTPGetter(cfg.QuotesURL, cfg.QuotesURLTimeout)
+		if err != nil {
+			log.Warn("fall back to embedded quotes", "err", err, "url", cfg.QuotesURL)
+		}
+		quoteGetter = httpGetter
+	case cfg.QuotesDB != "":
+		quotesDB, err = sql.Open("postgres", cfg.QuotesDB)
+		if err != nil {
+			log.Error(err, "action", "open quotes database")
+			os.Exit(1)
+		}
+		sqlGetter, err := service.NewSQLGetter(quotesDB, service.SQLSettings{
+			Table:       cfg.QuotesDBTable,
+			Placeholder: "$1",
+			OnError: func(err error) {
+				log.Error(err, "action", "query quotes")
+			},
+		})
+		if err != nil {
+			log.Error(err, "action", "prepare quotes queries")
+			os.Exit(1)
+		}
+		// the table is not queried on every request: quotes and their ids are cached,
+		// while the ids are listed again once QUOTES_DB_REFRESH has passed (see service.CachedGetter)
+		quoteGetter = service.NewCachedGetter(sqlGetter, service.CacheSettings{
+			TTL:             cfg.QuotesDBCacheTTL,
+			RefreshInterval: cfg.QuotesDBRefresh,
+		})
+	}
+
+	// quotes are normalized, and invalid ones are skipped at startup (and on every reload of a quotes file)
+	if cfg.QuoteFilter {
+		filter := service.QuoteFilter{
+			MaxLength: cfg.QuoteFilterMaxLength,
+			OnSkip: func(id, reason string) {
+				log.Warn("quote skipped", "id", id, "reason", reason)
+			},
+		}
+		if g, ok := quoteGetter.(interface{ Filter(service.QuoteFilter) }); ok {
+			g.Filter(filter)
+		}
+	}
+
+	// the server keeps running with no quotes, so a quotes file might be fixed and reloaded,
+	// but clients are told there are no quotes available meanwhile
+	if len(quoteGetter.GetIds()) == 0 {
+		log.Warn("quote corpus is empty", "file", cfg.QuotesFile, "url", cfg.QuotesURL)
+	}
+
+	wordOfWisdomSrv := service.NewWordOfWisdomService(quoteGetter, service.RNGOf(cfg.QuoteRNG))
+	wordOfWisdomSrv.NoRepeat = cfg.QuoteNoRepeat
+
+	// served quotes are counted for the control socket "stats" command and published as an exported variable
+	quotes := metrics.NewCounter()
+	expvar.Publish("quotes_total", quotes)
+
+	wordOfWisdomSettings := handler.WordOfWisdomSettings{
+		FailurePolicy: handler.FailurePolicyOf(cfg.QuoteFailurePolicy),
+		FallbackQuote: cfg.FallbackQuote,
+		MaxQuoteSize:  cfg.QuoteMaxSize,
+		QuoteTimeout:  cfg.QuoteTimeout,
+		Quotes:        quotes,
+
+		MaxQuotesPerSession: cfg.MaxQuotesPerSession,
+	}
+	wordOfWisdomHandler := handler.NewWordOfWisdomHandler(wordOfWisdomSrv, wordOfWisdomSettings, log)
+
+	// issued challenge bits are published as an exported variable (see expvar)
+	bits := metrics.NewHistogram()
+	expvar.Publish("challenge_bits", bits)
+
+	// passed and failed PoW verifications totals are persisted across restarts, if a state file is set
+	served, failures := metrics.NewCounter(), metrics.NewCounter()
+	var state *metrics.State
+	if cfg.MetricsStateFile != "" {
+		state = metrics.NewState(cfg.MetricsStateFile)
+		served, failures = state.Counter("served"), state.Counter("failures")
+		if err := state.Load(); err != nil {
+			log.Error(err, "action", "load metrics state")
+		}
+	}
+	expvar.Publish("served_total", served)
+	expvar.Publish("failures_total", failures)
 
-	wordOfWisdomHandler := handler.NewWordOfWisdomHandler(wordOfWisdomSrv, log)
+	// connections and PoW outcomes are collected for Prometheus, if its listener is set
+	var prom *metrics.Prometheus
+	if cfg.PrometheusAddr != "" {
+		prom = metrics.NewPrometheus()
+	}
 
 	// initiate a PoW handler
+	algorithm := pow.AlgorithmOf(cfg.HashAlgorithm)
 	settings := handler.ProofOfWorkSettings{
-		Challenge:  pow.Challenge,
-		Verify:     pow.Verify,
-		Complexity: cfg.Complexity,
-		WaitPOW:    cfg.WaitPOW,
+		Challenge: func(bits uint, resource string) (string, error) {
+			return pow.ChallengeWithAlgorithm(bits, resource, algorithm)
+		},
+		VerifyReason: pow.VerifyReason,
+		Algorithm:    algorithm,
+		Complexity:   cfg.Complexity,
+		MinBits:      cfg.MinBits,
+		WaitPOW:      cfg.WaitPOW,
+		ChallengeTTL: cfg.ChallengeTTL,
+		WaitQuote:    cfg.WaitQuote,
+
+		IssueNextChallenge: cfg.IssueNextChallenge,
+		MaxBatch:           cfg.MaxBatch,
+		VerifyBudget:       cfg.VerifyBudget,
+		MinNegotiatedBits:  cfg.MinNegotiatedBits,
+		EstimateHashRate:   cfg.EstimateHashRate,
+		ChallengeHint:      cfg.ChallengeHint,
+
+		RateLimit: cfg.RateLimit,
+		RateBurst: cfg.RateBurst,
+
+		TokenSecret:     []byte(cfg.TokenSecret),
+		TokenTTL:        cfg.TokenTTL,
+		TokenComplexity: cfg.TokenComplexity,
+
+		Bits:       bits,
+		Served:     served,
+		Failures:   failures,
+		Prometheus: prom,
+	}
+	if len(cfg.APIKeys) > 0 {
+		settings.Authenticator = handler.NewAPIKeyAuthenticator(cfg.APIKeys)
+		settings.AuthenticatedReduced = cfg.AuthenticatedReduced
+	}
+	if cfg.ChallengeResource == "remote-ip" {
+		settings.Resource = handler.RemoteIPResource
+	}
+	if cfg.Catalog {
+		settings.Categories = wordOfWisdomSrv.Categories
+		settings.CatalogAfterPoW = cfg.CatalogAfterPoW
+	}
+	if cfg.AdaptiveSaturation > 0 {
+		settings.Load = handler.NewConnectionRate(cfg.AdaptiveWindow, cfg.AdaptiveSaturation)
+		settings.WarmUp = cfg.AdaptiveWarmUp
+	}
+	// accepted PoW results are audited to a dedicated file, separately from operational logs
+	if cfg.AuditLog != "" {
+		auditLog, err := os.OpenFile(cfg.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			log.Error(err, "action", "open audit log")
+			os.Exit(1)
+		}
+		defer auditLog.Close()
+
+		settings.Auditor = handler.NewWriterAuditor(auditLog, handler.AuditFormatOf(cfg.AuditFormat))
+	}
+	powHandler, err := handler.NewProofOfWork(wordOfWisdomHandler, settings, log)
+	if err != nil {
+		log.Error(err, "action", "create PoW handler")
+		os.Exit(1)
+	}
+
+	// SIGUSR1 cycles the logging level (error, warn, info, debug), so it's raised without a restart
+	// SIGHUP reloads the quotes file (if it's set) and re-reads the configuration to apply its mutable settings
+	// (the logging level and the PoW difficulty), while connections are kept
+	var usr1 chan os.Signal
+	setter, canSetLevel := log.(logger.LevelSetter)
+	if canSetLevel {
+		usr1 = make(chan os.Signal, 1)
+		signal.Notify(usr1, syscall.SIGUSR1)
 	}
-	powHandler := handler.NewProofOfWork(wordOfWisdomHandler, settings, log)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		level := logger.LevelOf(cfg.LoggingLevel)
+		for {
+			select {
+			case <-usr1:
+				level = (level + 1) % (logger.LevelDebug + 1)
+				setter.SetLevel(level)
+				log.Warn("logging level changed", "level", level)
+			case <-hup:
+				if fileGetter != nil {
+					if err := fileGetter.Reload(); err != nil {
+						log.Error(err, "action", "reload quotes file")
+					} else {
+						log.Info("quotes file reloaded", "path", cfg.QuotesFile)
+					}
+				}
+
+				reloaded, err := initConfig()
+				if err != nil {
+					log.Error(err, "action", "reload config")
+					continue
+				}
+				if err := reload(reloaded, log, powHandler); err != nil {
+					log.Error(err, "action", "reload config")
+					continue
+				}
+				level = logger.LevelOf(reloaded.LoggingLevel)
+				log.Info("config reloaded", "logging level", reloaded.LoggingLevel,
+					"complexity", reloaded.Complexity, "min bits", reloaded.MinBits)
+			}
+		}
+	}()
 
 	// initiate TCP server
 	tcpServer := tcp.NewServer(cfg.TCPAddr, powHandler, log)
+	for _, addr := range cfg.ExtraTCPAddrs {
+		tcpServer.AddListener(addr)
+	}
+	tcpServer.Network = cfg.TCPNetwork
+	tcpServer.MaxMessageSize = cfg.MaxMessageSize
+	tcpServer.ReadTimeout = cfg.ReadTimeout
+	tcpServer.WriteTimeout = cfg.WriteTimeout
+	tcpServer.MaxConcurrentConns = cfg.MaxConcurrentConns
+	tcpServer.Prometheus = prom
+	tcpServer.ProxyProtocol = cfg.ProxyProtocol
+
+	if len(cfg.AllowedIPs) > 0 || len(cfg.DeniedIPs) > 0 {
+		ipFilter, err := tcp.NewIPFilter(cfg.AllowedIPs, cfg.DeniedIPs)
+		if err != nil {
+			log.Error(err, "action", "create IP filter")
+			os.Exit(1)
+		}
+
+		tcpServer.IPFilter = ipFilter
+		tcpServer.DeniedMessage = cfg.DeniedMessage
+	}
+
+	if cfg.TLSCertFile != "" && cfg.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			log.Error(err, "action", "load TLS certificate")
+			os.Exit(1)
+		}
+
+		tcpServer.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
 
 	// create cancelling context to handle a graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 
+	// start metrics HTTP server: exported variables are served at /debug/vars
+	if cfg.MetricsAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(cfg.MetricsAddr, nil); err != nil {
+				log.Error(err, "action", "metrics listen and serve")
+			}
+		}()
+	}
+
+	// start Prometheus metrics HTTP server on a separate listener
+	if prom != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", prom.Handler())
+
+		go func() {
+			if err := http.ListenAndServe(cfg.PrometheusAddr, mux); err != nil {
+				log.Error(err, "action", "prometheus metrics listen and serve")
+			}
+		}()
+	}
+
+	// start PoW HTTP API server, challenges are valid for the time a TCP client is waited for unless they expire earlier
+	var apiServer *http.Server
+	if cfg.HTTPAPIAddr != "" {
+		challengeTTL := cfg.ChallengeTTL
+		if challengeTTL == 0 {
+			challengeTTL = cfg.WaitPOW
+		}
+		apiHandler, err := httpapi.NewHandler(wordOfWisdomSrv, httpapi.Settings{
+			Challenge:     settings.Challenge,
+			VerifyReason:  settings.VerifyReason,
+			Complexity:    cfg.Complexity,
+			MinBits:       cfg.MinBits,
+			ChallengeTTL:  challengeTTL,
+			MaxChallenges: cfg.HTTPAPIMaxChallenges,
+		}, log)
+		if err != nil {
+			log.Error(err, "action", "create HTTP API handler")
+			os.Exit(1)
+		}
+
+		apiServer = &http.Server{Addr: cfg.HTTPAPIAddr, Handler: apiHandler, ReadHeaderTimeout: cfg.ReadTimeout}
+		go func() {
+			if err := apiServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Error(err, "action", "HTTP API listen and serve")
+			}
+		}()
+	}
+
+	// start the control socket serving admin commands, it's meant to be reached locally only
+	var controlListener net.Listener
+	if cfg.Control {
+		controlListener, err = net.Listen(cfg.ControlNetwork, cfg.ControlAddr)
+		if err != nil {
+			log.Error(err, "action", "listen control socket")
+			os.Exit(1)
+		}
+
+		controlSettings := control.Settings{
+			Difficulty:  powHandler,
+			ActiveConns: tcpServer.ActiveConns,
+			Quotes:      quotes,
+		}
+		if canSetLevel {
+			controlSettings.Level = setter
+		}
+
+		controlHandler := control.NewHandler(controlSettings, log)
+		go func() {
+			if err := controlHandler.Serve(controlListener); err != nil {
+				log.Error(err, "action", "control serve")
+			}
+		}()
+	}
+
 	// start TCP server
 	go func() {
 		if err := tcpServer.ListenAndServe(ctx); err != nil {
@@ -53,24 +378,87 @@ func main() {
 		}
 	}()
 
-	log.Info("server settings", "complexity", cfg.Complexity, "wait PoW duration", cfg.WaitPOW)
+	log.Info("server settings", "complexity", cfg.Complexity, "hash algorithm", algorithm, "challenge resource", cfg.ChallengeResource, "wait PoW duration", cfg.WaitPOW, "challenge TTL", cfg.ChallengeTTL,
+		"wait quote duration", cfg.WaitQuote,
+		"adaptive saturation", cfg.AdaptiveSaturation, "adaptive window", cfg.AdaptiveWindow,
+		"adaptive warm-up", cfg.AdaptiveWarmUp,
+		"min negotiated bits", cfg.MinNegotiatedBits, "estimate hash rate", cfg.EstimateHashRate, "challenge hint", cfg.ChallengeHint,
+		"metrics address", cfg.MetricsAddr, "HTTP API address", cfg.HTTPAPIAddr,
+		"control", cfg.Control, "control network", cfg.ControlNetwork, "control address", cfg.ControlAddr, "metrics state file", cfg.MetricsStateFile,
+		"audit log", cfg.AuditLog, "audit format", cfg.AuditFormat, "extra TCP addresses", cfg.ExtraTCPAddrs,
+		"issue next challenge", cfg.IssueNextChallenge, "max batch", cfg.MaxBatch, "verify budget", cfg.VerifyBudget,
+		"API keys", len(cfg.APIKeys), "authenticated reduced", cfg.AuthenticatedReduced,
+		"catalog", cfg.Catalog, "catalog after PoW", cfg.CatalogAfterPoW,
+		"difficulty tokens", cfg.TokenSecret != "", "token TTL", cfg.TokenTTL, "token complexity", cfg.TokenComplexity,
+		"quote no repeat", cfg.QuoteNoRepeat, "quote max size", cfg.QuoteMaxSize, "quote timeout", cfg.QuoteTimeout, "quote filter", cfg.QuoteFilter, "quote filter max length", cfg.QuoteFilterMaxLength, "max quotes per session", cfg.MaxQuotesPerSession, "quotes file", cfg.QuotesFile, "quotes URL", cfg.QuotesURL, "quotes DB table", cfg.QuotesDBTable, "shutdown grace period", cfg.ShutdownGrace,
+		"read timeout", cfg.ReadTimeout, "write timeout", cfg.WriteTimeout,
+		"max concurrent connections", cfg.MaxConcurrentConns,
+		"rate limit", cfg.RateLimit, "rate burst", cfg.RateBurst)
 
 	// start listening for external signals to handle a server graceful shutdown
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 
 	log.Info("received system interruption", "signal", <-c)
+
+	if controlListener != nil {
+		if err := controlListener.Close(); err != nil {
+			log.Error(err, "action", "close control socket")
+		}
+	}
+
+	// stop issuing new challenges and let clients which have already received one complete the flow
+	graceCtx, graceCancel := context.WithTimeout(context.Background(), cfg.ShutdownGrace)
+	if err := powHandler.Drain(graceCtx); err != nil {
+		log.Warn("grace period is over with PoW flows in flight", "err", err)
+	}
+
+	// stop accepting connections and wait for the ones being served
+	if err := tcpServer.Shutdown(graceCtx); err != nil {
+		log.Warn("grace period is over with connections in flight", "err", err)
+	}
+	if apiServer != nil {
+		if err := apiServer.Shutdown(graceCtx); err != nil {
+			log.Warn("grace period is over with HTTP API requests in flight", "err", err)
+		}
+	}
+	graceCancel()
+
+	// interrupt the remaining connections and let them notify their clients
 	cancel()
 
-	// wait for completion of graceful shutdown
-	time.Sleep(3 * time.Second)
+	interruptCtx, interruptCancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer interruptCancel()
+	if err := tcpServer.Shutdown(interruptCtx); err != nil {
+		log.Error(err, "action", "shut down TCP server")
+	}
+
+	// no quotes are served anymore, so the quotes source releases its resources
+	if err := wordOfWisdomSrv.Close(); err != nil {
+		log.Error(err, "action", "close quotes source")
+	}
+	if quotesDB != nil {
+		if err := quotesDB.Close(); err != nil {
+			log.Error(err, "action", "close quotes database")
+		}
+	}
+
+	if state != nil {
+		if err := state.Save(); err != nil {
+			log.Error(err, "action", "save metrics state")
+		}
+	}
 }
 
-func initConfig() *config.ServerParameters {
-	params := config.ServerParameters{}
-	if err := env.Parse(&params); err != nil {
-		panic(err)
+// initConfig parses the settings from the environment and the CONFIG_FILE file, if it's set, and validates them.
+func initConfig() (*config.ServerParameters, error) {
+	params, err := config.LoadServerConfig(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	if err := params.Validate(); err != nil {
+		return nil, err
 	}
 
-	return &params
+	return params, nil
 }
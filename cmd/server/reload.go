@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/laonix/pow-word-of-wisdom/config"
+	"github.com/laonix/pow-word-of-wisdom/handler"
+	"github.com/laonix/pow-word-of-wisdom/logger"
+)
+
+// reload applies the mutable settings of a re-read configuration without dropping connections:
+// the logging level, if the logger supports changing it, and the PoW difficulty (complexity and min bits).
+//
+// The flows in progress keep their challenges, while subsequent connections get the new difficulty.
+func reload(cfg *config.ServerParameters, log logger.Logger, powHandler *handler.ProofOfWork) error {
+	if err := powHandler.SetDifficulty(cfg.Complexity, cfg.MinBits); err != nil {
+		return fmt.Errorf("set PoW difficulty: %w", err)
+	}
+
+	if setter, ok := log.(logger.LevelSetter); ok {
+		setter.SetLevel(logger.LevelOf(cfg.LoggingLevel))
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/laonix/pow-word-of-wisdom/config"
+	"github.com/laonix/pow-word-of-wisdom/handler"
+	"github.com/laonix/pow-word-of-wisdom/logger"
+	"github.com/laonix/pow-word-of-wisdom/pow"
+	"github.com/laonix/pow-word-of-wisdom/tcp"
+	"github.com/laonix/pow-word-of-wisdom/tcp/tcptest"
+)
+
+// levelLogger is a logger recording the level it's set to.
+type levelLogger struct {
+	logger.Logger
+	level logger.Level
+}
+
+func (l *levelLogger) SetLevel(level logger.Level) {
+	l.level = level
+}
+
+// nopHandler is a tcp.Handler doing nothing.
+type nopHandler struct{}
+
+func (nopHandler) ServeTCP(context.Context, tcp.Conn) {}
+
+func TestReload(t *testing.T) {
+	log := &levelLogger{Logger: logger.NewNopLogger(), level: logger.LevelError}
+
+	// issued challenge bits are recorded, and the client goes away right after getting a challenge
+	var issued []uint
+	settings := handler.ProofOfWorkSettings{
+		Challenge: func(bits uint, resource string) (string, error) {
+			issued = append(issued, bits)
+			return pow.Challenge(bits, resource)
+		},
+		Verify:     pow.Verify,
+		Complexity: 12,
+		MinBits:    10,
+		WaitPOW:    time.Minute,
+	}
+	powHandler, err := handler.NewProofOfWork(nopHandler{}, settings, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func() uint {
+		issued = nil
+		powHandler.ServeTCP(context.Background(), tcptest.NewConn("ping"))
+		if !assert.Len(t, issued, 1) {
+			return 0
+		}
+
+		return issued[0]
+	}
+
+	bits := serve()
+	assert.GreaterOrEqual(t, bits, uint(10))
+	assert.Less(t, bits, uint(12))
+
+	err = reload(&config.ServerParameters{LoggingLevel: "DEBUG", Complexity: 24, MinBits: 20}, log, powHandler)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, logger.LevelDebug, log.level)
+
+	// subsequent connections get the new difficulty
+	for i := 0; i < 10; i++ {
+		bits := serve()
+		assert.GreaterOrEqual(t, bits, uint(20))
+		assert.Less(t, bits, uint(24))
+	}
+
+	// invalid settings are rejected, and the difficulty is kept
+	err = reload(&config.ServerParameters{LoggingLevel: "INFO", Complexity: 20, MinBits: 20}, log, powHandler)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "set PoW difficulty: invalid Complexity 20: it must exceed MinBits 20", err.Error())
+	}
+	assert.Equal(t, logger.LevelDebug, log.level)
+
+	bits = serve()
+	assert.GreaterOrEqual(t, bits, uint(20))
+	assert.Less(t, bits, uint(24))
+}
@@ -1,7 +1,76 @@
 package config
 
+import (
+	"fmt"
+	"time"
+)
+
 // ClientParameters holds a client settings.
 type ClientParameters struct {
 	LoggingLevel string `env:"LOGGING_LEVEL" envDefault:"DEBUG"`
 	ServerAddr   string `env:"SERVER_ADDR" envDefault:":80"`
+	// LoggingBackend is either "zap" (default) or "slog" (requires Go 1.21).
+	LoggingBackend string `env:"LOGGING_BACKEND" envDefault:"zap"`
+	// LogEncoding is either "json" (default) or "console" (human-readable) format of log records.
+	LogEncoding string `env:"LOG_ENCODING" envDefault:"json"`
+
+	// Quotes is a number of quotes to request one by one.
+	Quotes int `env:"QUOTES" envDefault:"1"`
+	// NextChallenge flags to ask for a next challenge along with a quote and solve it in advance.
+	NextChallenge bool `env:"NEXT_CHALLENGE" envDefault:"false"`
+	// Batch flags to request all the quotes in batch mode, solving a batch of challenges at once.
+	Batch bool `env:"BATCH" envDefault:"false"`
+	// DifficultyToken flags to ask for a difficulty token along with a quote to get a reduced difficulty next time.
+	DifficultyToken bool `env:"DIFFICULTY_TOKEN" envDefault:"false"`
+	// APIKey is a key to authenticate with, so PoW is skipped or reduced by the server.
+	APIKey string `env:"API_KEY"`
+	// Bits is a challenge difficulty to propose to the server; 0 means no proposal.
+	Bits int `env:"BITS" envDefault:"0"`
+	// Category is a quote category to request; any category is served if it's empty.
+	Category string `env:"CATEGORY"`
+	// Hex flags to ask for challenges with the random and counter fields hex-encoded instead of base64-encoded.
+	Hex bool `env:"HEX" envDefault:"false"`
+
+	// Session flags to request all the quotes over a single connection as long as the server keeps the session open.
+	Session bool `env:"SESSION" envDefault:"false"`
+	// DialTimeout is the longest time to wait for a connection to the server to be established.
+	DialTimeout time.Duration `env:"DIAL_TIMEOUT" envDefault:"10s"`
+
+	// SolverWorkers is a number of goroutines calculating PoW result; 0 means GOMAXPROCS.
+	SolverWorkers int `env:"SOLVER_WORKERS" envDefault:"0"`
+	// SolverLockOSThread flags to wire every solver goroutine to its own OS thread.
+	SolverLockOSThread bool `env:"SOLVER_LOCK_OS_THREAD" envDefault:"false"`
+
+	// TLS flags to connect to the server over TLS.
+	TLS bool `env:"TLS" envDefault:"false"`
+	// TLSCAFile is a path to a PEM encoded CA certificate to verify the server with; system CAs are used if it's empty.
+	TLSCAFile string `env:"TLS_CA_FILE"`
+	// TLSServerName is a server name to verify the server certificate against; SERVER_ADDR host is used if it's empty.
+	TLSServerName string `env:"TLS_SERVER_NAME"`
+}
+
+// Validate checks the semantic constraints of the settings env parsing doesn't check.
+//
+// It returns an error describing the first violated constraint.
+func (p *ClientParameters) Validate() error {
+	if p.LogEncoding != "json" && p.LogEncoding != "console" {
+		return fmt.Errorf("invalid LOG_ENCODING %q: it must be either json or console", p.LogEncoding)
+	}
+	if err := validateAddr("SERVER_ADDR", p.ServerAddr); err != nil {
+		return err
+	}
+	if p.Quotes < 1 {
+		return fmt.Errorf("invalid QUOTES %d: it must be at least 1", p.Quotes)
+	}
+	if p.Bits < 0 {
+		return fmt.Errorf("invalid BITS %d: it mustn't be negative", p.Bits)
+	}
+	if p.DialTimeout <= 0 {
+		return fmt.Errorf("invalid DIAL_TIMEOUT %s: it must be positive", p.DialTimeout)
+	}
+	if p.SolverWorkers < 0 {
+		return fmt.Errorf("invalid SOLVER_WORKERS %d: it mustn't be negative", p.SolverWorkers)
+	}
+
+	return nil
 }
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientParameters_Validate(t *testing.T) {
+	var defaults ClientParameters
+	if err := env.Parse(&defaults); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, defaults.Validate())
+
+	tests := []struct {
+		name   string
+		modify func(p *ClientParameters)
+		want   string
+	}{
+		{
+			name:   "bad server address",
+			modify: func(p *ClientParameters) { p.ServerAddr = "server" },
+			want:   `invalid SERVER_ADDR "server": address server: missing port in address`,
+		},
+		{
+			name:   "no quotes",
+			modify: func(p *ClientParameters) { p.Quotes = 0 },
+			want:   "invalid QUOTES 0: it must be at least 1",
+		},
+		{
+			name:   "zero dial timeout",
+			modify: func(p *ClientParameters) { p.DialTimeout = 0 },
+			want:   "invalid DIAL_TIMEOUT 0s: it must be positive",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := defaults
+			test.modify(&p)
+
+			err := p.Validate()
+			if assert.NotNil(t, err) {
+				assert.Equal(t, test.want, err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/caarlos0/env/v6"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadServerConfig returns ServerParameters read from a YAML (or JSON) file with environment variables overlaid.
+//
+// See loadConfig for the file format.
+func LoadServerConfig(path string) (*ServerParameters, error) {
+	params := ServerParameters{}
+	if err := loadConfig(path, &params); err != nil {
+		return nil, err
+	}
+
+	return &params, nil
+}
+
+// LoadClientConfig returns ClientParameters read from a YAML (or JSON) file with environment variables overlaid.
+//
+// See loadConfig for the file format.
+func LoadClientConfig(path string) (*ClientParameters, error) {
+	params := ClientParameters{}
+	if err := loadConfig(path, &params); err != nil {
+		return nil, err
+	}
+
+	return &params, nil
+}
+
+// loadConfig parses settings from a file and environment variables into params.
+//
+// The file keys are the names of the environment variables (case-insensitive), e.g. `complexity: 25`.
+// Lists are joined with commas (e.g. EXTRA_TCP_ADDRS), and maps are joined as "key:value" pairs (e.g. API_KEYS).
+// An environment variable wins over the same setting of the file, and the defaults apply to settings set by neither.
+// If the path is empty or the file doesn't exist, only environment variables are parsed.
+func loadConfig(path string, params any) error {
+	environment, err := fileEnvironment(path)
+	if err != nil {
+		return err
+	}
+
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			environment[k] = v
+		}
+	}
+
+	if err := env.ParseWithFuncs(params, parsers, env.Options{Environment: environment}); err != nil {
+		return fmt.Errorf("parse config: %w", err)
+	}
+
+	return nil
+}
+
+// parsers parse the settings of types env doesn't support on its own.
+var parsers = map[reflect.Type]env.ParserFunc{
+	reflect.TypeOf(map[string]string{}): parseMap,
+}
+
+// parseMap parses a map of comma-separated "key:value" pairs, e.g. API_KEYS.
+func parseMap(value string) (any, error) {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid pair %q: it must be \"key:value\"", pair)
+		}
+		m[k] = v
+	}
+
+	return m, nil
+}
+
+// fileEnvironment reads settings from a YAML (or JSON) file as environment variables.
+func fileEnvironment(path string) (map[string]string, error) {
+	environment := make(map[string]string)
+	if path == "" {
+		return environment, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return environment, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	var settings map[string]any
+	if err := yaml.Unmarshal(b, &settings); err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	for key, value := range settings {
+		s, err := envValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("parse config file %s: %s: %w", path, key, err)
+		}
+		environment[strings.ToUpper(key)] = s
+	}
+
+	return environment, nil
+}
+
+// envValue returns a setting value of a file as it's set in an environment variable.
+func envValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := scalarValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		pairs := make([]string, 0, len(v))
+		for k, item := range v {
+			s, err := scalarValue(item)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, k+":"+s)
+		}
+		// pairs are sorted, so the value doesn't depend on the map iteration order
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	default:
+		return scalarValue(v)
+	}
+}
+
+// scalarValue returns a scalar setting value as a string.
+func scalarValue(value any) (string, error) {
+	switch value.(type) {
+	case []any, map[string]any:
+		return "", errors.New("nested lists and maps are not supported")
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
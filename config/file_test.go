@@ -0,0 +1,85 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeConfigFile writes a config file to a temporary directory and returns its path.
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadServerConfig(t *testing.T) {
+	yamlFile := writeConfigFile(t, "server.yaml", `
+complexity: 25
+wait_pow: 30s
+EXTRA_TCP_ADDRS: [":8081", ":8082"]
+api_keys:
+  alice: secret
+  bob: password
+`)
+
+	t.Run("file only", func(t *testing.T) {
+		params, err := LoadServerConfig(yamlFile)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 25, params.Complexity)
+		assert.Equal(t, 30*time.Second, params.WaitPOW)
+		assert.Equal(t, []string{":8081", ":8082"}, params.ExtraTCPAddrs)
+		assert.Equal(t, map[string]string{"alice": "secret", "bob": "password"}, params.APIKeys)
+
+		// the settings missing in the file keep their defaults
+		assert.Equal(t, ":80", params.TCPAddr)
+		assert.Equal(t, 10*time.Second, params.WaitQuote)
+	})
+
+	t.Run("JSON file", func(t *testing.T) {
+		params, err := LoadServerConfig(writeConfigFile(t, "server.json", `{"COMPLEXITY": 21, "CATALOG": true}`))
+		assert.Nil(t, err)
+
+		assert.Equal(t, 21, params.Complexity)
+		assert.True(t, params.Catalog)
+	})
+
+	t.Run("env override", func(t *testing.T) {
+		t.Setenv("COMPLEXITY", "20")
+		t.Setenv("TCP_ADDR", ":8080")
+		t.Setenv("API_KEYS", "carol:key")
+
+		params, err := LoadServerConfig(yamlFile)
+		assert.Nil(t, err)
+
+		// environment variables win over the file, which wins over the defaults
+		assert.Equal(t, 20, params.Complexity)
+		assert.Equal(t, ":8080", params.TCPAddr)
+		assert.Equal(t, 30*time.Second, params.WaitPOW)
+		assert.Equal(t, map[string]string{"carol": "key"}, params.APIKeys)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		params, err := LoadServerConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+		assert.Nil(t, err)
+
+		assert.Equal(t, 30, params.Complexity)
+		assert.Equal(t, time.Minute, params.WaitPOW)
+		assert.Equal(t, ":80", params.TCPAddr)
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		_, err := LoadServerConfig(writeConfigFile(t, "server.yaml", "complexity: [[25]]"))
+		assert.NotNil(t, err)
+
+		_, err = LoadServerConfig(writeConfigFile(t, "server.yaml", "complexity: high"))
+		assert.NotNil(t, err)
+	})
+}
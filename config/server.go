@@ -1,12 +1,332 @@
 package config
 
-import "time"
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // ServerParameters holds server settings.
 type ServerParameters struct {
 	LoggingLevel string `env:"LOGGING_LEVEL" envDefault:"DEBUG"`
 	TCPAddr      string `env:"TCP_ADDR" envDefault:":80"`
+	// LoggingBackend is either "zap" (default) or "slog" (requires Go 1.21).
+	LoggingBackend string `env:"LOGGING_BACKEND" envDefault:"zap"`
+	// LogEncoding is either "json" (default) or "console" (human-readable) format of log records.
+	LogEncoding string `env:"LOG_ENCODING" envDefault:"json"`
+	// ExtraTCPAddrs is a comma-separated list of addresses to listen on along with TCPAddr.
+	ExtraTCPAddrs []string `env:"EXTRA_TCP_ADDRS" envSeparator:","`
+	// TCPNetwork is either "tcp" (default, IPv4 and IPv6), "tcp4" (IPv4 only), "tcp6" (IPv6 only)
+	// or "unix" (Unix domain sockets) network TCPAddr and ExtraTCPAddrs are listened on;
+	// the addresses are socket file paths for "unix".
+	TCPNetwork string `env:"TCP_NETWORK" envDefault:"tcp"`
+	// MetricsAddr is an address to serve metrics at over HTTP (see expvar); metrics are not served if it's empty.
+	MetricsAddr string `env:"METRICS_ADDR"`
+	// PrometheusAddr is an address to serve Prometheus metrics at over HTTP (at /metrics);
+	// Prometheus metrics are not collected if it's empty.
+	PrometheusAddr string `env:"PROMETHEUS_ADDR"`
+	// MetricsStateFile is a path to a file to persist served and failures totals across restarts;
+	// totals are reset on restart if it's empty.
+	MetricsStateFile string `env:"METRICS_STATE_FILE"`
+	// HTTPAPIAddr is an address to serve the PoW challenge/verify flow at over HTTP (see httpapi)
+	// along with the TCP protocol; the HTTP API is not served if it's empty.
+	HTTPAPIAddr string `env:"HTTP_API_ADDR"`
+	// HTTPAPIMaxChallenges is the most HTTP API challenges outstanding at once (see httpapi.Settings.MaxChallenges).
+	HTTPAPIMaxChallenges int `env:"HTTP_API_MAX_CHALLENGES" envDefault:"100000"`
+	// Control enables the control socket serving admin commands (e.g. "stats", "setlevel debug", "setcomplexity 25").
+	// ControlNetwork is either "tcp" (default) or "unix" network ControlAddr is listened on;
+	// ControlAddr is a socket file path for "unix", and it's bound to localhost by default for "tcp".
+	Control        bool   `env:"CONTROL" envDefault:"false"`
+	ControlNetwork string `env:"CONTROL_NETWORK" envDefault:"tcp"`
+	ControlAddr    string `env:"CONTROL_ADDR" envDefault:"127.0.0.1:8083"`
+	// AuditLog is a path to a file to append audit records of accepted PoW results to; nothing is audited if it's empty.
+	AuditLog string `env:"AUDIT_LOG"`
+	// AuditFormat is either "json" or "text" format of audit records.
+	AuditFormat string `env:"AUDIT_FORMAT" envDefault:"json"`
+
+	// MaxMessageSize is an upper limit of a client message size in bytes.
+	// In batch mode, it must fit the results of MaxBatch challenges submitted at once.
+	MaxMessageSize int `env:"MAX_MESSAGE_SIZE" envDefault:"4096"`
+
+	// ReadTimeout and WriteTimeout limit a single read from and write to a client connection,
+	// so a stalled client is disconnected. ReadTimeout should exceed WaitPOW.
+	ReadTimeout  time.Duration `env:"READ_TIMEOUT" envDefault:"2m"`
+	WriteTimeout time.Duration `env:"WRITE_TIMEOUT" envDefault:"10s"`
+
+	// MaxConcurrentConns is an upper limit of client connections served at the same time; 0 means no limit.
+	MaxConcurrentConns int `env:"MAX_CONCURRENT_CONNS" envDefault:"1000"`
+
+	// RateLimit is a number of challenges per second issued to a single remote IP; 0 means no limit.
+	// RateBurst is a number of challenges a single remote IP may be issued at once.
+	RateLimit float64 `env:"RATE_LIMIT" envDefault:"1"`
+	RateBurst int     `env:"RATE_BURST" envDefault:"10"`
+
+	// AllowedIPs and DeniedIPs are comma-separated lists of CIDR ranges or single IPs connections are accepted from
+	// or denied; connections from any IP out of denied ranges are accepted if AllowedIPs is empty.
+	// A denied connection is closed right away, with DeniedMessage written to it if it's set.
+	AllowedIPs    []string `env:"ALLOWED_IPS" envSeparator:","`
+	DeniedIPs     []string `env:"DENIED_IPS" envSeparator:","`
+	DeniedMessage string   `env:"DENIED_MESSAGE"`
+
+	// ProxyProtocol flags that the server is behind a load balancer speaking PROXY protocol v1 or v2,
+	// so the client IP is taken from a PROXY protocol header every connection must start with.
+	ProxyProtocol bool `env:"PROXY_PROTOCOL" envDefault:"false"`
+
+	// TLSCertFile and TLSKeyFile are paths to a PEM encoded certificate and key; TLS is enabled if both are set.
+	TLSCertFile string `env:"TLS_CERT_FILE"`
+	TLSKeyFile  string `env:"TLS_KEY_FILE"`
 
 	Complexity int           `env:"COMPLEXITY" envDefault:"30"`
 	WaitPOW    time.Duration `env:"WAIT_POW" envDefault:"1m"`
+	// MinBits is a lower limit for challenge header bits, which vary in interval [MinBits, Complexity).
+	MinBits int `env:"MIN_BITS" envDefault:"10"`
+	// ChallengeTTL is the time a challenge is valid for since it's issued, regardless of WaitPOW;
+	// challenges don't expire if it's 0. A challenge date is precise to a minute, so it must be at least 1m.
+	ChallengeTTL time.Duration `env:"CHALLENGE_TTL" envDefault:"0"`
+	// HashAlgorithm is either "sha256" or "sha1" (canonical Hashcash) hash function a PoW result is calculated with.
+	HashAlgorithm string `env:"HASH_ALGORITHM" envDefault:"sha256"`
+	// AdaptiveSaturation is a number of connections within AdaptiveWindow considered a full server load,
+	// which raises challenge difficulty up to Complexity; adaptive difficulty is disabled if it's 0.
+	AdaptiveSaturation int           `env:"ADAPTIVE_SATURATION" envDefault:"0"`
+	AdaptiveWindow     time.Duration `env:"ADAPTIVE_WINDOW" envDefault:"10s"`
+	// AdaptiveWarmUp is a period after the server start during which challenges are issued with the baseline difficulty
+	// while the load history is accumulated.
+	AdaptiveWarmUp time.Duration `env:"ADAPTIVE_WARM_UP" envDefault:"0s"`
+	// MinNegotiatedBits is the lowest challenge bits a client may negotiate; negotiation is disabled if it's 0.
+	MinNegotiatedBits int `env:"MIN_NEGOTIATED_BITS" envDefault:"0"`
+	// EstimateHashRate is the number of hashes per second of a reference client challenge solve time is estimated for,
+	// and ChallengeHint is a human-readable message; both are sent along with challenges to clients requesting estimates.
+	EstimateHashRate float64 `env:"ESTIMATE_HASH_RATE" envDefault:"1000000"`
+	ChallengeHint    string  `env:"CHALLENGE_HINT"`
+	// WaitQuote is a time limit for a quote delivery once PoW verification has passed.
+	WaitQuote time.Duration `env:"WAIT_QUOTE" envDefault:"10s"`
+
+	// ShutdownGrace is a period for in-flight connections to complete on shutdown.
+	ShutdownGrace time.Duration `env:"SHUTDOWN_GRACE" envDefault:"1m"`
+
+	IssueNextChallenge bool `env:"ISSUE_NEXT_CHALLENGE" envDefault:"false"`
+	// MaxBatch is an upper limit of quotes per connection in batch mode; batch mode is disabled if it's less than 2.
+	MaxBatch int `env:"MAX_BATCH" envDefault:"10"`
+	// VerifyBudget is the time allowed to be spent on verifying results submitted over a single connection;
+	// it's unlimited if it's zero.
+	VerifyBudget time.Duration `env:"VERIFY_BUDGET" envDefault:"0"`
+
+	// TokenSecret is a key to sign difficulty tokens with; difficulty tokens are disabled if it's empty.
+	TokenSecret     string        `env:"TOKEN_SECRET"`
+	TokenTTL        time.Duration `env:"TOKEN_TTL" envDefault:"5m"`
+	TokenComplexity int           `env:"TOKEN_COMPLEXITY" envDefault:"15"`
+
+	// APIKeys holds API keys by client identities as comma-separated "identity:key" pairs;
+	// authenticated clients skip PoW, or get a TokenComplexity challenge if AuthenticatedReduced is set.
+	APIKeys              map[string]string `env:"API_KEYS"`
+	AuthenticatedReduced bool              `env:"AUTHENTICATED_REDUCED" envDefault:"false"`
+
+	// Catalog enables serving supported capabilities and quote categories to clients requesting them;
+	// the catalog is sent only along with a quote, once PoW has been passed, if CatalogAfterPoW is set.
+	Catalog         bool `env:"CATALOG" envDefault:"false"`
+	CatalogAfterPoW bool `env:"CATALOG_AFTER_POW" envDefault:"true"`
+
+	// ChallengeResource is either "random" (a random UUID) or "remote-ip" (the client's IP) challenge resource.
+	ChallengeResource string `env:"CHALLENGE_RESOURCE" envDefault:"random"`
+
+	// QuoteFailurePolicy is either "closed" (notify a client about a failure)
+	// or "open" (serve the last retrieved or the fallback quote) on quote source errors.
+	QuoteFailurePolicy string `env:"QUOTE_FAILURE_POLICY" envDefault:"closed"`
+	FallbackQuote      string `env:"FALLBACK_QUOTE"`
+	// QuoteMaxSize is the longest quote in bytes, longer quotes are truncated; quotes are not truncated if it's zero.
+	QuoteMaxSize int `env:"QUOTE_MAX_SIZE" envDefault:"0"`
+	// QuoteTimeout is a time limit for retrieving a quote from the quote source; it's unlimited if it's zero.
+	QuoteTimeout time.Duration `env:"QUOTE_TIMEOUT" envDefault:"0"`
+	// MaxQuotesPerSession is the most quotes served over a single connection to a client in session mode;
+	// all of them are requested within WaitQuote. Session mode is off unless it's greater than 1.
+	MaxQuotesPerSession int `env:"MAX_QUOTES_PER_SESSION" envDefault:"1"`
+
+	// QuoteFilter enables normalizing quotes as they're loaded: control characters are stripped, whitespace is trimmed,
+	// and quotes left empty or exceeding QuoteFilterMaxLength bytes are skipped (and logged).
+	QuoteFilter          bool `env:"QUOTE_FILTER" envDefault:"false"`
+	QuoteFilterMaxLength int  `env:"QUOTE_FILTER_MAX_LENGTH" envDefault:"1000"`
+
+	// QuotesFile is a path to a quotes file reloaded on SIGHUP; the embedded quotes are used if it's empty.
+	QuotesFile string `env:"QUOTES_FILE"`
+	// QuotesURL is a URL to fetch quotes from at startup, unless QuotesFile is set;
+	// the embedded quotes are used if fetching fails within QuotesURLTimeout.
+	QuotesURL        string        `env:"QUOTES_URL"`
+	QuotesURLTimeout time.Duration `env:"QUOTES_URL_TIMEOUT" envDefault:"5s"`
+	// QuotesDB is a PostgreSQL connection string to retrieve quotes from QuotesDBTable (see service.SQLGetter),
+	// unless QuotesFile or QuotesURL is set. Quotes are cached for QuotesDBCacheTTL,
+	// and their ids are listed again once QuotesDBRefresh has passed, so the table might be updated at runtime.
+	QuotesDB         string        `env:"QUOTES_DB"`
+	QuotesDBTable    string        `env:"QUOTES_DB_TABLE" envDefault:"quotes"`
+	QuotesDBCacheTTL time.Duration `env:"QUOTES_DB_CACHE_TTL" envDefault:"1m"`
+	QuotesDBRefresh  time.Duration `env:"QUOTES_DB_REFRESH" envDefault:"1m"`
+	// QuoteRNG is either "math" (fast) or "crypto" (cryptographically secure) random source to select quotes with.
+	QuoteRNG string `env:"QUOTE_RNG" envDefault:"math"`
+	// QuoteNoRepeat flags to avoid serving the same quote twice in a row.
+	QuoteNoRepeat bool `env:"QUOTE_NO_REPEAT" envDefault:"false"`
+}
+
+// maxProofSize is an upper estimate of a PoW result submitted in batch mode, in bytes of a JSON string:
+// a header of a connection id resource takes about 100 bytes, and extensions (e.g. the hash algorithm) add to it.
+const maxProofSize = 256
+
+// batchProofOverhead is the size of a batch of results holding no results (see protocol.BatchProof).
+const batchProofOverhead = len(`{"proofs":[]}`)
+
+// sqlIdentifier matches a (schema-qualified) table name, which is interpolated into SQL queries.
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// Validate checks the semantic constraints of the settings env parsing doesn't check.
+//
+// It returns an error describing the first violated constraint.
+func (p *ServerParameters) Validate() error {
+	if p.LogEncoding != "json" && p.LogEncoding != "console" {
+		return fmt.Errorf("invalid LOG_ENCODING %q: it must be either json or console", p.LogEncoding)
+	}
+	if p.MinBits < 1 {
+		return fmt.Errorf("invalid MIN_BITS %d: it must be positive", p.MinBits)
+	}
+	if p.Complexity <= p.MinBits {
+		return fmt.Errorf("invalid COMPLEXITY %d: it must exceed MIN_BITS %d", p.Complexity, p.MinBits)
+	}
+	if p.WaitPOW <= 0 {
+		return fmt.Errorf("invalid WAIT_POW %s: it must be positive", p.WaitPOW)
+	}
+	if p.ChallengeTTL != 0 && p.ChallengeTTL < time.Minute {
+		return fmt.Errorf("invalid CHALLENGE_TTL %s: it must be either 0 or at least 1m", p.ChallengeTTL)
+	}
+	switch p.TCPNetwork {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return fmt.Errorf("invalid TCP_NETWORK %q: it must be either tcp, tcp4, tcp6 or unix", p.TCPNetwork)
+	}
+	if err := p.validateListenAddr("TCP_ADDR", p.TCPAddr); err != nil {
+		return err
+	}
+	for _, addr := range p.ExtraTCPAddrs {
+		if err := p.validateListenAddr("EXTRA_TCP_ADDRS", addr); err != nil {
+			return err
+		}
+	}
+	if p.MetricsAddr != "" {
+		if err := validateAddr("METRICS_ADDR", p.MetricsAddr); err != nil {
+			return err
+		}
+	}
+	if p.PrometheusAddr != "" {
+		if err := validateAddr("PROMETHEUS_ADDR", p.PrometheusAddr); err != nil {
+			return err
+		}
+	}
+	if p.HTTPAPIAddr != "" {
+		if err := validateAddr("HTTP_API_ADDR", p.HTTPAPIAddr); err != nil {
+			return err
+		}
+	}
+	if p.Control {
+		if err := p.validateControl(); err != nil {
+			return err
+		}
+	}
+	if p.HTTPAPIMaxChallenges < 1 {
+		return fmt.Errorf("invalid HTTP_API_MAX_CHALLENGES %d: it must be positive", p.HTTPAPIMaxChallenges)
+	}
+	if p.MaxMessageSize < 1 {
+		return fmt.Errorf("invalid MAX_MESSAGE_SIZE %d: it must be positive", p.MaxMessageSize)
+	}
+	if size := p.MaxBatch*maxProofSize + batchProofOverhead; p.MaxBatch > 1 && p.MaxMessageSize < size {
+		return fmt.Errorf("invalid MAX_MESSAGE_SIZE %d: it must be at least %d to fit the results of MAX_BATCH %d challenges",
+			p.MaxMessageSize, size, p.MaxBatch)
+	}
+	if p.MaxConcurrentConns < 0 {
+		return fmt.Errorf("invalid MAX_CONCURRENT_CONNS %d: it mustn't be negative", p.MaxConcurrentConns)
+	}
+	if p.EstimateHashRate < 0 {
+		return fmt.Errorf("invalid ESTIMATE_HASH_RATE %v: it mustn't be negative", p.EstimateHashRate)
+	}
+	if p.RateLimit < 0 {
+		return fmt.Errorf("invalid RATE_LIMIT %v: it mustn't be negative", p.RateLimit)
+	}
+	if p.QuoteFilterMaxLength < 0 {
+		return fmt.Errorf("invalid QUOTE_FILTER_MAX_LENGTH %d: it mustn't be negative", p.QuoteFilterMaxLength)
+	}
+	if !sqlIdentifier.MatchString(p.QuotesDBTable) {
+		return fmt.Errorf("invalid QUOTES_DB_TABLE %q: it must be a table name", p.QuotesDBTable)
+	}
+	if p.QuotesDBCacheTTL <= 0 {
+		return fmt.Errorf("invalid QUOTES_DB_CACHE_TTL %s: it must be positive", p.QuotesDBCacheTTL)
+	}
+	if p.QuotesDBRefresh <= 0 {
+		return fmt.Errorf("invalid QUOTES_DB_REFRESH %s: it must be positive", p.QuotesDBRefresh)
+	}
+	if p.QuoteTimeout < 0 {
+		return fmt.Errorf("invalid QUOTE_TIMEOUT %s: it mustn't be negative", p.QuoteTimeout)
+	}
+	if err := validateRanges("ALLOWED_IPS", p.AllowedIPs); err != nil {
+		return err
+	}
+	if err := validateRanges("DENIED_IPS", p.DeniedIPs); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateListenAddr checks if an address is valid for TCPNetwork: a non-empty socket file path for "unix",
+// or a "host:port" pair otherwise (see validateAddr).
+func (p *ServerParameters) validateListenAddr(name, addr string) error {
+	if p.TCPNetwork != "unix" {
+		return validateAddr(name, addr)
+	}
+	if addr == "" {
+		return fmt.Errorf("invalid %s %q: it must be a socket file path", name, addr)
+	}
+
+	return nil
+}
+
+// validateControl checks if ControlAddr is valid for ControlNetwork: a non-empty socket file path for "unix",
+// or a "host:port" pair for "tcp" (see validateAddr).
+func (p *ServerParameters) validateControl() error {
+	switch p.ControlNetwork {
+	case "tcp":
+		return validateAddr("CONTROL_ADDR", p.ControlAddr)
+	case "unix":
+		if p.ControlAddr == "" {
+			return fmt.Errorf("invalid CONTROL_ADDR %q: it must be a socket file path", p.ControlAddr)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid CONTROL_NETWORK %q: it must be either tcp or unix", p.ControlNetwork)
+	}
+}
+
+// validateAddr checks if an address is a "host:port" address to listen on or dial, where the host may be empty.
+func validateAddr(name, addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, addr, err)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid %s %q: port must be a number within [0, 65535]", name, addr)
+	}
+
+	return nil
+}
+
+// validateRanges checks if every item of a list is either a CIDR range or a single IP address.
+func validateRanges(name string, ranges []string) error {
+	for _, r := range ranges {
+		r = strings.TrimSpace(r)
+		if r == "" || net.ParseIP(r) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(r); err != nil {
+			return fmt.Errorf("invalid %s %q: it must be a CIDR range or an IP address", name, r)
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerParameters_Validate(t *testing.T) {
+	var defaults ServerParameters
+	if err := env.Parse(&defaults); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, defaults.Validate())
+
+	tests := []struct {
+		name   string
+		modify func(p *ServerParameters)
+		want   string
+	}{
+		{
+			name:   "unknown log encoding",
+			modify: func(p *ServerParameters) { p.LogEncoding = "xml" },
+			want:   `invalid LOG_ENCODING "xml": it must be either json or console`,
+		},
+		{
+			name:   "complexity too low",
+			modify: func(p *ServerParameters) { p.Complexity = 10 },
+			want:   "invalid COMPLEXITY 10: it must exceed MIN_BITS 10",
+		},
+		{
+			name:   "complexity not exceeding min bits",
+			modify: func(p *ServerParameters) { p.MinBits, p.Complexity = 8, 8 },
+			want:   "invalid COMPLEXITY 8: it must exceed MIN_BITS 8",
+		},
+		{
+			name:   "zero min bits",
+			modify: func(p *ServerParameters) { p.MinBits = 0 },
+			want:   "invalid MIN_BITS 0: it must be positive",
+		},
+		{
+			name:   "zero wait",
+			modify: func(p *ServerParameters) { p.WaitPOW = 0 },
+			want:   "invalid WAIT_POW 0s: it must be positive",
+		},
+		{
+			name:   "unknown network",
+			modify: func(p *ServerParameters) { p.TCPNetwork = "udp" },
+			want:   `invalid TCP_NETWORK "udp": it must be either tcp, tcp4, tcp6 or unix`,
+		},
+		{
+			name: "empty socket path",
+			modify: func(p *ServerParameters) {
+				p.TCPNetwork, p.TCPAddr, p.ExtraTCPAddrs = "unix", "/run/pow.sock", []string{""}
+			},
+			want: `invalid EXTRA_TCP_ADDRS "": it must be a socket file path`,
+		},
+		{
+			name:   "challenge TTL under a minute",
+			modify: func(p *ServerParameters) { p.ChallengeTTL = 30 * time.Second },
+			want:   "invalid CHALLENGE_TTL 30s: it must be either 0 or at least 1m",
+		},
+		{
+			name:   "zero max message size",
+			modify: func(p *ServerParameters) { p.MaxMessageSize = 0 },
+			want:   "invalid MAX_MESSAGE_SIZE 0: it must be positive",
+		},
+		{
+			name:   "max message size not fitting a batch",
+			modify: func(p *ServerParameters) { p.MaxMessageSize, p.MaxBatch = 1024, 10 },
+			want:   "invalid MAX_MESSAGE_SIZE 1024: it must be at least 2573 to fit the results of MAX_BATCH 10 challenges",
+		},
+		{
+			name:   "negative estimate hash rate",
+			modify: func(p *ServerParameters) { p.EstimateHashRate = -1 },
+			want:   "invalid ESTIMATE_HASH_RATE -1: it mustn't be negative",
+		},
+		{
+			name:   "negative quote filter max length",
+			modify: func(p *ServerParameters) { p.QuoteFilterMaxLength = -1 },
+			want:   "invalid QUOTE_FILTER_MAX_LENGTH -1: it mustn't be negative",
+		},
+		{
+			name:   "quotes table name injecting SQL",
+			modify: func(p *ServerParameters) { p.QuotesDBTable = "quotes; DROP TABLE quotes" },
+			want:   `invalid QUOTES_DB_TABLE "quotes; DROP TABLE quotes": it must be a table name`,
+		},
+		{
+			name:   "zero quotes database refresh",
+			modify: func(p *ServerParameters) { p.QuotesDBRefresh = 0 },
+			want:   "invalid QUOTES_DB_REFRESH 0s: it must be positive",
+		},
+		{
+			name:   "zero HTTP API max challenges",
+			modify: func(p *ServerParameters) { p.HTTPAPIMaxChallenges = 0 },
+			want:   "invalid HTTP_API_MAX_CHALLENGES 0: it must be positive",
+		},
+		{
+			name:   "negative quote timeout",
+			modify: func(p *ServerParameters) { p.QuoteTimeout = -time.Second },
+			want:   "invalid QUOTE_TIMEOUT -1s: it mustn't be negative",
+		},
+		{
+			name:   "unknown control network",
+			modify: func(p *ServerParameters) { p.Control, p.ControlNetwork = true, "udp" },
+			want:   `invalid CONTROL_NETWORK "udp": it must be either tcp or unix`,
+		},
+		{
+			name:   "control address without port",
+			modify: func(p *ServerParameters) { p.Control, p.ControlAddr = true, "localhost" },
+			want:   `invalid CONTROL_ADDR "localhost": address localhost: missing port in address`,
+		},
+		{
+			name:   "address without port",
+			modify: func(p *ServerParameters) { p.TCPAddr = "localhost" },
+			want:   `invalid TCP_ADDR "localhost": address localhost: missing port in address`,
+		},
+		{
+			name:   "port out of range",
+			modify: func(p *ServerParameters) { p.TCPAddr = ":70000" },
+			want:   `invalid TCP_ADDR ":70000": port must be a number within [0, 65535]`,
+		},
+		{
+			name:   "bad extra address",
+			modify: func(p *ServerParameters) { p.ExtraTCPAddrs = []string{":8080", "8081"} },
+			want:   `invalid EXTRA_TCP_ADDRS "8081": address 8081: missing port in address`,
+		},
+		{
+			name:   "bad metrics address",
+			modify: func(p *ServerParameters) { p.PrometheusAddr = ":metrics" },
+			want:   `invalid PROMETHEUS_ADDR ":metrics": port must be a number within [0, 65535]`,
+		},
+		{
+			name:   "bad HTTP API address",
+			modify: func(p *ServerParameters) { p.HTTPAPIAddr = "localhost" },
+			want:   `invalid HTTP_API_ADDR "localhost": address localhost: missing port in address`,
+		},
+		{
+			name:   "bad IP range",
+			modify: func(p *ServerParameters) { p.DeniedIPs = []string{"10.0.0.1", "192.168.0.0/33"} },
+			want:   `invalid DENIED_IPS "192.168.0.0/33": it must be a CIDR range or an IP address`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := defaults
+			test.modify(&p)
+
+			err := p.Validate()
+			if assert.NotNil(t, err) {
+				assert.Equal(t, test.want, err.Error())
+			}
+		})
+	}
+}
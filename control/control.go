@@ -0,0 +1,204 @@
+// Package control serves an admin interface over a separate control socket,
+// so operators query live stats and adjust settings of a running server without HTTP.
+//
+// Commands are text lines, and every command gets a single line reply: "ok", "error: <reason>",
+// or the requested data (e.g. "active_conns=3 quotes_served=42 complexity=20 min_bits=10" for "stats").
+// Supported commands:
+//
+//	stats                  reports live stats
+//	setlevel <level>       changes the logging level (error, warn, info or debug)
+//	setcomplexity <bits>   changes the upper limit of challenge header bits
+//	setminbits <bits>      changes the lower limit of challenge header bits
+//	help                   lists the commands
+//	quit                   closes the connection
+package control
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/laonix/pow-word-of-wisdom/logger"
+	"github.com/laonix/pow-word-of-wisdom/metrics"
+)
+
+// Difficulty is a contract of the PoW difficulty knobs (see handler.ProofOfWork).
+type Difficulty interface {
+	// Difficulty returns the current bounds of challenge header bits.
+	Difficulty() (complexity, minBits int)
+	// SetDifficulty replaces the bounds of challenge header bits.
+	SetDifficulty(complexity, minBits int) error
+}
+
+// Settings holds Handler settings.
+//
+// The commands relying on a setting which is not set reply with an error, and "stats" omits the stat.
+type Settings struct {
+	// Difficulty is reported by "stats" and changed by "setcomplexity" and "setminbits".
+	Difficulty Difficulty
+	// Level changes the logging level on "setlevel".
+	Level logger.LevelSetter
+	// ActiveConns returns the number of client connections being served (see tcp.Server#ActiveConns).
+	ActiveConns func() int
+	// Quotes counts quotes served to clients.
+	Quotes *metrics.Counter
+}
+
+// Handler executes control commands.
+type Handler struct {
+	difficulty  Difficulty
+	level       logger.LevelSetter
+	activeConns func() int
+	quotes      *metrics.Counter
+
+	log logger.Logger
+}
+
+// NewHandler returns a new instance of Handler.
+func NewHandler(settings Settings, log logger.Logger) *Handler {
+	return &Handler{
+		difficulty:  settings.Difficulty,
+		level:       settings.Level,
+		activeConns: settings.ActiveConns,
+		quotes:      settings.Quotes,
+		log:         log,
+	}
+}
+
+// help is the reply to the "help" command.
+const help = "commands: stats, setlevel <error|warn|info|debug>, setcomplexity <bits>, setminbits <bits>, help, quit"
+
+// Exec executes a command line and returns its reply.
+func (h *Handler) Exec(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "error: empty command"
+	}
+
+	command, args := strings.ToLower(fields[0]), fields[1:]
+	switch command {
+	case "stats":
+		return h.stats()
+	case "setlevel":
+		return reply(h.setLevel(args))
+	case "setcomplexity", "setminbits":
+		return reply(h.setBits(command, args))
+	case "help":
+		return help
+	default:
+		return fmt.Sprintf("error: unknown command %q, see help", command)
+	}
+}
+
+// stats reports the stats which sources are set as space-separated "name=value" pairs.
+func (h *Handler) stats() string {
+	var stats []string
+	if h.activeConns != nil {
+		stats = append(stats, fmt.Sprintf("active_conns=%d", h.activeConns()))
+	}
+	if h.quotes != nil {
+		stats = append(stats, fmt.Sprintf("quotes_served=%d", h.quotes.Value()))
+	}
+	if h.difficulty != nil {
+		complexity, minBits := h.difficulty.Difficulty()
+		stats = append(stats, fmt.Sprintf("complexity=%d", complexity), fmt.Sprintf("min_bits=%d", minBits))
+	}
+
+	return strings.Join(stats, " ")
+}
+
+// setLevel changes the logging level to the one named by the single argument.
+func (h *Handler) setLevel(args []string) error {
+	if h.level == nil {
+		return errors.New("logging level cannot be changed")
+	}
+	if len(args) != 1 {
+		return errors.New("usage: setlevel <error|warn|info|debug>")
+	}
+
+	level := logger.LevelOf(args[0])
+	if level.String() != strings.ToLower(args[0]) {
+		return fmt.Errorf("unknown logging level %q", args[0])
+	}
+
+	h.level.SetLevel(level)
+	h.log.Warn("logging level changed", "level", level, "source", "control")
+
+	return nil
+}
+
+// setBits changes the bound of challenge header bits of the command ("setcomplexity" or "setminbits")
+// to the single argument, while the other bound is kept.
+func (h *Handler) setBits(command string, args []string) error {
+	if h.difficulty == nil {
+		return errors.New("difficulty cannot be changed")
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: %s <bits>", command)
+	}
+
+	bits, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid bits %q", args[0])
+	}
+
+	complexity, minBits := h.difficulty.Difficulty()
+	if command == "setcomplexity" {
+		complexity = bits
+	} else {
+		minBits = bits
+	}
+
+	if err := h.difficulty.SetDifficulty(complexity, minBits); err != nil {
+		return err
+	}
+	h.log.Warn("difficulty changed", "complexity", complexity, "min bits", minBits, "source", "control")
+
+	return nil
+}
+
+// reply returns the reply to a command which result is an error.
+func reply(err error) string {
+	if err != nil {
+		return "error: " + err.Error()
+	}
+
+	return "ok"
+}
+
+// ServeConn executes commands read from a connection line by line and writes their replies,
+// until the client quits or the connection is closed.
+func (h *Handler) ServeConn(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.EqualFold(line, "quit") {
+			return
+		}
+
+		if _, err := fmt.Fprintln(conn, h.Exec(line)); err != nil {
+			h.log.Error(err, "action", "write control reply")
+			return
+		}
+	}
+}
+
+// Serve accepts control connections on the listener and serves them (see ServeConn) until the listener is closed.
+func (h *Handler) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("accept control connection: %w", err)
+		}
+
+		go h.ServeConn(conn)
+	}
+}
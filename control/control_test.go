@@ -0,0 +1,129 @@
+package control
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/laonix/pow-word-of-wisdom/logger"
+	"github.com/laonix/pow-word-of-wisdom/metrics"
+)
+
+// bounds is a Difficulty requiring minBits to be positive and less than complexity.
+type bounds struct {
+	complexity, minBits int
+}
+
+func (b *bounds) Difficulty() (int, int) {
+	return b.complexity, b.minBits
+}
+
+func (b *bounds) SetDifficulty(complexity, minBits int) error {
+	if minBits < 1 || complexity <= minBits {
+		return fmt.Errorf("invalid bounds [%d, %d)", minBits, complexity)
+	}
+
+	b.complexity, b.minBits = complexity, minBits
+
+	return nil
+}
+
+// levelSetter records the level it's set to.
+type levelSetter struct {
+	level logger.Level
+}
+
+func (s *levelSetter) SetLevel(level logger.Level) {
+	s.level = level
+}
+
+func TestHandler_Exec(t *testing.T) {
+	difficulty := &bounds{complexity: 20, minBits: 10}
+	level := &levelSetter{level: logger.LevelInfo}
+	quotes := metrics.NewCounter()
+	quotes.Add(42)
+
+	h := NewHandler(Settings{
+		Difficulty:  difficulty,
+		Level:       level,
+		ActiveConns: func() int { return 3 },
+		Quotes:      quotes,
+	}, logger.NewNopLogger())
+
+	assert.Equal(t, "active_conns=3 quotes_served=42 complexity=20 min_bits=10", h.Exec("stats"))
+
+	assert.Equal(t, "ok", h.Exec("setlevel debug"))
+	assert.Equal(t, logger.LevelDebug, level.level)
+
+	assert.Equal(t, "ok", h.Exec("setcomplexity 25"))
+	assert.Equal(t, "ok", h.Exec("SETMINBITS 12"))
+	assert.Equal(t, &bounds{complexity: 25, minBits: 12}, difficulty)
+	assert.Equal(t, "active_conns=3 quotes_served=42 complexity=25 min_bits=12", h.Exec("stats"))
+
+	// invalid commands don't change the state
+	for command, want := range map[string]string{
+		"":                  "error: empty command",
+		"restart":           `error: unknown command "restart", see help`,
+		"setlevel verbose":  `error: unknown logging level "verbose"`,
+		"setlevel":          "error: usage: setlevel <error|warn|info|debug>",
+		"setcomplexity":     "error: usage: setcomplexity <bits>",
+		"setcomplexity ten": `error: invalid bits "ten"`,
+		"setminbits 30":     "error: invalid bounds [30, 25)",
+	} {
+		assert.Equal(t, want, h.Exec(command), command)
+	}
+	assert.Equal(t, logger.LevelDebug, level.level)
+	assert.Equal(t, &bounds{complexity: 25, minBits: 12}, difficulty)
+}
+
+func TestHandler_Exec_not_set(t *testing.T) {
+	h := NewHandler(Settings{}, logger.NewNopLogger())
+
+	assert.Equal(t, "", h.Exec("stats"))
+	assert.Equal(t, "error: logging level cannot be changed", h.Exec("setlevel debug"))
+	assert.Equal(t, "error: difficulty cannot be changed", h.Exec("setcomplexity 25"))
+}
+
+func TestHandler_Serve(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	difficulty := &bounds{complexity: 20, minBits: 10}
+	h := NewHandler(Settings{Difficulty: difficulty}, logger.NewNopLogger())
+
+	served := make(chan error, 1)
+	go func() {
+		served <- h.Serve(l)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	replies := bufio.NewScanner(conn)
+	for _, exchange := range []struct{ command, reply string }{
+		{command: "setcomplexity 25", reply: "ok"},
+		{command: "stats", reply: "complexity=25 min_bits=10"},
+	} {
+		_, err := fmt.Fprintln(conn, exchange.command)
+		assert.Nil(t, err)
+		assert.True(t, replies.Scan())
+		assert.Equal(t, exchange.reply, replies.Text())
+	}
+
+	// the connection is closed on quit
+	_, err = fmt.Fprintln(conn, "quit")
+	assert.Nil(t, err)
+	assert.False(t, replies.Scan())
+
+	// closing the listener stops serving
+	assert.Nil(t, l.Close())
+	assert.Nil(t, <-served)
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/laonix/pow-word-of-wisdom/pow"
+	"github.com/laonix/pow-word-of-wisdom/protocol"
+)
+
+// AuditRecord is a record of an accepted PoW result.
+type AuditRecord struct {
+	Time     time.Time `json:"time"`
+	Bits     uint      `json:"bits"`
+	Resource string    `json:"resource"`
+	RemoteIP string    `json:"remote_ip"`
+	// SolveDuration is a time from sending the challenge to receiving its result;
+	// it's zero for a result calculated in advance (see protocol.CapabilityNextChallenge).
+	SolveDuration time.Duration `json:"solve_duration"`
+	// HeaderHash is a hex-encoded SHA-256 hash of the result header, so the header itself is not exposed.
+	HeaderHash string `json:"header_hash"`
+}
+
+// Auditor is a contract to record accepted PoW results to an audit sink separate from operational logs.
+type Auditor interface {
+	Audit(record AuditRecord) error
+}
+
+// AuditFormat is a format of audit records written by WriterAuditor.
+type AuditFormat int
+
+const (
+	// AuditFormatJSON formats a record as a JSON object per line.
+	AuditFormatJSON AuditFormat = iota
+	// AuditFormatText formats a record as space-separated key=value pairs per line.
+	AuditFormatText
+)
+
+// AuditFormatOf returns an AuditFormat corresponding to an argument string: "text" for AuditFormatText,
+// AuditFormatJSON otherwise.
+func AuditFormatOf(format string) AuditFormat {
+	if strings.ToLower(format) == "text" {
+		return AuditFormatText
+	}
+
+	return AuditFormatJSON
+}
+
+// WriterAuditor implements Auditor to write audit records to an io.Writer (e.g. a dedicated file), a record per line.
+type WriterAuditor struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format AuditFormat
+}
+
+// NewWriterAuditor returns a new instance of WriterAuditor.
+func NewWriterAuditor(w io.Writer, format AuditFormat) *WriterAuditor {
+	return &WriterAuditor{w: w, format: format}
+}
+
+// Audit writes a record in the auditor format.
+func (a *WriterAuditor) Audit(record AuditRecord) error {
+	var line []byte
+	switch a.format {
+	case AuditFormatText:
+		line = []byte(fmt.Sprintf("time=%s bits=%d resource=%s remote_ip=%s solve_duration=%s header_hash=%s",
+			record.Time.Format(time.RFC3339Nano), record.Bits, record.Resource, record.RemoteIP,
+			record.SolveDuration, record.HeaderHash))
+	default:
+		b, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("marshal audit record: %w", err)
+		}
+		line = b
+	}
+
+	// records are written at once, so concurrent records are not interleaved
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write audit record: %w", err)
+	}
+
+	return nil
+}
+
+// auditRecords returns audit records of an accepted PoW result: a single header or a batch of them.
+func auditRecords(result, remoteIP string, solved time.Duration, now time.Time) []AuditRecord {
+	headers := []string{result}
+	if proof, ok := protocol.ParseBatchProof([]byte(result)); ok {
+		headers = proof.Proofs
+	}
+
+	records := make([]AuditRecord, 0, len(headers))
+	for _, header := range headers {
+		parsed, err := pow.ParseHeaderString(header)
+		if err != nil {
+			continue // an accepted result is always parsable
+		}
+
+		hash := sha256.Sum256([]byte(header))
+		records = append(records, AuditRecord{
+			Time:          now,
+			Bits:          parsed.Bits(),
+			Resource:      parsed.Resource(),
+			RemoteIP:      remoteIP,
+			SolveDuration: solved,
+			HeaderHash:    hex.EncodeToString(hash[:]),
+		})
+	}
+
+	return records
+}
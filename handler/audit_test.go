@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/laonix/pow-word-of-wisdom/handler/mocks"
+	"github.com/laonix/pow-word-of-wisdom/pow"
+	"github.com/laonix/pow-word-of-wisdom/tcp"
+)
+
+func TestWriterAuditor_Audit(t *testing.T) {
+	record := AuditRecord{
+		Time:          time.Date(2022, time.August, 8, 21, 21, 0, 0, time.UTC),
+		Bits:          12,
+		Resource:      "resource",
+		RemoteIP:      "10.0.0.1",
+		SolveDuration: 1500 * time.Millisecond,
+		HeaderHash:    "abcdef",
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var sink bytes.Buffer
+		assert.Nil(t, NewWriterAuditor(&sink, AuditFormatOf("json")).Audit(record))
+
+		assert.JSONEq(t, `{"time":"2022-08-08T21:21:00Z","bits":12,"resource":"resource","remote_ip":"10.0.0.1",`+
+			`"solve_duration":1500000000,"header_hash":"abcdef"}`, sink.String())
+		assert.True(t, bytes.HasSuffix(sink.Bytes(), []byte("\n")))
+	})
+
+	t.Run("text", func(t *testing.T) {
+		var sink bytes.Buffer
+		assert.Nil(t, NewWriterAuditor(&sink, AuditFormatOf("text")).Audit(record))
+
+		assert.Equal(t, "time=2022-08-08T21:21:00Z bits=12 resource=resource remote_ip=10.0.0.1 "+
+			"solve_duration=1.5s header_hash=abcdef\n", sink.String())
+	})
+}
+
+func TestProofOfWork_ServeTCP_audit(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	var sink bytes.Buffer
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.FixedChallenge(challengeStr),
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+		Auditor:    NewWriterAuditor(&sink, AuditFormatJSON),
+	}
+
+	conn := mocks.NewConn(t)
+	conn.On("Close").Return(nil)
+	conn.On("RemoteAddr").Return(func() net.Addr { return &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4242} })
+	onReadFrame(conn, "ping")
+	conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+	onReadFrame(conn, calculatedStr)
+
+	mockHandler := mocks.NewHandler(t)
+	mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(1).(tcp.Conn).Close()
+	}).Once()
+
+	handler := newProofOfWork(t, mockHandler, settings, setupLogMock(t))
+	handler.ServeTCP(context.Background(), conn)
+
+	var record AuditRecord
+	assert.Nil(t, json.Unmarshal(sink.Bytes(), &record))
+
+	hash := sha256.Sum256([]byte(calculatedStr))
+	assert.EqualValues(t, 12, record.Bits)
+	assert.Equal(t, "d778f1e9-d0a8-485e-ab51-053a12e9b397", record.Resource)
+	assert.Equal(t, "10.0.0.1", record.RemoteIP)
+	assert.Equal(t, hex.EncodeToString(hash[:]), record.HeaderHash)
+	assert.GreaterOrEqual(t, record.SolveDuration, time.Duration(0))
+	assert.WithinDuration(t, time.Now(), record.Time, time.Minute)
+}
+
+func TestProofOfWork_ServeTCP_audit_failed(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyNw=="
+
+	var sink bytes.Buffer
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.FixedChallenge(challengeStr),
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+		Auditor:    NewWriterAuditor(&sink, AuditFormatJSON),
+	}
+
+	conn := setupConnMock(t)
+	onReadFrame(conn, "ping")
+	conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+	onReadFrame(conn, calculatedStr)
+	conn.On("Write", frame("PoW verification failed")).Return(0, nil).Once()
+
+	handler := newProofOfWork(t, mocks.NewHandler(t), settings, setupLogMock(t))
+	handler.ServeTCP(context.Background(), conn)
+
+	// rejected results are not audited
+	assert.Empty(t, sink.String())
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+
+	"github.com/laonix/pow-word-of-wisdom/protocol"
+)
+
+// ErrUnauthenticated is returned by an Authenticator when a client presents invalid credentials.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Authenticator is a contract to authenticate a client by its initial message before PoW.
+type Authenticator interface {
+	// Authenticate returns an identity of a client presenting valid credentials,
+	// or an empty identity if the message holds no credentials at all (an anonymous client).
+	// It returns an error if the client must be rejected.
+	Authenticate(message []byte) (identity string, err error)
+}
+
+// APIKeyAuthenticator implements Authenticator to authenticate clients by API keys (see protocol.Request.APIKey).
+type APIKeyAuthenticator struct {
+	// keys holds API keys by identities
+	keys map[string]string
+}
+
+// NewAPIKeyAuthenticator returns a new instance of APIKeyAuthenticator accepting API keys of the identities.
+func NewAPIKeyAuthenticator(keys map[string]string) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{keys: keys}
+}
+
+// Authenticate returns an identity the API key presented in the initial message belongs to.
+//
+// A message holding no API key belongs to an anonymous client.
+// If the API key is unknown, it returns ErrUnauthenticated.
+func (a *APIKeyAuthenticator) Authenticate(message []byte) (string, error) {
+	// an invalid request holds no API key
+	request, _ := protocol.ParseRequest(message)
+	key := request.APIKey
+	if key == "" {
+		return "", nil
+	}
+
+	// every key is compared in constant time, so the time taken doesn't reveal a matching prefix
+	var identity string
+	for id, k := range a.keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			identity = id
+		}
+	}
+
+	if identity == "" {
+		return "", ErrUnauthenticated
+	}
+
+	return identity, nil
+}
+
+type identityKey struct{}
+
+// identityFrom returns an identity of an authenticated client passed within the context.
+func identityFrom(ctx context.Context) (string, bool) {
+	identity, ok := ctx.Value(identityKey{}).(string)
+	return identity, ok
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyAuthenticator_Authenticate(t *testing.T) {
+	auth := NewAPIKeyAuthenticator(map[string]string{
+		"alice": "key-1",
+		"bob":   "key-2",
+	})
+
+	tests := []struct {
+		name     string
+		message  string
+		identity string
+		err      error
+	}{
+		{name: "valid key", message: `{"api_key":"key-2"}`, identity: "bob"},
+		{name: "invalid key", message: `{"api_key":"key-3"}`, err: ErrUnauthenticated},
+		{name: "key prefix", message: `{"api_key":"key"}`, err: ErrUnauthenticated},
+		{name: "no key", message: `{"capabilities":["batch"],"count":2}`},
+		{name: "legacy ping", message: "ping"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identity, err := auth.Authenticate([]byte(test.message))
+			assert.ErrorIs(t, err, test.err)
+			assert.Equal(t, test.identity, identity)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/laonix/pow-word-of-wisdom/pow"
+)
+
+// ErrVerifyBudgetExceeded is returned when the time spent on verifying PoW results
+// submitted over a single connection exceeds the budget (see ProofOfWorkSettings.VerifyBudget).
+var ErrVerifyBudgetExceeded = errors.New("verification budget exceeded")
+
+// verifyBudget tracks the time spent on verifying PoW results submitted over a single connection.
+//
+// A verification in progress cannot be interrupted, so the budget is checked before and after every verification:
+// the connection may overrun the budget by a single verification at most.
+type verifyBudget struct {
+	verify pow.ReasonFunc
+	// limit is the time allowed to be spent on verification, unlimited if it's not positive
+	limit time.Duration
+	spent time.Duration
+	now   func() time.Time
+}
+
+// newVerifyBudget returns a new verification budget for a connection.
+func (h *ProofOfWork) newVerifyBudget() *verifyBudget {
+	return &verifyBudget{verify: h.verify, limit: h.verifyBudget, now: h.now}
+}
+
+// check verifies a PoW calculation result charging the budget with the time spent on it.
+//
+// It returns an error matching ErrVerifyBudgetExceeded once the budget is exhausted.
+func (b *verifyBudget) check(calculated, challenge string) (pow.Reason, error) {
+	if b.exceeded() {
+		return pow.ReasonFailed, b.err()
+	}
+
+	start := b.now()
+	reason, err := b.verify(calculated, challenge)
+	b.spent += b.now().Sub(start)
+
+	if err == nil && b.exceeded() {
+		return pow.ReasonFailed, b.err()
+	}
+
+	return reason, err
+}
+
+func (b *verifyBudget) exceeded() bool {
+	return b.limit > 0 && b.spent > b.limit
+}
+
+func (b *verifyBudget) err() error {
+	return fmt.Errorf("%w: spent %s of %s", ErrVerifyBudgetExceeded, b.spent, b.limit)
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/laonix/pow-word-of-wisdom/handler/mocks"
+	"github.com/laonix/pow-word-of-wisdom/pow"
+	"github.com/laonix/pow-word-of-wisdom/tcp"
+)
+
+// slowVerify returns a pow.ReasonFunc accepting any result after spending the duration.
+func slowVerify(d time.Duration) pow.ReasonFunc {
+	return func(_, _ string) (pow.Reason, error) {
+		time.Sleep(d)
+		return pow.ReasonValid, nil
+	}
+}
+
+func TestVerifyBudget_check(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	verify := func(_, _ string) (pow.Reason, error) {
+		now = now.Add(10 * time.Millisecond)
+		return pow.ReasonValid, nil
+	}
+
+	t.Run("unlimited", func(t *testing.T) {
+		budget := &verifyBudget{verify: verify, now: clock}
+		for i := 0; i < 100; i++ {
+			reason, err := budget.check("calculated", "challenge")
+			assert.Nil(t, err)
+			assert.Equal(t, pow.ReasonValid, reason)
+		}
+	})
+
+	t.Run("limited", func(t *testing.T) {
+		budget := &verifyBudget{verify: verify, limit: 25 * time.Millisecond, now: clock}
+
+		for i := 0; i < 2; i++ {
+			reason, err := budget.check("calculated", "challenge")
+			assert.Nil(t, err)
+			assert.Equal(t, pow.ReasonValid, reason)
+		}
+
+		// the third verification overruns the budget
+		reason, err := budget.check("calculated", "challenge")
+		assert.ErrorIs(t, err, ErrVerifyBudgetExceeded)
+		assert.Equal(t, pow.ReasonFailed, reason)
+
+		// nothing is verified once the budget is exhausted
+		spent := budget.spent
+		_, err = budget.check("calculated", "challenge")
+		assert.ErrorIs(t, err, ErrVerifyBudgetExceeded)
+		assert.Equal(t, spent, budget.spent)
+	})
+}
+
+func TestProofOfWork_ServeTCP_verify_budget(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	request := `{"capabilities":["batch"],"count":3}`
+	batch := `{"challenges":["` + challengeStr + `","` + challengeStr + `","` + challengeStr + `"]}`
+	proofs := `{"proofs":["a","b","c"]}`
+
+	tests := []struct {
+		name   string
+		budget time.Duration
+		passed bool
+	}{
+		{name: "within budget", budget: 1 * time.Second, passed: true},
+		{name: "budget exceeded", budget: 15 * time.Millisecond},
+		{name: "unlimited", passed: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := ProofOfWorkSettings{
+				Challenge:    pow.FixedChallenge(challengeStr),
+				VerifyReason: slowVerify(10 * time.Millisecond),
+				VerifyBudget: test.budget,
+				Complexity:   20,
+				WaitPOW:      1 * time.Minute,
+				MaxBatch:     3,
+			}
+
+			mockHandler := mocks.NewHandler(t)
+			if test.passed {
+				mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+					args.Get(1).(tcp.Conn).Close()
+				}).Once()
+			}
+
+			conn := setupConnMock(t)
+			onReadFrame(conn, request)
+			conn.On("Write", frame(batch)).Return(len(frame(batch)), nil).Once()
+			onReadFrame(conn, proofs)
+			if !test.passed {
+				conn.On("Write", frame("verification budget exceeded")).Return(0, nil).Once()
+			}
+
+			handler := newProofOfWork(t, mockHandler, settings, setupLogMock(t))
+			handler.ServeTCP(context.Background(), conn)
+
+			conn.AssertCalled(t, "Close")
+		})
+	}
+}
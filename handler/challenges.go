@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+// ChallengeStore is a contract to hold issued challenges until they are redeemed or expired,
+// so a challenge can be issued and verified apart (e.g. over different connections) and redeemed only once.
+type ChallengeStore interface {
+	// Put stores a challenge by its id until expiry.
+	Put(id, challenge string, expiry time.Time)
+	// Take removes a challenge from the store and returns it.
+	//
+	// It returns false if the challenge has never been stored, has already been taken, or has expired.
+	Take(id string) (challenge string, ok bool)
+}
+
+// MemoryChallengeStore is an in-memory implementation of ChallengeStore.
+//
+// Expired challenges are dropped as new ones are stored, so abandoned ones don't pile up.
+type MemoryChallengeStore struct {
+	mu         sync.Mutex
+	challenges map[string]storedChallenge
+	now        func() time.Time
+}
+
+type storedChallenge struct {
+	challenge string
+	expiry    time.Time
+}
+
+// NewMemoryChallengeStore returns a new instance of MemoryChallengeStore.
+func NewMemoryChallengeStore() *MemoryChallengeStore {
+	return &MemoryChallengeStore{challenges: make(map[string]storedChallenge), now: time.Now}
+}
+
+// Put stores a challenge by its id until expiry.
+func (s *MemoryChallengeStore) Put(id, challenge string, expiry time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// drop expired challenges, so abandoned ones don't pile up
+	now := s.now()
+	for i, c := range s.challenges {
+		if now.After(c.expiry) {
+			delete(s.challenges, i)
+		}
+	}
+
+	s.challenges[id] = storedChallenge{challenge: challenge, expiry: expiry}
+}
+
+// Take removes a challenge from the store and returns it.
+//
+// It returns false if the challenge has never been stored, has already been taken, or has expired.
+func (s *MemoryChallengeStore) Take(id string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.challenges[id]
+	if !ok {
+		return "", false
+	}
+	delete(s.challenges, id)
+
+	if s.now().After(c.expiry) {
+		return "", false
+	}
+
+	return c.challenge, true
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryChallengeStore_Take(t *testing.T) {
+	store := NewMemoryChallengeStore()
+	store.Put("id", "challenge", time.Now().Add(time.Minute))
+
+	challenge, ok := store.Take("id")
+	assert.True(t, ok)
+	assert.Equal(t, "challenge", challenge)
+
+	// a challenge is taken only once
+	_, ok = store.Take("id")
+	assert.False(t, ok)
+
+	// a challenge never stored cannot be taken
+	_, ok = store.Take("unknown")
+	assert.False(t, ok)
+}
+
+func TestMemoryChallengeStore_expiry(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryChallengeStore()
+	store.now = func() time.Time { return now }
+
+	store.Put("expiring", "challenge 1", now.Add(time.Minute))
+	store.Put("lasting", "challenge 2", now.Add(time.Hour))
+
+	now = now.Add(time.Minute + time.Second)
+
+	_, ok := store.Take("expiring")
+	assert.False(t, ok)
+
+	challenge, ok := store.Take("lasting")
+	assert.True(t, ok)
+	assert.Equal(t, "challenge 2", challenge)
+}
+
+func TestMemoryChallengeStore_Put_drops_expired(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryChallengeStore()
+	store.now = func() time.Time { return now }
+
+	store.Put("abandoned", "challenge 1", now.Add(time.Minute))
+
+	now = now.Add(time.Hour)
+	store.Put("fresh", "challenge 2", now.Add(time.Minute))
+
+	assert.Len(t, store.challenges, 1)
+	assert.Contains(t, store.challenges, "fresh")
+}
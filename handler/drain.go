@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"sync"
+)
+
+// drainer tracks in-flight flows and allows to stop accepting new ones.
+type drainer struct {
+	mu       sync.Mutex
+	draining bool
+	active   int
+	// idle is closed once draining has started and no flows are in flight
+	idle chan struct{}
+}
+
+func newDrainer() *drainer {
+	return &drainer{idle: make(chan struct{})}
+}
+
+// enter registers a new in-flight flow.
+//
+// It returns false if draining has started, so the flow must not be started.
+func (d *drainer) enter() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.draining {
+		return false
+	}
+	d.active++
+
+	return true
+}
+
+// leave unregisters an in-flight flow.
+func (d *drainer) leave() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.active--
+	if d.draining && d.active == 0 {
+		close(d.idle)
+	}
+}
+
+// drain stops accepting new flows and waits for in-flight ones to complete or for the context to be done.
+func (d *drainer) drain(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.draining {
+		d.draining = true
+		if d.active == 0 {
+			close(d.idle)
+		}
+	}
+	d.mu.Unlock()
+
+	select {
+	case <-d.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isDraining checks if draining has started.
+func (d *drainer) isDraining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.draining
+}
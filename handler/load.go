@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+// LoadTracker is a contract to track the server load challenge difficulty adapts to.
+type LoadTracker interface {
+	// Track records a newly accepted connection.
+	Track()
+	// Load returns the current server load in interval [0, 1], where 0 is an idle server and 1 is a fully loaded one.
+	Load() float64
+}
+
+// ConnectionRate implements LoadTracker
+// to measure the server load as the number of connections accepted within a sliding window.
+//
+// The load grows linearly with the number of connections and reaches 1 at the saturation number.
+type ConnectionRate struct {
+	mu         sync.Mutex
+	saturation int
+	// counts holds numbers of connections per second of the window,
+	// and seconds holds the unix time each count belongs to
+	counts  []int
+	seconds []int64
+
+	now func() time.Time
+}
+
+// NewConnectionRate returns a new instance of ConnectionRate
+// considering the server fully loaded once saturation connections have been accepted within the window.
+//
+// The window is rounded up to whole seconds.
+func NewConnectionRate(window time.Duration, saturation int) *ConnectionRate {
+	size := int((window + time.Second - 1) / time.Second)
+	if size < 1 {
+		size = 1
+	}
+	if saturation < 1 {
+		saturation = 1
+	}
+
+	return &ConnectionRate{
+		saturation: saturation,
+		counts:     make([]int, size),
+		seconds:    make([]int64, size),
+		now:        time.Now,
+	}
+}
+
+// Track records a newly accepted connection.
+func (r *ConnectionRate) Track() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	second := r.now().Unix()
+	i := int(second % int64(len(r.counts)))
+
+	// the slot holds a count of a second which has left the window
+	if r.seconds[i] != second {
+		r.seconds[i] = second
+		r.counts[i] = 0
+	}
+	r.counts[i]++
+}
+
+// Load returns the number of connections accepted within the window relative to the saturation number, up to 1.
+func (r *ConnectionRate) Load() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	second := r.now().Unix()
+	window := int64(len(r.counts))
+
+	var total int
+	for i, count := range r.counts {
+		if second-r.seconds[i] < window {
+			total += count
+		}
+	}
+
+	if total >= r.saturation {
+		return 1
+	}
+
+	return float64(total) / float64(r.saturation)
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionRate_Load(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	rate := NewConnectionRate(10*time.Second, 100)
+	rate.now = func() time.Time { return now }
+
+	assert.Equal(t, 0.0, rate.Load())
+
+	for i := 0; i < 50; i++ {
+		rate.Track()
+	}
+	assert.Equal(t, 0.5, rate.Load())
+
+	// connections within the window add up
+	now = now.Add(5 * time.Second)
+	for i := 0; i < 25; i++ {
+		rate.Track()
+	}
+	assert.Equal(t, 0.75, rate.Load())
+
+	// the load is capped
+	for i := 0; i < 100; i++ {
+		rate.Track()
+	}
+	assert.Equal(t, 1.0, rate.Load())
+
+	// connections which have left the window are not counted
+	now = now.Add(5 * time.Second)
+	assert.Equal(t, 1.0, rate.Load())
+	now = now.Add(5 * time.Second)
+	assert.Equal(t, 0.0, rate.Load())
+}
@@ -6,6 +6,8 @@ import (
 	net "net"
 
 	mock "github.com/stretchr/testify/mock"
+
+	time "time"
 )
 
 // Conn is an autogenerated mock type for the Conn type
@@ -66,6 +68,20 @@ func (_m *Conn) RemoteAddr() net.Addr {
 	return r0
 }
 
+// SetDeadline provides a mock function with given fields: t
+func (_m *Conn) SetDeadline(t time.Time) error {
+	ret := _m.Called(t)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(time.Time) error); ok {
+		r0 = rf(t)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Write provides a mock function with given fields: b
 func (_m *Conn) Write(b []byte) (int, error) {
 	ret := _m.Called(b)
@@ -9,6 +9,22 @@ type WordOfWisdom struct {
 	mock.Mock
 }
 
+// Categories provides a mock function with given fields:
+func (_m *WordOfWisdom) Categories() []string {
+	ret := _m.Called()
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func() []string); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	return r0
+}
+
 // Quote provides a mock function with given fields:
 func (_m *WordOfWisdom) Quote() (string, error) {
 	ret := _m.Called()
@@ -30,6 +46,27 @@ func (_m *WordOfWisdom) Quote() (string, error) {
 	return r0, r1
 }
 
+// QuoteByCategory provides a mock function with given fields: category
+func (_m *WordOfWisdom) QuoteByCategory(category string) (string, error) {
+	ret := _m.Called(category)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string) string); ok {
+		r0 = rf(category)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(category)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 type mockConstructorTestingTNewWordOfWisdom interface {
 	mock.TestingT
 	Cleanup(func())
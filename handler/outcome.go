@@ -0,0 +1,48 @@
+package handler
+
+import "context"
+
+// Outcome is a typed outcome of the PoW flow of a single connection (see ProofOfWorkSettings.OnOutcome).
+type Outcome int
+
+const (
+	// OutcomePassed means the client has passed PoW verification.
+	OutcomePassed Outcome = iota + 1
+	// OutcomeVerificationFailed means the client's PoW result has failed the verification
+	// (or the verification budget has been exceeded).
+	OutcomeVerificationFailed
+	// OutcomeTimedOut means the client hasn't sent a message in time.
+	OutcomeTimedOut
+	// OutcomeReadError means a message couldn't be read from the client (e.g. the client has gone).
+	OutcomeReadError
+	// OutcomeRateLimited means the client has exceeded the rate limit and hasn't been challenged.
+	OutcomeRateLimited
+)
+
+// String returns a metrics-friendly name of the outcome, e.g. "verification_failed".
+func (o Outcome) String() string {
+	switch o {
+	case OutcomePassed:
+		return "passed"
+	case OutcomeVerificationFailed:
+		return "verification_failed"
+	case OutcomeTimedOut:
+		return "timed_out"
+	case OutcomeReadError:
+		return "read_error"
+	case OutcomeRateLimited:
+		return "rate_limited"
+	default:
+		return "unknown"
+	}
+}
+
+// OutcomeFunc is called with the outcome of the PoW flow of a connection (see ProofOfWorkSettings.OnOutcome).
+type OutcomeFunc func(ctx context.Context, outcome Outcome)
+
+// record passes the outcome of the PoW flow to the outcome hook, if it's set.
+func (h *ProofOfWork) record(ctx context.Context, outcome Outcome) {
+	if h.onOutcome != nil {
+		h.onOutcome(ctx, outcome)
+	}
+}
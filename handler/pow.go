@@ -2,17 +2,21 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"math/rand"
 	"net"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/laonix/pow-word-of-wisdom/logger"
+	"github.com/laonix/pow-word-of-wisdom/metrics"
 	"github.com/laonix/pow-word-of-wisdom/pow"
+	"github.com/laonix/pow-word-of-wisdom/protocol"
 	"github.com/laonix/pow-word-of-wisdom/tcp"
 )
 
@@ -20,10 +24,71 @@ import (
 // to perform proof of work check before handing over control to the next tcp.Handler.
 type ProofOfWork struct {
 	challenge pow.ChallengeFunc
-	verify    pow.VerifyFunc
+	verify    pow.ReasonFunc
+	resource  ResourceFunc
+	// verifyBudget is the time allowed to be spent on verification per connection, unlimited if it's not positive
+	verifyBudget time.Duration
 
-	complexity int
-	waitPOW    time.Duration
+	// bounds holds the difficulty of challenges, replaced at once by SetDifficulty
+	bounds    atomic.Value
+	waitPOW   time.Duration
+	waitQuote time.Duration
+	// challengeTTL is the time a challenge is valid for since it's issued, unlimited if it's not positive
+	challengeTTL time.Duration
+
+	// issueNext flags to issue a next challenge along with a quote to clients supporting it
+	issueNext bool
+	next      ChallengeStore
+
+	drainer *drainer
+
+	// limiter throttles challenges issued per remote IP, if set
+	limiter *rateLimiter
+	// load raises the lower bound of challenge bits as the server load grows, if set
+	load LoadTracker
+	// warmUpUntil is the time the load starts affecting challenge bits at, see WarmUp
+	warmUpUntil time.Time
+	now         func() time.Time
+
+	// auth authenticates clients before PoW, if set
+	auth Authenticator
+	// authReduced flags to challenge authenticated clients with a reduced difficulty instead of skipping PoW
+	authReduced bool
+
+	// minNegotiatedBits is the lowest challenge bits a client may negotiate, negotiation is disabled if it's not set
+	minNegotiatedBits int
+
+	// hashRate is the number of hashes per second of a reference client challenge solve time is estimated for
+	hashRate float64
+	// hint is a human-readable message sent along with challenges to clients declaring protocol.CapabilityEstimate
+	hint string
+
+	// bits records the distribution of issued challenge bits, if set
+	bits *metrics.Histogram
+	// auditor records accepted PoW results, if set
+	auditor Auditor
+
+	// served and failures count passed and failed PoW verifications, if set
+	served   *metrics.Counter
+	failures *metrics.Counter
+	// prometheus collects PoW verification outcomes and wait times, if set
+	prometheus *metrics.Prometheus
+	// onOutcome is called with the outcome of the PoW flow, if set
+	onOutcome OutcomeFunc
+	// algorithm is advertised in protocol.Hello
+	algorithm pow.Algorithm
+
+	maxBatch int
+
+	// categories returns quote categories listed in the catalog, catalog requests are ignored if it's not set
+	categories func() []string
+	// catalogAfterPoW flags to send the catalog only to clients which have passed PoW
+	catalogAfterPoW bool
+
+	// tokens mint difficulty tokens for clients which have passed PoW verification, if enabled
+	tokens          *difficultyTokens
+	tokenTTL        time.Duration
+	tokenComplexity int
 
 	handler tcp.Handler
 	log     logger.Logger
@@ -33,26 +98,239 @@ type ProofOfWork struct {
 // such as methods to create PoW challenge header and to verify client's calculation,
 // challenge complexity, and PoW calculation result waiting time.
 type ProofOfWorkSettings struct {
+	// Scheme is the PoW algorithm challenges are issued and verified with, pow.Hashcash if it's not set.
+	// Challenge and Verify take precedence over it, if they are set.
+	//
+	// Some features are specific to Hashcash and don't apply to other schemes:
+	// hex encoding (protocol.CapabilityHex), ChallengeTTL and audit records (see Auditor).
+	Scheme    pow.Scheme
 	Challenge pow.ChallengeFunc
 	Verify    pow.VerifyFunc
+	// Algorithm is the hash algorithm of issued challenges advertised to clients in protocol.Hello.
+	// It doesn't affect the challenges themselves (see Challenge).
+	Algorithm pow.Algorithm
+	// Resource derives a challenge resource from a client connection (e.g. see RemoteIPResource), if it's set.
+	// Otherwise, a challenge resource is a random UUID string.
+	Resource ResourceFunc
+	// VerifyReason verifies a PoW calculation result telling the reason of a failure, if it's set,
+	// so the client is informed why its result has been rejected (e.g. "wrong challenge").
+	// It takes precedence over Verify.
+	VerifyReason pow.ReasonFunc
+	// VerifyBudget is the time allowed to be spent on verifying PoW results submitted over a single connection
+	// (e.g. a batch of results), if it's set. A client exceeding it is informed and disconnected.
+	VerifyBudget time.Duration
 
 	// Complexity is an upper limit for a randomly generated challenge header bits.
 	//
-	// Bits should vary in interval [10, Complexity).
+	// Bits should vary in interval [MinBits, Complexity).
 	Complexity int
-	WaitPOW    time.Duration
+	// MinBits is a lower limit for a randomly generated challenge header bits, DefaultMinBits if it's not set.
+	// It must be less than Complexity.
+	MinBits int
+	// WaitPOW is a time limit for a client to send a PoW result for a challenge issued over the connection.
+	WaitPOW time.Duration
+	// ChallengeTTL is the time a challenge is valid for since it's issued, if it's set:
+	// a result for a challenge with an older header date fails the verification with pow.ReasonExpired.
+	//
+	// Unlike WaitPOW, it bounds the challenge itself rather than the wait for its result,
+	// so slow clients may be given more time without keeping proofs replayable for long.
+	// A challenge date is precise to a minute (see pow.FormatDate), so is the expiry.
+	ChallengeTTL time.Duration
+	// WaitQuote is a time limit for the next handler (e.g. a quote delivery).
+	// Once it's exceeded, the context passed to the next handler is done.
+	// A zero WaitQuote means no limit.
+	WaitQuote time.Duration
+
+	// IssueNextChallenge enables issuing a next challenge along with a quote
+	// to clients declaring protocol.CapabilityNextChallenge.
+	//
+	// Such a client may solve the next challenge in advance and submit its result with the next request.
+	// A challenge issued in advance is valid for ChallengeTTL duration, if it's set, or WaitPOW duration otherwise.
+	IssueNextChallenge bool
+	// ChallengeStore holds challenges issued in advance until they are redeemed, NewMemoryChallengeStore if it's not set.
+	// A store shared by several servers lets a challenge issued by one of them be redeemed with another, only once.
+	ChallengeStore ChallengeStore
+
+	// TokenSecret is a key to sign difficulty tokens with.
+	// If it's set, clients declaring protocol.CapabilityDifficultyToken get a token along with a quote,
+	// which grants a reduced-difficulty challenge on the next request within TokenTTL.
+	TokenSecret []byte
+	TokenTTL    time.Duration
+	// TokenComplexity is an upper limit for challenge header bits issued for a valid difficulty token
+	// (or to an authenticated client, see AuthenticatedReduced).
+	//
+	// Bits should vary in interval [MinBits, TokenComplexity).
+	TokenComplexity int
+
+	// Bits records the distribution of issued challenge header bits, if it's set.
+	Bits *metrics.Histogram
+	// Auditor records every accepted PoW result to an audit sink, if it's set.
+	Auditor Auditor
+
+	// Served counts clients which have passed PoW verification, if it's set.
+	Served *metrics.Counter
+	// Failures counts clients which have failed PoW verification, if it's set.
+	Failures *metrics.Counter
+	// Prometheus collects PoW verification outcomes (passed, failed or timed out)
+	// and the time clients take to send a PoW result, if it's set.
+	Prometheus *metrics.Prometheus
+	// OnOutcome is called with the outcome of the PoW flow of every connection, if it's set:
+	// the client has passed or failed the verification, timed out, couldn't be read from, or has been rate limited.
+	// Flows ending otherwise (e.g. on shutdown or on an internal error) have no outcome.
+	OnOutcome OutcomeFunc
+
+	// EstimateHashRate is the number of hashes per second of a reference client challenge solve time is estimated for,
+	// DefaultEstimateHashRate if it's not set. A client declaring protocol.CapabilityEstimate gets a challenge
+	// along with its bits and the estimate (see protocol.EstimatedChallenge).
+	EstimateHashRate float64
+	// ChallengeHint is a human-readable message sent along with challenges to clients declaring
+	// protocol.CapabilityEstimate, if it's set (e.g. a grace message telling how long the server waits for a result).
+	ChallengeHint string
+
+	// MaxBatch is an upper limit of quotes a client declaring protocol.CapabilityBatch gets over a single connection.
+	// Batch mode is disabled if MaxBatch is less than 2.
+	MaxBatch int
+
+	// RateLimit is a number of challenges per second issued to a single remote IP in the long run.
+	// RateBurst is a number of challenges a single remote IP may be issued at once.
+	// A client exceeding the limit is informed and disconnected without getting a challenge.
+	// Rate limiting is disabled if RateLimit is not positive.
+	RateLimit float64
+	RateBurst int
+
+	// Load makes challenge difficulty adaptive, if it's set:
+	// every accepted connection is tracked, and the lower bound of challenge header bits
+	// rises from MinBits up to Complexity (or TokenComplexity) as the load grows.
+	Load LoadTracker
+	// WarmUp is a period after the handler creation during which the load is tracked,
+	// while challenges are issued with the baseline difficulty, so adaptive difficulty doesn't misfire
+	// before enough load history is accumulated.
+	WarmUp time.Duration
+
+	// MinNegotiatedBits enables difficulty negotiation, if it's set:
+	// a client declaring protocol.CapabilityNegotiation may propose challenge header bits,
+	// and the server accepts them or counters with the closest bits within [MinNegotiatedBits, Complexity).
+	// The server minimum is enforced regardless: a client cannot negotiate bits below MinBits.
+	// The lower bound rises with the server load, if Load is set.
+	MinNegotiatedBits int
+
+	// Authenticator authenticates clients by the initial message before PoW, if it's set.
+	// A client presenting invalid credentials is rejected without getting a challenge,
+	// while an anonymous client passes PoW as usual.
+	Authenticator Authenticator
+	// AuthenticatedReduced flags to challenge authenticated clients with TokenComplexity (as for a difficulty token).
+	// Otherwise, authenticated clients skip PoW.
+	AuthenticatedReduced bool
+
+	// Categories enables the catalog, if it's set: a client declaring protocol.CapabilityCatalog
+	// gets the capabilities the server supports and the quote categories returned by Categories.
+	Categories func() []string
+	// CatalogAfterPoW flags to send the catalog only along with a quote, once the client has passed PoW,
+	// so unauthenticated scanners learn nothing about the server.
+	// Otherwise, the catalog is sent right away instead of a challenge.
+	CatalogAfterPoW bool
 }
 
+// DefaultMinBits is the default lower limit for challenge header bits (see ProofOfWorkSettings.MinBits):
+// it makes little sense to set fewer bits, since PoW calculation appears too simple.
+const DefaultMinBits = 10
+
+// DefaultEstimateHashRate is the default number of hashes per second of a reference client
+// challenge solve time is estimated for (see ProofOfWorkSettings.EstimateHashRate).
+const DefaultEstimateHashRate = 1e6
+
 // NewProofOfWork returns a new instance of ProofOfWork.
-func NewProofOfWork(handler tcp.Handler, settings ProofOfWorkSettings, log logger.Logger) *ProofOfWork {
-	return &ProofOfWork{
-		handler:    handler,
-		challenge:  settings.Challenge,
-		verify:     settings.Verify,
-		complexity: settings.Complexity,
-		waitPOW:    settings.WaitPOW,
-		log:        log,
+//
+// It returns an error if the settings don't allow any challenge header bits: MinBits must be positive
+// and less than Complexity.
+func NewProofOfWork(handler tcp.Handler, settings ProofOfWorkSettings, log logger.Logger) (*ProofOfWork, error) {
+	bounds, err := newDifficulty(settings.Complexity, settings.MinBits)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &ProofOfWork{
+		handler:   handler,
+		challenge: settings.Challenge,
+		verify:    settings.VerifyReason,
+		resource:  settings.Resource,
+
+		verifyBudget: settings.VerifyBudget,
+		waitPOW:      settings.WaitPOW,
+		challengeTTL: settings.ChallengeTTL,
+		waitQuote:    settings.WaitQuote,
+		issueNext:    settings.IssueNextChallenge,
+		next:         settings.ChallengeStore,
+		drainer:      newDrainer(),
+		bits:         settings.Bits,
+		served:       settings.Served,
+		failures:     settings.Failures,
+		prometheus:   settings.Prometheus,
+		onOutcome:    settings.OnOutcome,
+		algorithm:    settings.Algorithm,
+		auditor:      settings.Auditor,
+		maxBatch:     settings.MaxBatch,
+		load:         settings.Load,
+		now:          time.Now,
+		log:          log,
+
+		minNegotiatedBits: settings.MinNegotiatedBits,
+
+		hashRate: settings.EstimateHashRate,
+		hint:     settings.ChallengeHint,
+
+		auth:        settings.Authenticator,
+		authReduced: settings.AuthenticatedReduced,
+
+		categories:      settings.Categories,
+		catalogAfterPoW: settings.CatalogAfterPoW,
+	}
+
+	h.warmUpUntil = h.now().Add(settings.WarmUp)
+
+	if h.resource == nil {
+		h.resource = RandomResource
+	}
+	if h.hashRate <= 0 {
+		h.hashRate = DefaultEstimateHashRate
 	}
+	scheme := settings.Scheme
+	if scheme == nil {
+		scheme = pow.Hashcash{}
+	}
+	if h.challenge == nil {
+		h.challenge = scheme.Challenge
+	}
+	if h.verify == nil {
+		verify := settings.Verify
+		if verify == nil {
+			verify = scheme.Verify
+		}
+		h.verify = pow.ReasonFuncOf(verify)
+	}
+	if h.challengeTTL > 0 {
+		h.verify = pow.ReasonFuncWithMaxAge(h.verify, h.challengeTTL, func() time.Time { return h.now() })
+	}
+
+	h.bounds.Store(bounds)
+
+	if h.next == nil {
+		h.next = NewMemoryChallengeStore()
+	}
+
+	if settings.RateLimit > 0 {
+		h.limiter = newRateLimiter(settings.RateLimit, settings.RateBurst)
+	}
+
+	if len(settings.TokenSecret) > 0 {
+		h.tokens = newDifficultyTokens(settings.TokenSecret)
+		h.tokenTTL = settings.TokenTTL
+	}
+	if len(settings.TokenSecret) > 0 || settings.AuthenticatedReduced {
+		h.tokenComplexity = settings.TokenComplexity
+	}
+
+	return h, nil
 }
 
 // ServeTCP takes control over a newly accepted connection.
@@ -62,125 +340,1079 @@ func NewProofOfWork(handler tcp.Handler, settings ProofOfWorkSettings, log logge
 // closes the connection.
 // If the received PoW calculation result cannot pass the verification,
 // the handler informs the client about a verification failure and closes the connection.
+//
+// If the initial message holds a result calculated in advance for a challenge issued along with a previous quote,
+// the result is verified right away without issuing a new challenge.
+// If the initial message holds a valid difficulty token, the client is challenged with a reduced difficulty.
+// If the remote IP has exceeded the rate limit, the client is informed and disconnected without getting a challenge.
+// If the context is already done (e.g. on shutdown), the client is informed and disconnected
+// without reading the initial message.
+// If an authenticator is set, a client presenting valid credentials skips PoW (or gets a reduced challenge),
+// and a client presenting invalid ones is rejected without getting a challenge.
+// If the initial message proposes challenge bits, the server accepts or counters them (see MinNegotiatedBits).
+// If the initial message requests batch mode, the client is challenged with a batch of challenges
+// and gets a quote for each of them.
+// If the initial message requests the catalog, it's sent right away without a challenge,
+// unless it's only sent after PoW (see CatalogAfterPoW): then the client is challenged as usual.
 func (h *ProofOfWork) ServeTCP(ctx context.Context, conn tcp.Conn) {
+	log := connLog(ctx, h.log, conn)
+
+	// a context done in advance (e.g. on shutdown) means no work is to be done for the client
+	if rejectDone(ctx, conn, log) {
+		return
+	}
+
 	// read initial message from connection
-	// the message itself doesn't matter, it only flags about the intention to initiate the flow
-	tmp := make([]byte, 1024)
-	tmp, err := conn.Read(tmp)
-	if err != nil && !errors.Is(err, io.EOF) {
-		h.log.Error(err, "action", "read from connection")
-		closeConn(conn, h.log)
+	// the message flags about the intention to initiate the flow and might declare client capabilities
+	tmp, err := tcp.ReadFrame(conn)
+	if errors.Is(err, tcp.ErrMessageTooLarge) {
+		h.record(ctx, OutcomeReadError)
+		rejectTooLarge(ctx, err, conn, log)
+		return
+	}
+	if isTimeout(err) {
+		h.record(ctx, OutcomeTimedOut)
+		dropStalled(err, conn, log)
+		return
+	}
+	if errors.Is(err, tcp.ErrConnClosed) {
+		h.record(ctx, OutcomeReadError)
+		dropClosed(err, conn, log)
+		return
+	}
+	if err != nil {
+		log.Error(err, "action", "read from connection")
+		h.record(ctx, OutcomeReadError)
+		closeConn(conn, log)
+		return
+	}
+
+	log.Info("got message", "message", protocol.RedactedMessage(tmp), "remote", tcp.RemoteAddr(conn))
+
+	// flooding clients are throttled before the server spends anything on them
+	if h.limiter != nil && !h.limiter.allow(tcp.RemoteIP(conn)) {
+		log.Warn("rate limit exceeded", "remote", tcp.RemoteAddr(conn))
+		h.record(ctx, OutcomeRateLimited)
+		writeMessage(ctx, protocol.NewError(protocol.CodeRateLimited, "rate limited"), conn, log)
+		closeConn(conn, log)
+		return
+	}
+
+	if h.load != nil {
+		h.load.Track()
+	}
+
+	// once draining has started no new challenges are issued
+	if !h.drainer.enter() {
+		log.Debug("reject connection while draining", "remote", tcp.RemoteAddr(conn))
+		writeMessage(ctx, protocol.NewError(protocol.CodeShuttingDown, "server is shutting down"), conn, log)
+		closeConn(conn, log)
 		return
 	}
+	defer h.drainer.leave()
 
-	h.log.Info("got message", "message", string(tmp), "remote", conn.RemoteAddr().String())
+	// invalid requests are rejected before any work is done for the client
+	request, err := protocol.ParseRequest(tmp)
+	if err != nil {
+		log.Warn("invalid request", "err", err, "remote", tcp.RemoteAddr(conn))
+		writeMessage(ctx, protocol.NewError(protocol.CodeInvalidRequest, "invalid request"), conn, log)
+		closeConn(conn, log)
+		return
+	}
+	ctx = withEnvelope(ctx, request)
+
+	// the client software is recorded, so every further record of the connection tells it
+	ctx = withClientVersion(ctx, request)
+	log = connLog(ctx, h.log, conn)
+	version, _ := clientVersionFrom(ctx)
+	h.prometheus.ClientVersion(version)
+
+	if !h.handshake(ctx, conn, request) {
+		return
+	}
+	if request.Category != "" {
+		ctx = context.WithValue(ctx, categoryKey{}, request.Category)
+	}
+	if request.Has(protocol.CapabilitySession) {
+		ctx = context.WithValue(ctx, sessionKey{}, true)
+	}
+
+	// authenticated clients skip PoW or get a reduced challenge, and invalid credentials are rejected right away
+	if h.auth != nil {
+		identity, err := h.auth.Authenticate(tmp)
+		if err != nil {
+			log.Warn("authentication failed", "err", err, "remote", tcp.RemoteAddr(conn))
+			writeMessage(ctx, protocol.NewError(protocol.CodeAuthenticationFailed, "authentication failed"), conn, log)
+			closeConn(conn, log)
+			return
+		}
+
+		if identity != "" {
+			log.Info("client authenticated", "identity", identity, "remote", tcp.RemoteAddr(conn))
+			ctx = context.WithValue(ctx, identityKey{}, identity)
+
+			if !h.authReduced {
+				h.serveAuthenticated(ctx, conn, request)
+				return
+			}
+		}
+	}
+
+	if h.categories != nil && request.Has(protocol.CapabilityCatalog) {
+		if !h.catalogAfterPoW {
+			h.serveCatalog(ctx, conn)
+			return
+		}
+		log.Debug("catalog withheld until PoW is passed", "remote", tcp.RemoteAddr(conn))
+	}
+
+	if request.Proof != "" {
+		h.serveSolvedInAdvance(ctx, conn, request)
+		return
+	}
+
+	if count := h.batchCount(request); count > 1 {
+		h.serveBatch(ctx, conn, request, count)
+		return
+	}
 
 	// send PoW challenge header to the client
+	challenge, message, err := h.challengeFor(ctx, request, conn)
+	if err != nil {
+		log.Error(err, "action", "generate PoW challenge")
+		writeMessage(ctx, protocol.NewError(protocol.CodeInternal, "internal error generating challenge"), conn, log)
+		closeConn(conn, log)
+		return
+	}
 
-	// bits should vary in interval [10, complexity)
-	// it makes no sense to set bits less than 10 as PoW calculation appears too simple
-	bits := rand.Intn(h.complexity-10) + 10
-	// since we have no determined resource to access here (e.g. requested quotes should be randomly chosen)
-	// let's set a resource as a random UUID string
-	resource := uuid.NewString()
+	writeMessage(ctx, message, conn, log)
 
-	challenge, err := h.challenge(uint(bits), resource)
+	budget := h.newVerifyBudget()
+	verify := func(result string) (pow.Reason, error) {
+		return budget.check(result, challenge)
+	}
+	if !h.awaitVerification(ctx, conn, verify) {
+		return
+	}
 
-	writeMessage(challenge, conn, h.log)
+	// if PoW verification passed hand over control to the next handler
+	h.serveNext(ctx, conn, request)
+}
+
+// awaitVerification waits for a PoW calculation result from the client and verifies it.
+//
+// It returns true if the verification has passed.
+// Otherwise, the client is informed about a failure (if possible), and the connection is closed.
+func (h *ProofOfWork) awaitVerification(ctx context.Context, conn tcp.Conn, verify func(result string) (pow.Reason, error)) bool {
+	log := connLog(ctx, h.log, conn)
 
 	// get PoW calculation result from the client
-	verification := make(chan verificationResult)
+	// the channel is buffered so the reading goroutine never blocks on sending a result nobody waits for
+	verification := make(chan verificationResult, 1)
+	done := make(chan struct{})
+	start := h.now()
 
 	timeOut, cancel := context.WithTimeout(ctx, h.waitPOW) // set calculation result awaiting timeout
 	defer cancel()
 
-	go h.getVerificationResult(verification, challenge, conn)
+	go func() {
+		defer close(done)
+		h.getVerificationResult(timeOut, verification, conn, verify)
+	}()
 
 	// while we wait for a calculation result we can either reach an awaiting timeout or get system interruption
-loop:
-	for {
-		select {
-		case <-timeOut.Done(): // handle system interruption or timeout
-			{
-				handleCtxDone(ctx, conn, h.log)
-				cancel()
-				return
+	select {
+	case <-timeOut.Done(): // handle system interruption or timeout
+		{
+			if ctx.Err() == nil {
+				h.prometheus.Outcome(metrics.OutcomeTimedOut)
+				h.record(ctx, OutcomeTimedOut)
 			}
-		case v := <-verification: // handle verification result
-			{
-				if v.err != nil {
-					h.log.Error(err, "action", "verify PoW")
-					writeMessage("internal error on verifying PoW", conn, h.log)
-					closeConn(conn, h.log)
-					return
-				}
-				if !v.ok {
-					h.log.Warn("PoW verification failed", "header", v.header, "remote", conn.RemoteAddr().String())
-					writeMessage("PoW verification failed", conn, h.log)
-					closeConn(conn, h.log)
-					return
-				} else {
-					h.log.Info("PoW verification passed", "header", v.header, "remote", conn.RemoteAddr().String())
-					break loop
+			handleCtxDone(ctx, conn, log)
+			cancel()
+			// the connection is closed, so the pending read is released: wait for the reading goroutine to exit
+			<-done
+			return false
+		}
+	case v := <-verification: // handle verification result
+		{
+			if errors.Is(v.err, tcp.ErrMessageTooLarge) {
+				h.record(ctx, OutcomeReadError)
+				rejectTooLarge(ctx, v.err, conn, log)
+				return false
+			}
+			if isTimeout(v.err) {
+				h.prometheus.Outcome(metrics.OutcomeTimedOut)
+				h.record(ctx, OutcomeTimedOut)
+				// the read has reached the end of the awaiting window, so the client is informed as on the timeout
+				if deadline, ok := timeOut.Deadline(); ok && !time.Now().Before(deadline) {
+					handleCtxDone(ctx, conn, log)
+					return false
 				}
+				dropStalled(v.err, conn, log)
+				return false
+			}
+			if errors.Is(v.err, tcp.ErrConnClosed) {
+				h.record(ctx, OutcomeReadError)
+				dropClosed(v.err, conn, log)
+				return false
+			}
+			if v.read {
+				log.Error(v.err, "action", "read from connection")
+				h.record(ctx, OutcomeReadError)
+				closeConn(conn, log)
+				return false
+			}
+
+			h.prometheus.Wait(h.now().Sub(start))
+
+			if errors.Is(v.err, ErrVerifyBudgetExceeded) {
+				h.rejectOverBudget(ctx, v.err, conn)
+				return false
 			}
+			if v.err != nil {
+				log.Error(v.err, "action", "verify PoW")
+				writeMessage(ctx, protocol.NewError(protocol.CodeInternal, "internal error on verifying PoW"), conn, log)
+				closeConn(conn, log)
+				return false
+			}
+			if v.reason != pow.ReasonValid {
+				h.rejectResult(ctx, conn, v.header, v.reason)
+				return false
+			}
+
+			log.Info("PoW verification passed", "header", v.header, "remote", tcp.RemoteAddr(conn))
+			inc(h.served)
+			h.prometheus.Outcome(metrics.OutcomePassed)
+			h.record(ctx, OutcomePassed)
+			h.audit(ctx, conn, v.header, h.now().Sub(start))
+			return true
 		}
 	}
+}
+
+// Drain is the first phase of a graceful shutdown: it stops issuing new challenges,
+// so newly connected clients are informed about shutting down,
+// while clients which have already received a challenge are allowed to complete the flow.
+//
+// It blocks until in-flight flows complete or the context is done (e.g. a grace period is over).
+func (h *ProofOfWork) Drain(ctx context.Context) error {
+	return h.drainer.drain(ctx)
+}
+
+// batchCount returns a number of challenges to issue for a batch mode request, which is 1 if batch mode is off.
+func (h *ProofOfWork) batchCount(request protocol.Request) int {
+	if h.maxBatch < 2 || !request.Has(protocol.CapabilityBatch) || request.Count < 2 {
+		return 1
+	}
+	if request.Count > h.maxBatch {
+		return h.maxBatch
+	}
+
+	return request.Count
+}
+
+// serveBatch challenges the client with a batch of challenges, verifies their results submitted at once,
+// and asks the next handler for a quote for each of them.
+func (h *ProofOfWork) serveBatch(ctx context.Context, conn tcp.Conn, request protocol.Request, count int) {
+	log := connLog(ctx, h.log, conn)
+
+	reduced := h.reduced(ctx, request, conn)
+
+	challenges := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		challenge, err := h.newChallenge(ctx, conn, reduced)
+		if err == nil {
+			challenge, err = encodeFor(request, challenge)
+		}
+		if err != nil {
+			log.Error(err, "action", "generate PoW challenge")
+			writeMessage(ctx, protocol.NewError(protocol.CodeInternal, "internal error generating challenge"), conn, log)
+			closeConn(conn, log)
+			return
+		}
+		challenges = append(challenges, challenge)
+	}
+
+	b, err := json.Marshal(protocol.BatchChallenge{Challenges: challenges})
+	if err != nil {
+		log.Error(err, "action", "marshal batch challenge")
+		writeMessage(ctx, protocol.NewError(protocol.CodeInternal, "internal error generating challenge"), conn, log)
+		closeConn(conn, log)
+		return
+	}
+
+	writeMessage(ctx, protocol.NewData(protocol.ResponseChallenge, b), conn, log)
+
+	// every result must pass the verification against the challenge of the same position
+	budget := h.newVerifyBudget()
+	verify := func(result string) (pow.Reason, error) {
+		proof, ok := protocol.ParseBatchProof([]byte(result))
+		if !ok || len(proof.Proofs) != len(challenges) {
+			return pow.ReasonFailed, nil
+		}
+
+		for i, challenge := range challenges {
+			if reason, err := budget.check(proof.Proofs[i], challenge); err != nil || reason != pow.ReasonValid {
+				return reason, err
+			}
+		}
+
+		return pow.ReasonValid, nil
+	}
+	if !h.awaitVerification(ctx, conn, verify) {
+		return
+	}
+
+	h.serveInner(context.WithValue(ctx, quoteCountKey{}, count), conn)
+}
+
+// serveSolvedInAdvance verifies a PoW calculation result submitted within the initial message
+// for a challenge issued along with a previous quote.
+func (h *ProofOfWork) serveSolvedInAdvance(ctx context.Context, conn tcp.Conn, request protocol.Request) {
+	log := connLog(ctx, h.log, conn)
+
+	// every challenge issued in advance can be redeemed only once
+	if _, ok := h.next.Take(request.Challenge); !ok {
+		log.Warn("unknown or expired challenge", "challenge", request.Challenge, "remote", tcp.RemoteAddr(conn))
+		writeMessage(ctx, protocol.NewError(protocol.CodeUnknownChallenge, "unknown or expired challenge"), conn, log)
+		closeConn(conn, log)
+		return
+	}
+
+	reason, err := h.newVerifyBudget().check(request.Proof, request.Challenge)
+	if errors.Is(err, ErrVerifyBudgetExceeded) {
+		h.rejectOverBudget(ctx, err, conn)
+		return
+	}
+	if err != nil {
+		log.Error(err, "action", "verify PoW")
+		writeMessage(ctx, protocol.NewError(protocol.CodeInternal, "internal error on verifying PoW"), conn, log)
+		closeConn(conn, log)
+		return
+	}
+	if reason != pow.ReasonValid {
+		h.rejectResult(ctx, conn, request.Proof, reason)
+		return
+	}
+
+	log.Info("PoW verification passed", "header", request.Proof, "remote", tcp.RemoteAddr(conn))
+	inc(h.served)
+	h.prometheus.Outcome(metrics.OutcomePassed)
+	h.record(ctx, OutcomePassed)
+	h.audit(ctx, conn, request.Proof, 0)
+
+	h.serveNext(ctx, conn, request)
+}
+
+// challengeFor generates a PoW challenge header string for a request and a response to deliver it with.
+//
+// A client proposing challenge bits gets a protocol.NegotiatedChallenge message holding the agreed bits,
+// if the server supports negotiation. A client declaring protocol.CapabilityEstimate gets the challenge bits
+// and the estimated solve time (see protocol.EstimatedChallenge). Otherwise, the message is the challenge itself.
+func (h *ProofOfWork) challengeFor(ctx context.Context, request protocol.Request, conn tcp.Conn) (challenge string, message protocol.Response, err error) {
+	log := connLog(ctx, h.log, conn)
+
+	reduced := h.reduced(ctx, request, conn)
+
+	if h.minNegotiatedBits <= 0 || !request.Has(protocol.CapabilityNegotiation) || request.Bits <= 0 {
+		challenge, err = h.newChallenge(ctx, conn, reduced)
+		if err != nil {
+			return "", protocol.Response{}, err
+		}
+		challenge, err = encodeFor(request, challenge)
+		if err != nil {
+			return "", protocol.Response{}, err
+		}
+
+		if !request.Has(protocol.CapabilityEstimate) {
+			return challenge, protocol.NewMessage(protocol.ResponseChallenge, challenge), nil
+		}
+
+		message, err = h.estimatedChallenge(challenge)
+		return challenge, message, err
+	}
+
+	bits := h.negotiatedBits(request.Bits, reduced)
+	log.Debug("negotiate PoW difficulty", "proposed", request.Bits, "agreed", bits)
+
+	challenge, err = h.issueChallenge(ctx, conn, bits, reduced)
+	if err != nil {
+		return "", protocol.Response{}, err
+	}
+	challenge, err = encodeFor(request, challenge)
+	if err != nil {
+		return "", protocol.Response{}, err
+	}
+
+	negotiated := protocol.NegotiatedChallenge{
+		Challenge: challenge,
+		Bits:      bits,
+		Accepted:  bits == request.Bits,
+	}
+	if request.Has(protocol.CapabilityEstimate) {
+		negotiated.EstimatedSolveMillis, negotiated.Hint = h.estimate(bits), h.hint
+	}
+
+	offer, err := json.Marshal(negotiated)
+	if err != nil {
+		return "", protocol.Response{}, fmt.Errorf("marshal negotiated challenge: %w", err)
+	}
+
+	return challenge, protocol.NewData(protocol.ResponseChallenge, offer), nil
+}
+
+// estimatedChallenge returns a protocol.EstimatedChallenge message delivering a challenge
+// along with the bits declared by its header.
+//
+// A challenge which is not a Hashcash header (see ProofOfWorkSettings.Scheme) is delivered as is.
+func (h *ProofOfWork) estimatedChallenge(challenge string) (protocol.Response, error) {
+	header, err := pow.ParseHeaderString(challenge)
+	if err != nil {
+		return protocol.NewMessage(protocol.ResponseChallenge, challenge), nil
+	}
+
+	bits := int(header.Bits())
+	b, err := json.Marshal(protocol.EstimatedChallenge{
+		Challenge:            challenge,
+		Bits:                 bits,
+		EstimatedSolveMillis: h.estimate(bits),
+		Hint:                 h.hint,
+	})
+	if err != nil {
+		return protocol.Response{}, fmt.Errorf("marshal estimated challenge: %w", err)
+	}
+
+	return protocol.NewData(protocol.ResponseChallenge, b), nil
+}
+
+// estimate returns the time in milliseconds a reference client is expected to solve a challenge of the bits in.
+func (h *ProofOfWork) estimate(bits int) int64 {
+	return pow.EstimateSolveTime(uint(bits), h.hashRate).Milliseconds()
+}
+
+// encodeFor re-encodes a challenge for a client declaring protocol.CapabilityHex,
+// otherwise the challenge is returned as is.
+func encodeFor(request protocol.Request, challenge string) (string, error) {
+	if !request.Has(protocol.CapabilityHex) {
+		return challenge, nil
+	}
+
+	return pow.EncodeChallenge(challenge, pow.EncodingHex)
+}
+
+// newChallenge generates a PoW challenge header string for a client connection.
+//
+// A reduced challenge is generated for a client presenting a valid difficulty token.
+func (h *ProofOfWork) newChallenge(ctx context.Context, conn tcp.Conn, reduced bool) (string, error) {
+	d := h.difficulty()
+	complexity := h.maxComplexity(d, reduced)
+
+	// bits should vary in interval [MinBits, complexity)
+	// the lower bound rises along with the server load
+	minBits := h.minBits(d, complexity)
+	bits := rand.Intn(complexity-minBits) + minBits
+
+	return h.issueChallenge(ctx, conn, bits, reduced)
+}
+
+// negotiatedBits returns challenge header bits proposed by a client clamped to the interval allowed by the server:
+// the lower bound is the greater of MinNegotiatedBits and the bound adapted to the server load,
+// and the upper bound is the highest bits a random challenge might get.
+func (h *ProofOfWork) negotiatedBits(proposed int, reduced bool) int {
+	d := h.difficulty()
+	complexity := h.maxComplexity(d, reduced)
+
+	lower := h.minBits(d, complexity)
+	if h.minNegotiatedBits > lower {
+		lower = h.minNegotiatedBits
+	}
+	if lower > complexity-1 {
+		lower = complexity - 1
+	}
+
+	switch {
+	case proposed < lower:
+		return lower
+	case proposed > complexity-1:
+		return complexity - 1
+	default:
+		return proposed
+	}
+}
+
+// maxComplexity returns the upper limit of challenge header bits: Complexity,
+// or TokenComplexity for a client presenting a valid difficulty token.
+func (h *ProofOfWork) maxComplexity(d difficulty, reduced bool) int {
+	if reduced && h.tokenComplexity > d.floorBits && h.tokenComplexity < d.complexity {
+		return h.tokenComplexity
+	}
+
+	return d.complexity
+}
+
+// difficulty holds the bounds of challenge header bits: Complexity and MinBits.
+type difficulty struct {
+	complexity int
+	// floorBits is the lowest challenge header bits, see ProofOfWorkSettings.MinBits
+	floorBits int
+}
+
+// newDifficulty returns the difficulty of the bounds, it falls back to DefaultMinBits if minBits is 0.
+//
+// It returns an error if the bounds don't allow any challenge header bits.
+func newDifficulty(complexity, minBits int) (difficulty, error) {
+	if minBits == 0 {
+		minBits = DefaultMinBits
+	}
+	if minBits < 0 {
+		return difficulty{}, fmt.Errorf("invalid MinBits %d: it must be positive", minBits)
+	}
+	if minBits >= complexity {
+		return difficulty{}, fmt.Errorf("invalid Complexity %d: it must exceed MinBits %d", complexity, minBits)
+	}
+
+	return difficulty{complexity: complexity, floorBits: minBits}, nil
+}
+
+// difficulty returns the current difficulty of challenges.
+func (h *ProofOfWork) difficulty() difficulty {
+	return h.bounds.Load().(difficulty)
+}
+
+// Difficulty returns the current bounds of challenge header bits (see ProofOfWorkSettings.Complexity and MinBits).
+func (h *ProofOfWork) Difficulty() (complexity, minBits int) {
+	d := h.difficulty()
+	return d.complexity, d.floorBits
+}
+
+// SetDifficulty replaces the bounds of challenge header bits (see ProofOfWorkSettings.Complexity and MinBits)
+// at once, so challenges issued afterwards get the new difficulty, while the flows in progress are not affected.
+//
+// It returns an error if the bounds don't allow any challenge header bits, and the difficulty is kept then.
+func (h *ProofOfWork) SetDifficulty(complexity, minBits int) error {
+	bounds, err := newDifficulty(complexity, minBits)
+	if err != nil {
+		return err
+	}
+
+	h.bounds.Store(bounds)
+
+	return nil
+}
+
+// issueChallenge generates a PoW challenge header string with the bits for a client connection and records them.
+func (h *ProofOfWork) issueChallenge(ctx context.Context, conn tcp.Conn, bits int, reduced bool) (string, error) {
+	log := connLog(ctx, h.log, conn)
+
+	resource := h.resource(conn)
+	if strings.Contains(resource, ":") {
+		return "", fmt.Errorf("invalid challenge resource %q: it mustn't contain header fields separator", resource)
+	}
+
+	challenge, err := h.challenge(uint(bits), resource)
+	if err != nil {
+		return "", err
+	}
+
+	log.Debug("issue PoW challenge", "bits", bits, "reduced", reduced)
+	if h.bits != nil {
+		h.bits.Observe(bits)
+	}
+
+	return challenge, nil
+}
+
+// ResourceFunc is a type of function to derive a challenge resource from a client connection.
+//
+// A PoW result is verified against the challenge, so it's valid for the resource of the challenge only.
+type ResourceFunc func(conn tcp.Conn) string
+
+// RandomResource is a ResourceFunc returning a random UUID string regardless of the connection:
+// it's the default one, since no determined resource is accessed (e.g. requested quotes are randomly chosen).
+func RandomResource(_ tcp.Conn) string {
+	return uuid.NewString()
+}
+
+// RemoteIPResource is a ResourceFunc returning the remote IP of the connection,
+// so a challenge is bound to the client it's been issued to.
+//
+// Colons of an IPv6 address are replaced with dashes, since a colon separates header fields.
+func RemoteIPResource(conn tcp.Conn) string {
+	return strings.ReplaceAll(tcp.RemoteIP(conn), ":", "-")
+}
+
+// minBits returns the lower bound of challenge header bits adapted to the server load:
+// it rises linearly from MinBits for an idle server up to complexity - 1 for a fully loaded one.
+// It stays at MinBits during the warm-up period (see ProofOfWorkSettings.WarmUp).
+func (h *ProofOfWork) minBits(d difficulty, complexity int) int {
+	if h.load == nil || h.now().Before(h.warmUpUntil) {
+		return d.floorBits
+	}
+
+	load := h.load.Load()
+	if load < 0 {
+		load = 0
+	}
+	if load > 1 {
+		load = 1
+	}
+
+	return d.floorBits + int(load*float64(complexity-1-d.floorBits))
+}
+
+// serveNext passes the rewards to the next handler and hands over control to it.
+func (h *ProofOfWork) serveNext(ctx context.Context, conn tcp.Conn, request protocol.Request) {
+	h.serveInner(h.withRewards(ctx, conn, request), conn)
+}
+
+// serveInner hands over control to the next handler within WaitQuote time limit.
+func (h *ProofOfWork) serveInner(ctx context.Context, conn tcp.Conn) {
+	if h.waitQuote > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.waitQuote)
+		defer cancel()
+	}
 
-	// if PoW verification passed hand over control to the next handler
 	h.handler.ServeTCP(ctx, conn)
 }
 
+// reduced checks if the client is granted a reduced challenge:
+// it's been authenticated (see AuthenticatedReduced) or it presents a valid difficulty token.
+func (h *ProofOfWork) reduced(ctx context.Context, request protocol.Request, conn tcp.Conn) bool {
+	if _, ok := identityFrom(ctx); ok {
+		return true
+	}
+
+	return h.redeemToken(ctx, request, conn)
+}
+
+// serveAuthenticated hands over control to the next handler right away for an authenticated client skipping PoW.
+func (h *ProofOfWork) serveAuthenticated(ctx context.Context, conn tcp.Conn, request protocol.Request) {
+	if count := h.batchCount(request); count > 1 {
+		ctx = context.WithValue(ctx, quoteCountKey{}, count)
+	}
+
+	h.serveInner(h.withCatalog(ctx, request), conn)
+}
+
+// redeemToken checks if the request holds a valid difficulty token.
+//
+// An invalid (e.g. expired or forged) token is ignored, so the client gets a full-difficulty challenge.
+func (h *ProofOfWork) redeemToken(ctx context.Context, request protocol.Request, conn tcp.Conn) bool {
+	log := connLog(ctx, h.log, conn)
+
+	if h.tokens == nil || request.Token == "" {
+		return false
+	}
+
+	if !h.tokens.valid(request.Token) {
+		log.Debug("ignore invalid difficulty token", "token", request.Token, "remote", tcp.RemoteAddr(conn))
+		return false
+	}
+
+	return true
+}
+
+// withRewards passes the rewards for a passed PoW verification to the next handler within the context.
+func (h *ProofOfWork) withRewards(ctx context.Context, conn tcp.Conn, request protocol.Request) context.Context {
+	return h.withCatalog(h.withDifficultyToken(h.withNextChallenge(ctx, conn, request), request), request)
+}
+
+type categoryKey struct{}
+
+// categoryFrom returns a quote category requested by the client passed within the context.
+func categoryFrom(ctx context.Context) string {
+	category, _ := ctx.Value(categoryKey{}).(string)
+	return category
+}
+
+type sessionKey struct{}
+
+// sessionFrom checks if the client has requested session mode (see protocol.CapabilitySession).
+func sessionFrom(ctx context.Context) bool {
+	session, _ := ctx.Value(sessionKey{}).(bool)
+	return session
+}
+
+type nextChallengeKey struct{}
+
+// withNextChallenge issues a next challenge for a client supporting it
+// and passes it to the next handler within the context.
+func (h *ProofOfWork) withNextChallenge(ctx context.Context, conn tcp.Conn, request protocol.Request) context.Context {
+	log := connLog(ctx, h.log, conn)
+
+	if !h.issueNext || !request.Has(protocol.CapabilityNextChallenge) || h.drainer.isDraining() {
+		return ctx
+	}
+
+	challenge, err := h.newChallenge(ctx, conn, false)
+	if err == nil {
+		challenge, err = encodeFor(request, challenge)
+	}
+	if err != nil {
+		log.Error(err, "action", "generate next PoW challenge")
+		return ctx
+	}
+
+	validFor := h.waitPOW
+	if h.challengeTTL > 0 {
+		validFor = h.challengeTTL
+	}
+	h.next.Put(challenge, challenge, time.Now().Add(validFor))
+
+	return context.WithValue(ctx, nextChallengeKey{}, challenge)
+}
+
+// nextChallengeFrom returns a next challenge issued for a client, if any.
+func nextChallengeFrom(ctx context.Context) (string, bool) {
+	challenge, ok := ctx.Value(nextChallengeKey{}).(string)
+	return challenge, ok
+}
+
+type difficultyTokenKey struct{}
+
+// withDifficultyToken mints a difficulty token for a client supporting it
+// and passes it to the next handler within the context.
+func (h *ProofOfWork) withDifficultyToken(ctx context.Context, request protocol.Request) context.Context {
+	if h.tokens == nil || !request.Has(protocol.CapabilityDifficultyToken) {
+		return ctx
+	}
+
+	return context.WithValue(ctx, difficultyTokenKey{}, h.tokens.mint(time.Now().Add(h.tokenTTL)))
+}
+
+// difficultyTokenFrom returns a difficulty token minted for a client, if any.
+func difficultyTokenFrom(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(difficultyTokenKey{}).(string)
+	return token, ok
+}
+
+type catalogKey struct{}
+
+// withCatalog passes the catalog to the next handler within the context for a client requesting it.
+func (h *ProofOfWork) withCatalog(ctx context.Context, request protocol.Request) context.Context {
+	if h.categories == nil || !request.Has(protocol.CapabilityCatalog) {
+		return ctx
+	}
+
+	catalog := h.catalog()
+	return context.WithValue(ctx, catalogKey{}, &catalog)
+}
+
+// catalogFrom returns the catalog requested by a client, if any.
+func catalogFrom(ctx context.Context) (*protocol.Catalog, bool) {
+	catalog, ok := ctx.Value(catalogKey{}).(*protocol.Catalog)
+	return catalog, ok
+}
+
+// catalog returns the capabilities the server supports and the quote categories it serves.
+func (h *ProofOfWork) catalog() protocol.Catalog {
+	capabilities := []protocol.Capability{protocol.CapabilityCatalog, protocol.CapabilityHex, protocol.CapabilityHello,
+		protocol.CapabilityEnvelope, protocol.CapabilityEstimate}
+	if h.issueNext {
+		capabilities = append(capabilities, protocol.CapabilityNextChallenge)
+	}
+	if h.tokens != nil {
+		capabilities = append(capabilities, protocol.CapabilityDifficultyToken)
+	}
+	if h.maxBatch > 1 {
+		capabilities = append(capabilities, protocol.CapabilityBatch)
+	}
+	if h.minNegotiatedBits > 0 {
+		capabilities = append(capabilities, protocol.CapabilityNegotiation)
+	}
+
+	categories := h.categories()
+	if categories == nil {
+		categories = []string{}
+	}
+
+	return protocol.Catalog{Capabilities: capabilities, Categories: categories}
+}
+
+// handshake rejects a client declaring a protocol version other than protocol.Version,
+// and greets a client declaring protocol.CapabilityHello with protocol.Hello.
+//
+// It returns false if the connection has been closed.
+func (h *ProofOfWork) handshake(ctx context.Context, conn tcp.Conn, request protocol.Request) bool {
+	log := connLog(ctx, h.log, conn)
+
+	if request.Version != 0 && request.Version != protocol.Version {
+		log.Warn("unsupported protocol version", "version", request.Version, "remote", tcp.RemoteAddr(conn))
+		writeMessage(ctx, protocol.NewError(protocol.CodeUnsupportedVersion, fmt.Sprintf(
+			"unsupported protocol version %d: server speaks version %d", request.Version, protocol.Version)), conn, log)
+		closeConn(conn, log)
+		return false
+	}
+
+	if !request.Has(protocol.CapabilityHello) {
+		return true
+	}
+
+	b, err := json.Marshal(protocol.Hello{Version: protocol.Version, Algorithm: h.algorithm.String()})
+	if err != nil {
+		log.Error(err, "action", "marshal hello")
+		writeMessage(ctx, protocol.NewError(protocol.CodeInternal, "internal error on handshake"), conn, log)
+		closeConn(conn, log)
+		return false
+	}
+
+	writeMessage(ctx, protocol.NewData(protocol.ResponseHello, b), conn, log)
+	return true
+}
+
+// serveCatalog sends the catalog to the client and closes the connection.
+func (h *ProofOfWork) serveCatalog(ctx context.Context, conn tcp.Conn) {
+	log := connLog(ctx, h.log, conn)
+
+	b, err := json.Marshal(h.catalog())
+	if err != nil {
+		log.Error(err, "action", "marshal catalog")
+		writeMessage(ctx, protocol.NewError(protocol.CodeInternal, "cannot get the catalog"), conn, log)
+		closeConn(conn, log)
+		return
+	}
+
+	writeMessage(ctx, protocol.NewData(protocol.ResponseCatalog, b), conn, log)
+	closeConn(conn, log)
+}
+
+type quoteCountKey struct{}
+
+// quoteCountFrom returns a number of quotes to serve for a client in batch mode, which is 1 if batch mode is off.
+func quoteCountFrom(ctx context.Context) int {
+	if count, ok := ctx.Value(quoteCountKey{}).(int); ok && count > 1 {
+		return count
+	}
+
+	return 1
+}
+
 type verificationResult struct {
-	ok     bool
+	reason pow.Reason
 	header string
 	err    error
+	// read flags that err has occurred on reading the result rather than on verifying it
+	read bool
 }
 
-func (h *ProofOfWork) getVerificationResult(v chan verificationResult, challenge string, conn tcp.Conn) {
-	// read PoW calculation result from the client
-	tmp := make([]byte, 1024)
-	tmp, err := conn.Read(tmp)
-	if err != nil {
-		if errors.Is(err, io.EOF) {
-			closeConn(conn, h.log)
-			return
-		}
-		if err, ok := err.(net.Error); ok {
-			v <- verificationResult{ok: false, header: "", err: fmt.Errorf("read from closed connection: %w", err)}
-			return
+// getVerificationResult reads a PoW calculation result from the client, verifies it and passes the outcome to v.
+//
+// The read is bound by the context deadline (the end of the awaiting window), if the connection supports that,
+// so it doesn't outlive the window even if the connection is kept open.
+func (h *ProofOfWork) getVerificationResult(ctx context.Context, v chan verificationResult, conn tcp.Conn, verify func(result string) (pow.Reason, error)) {
+	log := connLog(ctx, h.log, conn)
+
+	deadline, bound := ctx.Deadline()
+	if bound {
+		if err := tcp.SetReadDeadline(conn, deadline); err != nil {
+			log.Error(err, "action", "set read deadline")
 		}
+	}
 
-		h.log.Error(err, "action", "read from connection")
+	// read PoW calculation result from the client
+	tmp, release, err := tcp.ReadFrameBuffered(conn)
+	if err != nil {
+		// the client has gone, stalled, sent too much or the connection has failed: it's handled by the main handler flow
+		v <- verificationResult{reason: pow.ReasonFailed, header: "", err: err, read: true}
 		return
 	}
 
+	// the deadline is lifted once the result is read, so it doesn't affect the rest of the flow
+	if bound {
+		if err := tcp.SetReadDeadline(conn, time.Time{}); err != nil {
+			log.Error(err, "action", "reset read deadline")
+		}
+	}
+
 	header := string(tmp)
-	h.log.Debug("header to verify", "header", header, "remote", conn.RemoteAddr())
+	release()
+	log.Debug("header to verify", "header", header, "remote", tcp.RemoteAddr(conn))
 
 	// verify a received calculation result
-	ok, err := h.verify(header, challenge)
+	reason, err := verify(header)
 
 	// pass a verification result to the main handler flow
-	v <- verificationResult{ok: ok, header: header, err: err}
+	v <- verificationResult{reason: reason, header: header, err: err}
+}
+
+// rejectOverBudget informs the client that verification of its results has exceeded the budget
+// and closes the connection.
+func (h *ProofOfWork) rejectOverBudget(ctx context.Context, err error, conn tcp.Conn) {
+	log := connLog(ctx, h.log, conn)
+
+	log.Warn("verification budget exceeded", "err", err, "remote", tcp.RemoteAddr(conn))
+	inc(h.failures)
+	h.prometheus.Outcome(metrics.OutcomeFailed)
+	h.record(ctx, OutcomeVerificationFailed)
+	writeMessage(ctx, protocol.NewError(protocol.CodeBudgetExceeded, "verification budget exceeded"), conn, log)
+	closeConn(conn, log)
+}
+
+// rejectResult informs the client that its PoW calculation result has failed the verification and closes the connection.
+//
+// The client is told the reason of the failure, if it's known (see ProofOfWorkSettings.VerifyReason).
+func (h *ProofOfWork) rejectResult(ctx context.Context, conn tcp.Conn, header string, reason pow.Reason) {
+	log := connLog(ctx, h.log, conn)
+
+	message := "PoW verification failed"
+	if reason != pow.ReasonFailed {
+		message += ": " + reason.String()
+	}
+
+	log.Warn(message, "header", header, "remote", tcp.RemoteAddr(conn))
+	inc(h.failures)
+	h.prometheus.Outcome(metrics.OutcomeFailed)
+	h.record(ctx, OutcomeVerificationFailed)
+	writeMessage(ctx, protocol.NewError(protocol.CodeVerificationFailed, message), conn, log)
+	closeConn(conn, log)
 }
 
+// handleCtxDone informs the client that the flow is over and closes the connection:
+// either the client has run out of time, or the server is shutting down, if the context is cancelled.
 func handleCtxDone(ctx context.Context, conn tcp.Conn, log logger.Logger) {
 	log.Warn("context done", "err", ctx.Err())
-	writeMessage("context done", conn, log)
+	code := protocol.CodeTimeout
+	if errors.Is(ctx.Err(), context.Canceled) {
+		code = protocol.CodeShuttingDown
+	}
+	writeMessage(ctx, protocol.NewError(code, "context done"), conn, log)
+	closeConn(conn, log)
+}
+
+// rejectDone informs the client that the server is shutting down and closes the connection,
+// if the context is already done.
+//
+// It returns true if the connection has been closed.
+func rejectDone(ctx context.Context, conn tcp.Conn, log logger.Logger) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+
+	log.Warn("context done before serving", "err", ctx.Err(), "remote", tcp.RemoteAddr(conn))
+	writeMessage(ctx, protocol.NewError(protocol.CodeShuttingDown, "server is shutting down"), conn, log)
+	closeConn(conn, log)
+	return true
+}
+
+// rejectTooLarge informs the client that its message exceeds the maximum message size and closes the connection.
+func rejectTooLarge(ctx context.Context, err error, conn tcp.Conn, log logger.Logger) {
+	log.Warn("message too large", "err", err, "remote", tcp.RemoteAddr(conn))
+	writeMessage(ctx, protocol.NewError(protocol.CodeMessageTooLarge, "message too large"), conn, log)
 	closeConn(conn, log)
 }
 
+// dropStalled closes the connection of a client which has exceeded the connection read or write timeout.
+//
+// The client isn't informed since it doesn't keep up with the connection anyway.
+func dropStalled(err error, conn tcp.Conn, log logger.Logger) {
+	log.Warn("connection stalled", "err", err, "remote", tcp.RemoteAddr(conn))
+	closeConn(conn, log)
+}
+
+// dropClosed closes the connection which has been closed by the client or reset,
+// so the flow ends without waiting for a calculation result nobody would send.
+func dropClosed(err error, conn tcp.Conn, log logger.Logger) {
+	log.Debug("connection closed by client", "err", err, "remote", tcp.RemoteAddr(conn))
+	closeConn(conn, log)
+}
+
+// isTimeout checks if an error is caused by exceeding a connection deadline.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// connLog returns a logger adding the id of the connection as "conn_id" to every record,
+// so the records of a single connection can be correlated (see tcp.ConnID).
+// Once the client has declared its version (see withClientVersion), it's added as "client_version" as well.
+func connLog(ctx context.Context, log logger.Logger, conn tcp.Conn) logger.Logger {
+	if id := tcp.ConnID(conn); id != "" {
+		log = logger.WithFields(log, "conn_id", id)
+	}
+	if version, ok := clientVersionFrom(ctx); ok {
+		log = logger.WithFields(log, "client_version", version)
+	}
+
+	return log
+}
+
+type clientVersionKey struct{}
+
+// withClientVersion passes the version of the client software declared in the request within the context;
+// an undeclared version is passed as metrics.UnknownClientVersion.
+func withClientVersion(ctx context.Context, request protocol.Request) context.Context {
+	version := request.ClientVersion
+	if version == "" {
+		version = metrics.UnknownClientVersion
+	}
+
+	return context.WithValue(ctx, clientVersionKey{}, version)
+}
+
+// clientVersionFrom returns the version of the client software passed within the context (see withClientVersion).
+func clientVersionFrom(ctx context.Context) (string, bool) {
+	version, ok := ctx.Value(clientVersionKey{}).(string)
+	return version, ok
+}
+
 func closeConn(conn tcp.Conn, log logger.Logger) {
-	log.Debug("close TCP connection", "remote", conn.RemoteAddr())
+	log.Debug("close TCP connection", "remote", tcp.RemoteAddr(conn))
 	if err := conn.Close(); err != nil {
-		log.Error(err, "action", "close TCP connection", "remote", conn.RemoteAddr())
+		log.Error(err, "action", "close TCP connection", "remote", tcp.RemoteAddr(conn))
+	}
+}
+
+type envelopeKey struct{}
+
+// withEnvelope flags within the context that the client declaring protocol.CapabilityEnvelope
+// gets every message wrapped in a protocol.Response.
+func withEnvelope(ctx context.Context, request protocol.Request) context.Context {
+	if !request.Has(protocol.CapabilityEnvelope) {
+		return ctx
+	}
+
+	return context.WithValue(ctx, envelopeKey{}, true)
+}
+
+// writeMessage writes a response to the client: wrapped in a protocol.Response envelope,
+// if the client has declared protocol.CapabilityEnvelope (see withEnvelope), or its payload only otherwise.
+func writeMessage(ctx context.Context, response protocol.Response, conn tcp.Conn, log logger.Logger) {
+	message := string(response.Payload())
+	if envelope, _ := ctx.Value(envelopeKey{}).(bool); envelope {
+		b, err := json.Marshal(response)
+		if err != nil {
+			log.Error(err, "action", "marshal response", "remote", tcp.RemoteAddr(conn))
+			return
+		}
+		message = string(b)
+	}
+
+	log.Info("write message", "message", message, "remote", tcp.RemoteAddr(conn))
+	if err := tcp.WriteFrame(conn, []byte(message)); err != nil {
+		log.Error(err, "action", "write message", "message", message, "remote", tcp.RemoteAddr(conn))
+	}
+}
+
+// audit records an accepted PoW result (a single header or a batch of them), if an auditor is set.
+func (h *ProofOfWork) audit(ctx context.Context, conn tcp.Conn, result string, solved time.Duration) {
+	log := connLog(ctx, h.log, conn)
+
+	if h.auditor == nil {
+		return
+	}
+
+	for _, record := range auditRecords(result, tcp.RemoteIP(conn), solved, h.now()) {
+		if err := h.auditor.Audit(record); err != nil {
+			log.Error(err, "action", "audit PoW result")
+		}
 	}
 }
 
-func writeMessage(message string, conn tcp.Conn, log logger.Logger) {
-	log.Info("write message", "message", message, "remote", conn.RemoteAddr())
-	if _, err := conn.Write([]byte(message)); err != nil {
-		log.Error(err, "action", "write message", "message", message, "remote", conn.RemoteAddr())
+// inc increments a counter, if it's set.
+func inc(c *metrics.Counter) {
+	if c != nil {
+		c.Inc()
 	}
 }
@@ -5,18 +5,46 @@ package handler
 //go:generate mockery --dir=../tcp --name=Handler --case underscore
 //go:generate mockery --dir=../pow --name=ChallengeFunc --case underscore
 //go:generate mockery --dir=../pow --name=VerifyFunc --case underscore
+//go:generate mockery --dir=. --name=LoadTracker --case underscore
 
 import (
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
 	"github.com/laonix/pow-word-of-wisdom/handler/mocks"
+	"github.com/laonix/pow-word-of-wisdom/logger"
+	"github.com/laonix/pow-word-of-wisdom/metrics"
+	"github.com/laonix/pow-word-of-wisdom/pow"
+	"github.com/laonix/pow-word-of-wisdom/protocol"
+	"github.com/laonix/pow-word-of-wisdom/tcp"
+	"github.com/laonix/pow-word-of-wisdom/tcp/tcptest"
 )
 
+// newProofOfWork returns a new instance of ProofOfWork, failing the test if the settings are invalid.
+func newProofOfWork(t *testing.T, next tcp.Handler, settings ProofOfWorkSettings, log logger.Logger) *ProofOfWork {
+	t.Helper()
+
+	h, err := NewProofOfWork(next, settings, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
 func TestProofOfWork_ServeTCP_correct(t *testing.T) {
 	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
 	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
@@ -30,11 +58,16 @@ func TestProofOfWork_ServeTCP_correct(t *testing.T) {
 	verify := mocks.NewVerifyFunc(t)
 	verify.On("Execute", calculatedStr, challengeStr).Return(true, nil)
 
+	served, failures := metrics.NewCounter(), metrics.NewCounter()
+	prom := metrics.NewPrometheus()
 	settings := ProofOfWorkSettings{
 		Challenge:  challenge.Execute,
 		Verify:     verify.Execute,
 		Complexity: 20,
 		WaitPOW:    1 * time.Minute,
+		Served:     served,
+		Failures:   failures,
+		Prometheus: prom,
 	}
 
 	cancellingCtx, cancel := context.WithCancel(context.Background())
@@ -43,23 +76,32 @@ func TestProofOfWork_ServeTCP_correct(t *testing.T) {
 	conn := mocks.NewConn(t)
 	conn.On("Close").Return(nil)
 	conn.On("RemoteAddr").Return(func() net.Addr { return &net.TCPAddr{Port: 80} })
-	conn.On("Read", mock.AnythingOfType("[]uint8")).Return([]byte("ping"), nil).Once()
-	conn.On("Write", []byte(challengeStr)).Return(len([]byte(challengeStr)), nil).Once()
-	conn.On("Read", mock.AnythingOfType("[]uint8")).Return([]byte(calculatedStr), nil).Once()
+	onReadFrame(conn, "ping")
+	conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+	onReadFrame(conn, calculatedStr)
 
 	mockHandler := mocks.NewHandler(t)
-	mockHandler.On("ServeTCP", cancellingCtx, conn).Run(func(args mock.Arguments) {
+	// the context passed on is derived from the one of the connection carrying the client version
+	derived := mock.MatchedBy(func(ctx context.Context) bool {
+		version, ok := clientVersionFrom(ctx)
+		return ok && version == metrics.UnknownClientVersion && ctx.Done() == cancellingCtx.Done()
+	})
+	mockHandler.On("ServeTCP", derived, conn).Run(func(args mock.Arguments) {
 		conn.Close()
 	}).Once()
 
-	handler := NewProofOfWork(mockHandler, settings, log)
+	handler := newProofOfWork(t, mockHandler, settings, log)
 
 	handler.ServeTCP(cancellingCtx, conn)
 
 	log.AssertNumberOfCalls(t, "Info", 3)  // on read from and write to conn, no errors
-	log.AssertNumberOfCalls(t, "Debug", 1) // on get header to verify, no errors
+	log.AssertNumberOfCalls(t, "Debug", 2) // on issue challenge and get header to verify, no errors
 	log.AssertNumberOfCalls(t, "Warn", 0)  // ctx hasn't been cancelled
 	log.AssertNumberOfCalls(t, "Error", 0) // no errors
+
+	assert.EqualValues(t, 1, served.Value())
+	assert.EqualValues(t, 0, failures.Value())
+	assertOutcomes(t, prom, 1, 0, 0)
 }
 
 func TestProofOfWork_ServeTCP_verification_timeout(t *testing.T) {
@@ -75,10 +117,12 @@ func TestProofOfWork_ServeTCP_verification_timeout(t *testing.T) {
 	verify := mocks.NewVerifyFunc(t)
 	verify.On("Execute", mock.AnythingOfType("string"), challengeStr).Maybe().Return(true, nil)
 
+	prom := metrics.NewPrometheus()
 	settings := ProofOfWorkSettings{
 		Challenge:  challenge.Execute,
 		Verify:     verify.Execute,
 		Complexity: 20,
+		Prometheus: prom,
 		WaitPOW:    1 * time.Nanosecond,
 	}
 
@@ -88,21 +132,25 @@ func TestProofOfWork_ServeTCP_verification_timeout(t *testing.T) {
 	conn := mocks.NewConn(t)
 	conn.On("Close").Return(nil)
 	conn.On("RemoteAddr").Return(func() net.Addr { return &net.TCPAddr{Port: 80} })
-	conn.On("Read", mock.AnythingOfType("[]uint8")).Return([]byte("ping"), nil).Once()
-	conn.On("Write", []byte(challengeStr)).Return(len([]byte(challengeStr)), nil).Once()
-	conn.On("Read", mock.AnythingOfType("[]uint8")).Maybe().Return([]byte(calculatedStr), nil).Once()
-	conn.On("Write", []byte("context done")).Return(len([]byte("context done")), nil).Once()
+	onReadFrame(conn, "ping")
+	conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+	header, payload := onReadFrame(conn, calculatedStr)
+	header.Maybe().After(10 * time.Millisecond)
+	payload.Maybe()
+	conn.On("Write", frame("context done")).Return(len(frame("context done")), nil).Once()
 
 	mockHandler := mocks.NewHandler(t)
 
-	handler := NewProofOfWork(mockHandler, settings, log)
+	handler := newProofOfWork(t, mockHandler, settings, log)
 
 	handler.ServeTCP(cancellingCtx, conn)
 
 	log.AssertNumberOfCalls(t, "Info", 3)  // on read from and write to conn, no errors
-	log.AssertNumberOfCalls(t, "Debug", 2) // on read calc result and close conn, no errors
+	log.AssertNumberOfCalls(t, "Debug", 3) // on issue challenge, read calc result and close conn, no errors
 	log.AssertNumberOfCalls(t, "Warn", 1)  // ctx has been cancelled
 	log.AssertNumberOfCalls(t, "Error", 0) // no errors
+
+	assertOutcomes(t, prom, 0, 0, 1)
 }
 
 func TestProofOfWork_ServeTCP_context_cancelled(t *testing.T) {
@@ -118,10 +166,12 @@ func TestProofOfWork_ServeTCP_context_cancelled(t *testing.T) {
 	verify := mocks.NewVerifyFunc(t)
 	verify.On("Execute", mock.AnythingOfType("string"), challengeStr).Maybe().Return(true, nil)
 
+	prom := metrics.NewPrometheus()
 	settings := ProofOfWorkSettings{
 		Challenge:  challenge.Execute,
 		Verify:     verify.Execute,
 		Complexity: 20,
+		Prometheus: prom,
 		WaitPOW:    1 * time.Minute,
 	}
 
@@ -131,21 +181,25 @@ func TestProofOfWork_ServeTCP_context_cancelled(t *testing.T) {
 	conn := mocks.NewConn(t)
 	conn.On("Close").Return(nil)
 	conn.On("RemoteAddr").Return(func() net.Addr { return &net.TCPAddr{Port: 80} })
-	conn.On("Read", mock.AnythingOfType("[]uint8")).Return([]byte("ping"), nil).Once()
-	conn.On("Write", []byte(challengeStr)).Return(len([]byte(challengeStr)), nil).Once()
-	conn.On("Read", mock.AnythingOfType("[]uint8")).Maybe().Return([]byte(calculatedStr), nil).Once()
-	conn.On("Write", []byte("context done")).Return(len([]byte("context done")), nil).Once()
+	onReadFrame(conn, "ping")
+	conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+	header, payload := onReadFrame(conn, calculatedStr)
+	header.Maybe().After(10 * time.Millisecond)
+	payload.Maybe()
+	conn.On("Write", frame("context done")).Return(len(frame("context done")), nil).Once()
 
 	mockHandler := mocks.NewHandler(t)
 
-	handler := NewProofOfWork(mockHandler, settings, log)
+	handler := newProofOfWork(t, mockHandler, settings, log)
 
 	handler.ServeTCP(cancellingCtx, conn)
 
 	log.AssertNumberOfCalls(t, "Info", 3)  // on read from and write to conn, no errors
-	log.AssertNumberOfCalls(t, "Debug", 2) // on read calc result and close conn, no errors
+	log.AssertNumberOfCalls(t, "Debug", 3) // on issue challenge, read calc result and close conn, no errors
 	log.AssertNumberOfCalls(t, "Warn", 1)  // ctx has been cancelled
 	log.AssertNumberOfCalls(t, "Error", 0) // no errors
+
+	assertOutcomes(t, prom, 0, 0, 0) // a cancelled context is not a timeout
 }
 
 func TestProofOfWork_ServeTCP_verification_failed(t *testing.T) {
@@ -161,11 +215,16 @@ func TestProofOfWork_ServeTCP_verification_failed(t *testing.T) {
 	verify := mocks.NewVerifyFunc(t)
 	verify.On("Execute", calculatedStr, challengeStr).Return(false, nil)
 
+	served, failures := metrics.NewCounter(), metrics.NewCounter()
+	prom := metrics.NewPrometheus()
 	settings := ProofOfWorkSettings{
 		Challenge:  challenge.Execute,
 		Verify:     verify.Execute,
 		Complexity: 20,
 		WaitPOW:    1 * time.Minute,
+		Served:     served,
+		Failures:   failures,
+		Prometheus: prom,
 	}
 
 	cancellingCtx, cancel := context.WithCancel(context.Background())
@@ -174,19 +233,2236 @@ func TestProofOfWork_ServeTCP_verification_failed(t *testing.T) {
 	conn := mocks.NewConn(t)
 	conn.On("Close").Return(nil)
 	conn.On("RemoteAddr").Return(func() net.Addr { return &net.TCPAddr{Port: 80} })
-	conn.On("Read", mock.AnythingOfType("[]uint8")).Return([]byte("ping"), nil).Once()
-	conn.On("Write", []byte(challengeStr)).Return(len([]byte(challengeStr)), nil).Once()
-	conn.On("Read", mock.AnythingOfType("[]uint8")).Return([]byte(calculatedStr), nil).Once()
-	conn.On("Write", []byte("PoW verification failed")).Return(len([]byte("PoW verification failed")), nil).Once()
+	onReadFrame(conn, "ping")
+	conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+	onReadFrame(conn, calculatedStr)
+	conn.On("Write", frame("PoW verification failed")).Return(len(frame("PoW verification failed")), nil).Once()
 
 	mockHandler := mocks.NewHandler(t)
 
-	handler := NewProofOfWork(mockHandler, settings, log)
+	handler := newProofOfWork(t, mockHandler, settings, log)
 
 	handler.ServeTCP(cancellingCtx, conn)
 
 	log.AssertNumberOfCalls(t, "Info", 3)  // on read from and write to conn, no errors
-	log.AssertNumberOfCalls(t, "Debug", 2) // on read calc result and close conn, no errors
+	log.AssertNumberOfCalls(t, "Debug", 3) // on issue challenge, read calc result and close conn, no errors
 	log.AssertNumberOfCalls(t, "Warn", 1)  // PoW verification failed
 	log.AssertNumberOfCalls(t, "Error", 0) // no errors
+
+	assert.EqualValues(t, 0, served.Value())
+	assert.EqualValues(t, 1, failures.Value())
+	assertOutcomes(t, prom, 0, 1, 0)
+}
+
+func TestProofOfWork_ServeTCP_closed_by_client(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.FixedChallenge(challengeStr),
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+	}
+
+	// the client disconnects instead of sending a calculation result
+	conn := setupConnMock(t)
+	onReadFrame(conn, "ping")
+	conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+	conn.On("Read", mock.AnythingOfType("[]uint8")).Return(nil, io.EOF).Once()
+
+	handler := newProofOfWork(t, mocks.NewHandler(t), settings, setupLogMock(t))
+
+	// the flow ends right away rather than when the calculation result awaiting timeout is reached
+	start := time.Now()
+	handler.ServeTCP(context.Background(), conn)
+	assert.Less(t, time.Since(start), time.Second)
+
+	conn.AssertCalled(t, "Close")
+}
+
+func TestProofOfWork_ServeTCP_result_in_chunks(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	t.Run("reassembled", func(t *testing.T) {
+		verify := mocks.NewVerifyFunc(t)
+		verify.On("Execute", calculatedStr, challengeStr).Return(true, nil).Once()
+
+		settings := ProofOfWorkSettings{
+			Challenge:  pow.FixedChallenge(challengeStr),
+			Verify:     verify.Execute,
+			Complexity: 20,
+			WaitPOW:    1 * time.Minute,
+		}
+
+		// both the frame header and the result are delivered in two chunks each, as split across TCP segments
+		b := frame(calculatedStr)
+		split := tcp.FrameHeaderSize + len(calculatedStr)/2
+
+		conn := setupConnMock(t)
+		onReadFrame(conn, "ping")
+		conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+		conn.On("Read", mock.AnythingOfType("[]uint8")).Return(b[:2], nil).Once()
+		conn.On("Read", mock.AnythingOfType("[]uint8")).Return(b[2:tcp.FrameHeaderSize], nil).Once()
+		conn.On("Read", mock.AnythingOfType("[]uint8")).Return(b[tcp.FrameHeaderSize:split], nil).Once()
+		conn.On("Read", mock.AnythingOfType("[]uint8")).Return(b[split:], nil).Once()
+
+		mockHandler := mocks.NewHandler(t)
+		mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			args.Get(1).(tcp.Conn).Close()
+		}).Once()
+
+		handler := newProofOfWork(t, mockHandler, settings, setupLogMock(t))
+		handler.ServeTCP(context.Background(), conn)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		settings := ProofOfWorkSettings{
+			Challenge:  pow.FixedChallenge(challengeStr),
+			Verify:     mocks.NewVerifyFunc(t).Execute,
+			Complexity: 20,
+			WaitPOW:    1 * time.Minute,
+		}
+
+		// the connection is closed after a part of the result along with an error: the part is never verified
+		b := frame(calculatedStr)
+		split := tcp.FrameHeaderSize + len(calculatedStr)/2
+
+		conn := setupConnMock(t)
+		onReadFrame(conn, "ping")
+		conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+		conn.On("Read", mock.AnythingOfType("[]uint8")).Return(b[:tcp.FrameHeaderSize], nil).Once()
+		conn.On("Read", mock.AnythingOfType("[]uint8")).Return(b[tcp.FrameHeaderSize:split], io.EOF).Once()
+
+		handler := newProofOfWork(t, mocks.NewHandler(t), settings, setupLogMock(t))
+		handler.ServeTCP(context.Background(), conn)
+
+		conn.AssertCalled(t, "Close")
+	})
+}
+
+func TestProofOfWork_ServeTCP_challenge_error(t *testing.T) {
+	log := setupLogMock(t)
+
+	challenge := mocks.NewChallengeFunc(t)
+	challenge.On("Execute", mock.AnythingOfType("uint"), mock.AnythingOfType("string")).
+		Return("", errors.New("get random: read random bytes"))
+
+	verify := mocks.NewVerifyFunc(t)
+
+	settings := ProofOfWorkSettings{
+		Challenge:  challenge.Execute,
+		Verify:     verify.Execute,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+	}
+
+	cancellingCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conn := setupConnMock(t)
+	onReadFrame(conn, "ping")
+	conn.On("Write", frame("internal error generating challenge")).
+		Return(len(frame("internal error generating challenge")), nil).Once()
+
+	mockHandler := mocks.NewHandler(t)
+
+	handler := newProofOfWork(t, mockHandler, settings, log)
+
+	handler.ServeTCP(cancellingCtx, conn)
+
+	conn.AssertCalled(t, "Close")
+	mockHandler.AssertNotCalled(t, "ServeTCP", mock.Anything, mock.Anything)
+
+	log.AssertNumberOfCalls(t, "Info", 2)  // on read from and write to conn
+	log.AssertNumberOfCalls(t, "Debug", 1) // on closing conn
+	log.AssertNumberOfCalls(t, "Warn", 0)  // ctx hasn't been cancelled
+	log.AssertNumberOfCalls(t, "Error", 1) // on generating challenge
+}
+
+func TestProofOfWork_ServeTCP_next_challenge(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	log := setupLogMock(t)
+
+	settings := ProofOfWorkSettings{
+		Challenge:          pow.FixedChallenge(challengeStr),
+		Verify:             pow.Verify,
+		Complexity:         20,
+		WaitPOW:            1 * time.Minute,
+		IssueNextChallenge: true,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var next string
+
+	mockHandler := mocks.NewHandler(t)
+	mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		next, _ = nextChallengeFrom(args.Get(0).(context.Context))
+		args.Get(1).(tcp.Conn).Close()
+	}).Twice()
+
+	handler := newProofOfWork(t, mockHandler, settings, log)
+
+	// a client declaring the capability gets a next challenge along with a quote
+	request := []byte(`{"capabilities":["next-challenge"]}`)
+
+	conn := setupConnMock(t)
+	onReadFrame(conn, string(request))
+	conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+	onReadFrame(conn, calculatedStr)
+
+	handler.ServeTCP(ctx, conn)
+
+	assert.Equal(t, challengeStr, next)
+
+	// the next challenge solved in advance is verified right away
+	next = ""
+	request, err := json.Marshal(protocol.Request{
+		Capabilities: []protocol.Capability{protocol.CapabilityNextChallenge},
+		Challenge:    challengeStr,
+		Proof:        calculatedStr,
+	})
+	assert.Nil(t, err)
+
+	conn = setupConnMock(t)
+	onReadFrame(conn, string(request))
+
+	handler.ServeTCP(ctx, conn)
+
+	assert.Equal(t, challengeStr, next)
+
+	// a redeemed challenge cannot be used twice
+	handler.next.Take(challengeStr)
+
+	conn = setupConnMock(t)
+	onReadFrame(conn, string(request))
+	conn.On("Write", frame("unknown or expired challenge")).
+		Return(len(frame("unknown or expired challenge")), nil).Once()
+
+	handler.ServeTCP(ctx, conn)
+}
+
+func TestProofOfWork_ServeTCP_no_next_challenge(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	log := setupLogMock(t)
+
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.FixedChallenge(challengeStr),
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+	}
+
+	mockHandler := mocks.NewHandler(t)
+	mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		_, ok := nextChallengeFrom(args.Get(0).(context.Context))
+		assert.False(t, ok)
+		args.Get(1).(tcp.Conn).Close()
+	}).Once()
+
+	handler := newProofOfWork(t, mockHandler, settings, log)
+
+	conn := setupConnMock(t)
+	onReadFrame(conn, `{"capabilities":["next-challenge"]}`)
+	conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+	onReadFrame(conn, calculatedStr)
+
+	handler.ServeTCP(context.Background(), conn)
+}
+
+func TestProofOfWork_ServeTCP_message_too_large(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	oversized := make([]byte, tcp.FrameHeaderSize)
+	binary.BigEndian.PutUint32(oversized, tcp.DefaultMaxMessageSize+1)
+
+	tests := []struct {
+		name  string
+		setup func(conn *mocks.Conn)
+	}{
+		{
+			name: "oversized initial message",
+			setup: func(conn *mocks.Conn) {
+				conn.On("Read", mock.AnythingOfType("[]uint8")).Return(oversized, nil).Once()
+			},
+		},
+		{
+			name: "oversized PoW result",
+			setup: func(conn *mocks.Conn) {
+				onReadFrame(conn, "ping")
+				conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+				conn.On("Read", mock.AnythingOfType("[]uint8")).Return(oversized, nil).Once()
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := setupLogMock(t)
+
+			settings := ProofOfWorkSettings{
+				Challenge:  pow.FixedChallenge(challengeStr),
+				Verify:     pow.Verify,
+				Complexity: 20,
+				WaitPOW:    1 * time.Minute,
+			}
+
+			conn := setupConnMock(t)
+			test.setup(conn)
+			conn.On("Write", frame("message too large")).Return(len(frame("message too large")), nil).Once()
+
+			mockHandler := mocks.NewHandler(t)
+
+			handler := newProofOfWork(t, mockHandler, settings, log)
+
+			handler.ServeTCP(context.Background(), conn)
+
+			conn.AssertCalled(t, "Close")
+			log.AssertNumberOfCalls(t, "Warn", 1)  // on message too large
+			log.AssertNumberOfCalls(t, "Error", 0) // rejected cleanly
+		})
+	}
+}
+
+func TestProofOfWork_ServeTCP_difficulty_token(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	log := setupLogMock(t)
+
+	var bits uint
+
+	settings := ProofOfWorkSettings{
+		Challenge: func(b uint, _ string) (string, error) {
+			bits = b
+			return challengeStr, nil
+		},
+		Verify:          pow.Verify,
+		Complexity:      40,
+		WaitPOW:         1 * time.Minute,
+		TokenSecret:     []byte("secret"),
+		TokenTTL:        1 * time.Minute,
+		TokenComplexity: 11,
+	}
+
+	var token string
+
+	mockHandler := mocks.NewHandler(t)
+	mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		token, _ = difficultyTokenFrom(args.Get(0).(context.Context))
+		args.Get(1).(tcp.Conn).Close()
+	}).Twice()
+
+	handler := newProofOfWork(t, mockHandler, settings, log)
+
+	serve := func(request protocol.Request) {
+		b, err := json.Marshal(request)
+		assert.Nil(t, err)
+
+		conn := setupConnMock(t)
+		onReadFrame(conn, string(b))
+		conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+		onReadFrame(conn, calculatedStr)
+
+		handler.ServeTCP(context.Background(), conn)
+	}
+
+	// a client declaring the capability gets a token after passing PoW verification
+	request := protocol.Request{Capabilities: []protocol.Capability{protocol.CapabilityDifficultyToken}}
+	serve(request)
+
+	assert.NotEmpty(t, token)
+
+	// the token grants a reduced-difficulty challenge
+	request.Token = token
+	serve(request)
+
+	assert.EqualValues(t, 10, bits)
+
+	// expired and forged tokens are ignored, so the bits vary over the full interval
+	tokens := []string{
+		newDifficultyTokens(settings.TokenSecret).mint(time.Now().Add(-time.Minute)),
+		newDifficultyTokens([]byte("forged")).mint(time.Now().Add(time.Minute)),
+	}
+	for _, token := range tokens {
+		settings.Challenge = func(b uint, _ string) (string, error) {
+			bits = b
+			return "", errors.New("challenge error")
+		}
+		handler := newProofOfWork(t, mocks.NewHandler(t), settings, log)
+
+		var maxBits uint
+		for i := 0; i < 20; i++ {
+			b, err := json.Marshal(protocol.Request{Token: token})
+			assert.Nil(t, err)
+
+			conn := setupConnMock(t)
+			onReadFrame(conn, string(b))
+			conn.On("Write", frame("internal error generating challenge")).
+				Return(len(frame("internal error generating challenge")), nil).Once()
+
+			handler.ServeTCP(context.Background(), conn)
+
+			if bits > maxBits {
+				maxBits = bits
+			}
+		}
+
+		assert.Greater(t, maxBits, uint(10))
+	}
+}
+
+func TestProofOfWork_ServeTCP_quote_timeout(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	log := setupLogMock(t)
+
+	// the quote getter is stuck until the test is over
+	stuck := make(chan struct{})
+	defer close(stuck)
+
+	svc := mocks.NewWordOfWisdom(t)
+	svc.On("Quote").Run(func(_ mock.Arguments) { <-stuck }).Return("random quote", nil).Once()
+
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.FixedChallenge(challengeStr),
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+		WaitQuote:  10 * time.Millisecond,
+	}
+
+	handler := newProofOfWork(t, NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, log), settings, log)
+
+	conn := setupConnMock(t)
+	onReadFrame(conn, "ping")
+	conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+	onReadFrame(conn, calculatedStr)
+	conn.On("Write", frame("context done")).Return(len(frame("context done")), nil).Once()
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeTCP(context.Background(), conn)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the quote stage hasn't timed out")
+	}
+
+	conn.AssertCalled(t, "Close")
+	log.AssertNumberOfCalls(t, "Warn", 1)  // on context done
+	log.AssertNumberOfCalls(t, "Error", 0) // no errors
+}
+
+func TestProofOfWork_newChallenge_bits_histogram(t *testing.T) {
+	bits := metrics.NewHistogram()
+
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.Challenge,
+		Verify:     pow.Verify,
+		Complexity: 15,
+		WaitPOW:    1 * time.Minute,
+		Bits:       bits,
+	}
+
+	handler := newProofOfWork(t, mocks.NewHandler(t), settings, setupLogMock(t))
+
+	for i := 0; i < 1000; i++ {
+		_, err := handler.newChallenge(context.Background(), nil, false)
+		assert.Nil(t, err)
+	}
+
+	// every bits value of interval [10, Complexity) is issued, and nothing else
+	snapshot := bits.Snapshot()
+	assert.Len(t, snapshot, 5)
+
+	var total uint64
+	for b := 10; b < 15; b++ {
+		assert.Greater(t, snapshot[b], uint64(0), "bits %d", b)
+		total += snapshot[b]
+	}
+	assert.EqualValues(t, 1000, total)
+}
+
+func TestProofOfWork_newChallenge_min_bits(t *testing.T) {
+	tests := []struct {
+		name       string
+		minBits    int
+		complexity int
+		// lowest is the lowest bits issued
+		lowest int
+	}{
+		{name: "default", complexity: 13, lowest: DefaultMinBits},
+		{name: "below default", minBits: 4, complexity: 8, lowest: 4},
+		{name: "above default", minBits: 16, complexity: 19, lowest: 16},
+		{name: "single value", minBits: 12, complexity: 13, lowest: 12},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bits := metrics.NewHistogram()
+			settings := ProofOfWorkSettings{
+				Challenge:  pow.Challenge,
+				Verify:     pow.Verify,
+				Complexity: test.complexity,
+				MinBits:    test.minBits,
+				WaitPOW:    1 * time.Minute,
+				Bits:       bits,
+			}
+
+			handler := newProofOfWork(t, mocks.NewHandler(t), settings, setupLogMock(t))
+
+			for i := 0; i < 500; i++ {
+				_, err := handler.newChallenge(context.Background(), nil, false)
+				assert.Nil(t, err)
+			}
+
+			// every bits value of interval [MinBits, Complexity) is issued, and nothing else
+			snapshot := bits.Snapshot()
+			assert.Len(t, snapshot, test.complexity-test.lowest)
+
+			var total uint64
+			for b := test.lowest; b < test.complexity; b++ {
+				assert.Greater(t, snapshot[b], uint64(0), "bits %d", b)
+				total += snapshot[b]
+			}
+			assert.EqualValues(t, 500, total)
+		})
+	}
+}
+
+func TestNewProofOfWork_invalid_bits(t *testing.T) {
+	tests := []struct {
+		name       string
+		minBits    int
+		complexity int
+		want       string
+	}{
+		{
+			name:       "complexity equal to default min bits",
+			complexity: DefaultMinBits,
+			want:       "invalid Complexity 10: it must exceed MinBits 10",
+		},
+		{
+			name:       "complexity below min bits",
+			minBits:    16,
+			complexity: 12,
+			want:       "invalid Complexity 12: it must exceed MinBits 16",
+		},
+		{
+			name:       "negative min bits",
+			minBits:    -1,
+			complexity: 12,
+			want:       "invalid MinBits -1: it must be positive",
+		},
+		{
+			name: "no complexity",
+			want: "invalid Complexity 0: it must exceed MinBits 10",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := ProofOfWorkSettings{
+				Challenge:  pow.Challenge,
+				Verify:     pow.Verify,
+				Complexity: test.complexity,
+				MinBits:    test.minBits,
+				WaitPOW:    1 * time.Minute,
+			}
+
+			handler, err := NewProofOfWork(mocks.NewHandler(t), settings, setupLogMock(t))
+			assert.Nil(t, handler)
+			if assert.NotNil(t, err) {
+				assert.Equal(t, test.want, err.Error())
+			}
+		})
+	}
+}
+
+func TestProofOfWork_ServeTCP_batch(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+	incorrectStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyNw=="
+
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.FixedChallenge(challengeStr),
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+		MaxBatch:   2,
+	}
+
+	// more quotes than allowed are requested, so the batch is limited to MaxBatch
+	request := `{"capabilities":["batch"],"count":3}`
+	batch := `{"challenges":["` + challengeStr + `","` + challengeStr + `"]}`
+
+	tests := []struct {
+		name   string
+		proofs string
+		passed bool
+	}{
+		{name: "all results correct", proofs: `{"proofs":["` + calculatedStr + `","` + calculatedStr + `"]}`, passed: true},
+		{name: "one result incorrect", proofs: `{"proofs":["` + calculatedStr + `","` + incorrectStr + `"]}`},
+		{name: "results missing", proofs: `{"proofs":["` + calculatedStr + `"]}`},
+		{name: "not a batch proof", proofs: calculatedStr},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := setupLogMock(t)
+
+			mockHandler := mocks.NewHandler(t)
+			if test.passed {
+				mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+					assert.Equal(t, 2, quoteCountFrom(args.Get(0).(context.Context)))
+					args.Get(1).(tcp.Conn).Close()
+				}).Once()
+			}
+
+			conn := setupConnMock(t)
+			onReadFrame(conn, request)
+			conn.On("Write", frame(batch)).Return(len(frame(batch)), nil).Once()
+			onReadFrame(conn, test.proofs)
+			if !test.passed {
+				conn.On("Write", frame("PoW verification failed")).Return(len(frame("PoW verification failed")), nil).Once()
+			}
+
+			handler := newProofOfWork(t, mockHandler, settings, log)
+
+			handler.ServeTCP(context.Background(), conn)
+
+			conn.AssertCalled(t, "Close")
+			log.AssertNumberOfCalls(t, "Error", 0)
+		})
+	}
+}
+
+func TestProofOfWork_ServeTCP_stalled(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	tests := []struct {
+		name     string
+		setup    func(conn *mocks.Conn)
+		timedOut float64
+	}{
+		{
+			name: "stalled on initial message",
+			setup: func(conn *mocks.Conn) {
+				conn.On("Read", mock.AnythingOfType("[]uint8")).Return([]byte{}, os.ErrDeadlineExceeded).Once()
+			},
+		},
+		{
+			name: "stalled on PoW result",
+			setup: func(conn *mocks.Conn) {
+				onReadFrame(conn, "ping")
+				conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+				conn.On("Read", mock.AnythingOfType("[]uint8")).Return([]byte{}, os.ErrDeadlineExceeded).Once()
+			},
+			timedOut: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := setupLogMock(t)
+
+			prom := metrics.NewPrometheus()
+			settings := ProofOfWorkSettings{
+				Challenge:  pow.FixedChallenge(challengeStr),
+				Verify:     pow.Verify,
+				Complexity: 20,
+				Prometheus: prom,
+				WaitPOW:    1 * time.Minute,
+			}
+
+			conn := setupConnMock(t)
+			test.setup(conn)
+
+			mockHandler := mocks.NewHandler(t)
+
+			handler := newProofOfWork(t, mockHandler, settings, log)
+
+			handler.ServeTCP(context.Background(), conn)
+
+			conn.AssertCalled(t, "Close")
+			log.AssertNumberOfCalls(t, "Warn", 1)  // on connection stalled
+			log.AssertNumberOfCalls(t, "Error", 0) // dropped cleanly
+
+			assertOutcomes(t, prom, 0, 0, test.timedOut)
+		})
+	}
+}
+
+func TestProofOfWork_Drain(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	log := setupLogMock(t)
+
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.FixedChallenge(challengeStr),
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+	}
+
+	mockHandler := mocks.NewHandler(t)
+	mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(1).(tcp.Conn).Close()
+	}).Once()
+
+	handler := newProofOfWork(t, mockHandler, settings, log)
+
+	// a connection which has received a challenge is solving it
+	challenged := make(chan struct{})
+	solved := make(chan struct{})
+
+	solving := setupConnMock(t)
+	onReadFrame(solving, "ping")
+	solving.On("Write", frame(challengeStr)).Run(func(_ mock.Arguments) {
+		close(challenged)
+	}).Return(len(frame(challengeStr)), nil).Once()
+	header, _ := onReadFrame(solving, calculatedStr)
+	header.Run(func(_ mock.Arguments) {
+		<-solved
+	})
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		handler.ServeTCP(context.Background(), solving)
+	}()
+
+	<-challenged
+
+	drained := make(chan error)
+	go func() {
+		drained <- handler.Drain(context.Background())
+	}()
+
+	// wait for draining to start
+	assert.Eventually(t, handler.drainer.isDraining, time.Second, time.Millisecond)
+
+	// a connection accepted after draining has started gets no challenge
+	rejected := setupConnMock(t)
+	onReadFrame(rejected, "ping")
+	rejected.On("Write", frame("server is shutting down")).Return(len(frame("server is shutting down")), nil).Once()
+
+	handler.ServeTCP(context.Background(), rejected)
+
+	select {
+	case <-drained:
+		t.Fatal("drain completed with a connection in flight")
+	default:
+	}
+
+	// the connection in flight completes the flow
+	close(solved)
+	<-served
+
+	assert.Nil(t, <-drained)
+	mockHandler.AssertNumberOfCalls(t, "ServeTCP", 1)
+}
+
+func TestProofOfWork_Drain_grace_period(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	handler := newProofOfWork(t, mocks.NewHandler(t), ProofOfWorkSettings{
+		Challenge:  pow.FixedChallenge(challengeStr),
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+	}, setupLogMock(t))
+
+	// a flow in flight never completes
+	assert.True(t, handler.drainer.enter())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, handler.Drain(ctx), context.DeadlineExceeded)
+}
+
+func TestProofOfWork_ServeTCP_rate_limited(t *testing.T) {
+	log := setupLogMock(t)
+
+	// challenges are counted and not issued, so the flow ends right after challenge generation
+	var challenged int
+	settings := ProofOfWorkSettings{
+		Challenge: func(bits uint, resource string) (string, error) {
+			challenged++
+			return "", errors.New("not issued")
+		},
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+		RateLimit:  0.001,
+		RateBurst:  2,
+	}
+
+	handler := newProofOfWork(t, mocks.NewHandler(t), settings, log)
+
+	connect := func(ip string) *mocks.Conn {
+		conn := mocks.NewConn(t)
+		conn.On("Close").Return(nil)
+		conn.On("RemoteAddr").Return(func() net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: 4242} })
+		onReadFrame(conn, "ping")
+
+		return conn
+	}
+
+	// the burst is served
+	for i := 0; i < 2; i++ {
+		conn := connect("10.0.0.1")
+		conn.On("Write", frame("internal error generating challenge")).Return(0, nil).Once()
+
+		handler.ServeTCP(context.Background(), conn)
+	}
+	assert.Equal(t, 2, challenged)
+
+	// the next connection from the same IP is throttled without getting a challenge
+	conn := connect("10.0.0.1")
+	conn.On("Write", frame("rate limited")).Return(0, nil).Once()
+
+	handler.ServeTCP(context.Background(), conn)
+
+	assert.Equal(t, 2, challenged)
+	conn.AssertCalled(t, "Close")
+	log.AssertNumberOfCalls(t, "Warn", 1) // on rate limit exceeded
+
+	// other IPs are not affected
+	conn = connect("10.0.0.2")
+	conn.On("Write", frame("internal error generating challenge")).Return(0, nil).Once()
+
+	handler.ServeTCP(context.Background(), conn)
+
+	assert.Equal(t, 3, challenged)
+}
+
+func TestProofOfWork_ServeTCP_unknown_remote(t *testing.T) {
+	settings := ProofOfWorkSettings{
+		Challenge: func(bits uint, resource string) (string, error) {
+			return "", errors.New("not issued")
+		},
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+		RateLimit:  0.001,
+		RateBurst:  1,
+	}
+
+	handler := newProofOfWork(t, mocks.NewHandler(t), settings, setupLogMock(t))
+
+	// connections with no remote address are served without a panic and share the "unknown" key
+	connect := func() *mocks.Conn {
+		conn := mocks.NewConn(t)
+		conn.On("Close").Return(nil)
+		conn.On("RemoteAddr").Return(nil)
+		onReadFrame(conn, "ping")
+
+		return conn
+	}
+
+	conn := connect()
+	conn.On("Write", frame("internal error generating challenge")).Return(0, nil).Once()
+	assert.NotPanics(t, func() {
+		handler.ServeTCP(context.Background(), conn)
+	})
+
+	conn = connect()
+	conn.On("Write", frame("rate limited")).Return(0, nil).Once()
+	assert.NotPanics(t, func() {
+		handler.ServeTCP(context.Background(), conn)
+	})
+
+	assert.False(t, handler.limiter.allow(tcp.UnknownRemote))
+}
+
+func TestProofOfWork_newChallenge_adaptive(t *testing.T) {
+	tests := []struct {
+		name    string
+		load    float64
+		minBits int
+		maxBits int
+	}{
+		{name: "idle server", load: 0, minBits: 10, maxBits: 19},
+		{name: "half loaded server", load: 0.5, minBits: 14, maxBits: 19},
+		{name: "fully loaded server", load: 1, minBits: 19, maxBits: 19},
+		{name: "overloaded server", load: 2, minBits: 19, maxBits: 19},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			load := mocks.NewLoadTracker(t)
+			load.On("Load").Return(test.load)
+
+			bits := metrics.NewHistogram()
+
+			settings := ProofOfWorkSettings{
+				Challenge:  pow.Challenge,
+				Verify:     pow.Verify,
+				Complexity: 20,
+				WaitPOW:    1 * time.Minute,
+				Bits:       bits,
+				Load:       load,
+			}
+
+			handler := newProofOfWork(t, mocks.NewHandler(t), settings, setupLogMock(t))
+
+			for i := 0; i < 200; i++ {
+				_, err := handler.newChallenge(context.Background(), nil, false)
+				assert.Nil(t, err)
+			}
+
+			for b := range bits.Snapshot() {
+				assert.GreaterOrEqual(t, b, test.minBits)
+				assert.LessOrEqual(t, b, test.maxBits)
+			}
+		})
+	}
+}
+
+func TestProofOfWork_minBits_warm_up(t *testing.T) {
+	// the server is fully loaded from the very start
+	load := mocks.NewLoadTracker(t)
+	load.On("Load").Maybe().Return(1.0)
+
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.Challenge,
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+		Load:       load,
+		WarmUp:     30 * time.Second,
+	}
+
+	handler := newProofOfWork(t, mocks.NewHandler(t), settings, setupLogMock(t))
+
+	now := time.Now()
+	handler.now = func() time.Time { return now }
+
+	// the baseline difficulty is kept during the warm-up regardless of the load
+	for _, elapsed := range []time.Duration{0, 10 * time.Second, 29 * time.Second} {
+		now = handler.warmUpUntil.Add(elapsed - settings.WarmUp)
+		assert.Equal(t, 10, handler.minBits(handler.difficulty(), settings.Complexity), "elapsed %s", elapsed)
+	}
+	load.AssertNotCalled(t, "Load")
+
+	// and then the difficulty escalates
+	now = handler.warmUpUntil
+	assert.Equal(t, 19, handler.minBits(handler.difficulty(), settings.Complexity))
+}
+
+func TestProofOfWork_ServeTCP_adaptive(t *testing.T) {
+	// challenges are recorded and not issued, so the flow ends right after challenge generation
+	var issued []uint
+
+	settings := ProofOfWorkSettings{
+		Challenge: func(bits uint, resource string) (string, error) {
+			issued = append(issued, bits)
+			return "", errors.New("not issued")
+		},
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+		// the server is fully loaded with 10 connections a minute
+		Load: NewConnectionRate(time.Minute, 10),
+	}
+
+	handler := newProofOfWork(t, mocks.NewHandler(t), settings, setupLogMock(t))
+
+	// simulate a connection flood
+	for i := 0; i < 20; i++ {
+		conn := setupConnMock(t)
+		onReadFrame(conn, "ping")
+		conn.On("Write", frame("internal error generating challenge")).Return(0, nil).Once()
+
+		handler.ServeTCP(context.Background(), conn)
+	}
+
+	// the lower bound of bits rises with every connection until the server is saturated
+	assert.Len(t, issued, 20)
+	for i, bits := range issued {
+		load := float64(i+1) / 10
+		if load > 1 {
+			load = 1
+		}
+		assert.GreaterOrEqual(t, bits, uint(10+int(load*9)), "connection %d", i)
+	}
+	for _, bits := range issued[9:] {
+		assert.EqualValues(t, 19, bits)
+	}
+}
+
+func TestProofOfWork_ServeTCP_negotiation(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	tests := []struct {
+		name     string
+		proposed int
+		want     int
+		accepted bool
+	}{
+		{name: "in bounds", proposed: 15, want: 15, accepted: true},
+		{name: "lower bound", proposed: 12, want: 12, accepted: true},
+		{name: "upper bound", proposed: 19, want: 19, accepted: true},
+		{name: "below lower bound", proposed: 5, want: 12, accepted: false},
+		{name: "over upper bound", proposed: 30, want: 19, accepted: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bits := metrics.NewHistogram()
+
+			var issued uint
+			settings := ProofOfWorkSettings{
+				Challenge: func(b uint, _ string) (string, error) {
+					issued = b
+					return challengeStr, nil
+				},
+				Verify:            pow.Verify,
+				Complexity:        20,
+				WaitPOW:           1 * time.Minute,
+				MinNegotiatedBits: 12,
+				Bits:              bits,
+			}
+
+			request, err := json.Marshal(protocol.Request{
+				Capabilities: []protocol.Capability{protocol.CapabilityNegotiation},
+				Bits:         test.proposed,
+			})
+			assert.Nil(t, err)
+
+			offer, err := json.Marshal(protocol.NegotiatedChallenge{
+				Challenge: challengeStr,
+				Bits:      test.want,
+				Accepted:  test.accepted,
+			})
+			assert.Nil(t, err)
+
+			conn := setupConnMock(t)
+			onReadFrame(conn, string(request))
+			conn.On("Write", frame(string(offer))).Return(len(frame(string(offer))), nil).Once()
+			onReadFrame(conn, calculatedStr)
+
+			mockHandler := mocks.NewHandler(t)
+			mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				args.Get(1).(tcp.Conn).Close()
+			}).Once()
+
+			handler := newProofOfWork(t, mockHandler, settings, setupLogMock(t))
+			handler.ServeTCP(context.Background(), conn)
+
+			// the agreed bits are issued and recorded
+			assert.EqualValues(t, test.want, issued)
+			assert.Equal(t, map[int]uint64{test.want: 1}, bits.Snapshot())
+		})
+	}
+}
+
+func TestProofOfWork_negotiatedBits_min_bits(t *testing.T) {
+	// negotiation is allowed down to bits the server doesn't issue at all
+	settings := ProofOfWorkSettings{
+		Challenge:         pow.Challenge,
+		Verify:            pow.Verify,
+		Complexity:        20,
+		MinBits:           14,
+		WaitPOW:           1 * time.Minute,
+		MinNegotiatedBits: 1,
+	}
+
+	handler := newProofOfWork(t, mocks.NewHandler(t), settings, setupLogMock(t))
+
+	tests := []struct {
+		name     string
+		proposed int
+		want     int
+	}{
+		{name: "below MinBits", proposed: 4, want: 14},
+		{name: "in bounds", proposed: 16, want: 16},
+		{name: "over Complexity", proposed: 25, want: 19},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.want, handler.negotiatedBits(test.proposed, false), test.name)
+	}
+}
+
+func TestProofOfWork_ServeTCP_negotiation_disabled(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.FixedChallenge(challengeStr),
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+	}
+
+	request, err := json.Marshal(protocol.Request{
+		Capabilities: []protocol.Capability{protocol.CapabilityNegotiation},
+		Bits:         12,
+	})
+	assert.Nil(t, err)
+
+	// the proposal is ignored, and the plain challenge header is sent
+	conn := setupConnMock(t)
+	onReadFrame(conn, string(request))
+	conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+	onReadFrame(conn, calculatedStr)
+
+	mockHandler := mocks.NewHandler(t)
+	mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(1).(tcp.Conn).Close()
+	}).Once()
+
+	handler := newProofOfWork(t, mockHandler, settings, setupLogMock(t))
+	handler.ServeTCP(context.Background(), conn)
+}
+
+func TestProofOfWork_ServeTCP_hex(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	hexChallengeStr, err := pow.EncodeChallenge(challengeStr, pow.EncodingHex)
+	assert.Nil(t, err)
+	calculatedStr, err := pow.Calculate(hexChallengeStr)
+	assert.Nil(t, err)
+
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.FixedChallenge(challengeStr),
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+	}
+
+	request, err := json.Marshal(protocol.Request{Capabilities: []protocol.Capability{protocol.CapabilityHex}})
+	assert.Nil(t, err)
+
+	// the challenge is sent hex-encoded, and the hex-encoded result passes the verification
+	conn := setupConnMock(t)
+	onReadFrame(conn, string(request))
+	conn.On("Write", frame(hexChallengeStr)).Return(len(frame(hexChallengeStr)), nil).Once()
+	onReadFrame(conn, calculatedStr)
+
+	mockHandler := mocks.NewHandler(t)
+	mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(1).(tcp.Conn).Close()
+	}).Once()
+
+	handler := newProofOfWork(t, mockHandler, settings, setupLogMock(t))
+	handler.ServeTCP(context.Background(), conn)
+}
+
+func TestProofOfWork_ServeTCP_authentication(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	newHandler := func(t *testing.T, next tcp.Handler, reduced bool) (*ProofOfWork, *[]uint) {
+		var issued []uint
+
+		settings := ProofOfWorkSettings{
+			Challenge: func(bits uint, _ string) (string, error) {
+				issued = append(issued, bits)
+				return challengeStr, nil
+			},
+			Verify:     pow.Verify,
+			Complexity: 40,
+			WaitPOW:    1 * time.Minute,
+
+			Authenticator:        NewAPIKeyAuthenticator(map[string]string{"alice": "key"}),
+			AuthenticatedReduced: reduced,
+			TokenComplexity:      11,
+		}
+
+		return newProofOfWork(t, next, settings, setupLogMock(t)), &issued
+	}
+
+	t.Run("valid key skips PoW", func(t *testing.T) {
+		var identity string
+
+		mockHandler := mocks.NewHandler(t)
+		mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			identity, _ = identityFrom(args.Get(0).(context.Context))
+			args.Get(1).(tcp.Conn).Close()
+		}).Once()
+
+		handler, issued := newHandler(t, mockHandler, false)
+
+		conn := setupConnMock(t)
+		onReadFrame(conn, `{"api_key":"key"}`)
+
+		handler.ServeTCP(context.Background(), conn)
+
+		assert.Empty(t, *issued)
+		assert.Equal(t, "alice", identity)
+	})
+
+	t.Run("valid key reduces PoW", func(t *testing.T) {
+		mockHandler := mocks.NewHandler(t)
+		mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			args.Get(1).(tcp.Conn).Close()
+		}).Once()
+
+		handler, issued := newHandler(t, mockHandler, true)
+
+		conn := setupConnMock(t)
+		onReadFrame(conn, `{"api_key":"key"}`)
+		conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+		onReadFrame(conn, calculatedStr)
+
+		handler.ServeTCP(context.Background(), conn)
+
+		assert.Equal(t, []uint{10}, *issued)
+	})
+
+	t.Run("invalid key is rejected", func(t *testing.T) {
+		handler, issued := newHandler(t, mocks.NewHandler(t), false)
+
+		conn := setupConnMock(t)
+		onReadFrame(conn, `{"api_key":"forged"}`)
+		conn.On("Write", frame("authentication failed")).Return(0, nil).Once()
+
+		handler.ServeTCP(context.Background(), conn)
+
+		assert.Empty(t, *issued)
+		conn.AssertCalled(t, "Close")
+	})
+
+	t.Run("anonymous client passes PoW", func(t *testing.T) {
+		mockHandler := mocks.NewHandler(t)
+		mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			args.Get(1).(tcp.Conn).Close()
+		}).Once()
+
+		handler, issued := newHandler(t, mockHandler, false)
+
+		conn := setupConnMock(t)
+		onReadFrame(conn, "ping")
+		conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+		onReadFrame(conn, calculatedStr)
+
+		handler.ServeTCP(context.Background(), conn)
+
+		assert.Len(t, *issued, 1)
+	})
+}
+
+func TestProofOfWork_ServeTCP_catalog(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	request, err := json.Marshal(protocol.Request{Capabilities: []protocol.Capability{protocol.CapabilityCatalog}})
+	assert.Nil(t, err)
+
+	newHandler := func(t *testing.T, next tcp.Handler, afterPoW bool) *ProofOfWork {
+		settings := ProofOfWorkSettings{
+			Challenge:  pow.FixedChallenge(challengeStr),
+			Verify:     pow.Verify,
+			Complexity: 20,
+			WaitPOW:    1 * time.Minute,
+
+			Categories:      func() []string { return []string{"life", "wit"} },
+			CatalogAfterPoW: afterPoW,
+		}
+
+		return newProofOfWork(t, next, settings, setupLogMock(t))
+	}
+
+	t.Run("catalog before PoW", func(t *testing.T) {
+		var written []byte
+
+		conn := setupConnMock(t)
+		onReadFrame(conn, string(request))
+		conn.On("Write", mock.AnythingOfType("[]uint8")).Run(func(args mock.Arguments) {
+			written = args.Get(0).([]byte)
+		}).Return(func(b []byte) int { return len(b) }, nil).Once()
+
+		newHandler(t, mocks.NewHandler(t), false).ServeTCP(context.Background(), conn)
+
+		catalog, ok := protocol.ParseCatalog(written[tcp.FrameHeaderSize:])
+		if assert.True(t, ok) {
+			assert.Contains(t, catalog.Capabilities, protocol.CapabilityCatalog)
+			assert.Equal(t, []string{"life", "wit"}, catalog.Categories)
+		}
+		conn.AssertCalled(t, "Close")
+	})
+
+	t.Run("catalog refused before PoW", func(t *testing.T) {
+		// the client gets a challenge instead of the catalog and gets nothing if it doesn't pass PoW
+		conn := setupConnMock(t)
+		onReadFrame(conn, string(request))
+		conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+		onReadFrame(conn, challengeStr)
+		conn.On("Write", frame("PoW verification failed")).Return(0, nil).Once()
+
+		newHandler(t, mocks.NewHandler(t), true).ServeTCP(context.Background(), conn)
+	})
+
+	t.Run("catalog after PoW", func(t *testing.T) {
+		var catalog *protocol.Catalog
+
+		mockHandler := mocks.NewHandler(t)
+		mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			catalog, _ = catalogFrom(args.Get(0).(context.Context))
+			args.Get(1).(tcp.Conn).Close()
+		}).Once()
+
+		conn := setupConnMock(t)
+		onReadFrame(conn, string(request))
+		conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+		onReadFrame(conn, calculatedStr)
+
+		newHandler(t, mockHandler, true).ServeTCP(context.Background(), conn)
+
+		if assert.NotNil(t, catalog) {
+			assert.Equal(t, []string{"life", "wit"}, catalog.Categories)
+		}
+	})
+}
+
+func TestProofOfWork_ServeTCP_verification_reason(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	tests := []struct {
+		name    string
+		result  string
+		message string
+	}{
+		{
+			name:    "not enough leading zeros",
+			result:  "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyNw==",
+			message: "PoW verification failed: not enough leading zeros",
+		},
+		{
+			name:    "wrong challenge",
+			result:  "1:12:2208082127:f1a5a003-27ce-4e62-8c48-14c250965b92::kUumfNZAqta03Q==:MTA4MDAyODM5MTgzMzgyMTg0OQ==",
+			message: "PoW verification failed: wrong challenge",
+		},
+		{
+			name:    "malformed result",
+			result:  "not a header",
+			message: "PoW verification failed: malformed result",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := ProofOfWorkSettings{
+				Challenge:    pow.FixedChallenge(challengeStr),
+				VerifyReason: pow.VerifyReason,
+				Complexity:   20,
+				WaitPOW:      1 * time.Minute,
+			}
+
+			log := setupLogMock(t)
+
+			conn := setupConnMock(t)
+			onReadFrame(conn, "ping")
+			conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+			onReadFrame(conn, test.result)
+			conn.On("Write", frame(test.message)).Return(len(frame(test.message)), nil).Once()
+
+			handler := newProofOfWork(t, mocks.NewHandler(t), settings, log)
+			handler.ServeTCP(context.Background(), conn)
+
+			conn.AssertCalled(t, "Close")
+			log.AssertNumberOfCalls(t, "Error", 0)
+		})
+	}
+}
+
+func TestProofOfWork_ServeTCP_challenge_ttl(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+	issued := time.Date(2022, time.August, 8, 21, 21, 0, 0, time.Local)
+
+	tests := []struct {
+		name    string
+		waitPOW time.Duration
+		ttl     time.Duration
+		// age is the time since the challenge has been issued at verification
+		age time.Duration
+		// delay is the time the client takes to send the result
+		delay time.Duration
+		// message is the one the client is told, the client is served if it's empty
+		message string
+	}{
+		{
+			name:    "within TTL",
+			waitPOW: time.Minute,
+			ttl:     5 * time.Minute,
+			age:     4 * time.Minute,
+		},
+		{
+			name:    "expired",
+			waitPOW: time.Minute,
+			ttl:     5 * time.Minute,
+			age:     6 * time.Minute,
+			message: "PoW verification failed: expired challenge",
+		},
+		{
+			name:    "long wait, short TTL",
+			waitPOW: time.Hour,
+			ttl:     2 * time.Minute,
+			age:     3 * time.Minute,
+			message: "PoW verification failed: expired challenge",
+		},
+		{
+			name:    "short wait, long TTL",
+			waitPOW: 10 * time.Millisecond,
+			ttl:     time.Hour,
+			delay:   50 * time.Millisecond,
+			message: "context done",
+		},
+		{
+			name:    "no TTL",
+			waitPOW: time.Minute,
+			age:     24 * time.Hour,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings := ProofOfWorkSettings{
+				Challenge:    pow.FixedChallenge(challengeStr),
+				VerifyReason: pow.VerifyReason,
+				Complexity:   20,
+				WaitPOW:      test.waitPOW,
+				ChallengeTTL: test.ttl,
+			}
+
+			log := setupLogMock(t)
+
+			conn := setupConnMock(t)
+			onReadFrame(conn, "ping")
+			conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+			header, payload := onReadFrame(conn, calculatedStr)
+			if test.delay > 0 {
+				header.Maybe().After(test.delay)
+				payload.Maybe()
+			}
+
+			next := mocks.NewHandler(t)
+			if test.message == "" {
+				next.On("ServeTCP", mock.Anything, conn).Run(func(args mock.Arguments) {
+					conn.Close()
+				}).Once()
+			} else {
+				conn.On("Write", frame(test.message)).Return(len(frame(test.message)), nil).Once()
+			}
+
+			handler := newProofOfWork(t, next, settings, log)
+			handler.now = func() time.Time { return issued.Add(test.age) }
+			handler.ServeTCP(context.Background(), conn)
+
+			conn.AssertCalled(t, "Close")
+			log.AssertNumberOfCalls(t, "Error", 0)
+		})
+	}
+}
+
+func TestProofOfWork_ServeTCP_resource(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	var resources []string
+
+	settings := ProofOfWorkSettings{
+		Challenge: func(_ uint, resource string) (string, error) {
+			resources = append(resources, resource)
+			return challengeStr, nil
+		},
+		Verify:             pow.Verify,
+		Complexity:         20,
+		WaitPOW:            1 * time.Minute,
+		IssueNextChallenge: true,
+		Resource:           func(_ tcp.Conn) string { return "quotes" },
+	}
+
+	request, err := json.Marshal(protocol.Request{Capabilities: []protocol.Capability{protocol.CapabilityNextChallenge}})
+	assert.Nil(t, err)
+
+	conn := setupConnMock(t)
+	onReadFrame(conn, string(request))
+	conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+	onReadFrame(conn, calculatedStr)
+
+	mockHandler := mocks.NewHandler(t)
+	mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(1).(tcp.Conn).Close()
+	}).Once()
+
+	handler := newProofOfWork(t, mockHandler, settings, setupLogMock(t))
+	handler.ServeTCP(context.Background(), conn)
+
+	// both the challenge and the next one are issued for the resource derived from the connection
+	assert.Equal(t, []string{"quotes", "quotes"}, resources)
+}
+
+func TestProofOfWork_issueChallenge_invalid_resource(t *testing.T) {
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.Challenge,
+		Verify:     pow.Verify,
+		Complexity: 11,
+		Resource:   func(_ tcp.Conn) string { return "::1" },
+	}
+
+	handler := newProofOfWork(t, mocks.NewHandler(t), settings, setupLogMock(t))
+
+	_, err := handler.issueChallenge(context.Background(), nil, 10, false)
+	assert.EqualError(t, err, `invalid challenge resource "::1": it mustn't contain header fields separator`)
+}
+
+func TestRemoteIPResource(t *testing.T) {
+	tests := []struct {
+		name string
+		addr net.Addr
+		want string
+	}{
+		{
+			name: "IPv4",
+			addr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 80},
+			want: "192.0.2.1",
+		},
+		{
+			name: "IPv6",
+			addr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 80},
+			want: "2001-db8--1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := mocks.NewConn(t)
+			conn.On("RemoteAddr").Return(test.addr)
+
+			assert.Equal(t, test.want, RemoteIPResource(conn))
+		})
+	}
+}
+
+func TestProofOfWork_ServeTCP_context_done(t *testing.T) {
+	challenge := mocks.NewChallengeFunc(t)
+
+	settings := ProofOfWorkSettings{
+		Challenge:  challenge.Execute,
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// nothing is read from the connection, and no challenge is issued
+	conn := setupConnMock(t)
+	conn.On("Write", frame("server is shutting down")).Return(len(frame("server is shutting down")), nil).Once()
+
+	handler := newProofOfWork(t, mocks.NewHandler(t), settings, setupLogMock(t))
+	handler.ServeTCP(ctx, conn)
+
+	conn.AssertNotCalled(t, "Read", mock.Anything)
+	conn.AssertCalled(t, "Close")
+}
+
+// assertOutcomes asserts the numbers of PoW verification outcomes collected for Prometheus.
+func assertOutcomes(t *testing.T, prom *metrics.Prometheus, passed, failed, timedOut float64) {
+	t.Helper()
+
+	assert.Equal(t, passed, testutil.ToFloat64(prom.Verifications.WithLabelValues(metrics.OutcomePassed)), "passed")
+	assert.Equal(t, failed, testutil.ToFloat64(prom.Verifications.WithLabelValues(metrics.OutcomeFailed)), "failed")
+	assert.Equal(t, timedOut, testutil.ToFloat64(prom.Verifications.WithLabelValues(metrics.OutcomeTimedOut)), "timed out")
+}
+
+func TestProofOfWork_ServeTCP_handshake(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.FixedChallenge(challengeStr),
+		Verify:     pow.Verify,
+		Algorithm:  pow.SHA1,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+	}
+
+	t.Run("matching version", func(t *testing.T) {
+		request, err := json.Marshal(protocol.Request{
+			Capabilities: []protocol.Capability{protocol.CapabilityHello},
+			Version:      protocol.Version,
+		})
+		assert.Nil(t, err)
+
+		hello, err := json.Marshal(protocol.Hello{Version: protocol.Version, Algorithm: "sha1"})
+		assert.Nil(t, err)
+
+		// the client is greeted before the challenge, and the flow proceeds as usual
+		conn := setupConnMock(t)
+		onReadFrame(conn, string(request))
+		conn.On("Write", frame(string(hello))).Return(len(frame(string(hello))), nil).Once()
+		conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+		onReadFrame(conn, calculatedStr)
+
+		mockHandler := mocks.NewHandler(t)
+		mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			args.Get(1).(tcp.Conn).Close()
+		}).Once()
+
+		handler := newProofOfWork(t, mockHandler, settings, setupLogMock(t))
+		handler.ServeTCP(context.Background(), conn)
+	})
+
+	t.Run("mismatched version", func(t *testing.T) {
+		request, err := json.Marshal(protocol.Request{
+			Capabilities: []protocol.Capability{protocol.CapabilityHello},
+			Version:      protocol.Version + 1,
+		})
+		assert.Nil(t, err)
+
+		message := fmt.Sprintf("unsupported protocol version %d: server speaks version %d",
+			protocol.Version+1, protocol.Version)
+
+		// the client is rejected before any challenge is issued
+		conn := setupConnMock(t)
+		onReadFrame(conn, string(request))
+		conn.On("Write", frame(message)).Return(len(frame(message)), nil).Once()
+
+		log := setupLogMock(t)
+
+		handler := newProofOfWork(t, mocks.NewHandler(t), settings, log)
+		handler.ServeTCP(context.Background(), conn)
+
+		log.AssertNumberOfCalls(t, "Warn", 1)  // on unsupported version
+		log.AssertNumberOfCalls(t, "Error", 0) // rejected cleanly
+	})
+
+	t.Run("no version declared", func(t *testing.T) {
+		// a legacy client is neither greeted nor rejected
+		conn := setupConnMock(t)
+		onReadFrame(conn, "ping")
+		conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+		onReadFrame(conn, calculatedStr)
+
+		mockHandler := mocks.NewHandler(t)
+		mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			args.Get(1).(tcp.Conn).Close()
+		}).Once()
+
+		handler := newProofOfWork(t, mockHandler, settings, setupLogMock(t))
+		handler.ServeTCP(context.Background(), conn)
+	})
+}
+
+func TestProofOfWork_ServeTCP_invalid_request(t *testing.T) {
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.Challenge,
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+	}
+
+	for _, message := range []string{"pong", `{"capabilities":"batch"}`} {
+		t.Run(message, func(t *testing.T) {
+			log := setupLogMock(t)
+
+			// the client is rejected before any challenge is issued
+			conn := setupConnMock(t)
+			onReadFrame(conn, message)
+			conn.On("Write", frame("invalid request")).Return(len(frame("invalid request")), nil).Once()
+
+			handler := newProofOfWork(t, mocks.NewHandler(t), settings, log)
+			handler.ServeTCP(context.Background(), conn)
+
+			log.AssertNumberOfCalls(t, "Warn", 1)  // on invalid request
+			log.AssertNumberOfCalls(t, "Error", 0) // rejected cleanly
+		})
+	}
+}
+
+func TestProofOfWork_ServeTCP_envelope(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	hello, err := json.Marshal(protocol.Hello{Version: protocol.Version, Algorithm: "sha256"})
+	assert.Nil(t, err)
+
+	tests := []struct {
+		name    string
+		request protocol.Request
+		waitPOW time.Duration
+		result  string
+		want    []protocol.Response
+		next    bool
+	}{
+		{
+			name:    "challenge",
+			request: protocol.Request{},
+			result:  calculatedStr,
+			want:    []protocol.Response{protocol.NewMessage(protocol.ResponseChallenge, challengeStr)},
+			next:    true,
+		},
+		{
+			name:    "hello",
+			request: protocol.Request{Capabilities: []protocol.Capability{protocol.CapabilityHello}, Version: protocol.Version},
+			result:  calculatedStr,
+			want: []protocol.Response{
+				protocol.NewData(protocol.ResponseHello, hello),
+				protocol.NewMessage(protocol.ResponseChallenge, challengeStr),
+			},
+			next: true,
+		},
+		{
+			name:    "unsupported version",
+			request: protocol.Request{Version: protocol.Version + 1},
+			want: []protocol.Response{protocol.NewError(protocol.CodeUnsupportedVersion,
+				fmt.Sprintf("unsupported protocol version %d: server speaks version %d", protocol.Version+1, protocol.Version))},
+		},
+		{
+			name:    "verification failed",
+			request: protocol.Request{},
+			result:  challengeStr,
+			want: []protocol.Response{
+				protocol.NewMessage(protocol.ResponseChallenge, challengeStr),
+				protocol.NewError(protocol.CodeVerificationFailed, "PoW verification failed"),
+			},
+		},
+		{
+			name:    "unknown challenge",
+			request: protocol.Request{Challenge: challengeStr, Proof: calculatedStr},
+			want:    []protocol.Response{protocol.NewError(protocol.CodeUnknownChallenge, "unknown or expired challenge")},
+		},
+		{
+			name:    "timeout",
+			request: protocol.Request{},
+			waitPOW: time.Nanosecond,
+			result:  calculatedStr,
+			want: []protocol.Response{
+				protocol.NewMessage(protocol.ResponseChallenge, challengeStr),
+				protocol.NewError(protocol.CodeTimeout, "context done"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			waitPOW := test.waitPOW
+			if waitPOW == 0 {
+				waitPOW = time.Minute
+			}
+
+			settings := ProofOfWorkSettings{
+				Challenge:  pow.FixedChallenge(challengeStr),
+				Verify:     pow.Verify,
+				Complexity: 20,
+				WaitPOW:    waitPOW,
+			}
+
+			request := test.request
+			request.Capabilities = append(request.Capabilities, protocol.CapabilityEnvelope)
+			b, err := json.Marshal(request)
+			assert.Nil(t, err)
+
+			conn := setupConnMock(t)
+			onReadFrame(conn, string(b))
+			for _, response := range test.want {
+				message := envelope(t, response)
+				conn.On("Write", frame(message)).Return(len(frame(message)), nil).Once()
+			}
+			if test.result != "" {
+				header, payload := onReadFrame(conn, test.result)
+				if test.waitPOW > 0 {
+					header.Maybe().After(10 * time.Millisecond)
+					payload.Maybe()
+				}
+			}
+
+			mockHandler := mocks.NewHandler(t)
+			if test.next {
+				mockHandler.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+					args.Get(1).(tcp.Conn).Close()
+				}).Once()
+			}
+
+			handler := newProofOfWork(t, mockHandler, settings, setupLogMock(t))
+			handler.ServeTCP(context.Background(), conn)
+		})
+	}
+}
+
+// envelope returns a response as it's written to a client declaring protocol.CapabilityEnvelope.
+func envelope(t *testing.T, response protocol.Response) string {
+	t.Helper()
+
+	b, err := json.Marshal(response)
+	assert.Nil(t, err)
+
+	return string(b)
+}
+
+func TestProofOfWork_ServeTCP_client_version(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	tests := []struct {
+		name    string
+		request string
+		want    string
+	}{
+		{name: "declared", request: `{"client_version":"pow-client/1.2.0"}`, want: "pow-client/1.2.0"},
+		{name: "undeclared", request: `{}`, want: metrics.UnknownClientVersion},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := setupLogMock(t)
+			prom := metrics.NewPrometheus()
+
+			settings := ProofOfWorkSettings{
+				Challenge:  pow.FixedChallenge(challengeStr),
+				Verify:     pow.Verify,
+				Complexity: 20,
+				WaitPOW:    1 * time.Minute,
+				Prometheus: prom,
+			}
+
+			conn := setupConnMock(t)
+			onReadFrame(conn, test.request)
+			conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+			onReadFrame(conn, calculatedStr)
+
+			var nextVersion string
+			next := mocks.NewHandler(t)
+			next.On("ServeTCP", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				nextVersion, _ = clientVersionFrom(args.Get(0).(context.Context))
+				conn.Close()
+			}).Once()
+
+			newProofOfWork(t, next, settings, log).ServeTCP(context.Background(), conn)
+
+			// the version is passed on to the next handler
+			assert.Equal(t, test.want, nextVersion)
+
+			// every record logged once the request is parsed tells the version
+			var versioned int
+			for _, call := range log.Calls {
+				args := call.Arguments
+				if len(args) == 7 && args[5] == "client_version" && args[6] == test.want {
+					versioned++
+				}
+			}
+			assert.Equal(t, len(log.Calls)-1, versioned, "only the record of the read request misses the version")
+
+			assert.Equal(t, float64(1), testutil.ToFloat64(prom.ClientVersions.WithLabelValues(test.want)))
+		})
+	}
+}
+
+func TestProofOfWork_ServeTCP_outcome(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	// challenged sets up the connection to read the initial message and to write the challenge
+	challenged := func(conn *mocks.Conn) {
+		onReadFrame(conn, "ping")
+		conn.On("Write", frame(challengeStr)).Return(len(frame(challengeStr)), nil).Once()
+	}
+
+	tests := []struct {
+		name    string
+		waitPOW time.Duration
+		setup   func(h *ProofOfWork, conn *mocks.Conn, next *mocks.Handler)
+		outcome Outcome
+	}{
+		{
+			name: "passed",
+			setup: func(h *ProofOfWork, conn *mocks.Conn, next *mocks.Handler) {
+				challenged(conn)
+				onReadFrame(conn, calculatedStr)
+				next.On("ServeTCP", mock.Anything, conn).Run(func(args mock.Arguments) {
+					conn.Close()
+				}).Once()
+			},
+			outcome: OutcomePassed,
+		},
+		{
+			name: "verification failed",
+			setup: func(h *ProofOfWork, conn *mocks.Conn, next *mocks.Handler) {
+				challenged(conn)
+				onReadFrame(conn, challengeStr)
+				conn.On("Write", frame("PoW verification failed")).Return(0, nil).Once()
+			},
+			outcome: OutcomeVerificationFailed,
+		},
+		{
+			name:    "result not sent in time",
+			waitPOW: time.Nanosecond,
+			setup: func(h *ProofOfWork, conn *mocks.Conn, next *mocks.Handler) {
+				challenged(conn)
+				header, payload := onReadFrame(conn, calculatedStr)
+				header.Maybe().After(10 * time.Millisecond)
+				payload.Maybe()
+				conn.On("Write", frame("context done")).Return(0, nil).Once()
+			},
+			outcome: OutcomeTimedOut,
+		},
+		{
+			name: "stalled on initial message",
+			setup: func(h *ProofOfWork, conn *mocks.Conn, next *mocks.Handler) {
+				conn.On("Read", mock.AnythingOfType("[]uint8")).Return([]byte{}, os.ErrDeadlineExceeded).Once()
+			},
+			outcome: OutcomeTimedOut,
+		},
+		{
+			name: "stalled on PoW result",
+			setup: func(h *ProofOfWork, conn *mocks.Conn, next *mocks.Handler) {
+				challenged(conn)
+				conn.On("Read", mock.AnythingOfType("[]uint8")).Return([]byte{}, os.ErrDeadlineExceeded).Once()
+			},
+			outcome: OutcomeTimedOut,
+		},
+		{
+			name: "initial message read error",
+			setup: func(h *ProofOfWork, conn *mocks.Conn, next *mocks.Handler) {
+				conn.On("Read", mock.AnythingOfType("[]uint8")).Return([]byte{}, errors.New("read failed")).Once()
+			},
+			outcome: OutcomeReadError,
+		},
+		{
+			name: "PoW result read error",
+			setup: func(h *ProofOfWork, conn *mocks.Conn, next *mocks.Handler) {
+				challenged(conn)
+				conn.On("Read", mock.AnythingOfType("[]uint8")).Return([]byte{}, errors.New("read failed")).Once()
+			},
+			outcome: OutcomeReadError,
+		},
+		{
+			name: "closed by client",
+			setup: func(h *ProofOfWork, conn *mocks.Conn, next *mocks.Handler) {
+				challenged(conn)
+				conn.On("Read", mock.AnythingOfType("[]uint8")).Return(nil, io.EOF).Once()
+			},
+			outcome: OutcomeReadError,
+		},
+		{
+			name: "rate limited",
+			setup: func(h *ProofOfWork, conn *mocks.Conn, next *mocks.Handler) {
+				// the only token of the remote IP has been taken already
+				h.limiter.allow(tcp.RemoteIP(conn))
+				onReadFrame(conn, "ping")
+				conn.On("Write", frame("rate limited")).Return(0, nil).Once()
+			},
+			outcome: OutcomeRateLimited,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var outcomes []Outcome
+			settings := ProofOfWorkSettings{
+				Challenge: pow.FixedChallenge(challengeStr),
+				Verify: func(result, challenge string) (bool, error) {
+					return result == calculatedStr && challenge == challengeStr, nil
+				},
+				Complexity: 20,
+				WaitPOW:    test.waitPOW,
+				RateLimit:  0.001,
+				RateBurst:  1,
+				OnOutcome: func(ctx context.Context, outcome Outcome) {
+					outcomes = append(outcomes, outcome)
+				},
+			}
+			if settings.WaitPOW == 0 {
+				settings.WaitPOW = time.Minute
+			}
+
+			conn := setupConnMock(t)
+			next := mocks.NewHandler(t)
+			handler := newProofOfWork(t, next, settings, setupLogMock(t))
+			test.setup(handler, conn, next)
+
+			handler.ServeTCP(context.Background(), conn)
+
+			assert.Equal(t, []Outcome{test.outcome}, outcomes)
+			conn.AssertCalled(t, "Close")
+		})
+	}
+}
+
+func TestOutcome_String(t *testing.T) {
+	assert.Equal(t, "passed", OutcomePassed.String())
+	assert.Equal(t, "verification_failed", OutcomeVerificationFailed.String())
+	assert.Equal(t, "timed_out", OutcomeTimedOut.String())
+	assert.Equal(t, "read_error", OutcomeReadError.String())
+	assert.Equal(t, "rate_limited", OutcomeRateLimited.String())
+	assert.Equal(t, "unknown", Outcome(0).String())
+}
+
+func TestProofOfWork_ServeTCP_fake_conn(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	svc := mocks.NewWordOfWisdom(t)
+	svc.On("Quote").Return("random quote", nil).Once()
+
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.FixedChallenge(challengeStr),
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+	}
+	next := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, logger.NewNopLogger())
+	handler := newProofOfWork(t, next, settings, logger.NewNopLogger())
+
+	// the client solves the challenge as soon as it's written
+	conn := tcptest.NewConn("ping")
+	conn.OnWrite = func(message string) {
+		if message != challengeStr {
+			return
+		}
+
+		result, err := pow.Calculate(message)
+		assert.Nil(t, err)
+		conn.Push(result)
+	}
+
+	handler.ServeTCP(context.Background(), conn)
+
+	assert.Equal(t, []string{challengeStr, "random quote"}, conn.Written())
+	assert.True(t, conn.Closed())
+}
+
+func TestProofOfWork_getVerificationResult_read_deadline(t *testing.T) {
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.FixedChallenge("challenge"),
+		Verify:     pow.Verify,
+		Complexity: 20,
+		WaitPOW:    1 * time.Minute,
+	}
+	handler := newProofOfWork(t, mocks.NewHandler(t), settings, logger.NewNopLogger())
+
+	// the client keeps the connection open but never sends a result
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	conn := tcp.NewConnWrapper(server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	verification := make(chan verificationResult, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.getVerificationResult(ctx, verification, conn, func(string) (pow.Reason, error) {
+			return pow.ReasonValid, nil
+		})
+	}()
+
+	// the read is released at the end of the awaiting window, so the goroutine doesn't outlive it
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reading goroutine hasn't exited after the deadline")
+	}
+
+	v := <-verification
+	assert.True(t, v.read)
+	assert.True(t, isTimeout(v.err))
+}
+
+// reverseScheme is a trivial pow.Scheme: a challenge is solved by reversing it.
+type reverseScheme struct{}
+
+func (reverseScheme) Challenge(bits uint, resource string) (string, error) {
+	return fmt.Sprintf("reverse:%d:%s", bits, resource), nil
+}
+
+func (s reverseScheme) Verify(calculated, challenge string) (bool, error) {
+	solved, err := s.Solve(challenge)
+	return calculated == solved, err
+}
+
+func (reverseScheme) Solve(challenge string) (string, error) {
+	runes := []rune(challenge)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+
+	return string(runes), nil
+}
+
+func TestProofOfWork_ServeTCP_scheme(t *testing.T) {
+	for name, scheme := range map[string]pow.Scheme{
+		"hashcash": pow.Hashcash{},
+		"reverse":  reverseScheme{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			svc := mocks.NewWordOfWisdom(t)
+			svc.On("Quote").Return("random quote", nil).Once()
+
+			settings := ProofOfWorkSettings{
+				Scheme:     scheme,
+				Complexity: 12,
+				WaitPOW:    1 * time.Minute,
+			}
+			next := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, logger.NewNopLogger())
+			handler := newProofOfWork(t, next, settings, logger.NewNopLogger())
+
+			var challenge string
+			conn := tcptest.NewConn("ping")
+			conn.OnWrite = func(message string) {
+				if challenge != "" {
+					return
+				}
+				challenge = message
+
+				result, err := scheme.Solve(message)
+				assert.Nil(t, err)
+				conn.Push(result)
+			}
+
+			handler.ServeTCP(context.Background(), conn)
+
+			assert.Equal(t, []string{challenge, "random quote"}, conn.Written())
+			assert.True(t, conn.Closed())
+		})
+	}
+}
+
+func TestProofOfWork_ServeTCP_scheme_rejected(t *testing.T) {
+	settings := ProofOfWorkSettings{
+		Scheme:     reverseScheme{},
+		Complexity: 12,
+		WaitPOW:    1 * time.Minute,
+	}
+	handler := newProofOfWork(t, mocks.NewHandler(t), settings, logger.NewNopLogger())
+
+	// a Hashcash result is no solution for a challenge of another scheme
+	conn := tcptest.NewConn("ping")
+	conn.OnWrite = func(message string) {
+		if !strings.HasPrefix(message, "reverse:") {
+			return
+		}
+
+		result, err := pow.Calculate("1:12:2208082121:resource::cRvZdlXCCIrWoQ==:MTAwMA==")
+		assert.Nil(t, err)
+		conn.Push(result)
+	}
+
+	handler.ServeTCP(context.Background(), conn)
+
+	written := conn.Written()
+	if assert.Len(t, written, 2) {
+		assert.True(t, strings.HasPrefix(written[0], "reverse:"))
+		assert.Equal(t, "PoW verification failed", written[1])
+	}
+	assert.True(t, conn.Closed())
+}
+
+func TestProofOfWork_ServeTCP_estimate(t *testing.T) {
+	challengeStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculatedStr := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+
+	tests := []struct {
+		name     string
+		request  protocol.Request
+		hashRate float64
+		want     func(t *testing.T, message string)
+	}{
+		{
+			name:     "estimate",
+			request:  protocol.Request{Capabilities: []protocol.Capability{protocol.CapabilityEstimate}},
+			hashRate: 1000,
+			want: func(t *testing.T, message string) {
+				// the bits are the ones of the header, and 2^12 hashes take about 4 seconds at 1000 hashes per second
+				challenge, ok := protocol.ParseEstimatedChallenge([]byte(message))
+				assert.True(t, ok)
+				assert.Equal(t, protocol.EstimatedChallenge{
+					Challenge:            challengeStr,
+					Bits:                 12,
+					EstimatedSolveMillis: 4096,
+					Hint:                 "you have a minute",
+				}, challenge)
+			},
+		},
+		{
+			name:    "default hash rate",
+			request: protocol.Request{Capabilities: []protocol.Capability{protocol.CapabilityEstimate}},
+			want: func(t *testing.T, message string) {
+				challenge, ok := protocol.ParseEstimatedChallenge([]byte(message))
+				assert.True(t, ok)
+				assert.Equal(t, 12, challenge.Bits)
+				assert.Equal(t, pow.EstimateSolveTime(12, DefaultEstimateHashRate).Milliseconds(), challenge.EstimatedSolveMillis)
+			},
+		},
+		{
+			name: "negotiation",
+			request: protocol.Request{
+				Capabilities: []protocol.Capability{protocol.CapabilityNegotiation, protocol.CapabilityEstimate},
+				Bits:         15,
+			},
+			hashRate: 1000,
+			want: func(t *testing.T, message string) {
+				// the estimate is of the agreed bits
+				challenge, ok := protocol.ParseNegotiatedChallenge([]byte(message))
+				assert.True(t, ok)
+				assert.Equal(t, protocol.NegotiatedChallenge{
+					Challenge:            challengeStr,
+					Bits:                 15,
+					Accepted:             true,
+					EstimatedSolveMillis: 32768,
+					Hint:                 "you have a minute",
+				}, challenge)
+			},
+		},
+		{
+			name:    "not declared",
+			request: protocol.Request{},
+			want: func(t *testing.T, message string) {
+				assert.Equal(t, challengeStr, message)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			svc := mocks.NewWordOfWisdom(t)
+			svc.On("Quote").Return("random quote", nil).Once()
+
+			settings := ProofOfWorkSettings{
+				Challenge:         pow.FixedChallenge(challengeStr),
+				Verify:            pow.Verify,
+				Complexity:        20,
+				WaitPOW:           1 * time.Minute,
+				MinNegotiatedBits: 12,
+				EstimateHashRate:  test.hashRate,
+				ChallengeHint:     "you have a minute",
+			}
+			next := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, logger.NewNopLogger())
+			handler := newProofOfWork(t, next, settings, logger.NewNopLogger())
+
+			request, err := json.Marshal(test.request)
+			assert.Nil(t, err)
+
+			conn := tcptest.NewConn(string(request), calculatedStr)
+			handler.ServeTCP(context.Background(), conn)
+
+			written := conn.Written()
+			if assert.Len(t, written, 2) {
+				test.want(t, written[0])
+				assert.Equal(t, "random quote", written[1])
+			}
+		})
+	}
 }
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket rate limiter keeping a separate bucket per key (e.g. a remote IP).
+//
+// Every bucket holds up to burst tokens and is refilled with rate tokens per second.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	// purged is a time of the last purge of full buckets
+	purged time.Time
+
+	now func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// allow takes a token from the bucket of a key.
+//
+// It returns false if the bucket is empty.
+func (l *rateLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.purge(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = l.refilled(b, now)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+// refilled returns the number of tokens in a bucket refilled since its last use.
+func (l *rateLimiter) refilled(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.rate
+	if tokens > l.burst {
+		return l.burst
+	}
+
+	return tokens
+}
+
+// purge drops full buckets once a minute, so buckets of keys which are not seen anymore don't pile up.
+func (l *rateLimiter) purge(now time.Time) {
+	if now.Sub(l.purged) < time.Minute {
+		return
+	}
+	l.purged = now
+
+	for key, b := range l.buckets {
+		if l.refilled(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
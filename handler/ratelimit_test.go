@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_allow(t *testing.T) {
+	now := time.Now()
+
+	limiter := newRateLimiter(1, 2)
+	limiter.now = func() time.Time { return now }
+
+	// the burst is allowed right away
+	assert.True(t, limiter.allow("1.1.1.1"))
+	assert.True(t, limiter.allow("1.1.1.1"))
+	assert.False(t, limiter.allow("1.1.1.1"))
+
+	// other keys have their own buckets
+	assert.True(t, limiter.allow("2.2.2.2"))
+
+	// a token is refilled in a second
+	now = now.Add(500 * time.Millisecond)
+	assert.False(t, limiter.allow("1.1.1.1"))
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, limiter.allow("1.1.1.1"))
+	assert.False(t, limiter.allow("1.1.1.1"))
+
+	// tokens are refilled up to the burst
+	now = now.Add(time.Hour)
+	assert.True(t, limiter.allow("1.1.1.1"))
+	assert.True(t, limiter.allow("1.1.1.1"))
+	assert.False(t, limiter.allow("1.1.1.1"))
+}
+
+func TestRateLimiter_purge(t *testing.T) {
+	now := time.Now()
+
+	limiter := newRateLimiter(1, 1)
+	limiter.now = func() time.Time { return now }
+
+	assert.True(t, limiter.allow("1.1.1.1"))
+	assert.True(t, limiter.allow("2.2.2.2"))
+	assert.Len(t, limiter.buckets, 2)
+
+	// the buckets are full again by the next purge, so they're dropped
+	now = now.Add(2 * time.Minute)
+	assert.True(t, limiter.allow("3.3.3.3"))
+	assert.Len(t, limiter.buckets, 1)
+}
@@ -0,0 +1,440 @@
+package handler
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/laonix/pow-word-of-wisdom/handler/mocks"
+	"github.com/laonix/pow-word-of-wisdom/logger"
+	"github.com/laonix/pow-word-of-wisdom/pow"
+	"github.com/laonix/pow-word-of-wisdom/protocol"
+	"github.com/laonix/pow-word-of-wisdom/tcp"
+)
+
+// selfSignedCert returns a self-signed certificate for 127.0.0.1 and a pool trusting it.
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "pow-word-of-wisdom"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// freeAddr returns a loopback address with a port free to listen on.
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen(tcp.NetworkTcp, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	return l.Addr().String()
+}
+
+// newPoWServer returns a server performing the full PoW flow with a quote source returning the quote.
+func newPoWServer(t *testing.T, addr, quote string, log logger.Logger) *tcp.Server {
+	svc := mocks.NewWordOfWisdom(t)
+	svc.On("Quote").Return(quote, nil)
+
+	settings := ProofOfWorkSettings{
+		Challenge:  pow.Challenge,
+		Verify:     pow.Verify,
+		Complexity: 12,
+		WaitPOW:    1 * time.Minute,
+	}
+	powHandler := newProofOfWork(t, NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, log), settings, log)
+
+	return tcp.NewServer(addr, powHandler, log)
+}
+
+// dialEventually connects to the server, retrying while it's starting up.
+func dialEventually(t *testing.T, dial func() (net.Conn, error)) tcp.Conn {
+	var conn net.Conn
+	var err error
+
+	for i := 0; i < 100; i++ {
+		if conn, err = dial(); err == nil {
+			return tcp.NewConnWrapper(conn)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(err)
+
+	return nil
+}
+
+// requestQuote completes the full PoW flow and returns a received quote.
+func requestQuote(t *testing.T, conn tcp.Conn) string {
+	assert.Nil(t, tcp.WriteFrame(conn, []byte("ping")))
+
+	challenge, err := tcp.ReadFrame(conn)
+	assert.Nil(t, err)
+
+	result, err := pow.Calculate(string(challenge))
+	assert.Nil(t, err)
+	assert.Nil(t, tcp.WriteFrame(conn, []byte(result)))
+
+	quote, err := tcp.ReadFrame(conn)
+	assert.Nil(t, err)
+
+	return string(quote)
+}
+
+func TestServer_TLS(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+
+	addr := freeAddr(t)
+
+	server := newPoWServer(t, addr, "random quote", logger.NewNopLogger())
+	server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = server.ListenAndServe(ctx)
+	}()
+
+	// complete the full PoW flow over TLS
+	conn := dialEventually(t, func() (net.Conn, error) {
+		return tls.Dial(tcp.NetworkTcp, addr, &tls.Config{RootCAs: pool})
+	})
+	defer conn.Close()
+
+	assert.Equal(t, "random quote", requestQuote(t, conn))
+
+	// a plaintext client cannot complete the flow
+	plain, err := net.Dial(tcp.NetworkTcp, addr)
+	if assert.Nil(t, err) {
+		defer plain.Close()
+
+		plainConn := tcp.NewConnWrapper(plain)
+		_ = tcp.WriteFrame(plainConn, []byte("ping"))
+
+		_, err = tcp.ReadFrame(plainConn)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestServer_unix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pow.sock")
+
+	server := newPoWServer(t, path, "random quote", logger.NewNopLogger())
+	server.Network = tcp.NetworkUnix
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = server.ListenAndServe(ctx)
+	}()
+
+	// complete the full PoW flow over a Unix socket
+	conn := dialEventually(t, func() (net.Conn, error) {
+		return net.Dial(tcp.NetworkUnix, path)
+	})
+	defer conn.Close()
+
+	assert.Equal(t, "random quote", requestQuote(t, conn))
+}
+
+func TestServer_multiple_listeners(t *testing.T) {
+	addrs := []string{freeAddr(t), freeAddr(t)}
+
+	server := newPoWServer(t, addrs[0], "random quote", logger.NewNopLogger())
+	server.AddListener(addrs[1])
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe(ctx)
+	}()
+
+	// both listeners accept the PoW flow
+	for _, addr := range addrs {
+		conn := dialEventually(t, func() (net.Conn, error) {
+			return net.Dial(tcp.NetworkTcp, addr)
+		})
+
+		assert.Equal(t, "random quote", requestQuote(t, conn))
+		_ = conn.Close()
+	}
+
+	// context cancellation closes all the listeners
+	cancel()
+	assert.Nil(t, <-served)
+
+	for _, addr := range addrs {
+		_, err := net.Dial(tcp.NetworkTcp, addr)
+		assert.NotNil(t, err)
+	}
+}
+
+// recordingLogger keeps the messages logged with their key-value pairs.
+type recordingLogger struct {
+	mu      sync.Mutex
+	records []logRecord
+}
+
+type logRecord struct {
+	msg string
+	kvs []any
+}
+
+// value returns the value logged for the key.
+func (r logRecord) value(key string) any {
+	for i := 0; i+1 < len(r.kvs); i += 2 {
+		if r.kvs[i] == key {
+			return r.kvs[i+1]
+		}
+	}
+
+	return nil
+}
+
+func (l *recordingLogger) record(msg string, kvs []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.records = append(l.records, logRecord{msg: msg, kvs: kvs})
+}
+
+func (l *recordingLogger) Debug(msg string, kvs ...any) { l.record(msg, kvs) }
+func (l *recordingLogger) Info(msg string, kvs ...any)  { l.record(msg, kvs) }
+func (l *recordingLogger) Warn(msg string, kvs ...any)  { l.record(msg, kvs) }
+func (l *recordingLogger) Error(err error, kvs ...any)  { l.record(err.Error(), kvs) }
+
+// find returns the first record satisfying the predicate.
+func (l *recordingLogger) find(match func(r logRecord) bool) (logRecord, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, r := range l.records {
+		if match(r) {
+			return r, true
+		}
+	}
+
+	return logRecord{}, false
+}
+
+// contains checks if any message logged or any value logged along with it contains the text.
+func (l *recordingLogger) contains(text string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, r := range l.records {
+		if strings.Contains(fmt.Sprint(r.msg, r.kvs), text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestServer_conn_id(t *testing.T) {
+	addr := freeAddr(t)
+	log := &recordingLogger{}
+
+	server := newPoWServer(t, addr, "random quote", log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = server.ListenAndServe(ctx)
+	}()
+
+	ids := make(map[any]bool)
+	for i := 0; i < 2; i++ {
+		var raw net.Conn
+		conn := dialEventually(t, func() (net.Conn, error) {
+			c, err := net.Dial(tcp.NetworkTcp, addr)
+			raw = c
+			return c, err
+		})
+		assert.Equal(t, "random quote", requestQuote(t, conn))
+		local := raw.LocalAddr().String()
+		_ = conn.Close()
+
+		// the records of the connection are found by its remote address as seen by the server
+		ofConn := func(msg string, message any) func(r logRecord) bool {
+			return func(r logRecord) bool {
+				return r.msg == msg && r.value("remote") == local && (message == nil || r.value("message") == message)
+			}
+		}
+
+		got, ok := log.find(ofConn("got message", nil))
+		if !assert.True(t, ok) {
+			continue
+		}
+		id := got.value("conn_id")
+		assert.NotEmpty(t, id)
+
+		// the same id is logged along the challenge, the verification, and the quote
+		for name, match := range map[string]func(r logRecord) bool{
+			"challenge": ofConn("write message", nil),
+			"verify":    ofConn("PoW verification passed", nil),
+			"quote":     ofConn("write message", "random quote"),
+		} {
+			r, ok := log.find(match)
+			if assert.True(t, ok, name) {
+				assert.Equal(t, id, r.value("conn_id"), name)
+			}
+		}
+
+		ids[id] = true
+	}
+
+	// every connection has its own id
+	assert.Len(t, ids, 2)
+}
+
+func TestServer_session(t *testing.T) {
+	addr := freeAddr(t)
+	log := logger.NewNopLogger()
+
+	svc := mocks.NewWordOfWisdom(t)
+	svc.On("Quote").Return("random quote", nil).Times(3)
+
+	settings := ProofOfWorkSettings{
+		Challenge:     pow.Challenge,
+		Verify:        pow.Verify,
+		Complexity:    12,
+		WaitPOW:       1 * time.Minute,
+		Authenticator: NewAPIKeyAuthenticator(map[string]string{"client": "secret"}),
+	}
+	wordOfWisdom := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{MaxQuotesPerSession: 3}, log)
+	server := tcp.NewServer(addr, newProofOfWork(t, wordOfWisdom, settings, log), log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = server.ListenAndServe(ctx)
+	}()
+
+	conn := dialEventually(t, func() (net.Conn, error) {
+		return net.Dial(tcp.NetworkTcp, addr)
+	})
+	defer conn.Close()
+
+	// the authenticated client skips PoW and requests three quotes over the same connection
+	request, err := json.Marshal(protocol.Request{
+		Capabilities: []protocol.Capability{protocol.CapabilitySession},
+		APIKey:       "secret",
+	})
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			request = []byte("{}")
+		}
+		assert.Nil(t, tcp.WriteFrame(conn, request))
+
+		quote, err := tcp.ReadFrame(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, "random quote", string(quote))
+	}
+
+	// the session is over once the most quotes are served
+	_, err = tcp.ReadFrame(conn)
+	assert.ErrorIs(t, err, tcp.ErrConnClosed)
+}
+
+func TestServer_api_key_not_logged(t *testing.T) {
+	addr := freeAddr(t)
+	log := &recordingLogger{}
+
+	svc := mocks.NewWordOfWisdom(t)
+	svc.On("Quote").Return("random quote", nil).Times(2)
+
+	settings := ProofOfWorkSettings{
+		Challenge:     pow.Challenge,
+		Verify:        pow.Verify,
+		Complexity:    12,
+		WaitPOW:       1 * time.Minute,
+		Authenticator: NewAPIKeyAuthenticator(map[string]string{"client": "s3cr3t-key"}),
+	}
+	wordOfWisdom := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{MaxQuotesPerSession: 2}, log)
+	server := tcp.NewServer(addr, newProofOfWork(t, wordOfWisdom, settings, log), log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = server.ListenAndServe(ctx)
+	}()
+
+	conn := dialEventually(t, func() (net.Conn, error) {
+		return net.Dial(tcp.NetworkTcp, addr)
+	})
+	defer conn.Close()
+
+	// the key is sent with both the initial request and the next request of the session
+	request, err := json.Marshal(protocol.Request{
+		Capabilities: []protocol.Capability{protocol.CapabilitySession},
+		APIKey:       "s3cr3t-key",
+	})
+	assert.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, tcp.WriteFrame(conn, request))
+
+		quote, err := tcp.ReadFrame(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, "random quote", string(quote))
+	}
+
+	// the requests are logged with the key redacted
+	_, ok := log.find(func(r logRecord) bool {
+		message, _ := r.value("message").(string)
+		return r.msg == "got message" && strings.Contains(message, protocol.RedactedAPIKey)
+	})
+	assert.True(t, ok)
+	assert.False(t, log.contains("s3cr3t-key"))
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// difficultyTokens mints and verifies tokens granting a reduced-difficulty challenge
+// to clients which have recently passed PoW verification.
+//
+// A token has format "<expiry unix time>.<signature>",
+// where the signature is a base-64 encoded HMAC-SHA256 of the expiry keyed with the server secret.
+type difficultyTokens struct {
+	secret []byte
+}
+
+func newDifficultyTokens(secret []byte) *difficultyTokens {
+	return &difficultyTokens{secret: secret}
+}
+
+// mint returns a token valid until expiry.
+func (t *difficultyTokens) mint(expiry time.Time) string {
+	payload := strconv.FormatInt(expiry.Unix(), 10)
+
+	return payload + "." + t.sign(payload)
+}
+
+// valid checks if a token has been minted with the server secret and hasn't expired.
+func (t *difficultyTokens) valid(token string) bool {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(t.sign(payload))) {
+		return false
+	}
+
+	expiry, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	return !time.Now().After(time.Unix(expiry, 0))
+}
+
+func (t *difficultyTokens) sign(payload string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDifficultyTokens_valid(t *testing.T) {
+	tokens := newDifficultyTokens([]byte("secret"))
+
+	token := tokens.mint(time.Now().Add(time.Minute))
+	expired := tokens.mint(time.Now().Add(-time.Minute))
+	payload, _, _ := strings.Cut(token, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "valid token", token: token, want: true},
+		{name: "expired token", token: expired, want: false},
+		{name: "empty token", token: "", want: false},
+		{name: "malformed token", token: "malformed", want: false},
+		{name: "forged signature", token: payload + ".forged", want: false},
+		{name: "prolonged expiry", token: "9999999999." + strings.SplitN(token, ".", 2)[1], want: false},
+		{name: "another secret", token: newDifficultyTokens([]byte("another")).mint(time.Now().Add(time.Minute)), want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, tokens.valid(test.token))
+		})
+	}
+}
@@ -2,8 +2,16 @@ package handler
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
 	"github.com/laonix/pow-word-of-wisdom/logger"
+	"github.com/laonix/pow-word-of-wisdom/metrics"
+	"github.com/laonix/pow-word-of-wisdom/protocol"
 	"github.com/laonix/pow-word-of-wisdom/service"
 	"github.com/laonix/pow-word-of-wisdom/tcp"
 )
@@ -12,58 +20,359 @@ import (
 // to send a random word of wisdom quote to the client.
 type WordOfWisdomHandler struct {
 	srv service.WordOfWisdom
+
+	failurePolicy FailurePolicy
+	fallback      string
+	// maxQuoteSize is the longest quote in bytes, quotes are not truncated if it's not positive
+	maxQuoteSize int
+	// last holds the last successfully retrieved quote
+	last atomic.Value
+	// maxQuotesPerSession is the most quotes served over a connection in session mode
+	maxQuotesPerSession int
+	// quoteTimeout is a time limit for quote retrieval, unlimited if it's not positive
+	quoteTimeout time.Duration
+	// quotes counts quotes served to clients, if it's set
+	quotes *metrics.Counter
+
 	log logger.Logger
 }
 
+// FailurePolicy defines the behavior of WordOfWisdomHandler when a quote source fails.
+type FailurePolicy int
+
+const (
+	// FailClosed is a FailurePolicy to notify the client about a failure and close the connection.
+	FailClosed FailurePolicy = iota
+	// FailOpen is a FailurePolicy to serve a fallback quote:
+	// the last successfully retrieved one, or a configured fallback quote if there's none.
+	FailOpen
+)
+
+// FailurePolicyOf returns a FailurePolicy corresponding to an argument string.
+func FailurePolicyOf(policy string) FailurePolicy {
+	if strings.ToLower(policy) == "open" {
+		return FailOpen
+	}
+
+	return FailClosed
+}
+
+// WordOfWisdomSettings holds WordOfWisdomHandler settings.
+type WordOfWisdomSettings struct {
+	// FailurePolicy defines the behavior on quote source errors.
+	FailurePolicy FailurePolicy
+	// FallbackQuote is served on quote source errors with FailOpen policy
+	// if no quote has been successfully retrieved yet.
+	FallbackQuote string
+	// MaxQuoteSize is the longest quote in bytes, if it's set:
+	// a longer quote is truncated at a character boundary, so it remains valid UTF-8.
+	MaxQuoteSize int
+	// MaxQuotesPerSession is the most quotes served over a single connection to a client in session mode
+	// (see protocol.CapabilitySession); session mode is off unless it's greater than 1.
+	MaxQuotesPerSession int
+	// QuoteTimeout is a time limit for retrieving quotes from the quote source, if it's set:
+	// once it's exceeded, the client is informed with "quote timeout" and disconnected,
+	// so a hanging quote source doesn't hold the client until the context is done.
+	QuoteTimeout time.Duration
+	// Quotes counts quotes served to clients, if it's set (including fallback ones).
+	Quotes *metrics.Counter
+}
+
 // NewWordOfWisdomHandler returns a new instance of WordOfWisdomHandler.
-func NewWordOfWisdomHandler(srv service.WordOfWisdom, log logger.Logger) *WordOfWisdomHandler {
+func NewWordOfWisdomHandler(srv service.WordOfWisdom, settings WordOfWisdomSettings, log logger.Logger) *WordOfWisdomHandler {
 	return &WordOfWisdomHandler{
-		srv: srv,
+		srv:           srv,
+		failurePolicy: settings.FailurePolicy,
+		fallback:      settings.FallbackQuote,
+		maxQuoteSize:  settings.MaxQuoteSize,
+
+		maxQuotesPerSession: settings.MaxQuotesPerSession,
+		quoteTimeout:        settings.QuoteTimeout,
+		quotes:              settings.Quotes,
+
 		log: log,
 	}
 }
 
 // ServeTCP writes a random word of wisdom quote to the client.
 //
+// If a next challenge or a difficulty token has been issued for the client (or it has requested the catalog),
+// it's sent along with the quote (see protocol.QuoteResponse).
+// In batch mode, the client gets several quotes at once (see protocol.BatchResponse).
+// In session mode (see protocol.CapabilitySession), the client requests more quotes over the same connection
+// until MaxQuotesPerSession quotes are served.
+// If the quote source fails, the behavior depends on FailurePolicy.
+// If the quote source doesn't respond within QuoteTimeout, the client is informed and disconnected.
 // If the server interrupts, it handles a correct connection closing (with client notification).
+// If the context is already done, no quote is retrieved.
 func (h *WordOfWisdomHandler) ServeTCP(ctx context.Context, conn tcp.Conn) {
-	// get a random word of wisdom quote
-	quote := make(chan quoteResult)
+	log := connLog(ctx, h.log, conn)
+
+	// a context done in advance (e.g. on shutdown) means no quote is to be retrieved
+	if rejectDone(ctx, conn, log) {
+		return
+	}
 
-	go getQuoteResult(quote, h.srv)
+	served := quoteCountFrom(ctx)
+	if !h.serveQuotes(ctx, conn, served, categoryFrom(ctx), true) {
+		return
+	}
+
+	// a client in session mode requests the next quote with a request of its own, e.g. selecting another category
+	for sessionFrom(ctx) && served < h.maxQuotesPerSession {
+		request, ok := h.readRequest(ctx, conn)
+		if !ok {
+			return
+		}
+
+		if !h.serveQuotes(ctx, conn, 1, request.Category, false) {
+			return
+		}
+		served++
+	}
+
+	closeConn(conn, log)
+}
+
+// serveQuotes writes a number of quotes of the category (or of any category if it's empty) to the client.
+//
+// The rewards passed within the context (e.g. a next challenge) are sent along with the quotes if withRewards is set.
+// It returns false if the connection has been closed.
+func (h *WordOfWisdomHandler) serveQuotes(ctx context.Context, conn tcp.Conn, count int, category string, withRewards bool) bool {
+	log := connLog(ctx, h.log, conn)
+
+	// get a random word of wisdom quote (or several ones in batch mode)
+	// the channel is buffered so the getting goroutine never blocks on sending a quote nobody waits for
+	quote := make(chan quoteResult, 1)
+
+	go getQuoteResult(quote, h.srv, count, category)
+
+	// a nil channel never fires, so quote retrieval is unlimited unless QuoteTimeout is set
+	var timeout <-chan time.Time
+	if h.quoteTimeout > 0 {
+		timer := time.NewTimer(h.quoteTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
 
 	// while we're getting the quote we might receive a system interruption
-	for {
-		select {
-		case <-ctx.Done(): // handle context cancellation
-			{
-				handleCtxDone(ctx, conn, h.log)
-				return
+	select {
+	case <-ctx.Done(): // handle context cancellation
+		{
+			handleCtxDone(ctx, conn, log)
+			return false
+		}
+	case <-timeout: // handle a quote source exceeding the time limit
+		{
+			log.Warn("quote retrieval timed out", "timeout", h.quoteTimeout, "remote", tcp.RemoteAddr(conn))
+			writeMessage(ctx, protocol.NewError(protocol.CodeQuoteTimeout, "quote timeout"), conn, log)
+			closeConn(conn, log)
+			return false
+		}
+	case res := <-quote: // handle a retrieved quote
+		{
+			if len(res.quotes) > 0 {
+				h.last.Store(res.quotes[len(res.quotes)-1])
 			}
-		case res := <-quote: // handle a retrieved quote
-			{
-				if res.err != nil {
-					h.log.Error(res.err, "action", "get quote")
-					writeMessage("cannot get a quote", conn, h.log)
-					closeConn(conn, h.log)
-					return
+
+			// the client has requested a category the server doesn't serve, so there's nothing to fall back to
+			if errors.Is(res.err, service.ErrUnknownCategory) {
+				log.Warn("unknown quote category", "err", res.err, "remote", tcp.RemoteAddr(conn))
+				writeMessage(ctx, protocol.NewError(protocol.CodeUnknownCategory, "unknown quote category"), conn, log)
+				closeConn(conn, log)
+				return false
+			}
+
+			if res.err != nil {
+				log.Error(res.err, "action", "get quote")
+
+				fallback, ok := h.fallbackQuote()
+				if !ok {
+					writeMessage(ctx, unavailable(res.err), conn, log)
+					closeConn(conn, log)
+					return false
+				}
+
+				// quotes which haven't been retrieved are replaced with the fallback one
+				for len(res.quotes) < count {
+					res.quotes = append(res.quotes, fallback)
 				}
+			}
+
+			// quotes are delivered as valid UTF-8 regardless of the quote source
+			for i := range res.quotes {
+				res.quotes[i] = validQuote(res.quotes[i], h.maxQuoteSize)
+			}
 
-				writeMessage(res.quote, conn, h.log)
-				closeConn(conn, h.log)
-				return
+			if h.quotes != nil {
+				h.quotes.Add(uint64(len(res.quotes)))
 			}
+
+			var catalog *protocol.Catalog
+			var next, token string
+			var withCatalog, withNext, withToken bool
+			if withRewards {
+				catalog, withCatalog = catalogFrom(ctx)
+				next, withNext = nextChallengeFrom(ctx)
+				token, withToken = difficultyTokenFrom(ctx)
+			}
+
+			if count > 1 {
+				return h.writeJSON(ctx, protocol.BatchResponse{Quotes: res.quotes, Catalog: catalog}, conn)
+			}
+
+			quote := res.quotes[0]
+			if withNext || withToken || withCatalog {
+				return h.writeJSON(ctx, protocol.QuoteResponse{Quote: quote, NextChallenge: next, Token: token, Catalog: catalog}, conn)
+			}
+
+			writeMessage(ctx, protocol.NewMessage(protocol.ResponseQuote, quote), conn, log)
+			return true
 		}
 	}
 }
 
+// readRequest reads the next request of a client in session mode.
+//
+// It returns false if the connection has been closed:
+// the client has ended the session, sent an invalid request, or the context is done.
+func (h *WordOfWisdomHandler) readRequest(ctx context.Context, conn tcp.Conn) (protocol.Request, bool) {
+	log := connLog(ctx, h.log, conn)
+
+	type readResult struct {
+		message []byte
+		err     error
+	}
+
+	// the channel is buffered so the reading goroutine never blocks on sending a message nobody waits for
+	messages := make(chan readResult, 1)
+	go func() {
+		message, err := tcp.ReadFrame(conn)
+		messages <- readResult{message: message, err: err}
+	}()
+
+	var res readResult
+	select {
+	case <-ctx.Done():
+		handleCtxDone(ctx, conn, log)
+		// the connection is closed, so the pending read is released
+		<-messages
+		return protocol.Request{}, false
+	case res = <-messages:
+	}
+
+	switch {
+	case errors.Is(res.err, tcp.ErrMessageTooLarge):
+		rejectTooLarge(ctx, res.err, conn, log)
+		return protocol.Request{}, false
+	case isTimeout(res.err):
+		dropStalled(res.err, conn, log)
+		return protocol.Request{}, false
+	case errors.Is(res.err, tcp.ErrConnClosed): // the client has ended the session
+		dropClosed(res.err, conn, log)
+		return protocol.Request{}, false
+	case res.err != nil:
+		log.Error(res.err, "action", "read from connection")
+		closeConn(conn, log)
+		return protocol.Request{}, false
+	}
+
+	log.Info("got message", "message", protocol.RedactedMessage(res.message), "remote", tcp.RemoteAddr(conn))
+
+	request, err := protocol.ParseRequest(res.message)
+	if err != nil {
+		log.Warn("invalid request", "err", err, "remote", tcp.RemoteAddr(conn))
+		writeMessage(ctx, protocol.NewError(protocol.CodeInvalidRequest, "invalid request"), conn, log)
+		closeConn(conn, log)
+		return protocol.Request{}, false
+	}
+
+	return request, true
+}
+
+// unavailable returns a response informing the client that no quote can be served due to a quote source error:
+// an empty quote corpus is told apart from a failing quote source.
+func unavailable(err error) protocol.Response {
+	if errors.Is(err, service.ErrNoQuotes) {
+		return protocol.NewError(protocol.CodeNoQuotes, "no quotes available")
+	}
+
+	return protocol.NewError(protocol.CodeQuoteUnavailable, "cannot get a quote")
+}
+
+// fallbackQuote returns a quote to serve on quote source errors if FailurePolicy allows it.
+func (h *WordOfWisdomHandler) fallbackQuote() (string, bool) {
+	if h.failurePolicy != FailOpen {
+		return "", false
+	}
+
+	if last, ok := h.last.Load().(string); ok {
+		return last, true
+	}
+
+	return h.fallback, h.fallback != ""
+}
+
+// writeJSON writes a JSON quote response to the client.
+//
+// It returns false if the connection has been closed.
+func (h *WordOfWisdomHandler) writeJSON(ctx context.Context, response any, conn tcp.Conn) bool {
+	log := connLog(ctx, h.log, conn)
+
+	b, err := json.Marshal(response)
+	if err != nil {
+		log.Error(err, "action", "marshal quote response")
+		writeMessage(ctx, protocol.NewError(protocol.CodeQuoteUnavailable, "cannot get a quote"), conn, log)
+		closeConn(conn, log)
+		return false
+	}
+
+	writeMessage(ctx, protocol.NewData(protocol.ResponseQuote, b), conn, log)
+	return true
+}
+
+// validQuote returns a quote with invalid UTF-8 sequences replaced with the replacement character,
+// truncated to max bytes at a character boundary, if max is positive.
+func validQuote(quote string, max int) string {
+	quote = strings.ToValidUTF8(quote, string(utf8.RuneError))
+	if max <= 0 || len(quote) <= max {
+		return quote
+	}
+
+	// step back from the limit to the first byte of a character, so no character is split
+	end := max
+	for end > 0 && !utf8.RuneStart(quote[end]) {
+		end--
+	}
+
+	return quote[:end]
+}
+
 type quoteResult struct {
-	quote string
-	err   error
+	quotes []string
+	err    error
 }
 
-func getQuoteResult(c chan quoteResult, srv service.WordOfWisdom) {
-	quote, err := srv.Quote()
+// getQuoteResult retrieves a number of quotes; it stops on the first error passing the quotes retrieved so far.
+func getQuoteResult(c chan quoteResult, srv service.WordOfWisdom, count int, category string) {
+	quotes := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		quote, err := getQuote(srv, category)
+		if err != nil {
+			c <- quoteResult{quotes: quotes, err: err}
+			return
+		}
+		quotes = append(quotes, quote)
+	}
+
+	c <- quoteResult{quotes: quotes}
+}
+
+// getQuote returns a random quote of the category, or of any category if it's empty.
+func getQuote(srv service.WordOfWisdom, category string) (string, error) {
+	if category == "" {
+		return srv.Quote()
+	}
 
-	c <- quoteResult{quote: quote, err: err}
+	return srv.QuoteByCategory(category)
 }
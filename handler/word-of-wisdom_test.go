@@ -4,14 +4,22 @@ package handler
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
+	"io"
 	"net"
 	"testing"
 	"time"
+	"unicode/utf8"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
 	"github.com/laonix/pow-word-of-wisdom/handler/mocks"
+	"github.com/laonix/pow-word-of-wisdom/pow"
+	"github.com/laonix/pow-word-of-wisdom/protocol"
+	"github.com/laonix/pow-word-of-wisdom/service"
+	"github.com/laonix/pow-word-of-wisdom/tcp"
 )
 
 func TestWordOfWisdomHandler_ServeTCP_correct(t *testing.T) {
@@ -20,10 +28,10 @@ func TestWordOfWisdomHandler_ServeTCP_correct(t *testing.T) {
 	svc := mocks.NewWordOfWisdom(t)
 	svc.On("Quote").Return("random quote", nil)
 
-	handler := NewWordOfWisdomHandler(svc, log)
+	handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, log)
 
 	conn := setupConnMock(t)
-	conn.On("Write", []byte("random quote")).Return(len([]byte("random quote")), nil)
+	conn.On("Write", frame("random quote")).Return(len(frame("random quote")), nil)
 
 	cancellingCtx, cancel := context.WithCancel(context.Background())
 	time.AfterFunc(5*time.Millisecond, cancel)
@@ -42,10 +50,10 @@ func TestWordOfWisdomHandler_ServeTCP_internal_error(t *testing.T) {
 	svc := mocks.NewWordOfWisdom(t)
 	svc.On("Quote").Return("", errors.New("get random quote id"))
 
-	handler := NewWordOfWisdomHandler(svc, log)
+	handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, log)
 
 	conn := setupConnMock(t)
-	conn.On("Write", []byte("cannot get a quote")).Return(len([]byte("cannot get a quote")), nil)
+	conn.On("Write", frame("cannot get a quote")).Return(len(frame("cannot get a quote")), nil)
 
 	cancellingCtx, cancel := context.WithCancel(context.Background())
 	time.AfterFunc(5*time.Millisecond, cancel)
@@ -58,22 +66,86 @@ func TestWordOfWisdomHandler_ServeTCP_internal_error(t *testing.T) {
 	log.AssertNumberOfCalls(t, "Error", 1) // log internal error
 }
 
+func TestWordOfWisdomHandler_ServeTCP_failure_policy(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings WordOfWisdomSettings
+		// served is a quote successfully served before the quote source failure
+		served string
+		want   string
+	}{
+		{
+			name:     "fail closed",
+			settings: WordOfWisdomSettings{FailurePolicy: FailClosed, FallbackQuote: "fallback quote"},
+			served:   "random quote",
+			want:     "cannot get a quote",
+		},
+		{
+			name:     "fail open with no fallback",
+			settings: WordOfWisdomSettings{FailurePolicy: FailOpen},
+			want:     "cannot get a quote",
+		},
+		{
+			name:     "fail open with fallback quote",
+			settings: WordOfWisdomSettings{FailurePolicy: FailOpen, FallbackQuote: "fallback quote"},
+			want:     "fallback quote",
+		},
+		{
+			name:     "fail open with last served quote",
+			settings: WordOfWisdomSettings{FailurePolicy: FailOpen, FallbackQuote: "fallback quote"},
+			served:   "random quote",
+			want:     "random quote",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := setupLogMock(t)
+
+			svc := mocks.NewWordOfWisdom(t)
+			if test.served != "" {
+				svc.On("Quote").Return(test.served, nil).Once()
+			}
+			svc.On("Quote").Return("", errors.New("quote source is down")).Once()
+
+			handler := NewWordOfWisdomHandler(svc, test.settings, log)
+
+			if test.served != "" {
+				conn := setupConnMock(t)
+				conn.On("Write", frame(test.served)).Return(len(frame(test.served)), nil).Once()
+
+				handler.ServeTCP(context.Background(), conn)
+			}
+
+			conn := setupConnMock(t)
+			conn.On("Write", frame(test.want)).Return(len(frame(test.want)), nil).Once()
+
+			handler.ServeTCP(context.Background(), conn)
+
+			log.AssertNumberOfCalls(t, "Error", 1) // log quote source error
+		})
+	}
+}
+
 func TestWordOfWisdomHandler_ServeTCP_context_cancelled(t *testing.T) {
 	log := setupLogMock(t)
 
+	cancellingCtx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(1*time.Millisecond, cancel)
+
 	svc := mocks.NewWordOfWisdom(t)
 	svc.On("Quote").Maybe().Run(func(_ mock.Arguments) {
+		<-cancellingCtx.Done()
 		time.Sleep(10 * time.Millisecond)
 	}).Return("random quote", nil)
 
-	handler := NewWordOfWisdomHandler(svc, log)
+	handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, log)
 
 	conn := setupConnMock(t)
-	conn.On("Write", []byte("random quote")).Maybe().Return(len([]byte("random quote")), nil)
-	conn.On("Write", []byte("context done")).Maybe().Return(len([]byte("context done")), nil)
-
-	cancellingCtx, cancel := context.WithCancel(context.Background())
-	time.AfterFunc(1*time.Millisecond, cancel)
+	conn.On("Write", frame("random quote")).Maybe().Return(len(frame("random quote")), nil)
+	conn.On("Write", frame("context done")).Maybe().Return(len(frame("context done")), nil)
+	// the context might be cancelled even before the handler starts
+	conn.On("Write", frame("server is shutting down")).Maybe().Return(len(frame("server is shutting down")), nil)
 
 	handler.ServeTCP(cancellingCtx, conn)
 
@@ -87,12 +159,16 @@ var skip = mock.Anything
 
 func setupLogMock(t *testing.T) *mocks.Logger {
 	skippedLogArgs := []interface{}{skip, skip, skip, skip, skip}
+	// records logged once the request is parsed carry the client version as well
+	skippedVersionedLogArgs := append(skippedLogArgs, skip, skip)
 
 	log := mocks.NewLogger(t)
-	log.On("Info", skippedLogArgs...).Maybe()
-	log.On("Debug", skippedLogArgs...).Maybe()
-	log.On("Warn", skippedLogArgs...).Maybe()
-	log.On("Error", skippedLogArgs...).Maybe()
+	for _, args := range [][]interface{}{skippedLogArgs, skippedVersionedLogArgs} {
+		log.On("Info", args...).Maybe()
+		log.On("Debug", args...).Maybe()
+		log.On("Warn", args...).Maybe()
+		log.On("Error", args...).Maybe()
+	}
 
 	return log
 }
@@ -104,3 +180,424 @@ func setupConnMock(t *testing.T) *mocks.Conn {
 
 	return conn
 }
+
+// frame returns a message prefixed with its length as it's written to a connection.
+func frame(message string) []byte {
+	b := make([]byte, tcp.FrameHeaderSize+len(message))
+	binary.BigEndian.PutUint32(b, uint32(len(message)))
+	copy(b[tcp.FrameHeaderSize:], message)
+
+	return b
+}
+
+// onReadFrame expects a framed message to be read from the connection mock:
+// a frame header is read first, and then a payload.
+func onReadFrame(conn *mocks.Conn, message string) (header, payload *mock.Call) {
+	b := frame(message)
+
+	header = conn.On("Read", mock.AnythingOfType("[]uint8")).Return(b[:tcp.FrameHeaderSize], nil).Once()
+	payload = conn.On("Read", mock.AnythingOfType("[]uint8")).Return(b[tcp.FrameHeaderSize:], nil).Once()
+
+	return header, payload
+}
+
+func TestWordOfWisdomHandler_ServeTCP_next_challenge(t *testing.T) {
+	log := setupLogMock(t)
+
+	svc := mocks.NewWordOfWisdom(t)
+	svc.On("Quote").Return("random quote", nil)
+
+	handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, log)
+
+	next, err := pow.Challenge(10, "resource")
+	assert.Nil(t, err)
+
+	var written []byte
+
+	conn := setupConnMock(t)
+	conn.On("Write", mock.AnythingOfType("[]uint8")).Run(func(args mock.Arguments) {
+		written = args.Get(0).([]byte)
+	}).Return(func(b []byte) int { return len(b) }, nil).Once()
+
+	ctx := context.WithValue(context.Background(), nextChallengeKey{}, next)
+
+	handler.ServeTCP(ctx, conn)
+
+	response, ok := protocol.ParseQuoteResponse(written[tcp.FrameHeaderSize:])
+	if assert.True(t, ok) {
+		assert.Equal(t, "random quote", response.Quote)
+		assert.Equal(t, next, response.NextChallenge)
+
+		// the client is able to solve the next challenge
+		result, err := pow.Calculate(response.NextChallenge)
+		assert.Nil(t, err)
+
+		ok, err := pow.Verify(result, next)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+}
+
+func TestWordOfWisdomHandler_ServeTCP_difficulty_token(t *testing.T) {
+	log := setupLogMock(t)
+
+	svc := mocks.NewWordOfWisdom(t)
+	svc.On("Quote").Return("random quote", nil)
+
+	handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, log)
+
+	var written []byte
+
+	conn := setupConnMock(t)
+	conn.On("Write", mock.AnythingOfType("[]uint8")).Run(func(args mock.Arguments) {
+		written = args.Get(0).([]byte)
+	}).Return(func(b []byte) int { return len(b) }, nil).Once()
+
+	ctx := context.WithValue(context.Background(), difficultyTokenKey{}, "token")
+
+	handler.ServeTCP(ctx, conn)
+
+	response, ok := protocol.ParseQuoteResponse(written[tcp.FrameHeaderSize:])
+	if assert.True(t, ok) {
+		assert.Equal(t, "random quote", response.Quote)
+		assert.Equal(t, "token", response.Token)
+		assert.Empty(t, response.NextChallenge)
+	}
+}
+
+func TestWordOfWisdomHandler_ServeTCP_catalog(t *testing.T) {
+	log := setupLogMock(t)
+
+	svc := mocks.NewWordOfWisdom(t)
+	svc.On("Quote").Return("random quote", nil)
+
+	handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, log)
+
+	var written []byte
+
+	conn := setupConnMock(t)
+	conn.On("Write", mock.AnythingOfType("[]uint8")).Run(func(args mock.Arguments) {
+		written = args.Get(0).([]byte)
+	}).Return(func(b []byte) int { return len(b) }, nil).Once()
+
+	catalog := &protocol.Catalog{Capabilities: []protocol.Capability{protocol.CapabilityCatalog}, Categories: []string{"life"}}
+	ctx := context.WithValue(context.Background(), catalogKey{}, catalog)
+
+	handler.ServeTCP(ctx, conn)
+
+	response, ok := protocol.ParseQuoteResponse(written[tcp.FrameHeaderSize:])
+	if assert.True(t, ok) {
+		assert.Equal(t, "random quote", response.Quote)
+		assert.Equal(t, catalog, response.Catalog)
+	}
+}
+
+func TestWordOfWisdomHandler_ServeTCP_batch(t *testing.T) {
+	log := setupLogMock(t)
+
+	svc := mocks.NewWordOfWisdom(t)
+	svc.On("Quote").Return("random quote", nil).Times(3)
+
+	handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, log)
+
+	var written []byte
+
+	conn := setupConnMock(t)
+	conn.On("Write", mock.AnythingOfType("[]uint8")).Run(func(args mock.Arguments) {
+		written = args.Get(0).([]byte)
+	}).Return(func(b []byte) int { return len(b) }, nil).Once()
+
+	ctx := context.WithValue(context.Background(), quoteCountKey{}, 3)
+
+	handler.ServeTCP(ctx, conn)
+
+	response, ok := protocol.ParseBatchResponse(written[tcp.FrameHeaderSize:])
+	if assert.True(t, ok) {
+		assert.Equal(t, []string{"random quote", "random quote", "random quote"}, response.Quotes)
+	}
+}
+
+func TestWordOfWisdomHandler_ServeTCP_context_done(t *testing.T) {
+	// no quote is retrieved
+	svc := mocks.NewWordOfWisdom(t)
+
+	handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, setupLogMock(t))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	conn := setupConnMock(t)
+	conn.On("Write", frame("server is shutting down")).Return(len(frame("server is shutting down")), nil).Once()
+
+	handler.ServeTCP(ctx, conn)
+
+	conn.AssertCalled(t, "Close")
+}
+
+func TestValidQuote(t *testing.T) {
+	tests := []struct {
+		name  string
+		quote string
+		max   int
+		want  string
+	}{
+		{name: "multi-byte characters", quote: "Ça va? 😀 — ok", want: "Ça va? 😀 — ok"},
+		{name: "invalid sequence", quote: "bad \xff byte", want: "bad � byte"},
+		{name: "fits the limit", quote: "😀😀", max: 8, want: "😀😀"},
+		{name: "truncated at a character boundary", quote: "😀😀😀", max: 6, want: "😀"},
+		{name: "truncated within a two-byte character", quote: "aÇb", max: 2, want: "a"},
+		{name: "no limit", quote: "😀😀😀", want: "😀😀😀"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := validQuote(test.quote, test.max)
+			assert.True(t, utf8.ValidString(got))
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestWordOfWisdomHandler_ServeTCP_utf8(t *testing.T) {
+	quote := "Curiouser and curiouser! 🐇 — Алиса"
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+	}{
+		{name: "plain quote", ctx: context.Background()},
+		{name: "quote response", ctx: context.WithValue(context.Background(), difficultyTokenKey{}, "token")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			svc := mocks.NewWordOfWisdom(t)
+			svc.On("Quote").Return(quote, nil)
+
+			handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, setupLogMock(t))
+
+			var written []byte
+
+			conn := setupConnMock(t)
+			conn.On("Write", mock.AnythingOfType("[]uint8")).Run(func(args mock.Arguments) {
+				written = args.Get(0).([]byte)
+			}).Return(func(b []byte) int { return len(b) }, nil).Once()
+
+			handler.ServeTCP(test.ctx, conn)
+
+			message := written[tcp.FrameHeaderSize:]
+			assert.True(t, utf8.Valid(message))
+
+			got := string(message)
+			if response, ok := protocol.ParseQuoteResponse(message); ok {
+				got = response.Quote
+			}
+			assert.Equal(t, quote, got)
+		})
+	}
+}
+
+func TestWordOfWisdomHandler_ServeTCP_category(t *testing.T) {
+	t.Run("known category", func(t *testing.T) {
+		svc := mocks.NewWordOfWisdom(t)
+		svc.On("QuoteByCategory", "life").Return("quote of life", nil).Once()
+
+		handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, setupLogMock(t))
+
+		conn := setupConnMock(t)
+		conn.On("Write", frame("quote of life")).Return(len(frame("quote of life")), nil).Once()
+
+		handler.ServeTCP(context.WithValue(context.Background(), categoryKey{}, "life"), conn)
+	})
+
+	t.Run("unknown category", func(t *testing.T) {
+		log := setupLogMock(t)
+
+		svc := mocks.NewWordOfWisdom(t)
+		svc.On("QuoteByCategory", "gossip").Return("", service.ErrUnknownCategory).Once()
+
+		// the fallback quote is not served instead of a quote of the requested category
+		handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{FailurePolicy: FailOpen, FallbackQuote: "fallback"}, log)
+
+		conn := setupConnMock(t)
+		conn.On("Write", frame("unknown quote category")).Return(len(frame("unknown quote category")), nil).Once()
+
+		handler.ServeTCP(context.WithValue(context.Background(), categoryKey{}, "gossip"), conn)
+
+		log.AssertNumberOfCalls(t, "Warn", 1)  // on unknown category
+		log.AssertNumberOfCalls(t, "Error", 0) // not an internal error
+	})
+}
+
+func TestWordOfWisdomHandler_ServeTCP_envelope(t *testing.T) {
+	ctx := withEnvelope(context.Background(), protocol.Request{Capabilities: []protocol.Capability{protocol.CapabilityEnvelope}})
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		category string
+		quote    string
+		err      error
+		want     protocol.Response
+	}{
+		{
+			name:  "quote",
+			ctx:   ctx,
+			quote: "random quote",
+			want:  protocol.NewMessage(protocol.ResponseQuote, "random quote"),
+		},
+		{
+			name:  "quote response",
+			ctx:   context.WithValue(ctx, difficultyTokenKey{}, "token"),
+			quote: "random quote",
+			want:  protocol.NewData(protocol.ResponseQuote, []byte(`{"quote":"random quote","token":"token"}`)),
+		},
+		{
+			name:     "unknown category",
+			ctx:      context.WithValue(ctx, categoryKey{}, "gossip"),
+			category: "gossip",
+			err:      service.ErrUnknownCategory,
+			want:     protocol.NewError(protocol.CodeUnknownCategory, "unknown quote category"),
+		},
+		{
+			name: "quote unavailable",
+			ctx:  ctx,
+			err:  errors.New("quote source is down"),
+			want: protocol.NewError(protocol.CodeQuoteUnavailable, "cannot get a quote"),
+		},
+		{
+			name: "no quotes",
+			ctx:  ctx,
+			err:  service.ErrNoQuotes,
+			want: protocol.NewError(protocol.CodeNoQuotes, "no quotes available"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			svc := mocks.NewWordOfWisdom(t)
+			if test.category != "" {
+				svc.On("QuoteByCategory", test.category).Return(test.quote, test.err).Once()
+			} else {
+				svc.On("Quote").Return(test.quote, test.err).Once()
+			}
+
+			message := envelope(t, test.want)
+
+			conn := setupConnMock(t)
+			conn.On("Write", frame(message)).Return(len(frame(message)), nil).Once()
+
+			handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, setupLogMock(t))
+			handler.ServeTCP(test.ctx, conn)
+		})
+	}
+}
+
+func TestWordOfWisdomHandler_ServeTCP_session(t *testing.T) {
+	ctx := context.WithValue(context.Background(), sessionKey{}, true)
+
+	t.Run("max quotes served", func(t *testing.T) {
+		svc := mocks.NewWordOfWisdom(t)
+		svc.On("Quote").Return("random quote", nil).Twice()
+		svc.On("QuoteByCategory", "life").Return("quote of life", nil).Once()
+
+		// every next quote is requested by the client, and the connection is closed once the third one is served
+		conn := setupConnMock(t)
+		conn.On("Write", frame("random quote")).Return(len(frame("random quote")), nil).Twice()
+		onReadFrame(conn, "{}")
+		onReadFrame(conn, `{"category":"life"}`)
+		conn.On("Write", frame("quote of life")).Return(len(frame("quote of life")), nil).Once()
+
+		handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{MaxQuotesPerSession: 3}, setupLogMock(t))
+		handler.ServeTCP(ctx, conn)
+
+		conn.AssertNumberOfCalls(t, "Write", 3)
+		conn.AssertNumberOfCalls(t, "Close", 1)
+	})
+
+	t.Run("ended by client", func(t *testing.T) {
+		svc := mocks.NewWordOfWisdom(t)
+		svc.On("Quote").Return("random quote", nil).Once()
+
+		conn := setupConnMock(t)
+		conn.On("Write", frame("random quote")).Return(len(frame("random quote")), nil).Once()
+		conn.On("Read", mock.AnythingOfType("[]uint8")).Return(nil, io.EOF).Once()
+
+		log := setupLogMock(t)
+
+		handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{MaxQuotesPerSession: 3}, log)
+		handler.ServeTCP(ctx, conn)
+
+		log.AssertNumberOfCalls(t, "Error", 0) // ending the session is not an error
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		svc := mocks.NewWordOfWisdom(t)
+		svc.On("Quote").Return("random quote", nil).Once()
+
+		conn := setupConnMock(t)
+		conn.On("Write", frame("random quote")).Return(len(frame("random quote")), nil).Once()
+		onReadFrame(conn, "more")
+		conn.On("Write", frame("invalid request")).Return(len(frame("invalid request")), nil).Once()
+
+		handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{MaxQuotesPerSession: 3}, setupLogMock(t))
+		handler.ServeTCP(ctx, conn)
+	})
+
+	t.Run("session mode off", func(t *testing.T) {
+		svc := mocks.NewWordOfWisdom(t)
+		svc.On("Quote").Return("random quote", nil).Once()
+
+		// a single quote is served regardless of the client request
+		conn := setupConnMock(t)
+		conn.On("Write", frame("random quote")).Return(len(frame("random quote")), nil).Once()
+
+		handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, setupLogMock(t))
+		handler.ServeTCP(ctx, conn)
+
+		conn.AssertNotCalled(t, "Read", mock.Anything)
+	})
+}
+
+// slowGetter is a service.Getter taking the delay to get a quote.
+type slowGetter struct {
+	delay time.Duration
+}
+
+func (g slowGetter) Get(string) string {
+	time.Sleep(g.delay)
+	return "slow quote"
+}
+func (g slowGetter) GetIds() []string                 { return []string{"1"} }
+func (g slowGetter) GetIdsByCategory(string) []string { return []string{"1"} }
+func (g slowGetter) Categories() []string             { return nil }
+
+func TestWordOfWisdomHandler_ServeTCP_quote_timeout(t *testing.T) {
+	log := setupLogMock(t)
+
+	svc := service.NewWordOfWisdomService(slowGetter{delay: time.Second}, service.RNGOf(""))
+	handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{QuoteTimeout: 10 * time.Millisecond}, log)
+
+	conn := setupConnMock(t)
+	conn.On("Write", frame("quote timeout")).Return(len(frame("quote timeout")), nil).Once()
+
+	// the client is informed long before the quote is retrieved, while the context is never done
+	start := time.Now()
+	handler.ServeTCP(context.Background(), conn)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	conn.AssertCalled(t, "Close")
+	log.AssertNumberOfCalls(t, "Warn", 1) // on quote retrieval timed out
+}
+
+func TestWordOfWisdomHandler_ServeTCP_no_quotes(t *testing.T) {
+	svc := mocks.NewWordOfWisdom(t)
+	svc.On("Quote").Return("", service.ErrNoQuotes).Once()
+
+	// an empty corpus is told apart from a failing quote source
+	conn := setupConnMock(t)
+	conn.On("Write", frame("no quotes available")).Return(len(frame("no quotes available")), nil).Once()
+
+	handler := NewWordOfWisdomHandler(svc, WordOfWisdomSettings{}, setupLogMock(t))
+	handler.ServeTCP(context.Background(), conn)
+}
@@ -0,0 +1,315 @@
+// Package httpapi serves the PoW challenge/verify flow over HTTP as an alternative to the raw TCP protocol.
+//
+// A client gets a challenge with GET /challenge, solves it and submits the result with POST /verify,
+// which returns a quote once the result has passed the verification.
+package httpapi
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/laonix/pow-word-of-wisdom/logger"
+	"github.com/laonix/pow-word-of-wisdom/pow"
+	"github.com/laonix/pow-word-of-wisdom/service"
+)
+
+// Paths of the API endpoints.
+const (
+	PathChallenge = "/challenge"
+	PathVerify    = "/verify"
+)
+
+// DefaultChallengeTTL is the default time a challenge is valid for (see Settings.ChallengeTTL).
+const DefaultChallengeTTL = time.Minute
+
+// DefaultMaxChallenges is the default limit of outstanding challenges (see Settings.MaxChallenges).
+const DefaultMaxChallenges = 100000
+
+// maxBodySize is an upper limit of a POST /verify request body size in bytes.
+const maxBodySize = 4 << 10
+
+// ChallengeResponse is a response to GET /challenge.
+type ChallengeResponse struct {
+	Challenge string    `json:"challenge"`
+	Bits      int       `json:"bits"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// VerifyRequest is a request body of POST /verify: a PoW result calculated for a challenge issued by GET /challenge.
+type VerifyRequest struct {
+	Challenge string `json:"challenge"`
+	Result    string `json:"result"`
+}
+
+// VerifyResponse is a response to POST /verify holding a quote for a result which has passed the verification.
+type VerifyResponse struct {
+	Quote string `json:"quote"`
+}
+
+// ErrorResponse is a response to a failed request.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Settings holds Handler settings.
+type Settings struct {
+	// Challenge generates PoW challenges, pow.Challenge if it's not set.
+	Challenge pow.ChallengeFunc
+	// Verify verifies PoW results, if it's set.
+	Verify pow.VerifyFunc
+	// VerifyReason verifies PoW results telling the reason of a failure, so the client is informed why its result
+	// has been rejected. It takes precedence over Verify; pow.VerifyReason is used if neither is set.
+	VerifyReason pow.ReasonFunc
+
+	// Complexity is an upper limit for a randomly generated challenge header bits.
+	//
+	// Bits should vary in interval [MinBits, Complexity).
+	Complexity int
+	// MinBits is a lower limit for a randomly generated challenge header bits, 10 if it's not set.
+	MinBits int
+
+	// ChallengeTTL is the time an issued challenge is valid for, DefaultChallengeTTL if it's not set.
+	// A challenge is redeemed by the first result submitted for it, whether the result is valid or not.
+	ChallengeTTL time.Duration
+	// MaxChallenges is the most challenges outstanding at once (issued and neither redeemed nor expired),
+	// DefaultMaxChallenges if it's not set. Once it's reached, no challenges are issued until some are redeemed
+	// or expired, so a flood of challenge requests cannot grow the memory held by the handler without bound.
+	MaxChallenges int
+}
+
+// Handler is an implementation of http.Handler serving the PoW HTTP API.
+//
+// Issued challenges are held by the handler until they are redeemed or expired,
+// so a result is accepted for a challenge issued by this handler only, and only once.
+type Handler struct {
+	svc       service.WordOfWisdom
+	challenge pow.ChallengeFunc
+	verify    pow.ReasonFunc
+
+	complexity int
+	minBits    int
+	ttl        time.Duration
+
+	challenges *challengeRegistry
+	mux        *http.ServeMux
+
+	log logger.Logger
+}
+
+// NewHandler returns a new instance of Handler serving quotes of the service.
+//
+// It returns an error if the settings don't allow any challenge header bits: MinBits must be positive
+// and less than Complexity.
+func NewHandler(svc service.WordOfWisdom, settings Settings, log logger.Logger) (*Handler, error) {
+	h := &Handler{
+		svc:        svc,
+		challenge:  settings.Challenge,
+		verify:     settings.VerifyReason,
+		complexity: settings.Complexity,
+		minBits:    settings.MinBits,
+		ttl:        settings.ChallengeTTL,
+		log:        log,
+	}
+
+	if h.challenge == nil {
+		h.challenge = pow.Challenge
+	}
+	if h.verify == nil && settings.Verify != nil {
+		h.verify = pow.ReasonFuncOf(settings.Verify)
+	}
+	if h.verify == nil {
+		h.verify = pow.VerifyReason
+	}
+	if h.minBits == 0 {
+		h.minBits = 10
+	}
+	if h.ttl <= 0 {
+		h.ttl = DefaultChallengeTTL
+	}
+	maxChallenges := settings.MaxChallenges
+	if maxChallenges <= 0 {
+		maxChallenges = DefaultMaxChallenges
+	}
+	h.challenges = newChallengeRegistry(maxChallenges)
+
+	if h.minBits < 0 {
+		return nil, fmt.Errorf("invalid MinBits %d: it must be positive", h.minBits)
+	}
+	if h.minBits >= h.complexity {
+		return nil, fmt.Errorf("invalid Complexity %d: it must exceed MinBits %d", h.complexity, h.minBits)
+	}
+
+	h.mux = http.NewServeMux()
+	h.mux.HandleFunc(PathChallenge, h.serveChallenge)
+	h.mux.HandleFunc(PathVerify, h.serveVerify)
+
+	return h, nil
+}
+
+// ServeHTTP serves GET /challenge and POST /verify requests.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// serveChallenge issues a challenge with random bits of interval [MinBits, Complexity).
+func (h *Handler) serveChallenge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	bits := rand.Intn(h.complexity-h.minBits) + h.minBits
+	challenge, err := h.challenge(uint(bits), "")
+	if err != nil {
+		h.log.Error(err, "action", "generate PoW challenge", "remote", r.RemoteAddr)
+		h.writeError(w, http.StatusInternalServerError, "internal error generating challenge")
+		return
+	}
+
+	expiresAt, ok := h.challenges.put(challenge, h.ttl)
+	if !ok {
+		h.log.Warn("too many outstanding challenges", "remote", r.RemoteAddr)
+		h.writeError(w, http.StatusServiceUnavailable, "server is busy")
+		return
+	}
+	h.log.Debug("issue PoW challenge", "bits", bits, "remote", r.RemoteAddr)
+
+	h.writeJSON(w, http.StatusOK, ChallengeResponse{Challenge: challenge, Bits: bits, ExpiresAt: expiresAt})
+}
+
+// serveVerify verifies a PoW result for a challenge issued earlier and returns a quote if it has passed.
+func (h *Handler) serveVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		h.writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var request VerifyRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(&request); err != nil {
+		h.log.Warn("invalid verify request", "err", err, "remote", r.RemoteAddr)
+		h.writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	if request.Challenge == "" || request.Result == "" {
+		h.writeError(w, http.StatusBadRequest, "challenge and result are required")
+		return
+	}
+
+	// a challenge is redeemed before the verification, so a result cannot be replayed or guessed repeatedly
+	answer, taken := h.challenges.take(request.Challenge, request.Result)
+	if answer == nil {
+		h.log.Warn("unknown or expired challenge", "challenge", request.Challenge, "remote", r.RemoteAddr)
+		h.writeError(w, http.StatusGone, "unknown or expired challenge")
+		return
+	}
+
+	// a client retrying a result (e.g. after a network failure) gets the same quote rather than an error,
+	// as long as the challenge would be valid, even if the quote is still being got for the first submission
+	if !taken {
+		select {
+		case <-answer.done:
+		case <-r.Context().Done():
+			return
+		}
+		if !answer.served {
+			h.log.Warn("unknown or expired challenge", "challenge", request.Challenge, "remote", r.RemoteAddr)
+			h.writeError(w, http.StatusGone, "unknown or expired challenge")
+			return
+		}
+
+		h.log.Debug("PoW result retried", "remote", r.RemoteAddr)
+		h.writeJSON(w, http.StatusOK, VerifyResponse{Quote: answer.quote})
+		return
+	}
+
+	reason, err := h.verify(request.Result, request.Challenge)
+	if reason != pow.ReasonValid {
+		h.challenges.forget(request.Challenge, answer)
+	}
+	if err != nil && !clientFault(err) {
+		h.log.Error(err, "action", "verify PoW", "remote", r.RemoteAddr)
+		h.writeError(w, http.StatusInternalServerError, "internal error verifying result")
+		return
+	}
+	if reason != pow.ReasonValid {
+		message := "PoW verification failed"
+		if reason != pow.ReasonFailed {
+			message += ": " + reason.String()
+		}
+
+		h.log.Warn(message, "header", request.Result, "err", err, "remote", r.RemoteAddr)
+		h.writeError(w, http.StatusForbidden, message)
+		return
+	}
+
+	quote, err := h.quote(request.Result)
+	if err != nil {
+		h.challenges.forget(request.Challenge, answer)
+		h.log.Error(err, "action", "get quote", "remote", r.RemoteAddr)
+		h.writeError(w, http.StatusInternalServerError, "internal error getting quote")
+		return
+	}
+	h.challenges.resolve(answer, quote)
+
+	h.writeJSON(w, http.StatusOK, VerifyResponse{Quote: quote})
+}
+
+// clientFault checks if a verification error is the fault of the submitted result rather than of the server:
+// a VerifyFunc reports those as errors rather than as reasons (see pow.ReasonFuncOf).
+func clientFault(err error) bool {
+	return errors.Is(err, pow.ErrHeaderMismatch) || errors.Is(err, pow.ErrMalformedHeader) ||
+		errors.Is(err, pow.ErrUnsupportedVersion)
+}
+
+// quote returns a quote for a PoW result which has passed the verification.
+//
+// If the service supports that (see service.SeededWordOfWisdom), the quote is selected by the result
+// (see proofSeed), so the same result always maps to the same quote. Otherwise, it's a random quote.
+func (h *Handler) quote(result string) (string, error) {
+	if seeded, ok := h.svc.(service.SeededWordOfWisdom); ok {
+		return seeded.QuoteBySeed(proofSeed(result))
+	}
+
+	return h.svc.Quote()
+}
+
+// proofSeed returns a seed derived from the random and counter fields of a PoW result header,
+// which make the result unique, or from the whole result if it's not a Hashcash header.
+func proofSeed(result string) int64 {
+	unique := result
+	if header, err := pow.ParseHeaderString(result); err == nil {
+		unique = header.Random() + ":" + strconv.FormatInt(header.Counter(), 10)
+	}
+
+	sum := sha256.Sum256([]byte(unique))
+	return int64(binary.BigEndian.Uint64(sum[:8]))
+}
+
+// writeMethodNotAllowed responds to a request of a method other than the allowed one.
+func (h *Handler) writeMethodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	h.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// writeError responds with an ErrorResponse.
+func (h *Handler) writeError(w http.ResponseWriter, status int, message string) {
+	h.writeJSON(w, status, ErrorResponse{Error: message})
+}
+
+// writeJSON responds with a JSON encoded body.
+func (h *Handler) writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		h.log.Error(err, "action", "write response")
+	}
+}
@@ -0,0 +1,420 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/laonix/pow-word-of-wisdom/logger"
+	"github.com/laonix/pow-word-of-wisdom/pow"
+	"github.com/laonix/pow-word-of-wisdom/service"
+)
+
+// quoteService is a service.WordOfWisdom returning the same quote or error.
+type quoteService struct {
+	quote string
+	err   error
+}
+
+func (s quoteService) Quote() (string, error)                 { return s.quote, s.err }
+func (s quoteService) QuoteByCategory(string) (string, error) { return s.quote, s.err }
+func (s quoteService) Categories() []string                   { return nil }
+
+// newTestServer starts an HTTP server of a Handler issuing easy challenges.
+func newTestServer(t *testing.T, svc service.WordOfWisdom) *httptest.Server {
+	server, _ := newTestHandlerServer(t, svc)
+	return server
+}
+
+// newTestHandlerServer starts an HTTP server of a Handler issuing easy challenges and returns the Handler as well.
+func newTestHandlerServer(t *testing.T, svc service.WordOfWisdom) (*httptest.Server, *Handler) {
+	h, err := NewHandler(svc, Settings{Complexity: 6, MinBits: 4}, logger.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+
+	return server, h
+}
+
+// getChallenge requests a challenge from the server.
+func getChallenge(t *testing.T, server *httptest.Server) ChallengeResponse {
+	resp, err := http.Get(server.URL + PathChallenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %q", resp.Status)
+	}
+
+	var challenge ChallengeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&challenge); err != nil {
+		t.Fatal(err)
+	}
+
+	return challenge
+}
+
+// getSolved requests a challenge from the server and calculates its result.
+func getSolved(t *testing.T, server *httptest.Server) (ChallengeResponse, string) {
+	challenge := getChallenge(t, server)
+
+	result, err := pow.Calculate(challenge.Challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return challenge, result
+}
+
+// postVerify submits a request body to the server and decodes the response into v.
+func postVerify(t *testing.T, server *httptest.Server, body []byte, v any) int {
+	resp, err := http.Post(server.URL+PathVerify, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode
+}
+
+// verifyBody returns a POST /verify request body.
+func verifyBody(t *testing.T, challenge, result string) []byte {
+	body, err := json.Marshal(VerifyRequest{Challenge: challenge, Result: result})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return body
+}
+
+func TestHandler_cycle(t *testing.T) {
+	server := newTestServer(t, quoteService{quote: "quote"})
+
+	challenge, result := getSolved(t, server)
+	assert.GreaterOrEqual(t, challenge.Bits, 4)
+	assert.Less(t, challenge.Bits, 6)
+	assert.True(t, challenge.ExpiresAt.After(time.Now()))
+
+	header, err := pow.ParseHeaderString(challenge.Challenge)
+	if assert.Nil(t, err) {
+		assert.EqualValues(t, challenge.Bits, header.Bits())
+	}
+
+	var quote VerifyResponse
+	status := postVerify(t, server, verifyBody(t, challenge.Challenge, result), &quote)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "quote", quote.Quote)
+
+	// a retry of the same result gets the same quote
+	var retry VerifyResponse
+	status = postVerify(t, server, verifyBody(t, challenge.Challenge, result), &retry)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "quote", retry.Quote)
+
+	// another result cannot be submitted for the redeemed challenge
+	var replay ErrorResponse
+	status = postVerify(t, server, verifyBody(t, challenge.Challenge, challenge.Challenge), &replay)
+	assert.Equal(t, http.StatusGone, status)
+	assert.Equal(t, "unknown or expired challenge", replay.Error)
+}
+
+// blockingService is a service.WordOfWisdom flagging a quote is requested and returning it once it's released.
+type blockingService struct {
+	quoteService
+	requested chan struct{}
+	release   chan struct{}
+}
+
+func (s blockingService) Quote() (string, error) {
+	s.requested <- struct{}{}
+	<-s.release
+
+	return s.quoteService.Quote()
+}
+
+func TestHandler_retry_in_flight(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		svc         quoteService
+		status      int
+		retryStatus int
+	}{
+		{name: "quote served", svc: quoteService{quote: "quote"}, status: http.StatusOK, retryStatus: http.StatusOK},
+		{name: "quote failed", svc: quoteService{err: errors.New("failed")},
+			status: http.StatusInternalServerError, retryStatus: http.StatusGone},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := blockingService{quoteService: tc.svc, requested: make(chan struct{}), release: make(chan struct{})}
+			server := newTestServer(t, svc)
+
+			challenge, result := getSolved(t, server)
+			body := verifyBody(t, challenge.Challenge, result)
+
+			statuses := make(chan int, 2)
+			quotes := make(chan string, 2)
+			post := func() {
+				var quote VerifyResponse
+				statuses <- postVerify(t, server, body, &quote)
+				quotes <- quote.Quote
+			}
+
+			// a retry arriving while the quote is being got for the first submission waits for its outcome
+			go post()
+			<-svc.requested
+			go post()
+
+			select {
+			case status := <-statuses:
+				t.Fatalf("a retry has been answered before the first submission: %d", status)
+			case <-time.After(50 * time.Millisecond):
+			}
+			close(svc.release)
+
+			assert.ElementsMatch(t, []int{tc.status, tc.retryStatus}, []int{<-statuses, <-statuses})
+			assert.Equal(t, []string{tc.svc.quote, tc.svc.quote}, []string{<-quotes, <-quotes})
+		})
+	}
+}
+
+// seededService is a service.SeededWordOfWisdom selecting a quote by a seed as is,
+// and a different quote on every call of Quote.
+type seededService struct {
+	quotes []string
+	calls  int
+}
+
+func (s *seededService) Quote() (string, error) {
+	s.calls++
+	return s.quotes[s.calls%len(s.quotes)], nil
+}
+func (s *seededService) QuoteByCategory(string) (string, error) { return s.Quote() }
+func (s *seededService) Categories() []string                   { return nil }
+func (s *seededService) QuoteBySeed(seed int64) (string, error) {
+	return s.quotes[uint64(seed)%uint64(len(s.quotes))], nil
+}
+
+func TestHandler_seeded_quote(t *testing.T) {
+	svc := &seededService{quotes: []string{"first", "second", "third", "fourth", "fifth"}}
+	server, h := newTestHandlerServer(t, svc)
+
+	challenge, result := getSolved(t, server)
+	want, _ := svc.QuoteBySeed(proofSeed(result))
+
+	var quote VerifyResponse
+	status := postVerify(t, server, verifyBody(t, challenge.Challenge, result), &quote)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, want, quote.Quote)
+
+	// once the answer has expired, the identical proof for the challenge issued again maps to the same quote
+	h.challenges.now = func() time.Time { return challenge.ExpiresAt.Add(time.Second) }
+	h.challenges.put(challenge.Challenge, time.Minute)
+
+	var again VerifyResponse
+	status = postVerify(t, server, verifyBody(t, challenge.Challenge, result), &again)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, want, again.Quote)
+	assert.Zero(t, svc.calls)
+}
+
+func TestProofSeed(t *testing.T) {
+	const (
+		result = "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+		// the same random and counter with another resource
+		sameProof = "1:12:2208082121:9a4e3c1d-0b5f-4c6a-8e2d-7f1a2b3c4d5e::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+		// another counter
+		otherProof = "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	)
+
+	assert.Equal(t, proofSeed(result), proofSeed(result))
+	assert.Equal(t, proofSeed(result), proofSeed(sameProof))
+	assert.NotEqual(t, proofSeed(result), proofSeed(otherProof))
+
+	// not a Hashcash header is seeded as a whole
+	assert.Equal(t, proofSeed("not a header"), proofSeed("not a header"))
+	assert.NotEqual(t, proofSeed("not a header"), proofSeed("another"))
+}
+
+func TestHandler_verify_errors(t *testing.T) {
+	// a challenge and its result issued by another server
+	foreign, foreignResult := getSolved(t, newTestServer(t, quoteService{}))
+
+	tests := []struct {
+		name string
+		svc  quoteService
+		// body returns a request body for a challenge issued by the server
+		body    func(t *testing.T, challenge string) []byte
+		status  int
+		message string
+	}{
+		{
+			name:    "malformed body",
+			body:    func(t *testing.T, _ string) []byte { return []byte(`{"challenge":`) },
+			status:  http.StatusBadRequest,
+			message: "invalid request",
+		},
+		{
+			name:    "missing result",
+			body:    func(t *testing.T, challenge string) []byte { return verifyBody(t, challenge, "") },
+			status:  http.StatusBadRequest,
+			message: "challenge and result are required",
+		},
+		{
+			name:    "challenge not issued by the server",
+			body:    func(t *testing.T, _ string) []byte { return verifyBody(t, foreign.Challenge, foreignResult) },
+			status:  http.StatusGone,
+			message: "unknown or expired challenge",
+		},
+		{
+			name:    "result of another challenge",
+			body:    func(t *testing.T, challenge string) []byte { return verifyBody(t, challenge, foreignResult) },
+			status:  http.StatusForbidden,
+			message: "PoW verification failed: wrong challenge",
+		},
+		{
+			name:    "malformed result",
+			body:    func(t *testing.T, challenge string) []byte { return verifyBody(t, challenge, "not a header") },
+			status:  http.StatusForbidden,
+			message: "PoW verification failed: malformed result",
+		},
+		{
+			name: "result of unsupported version",
+			body: func(t *testing.T, challenge string) []byte {
+				result, _ := pow.Calculate(challenge)
+				return verifyBody(t, challenge, "2"+strings.TrimPrefix(result, "1"))
+			},
+			status:  http.StatusForbidden,
+			message: "PoW verification failed: malformed result",
+		},
+		{
+			name: "quote error",
+			svc:  quoteService{err: errors.New("no quotes")},
+			body: func(t *testing.T, challenge string) []byte {
+				result, _ := pow.Calculate(challenge)
+				return verifyBody(t, challenge, result)
+			},
+			status:  http.StatusInternalServerError,
+			message: "internal error getting quote",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestServer(t, test.svc)
+			challenge, _ := getSolved(t, server)
+
+			var response ErrorResponse
+			status := postVerify(t, server, test.body(t, challenge.Challenge), &response)
+			assert.Equal(t, test.status, status)
+			assert.Equal(t, test.message, response.Error)
+		})
+	}
+}
+
+func TestHandler_Verify_client_fault(t *testing.T) {
+	h, err := NewHandler(quoteService{quote: "quote"}, Settings{Verify: pow.Verify, Complexity: 6, MinBits: 4},
+		logger.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	// a result a VerifyFunc fails with an error is rejected as the client's fault rather than a server error
+	challenge, result := getSolved(t, server)
+
+	var response ErrorResponse
+	status := postVerify(t, server, verifyBody(t, challenge.Challenge, "2"+strings.TrimPrefix(result, "1")), &response)
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, "PoW verification failed", response.Error)
+}
+
+func TestHandler_expired_challenge(t *testing.T) {
+	server, h := newTestHandlerServer(t, quoteService{quote: "quote"})
+
+	challenge, result := getSolved(t, server)
+
+	h.challenges.now = func() time.Time { return challenge.ExpiresAt.Add(time.Second) }
+
+	var response ErrorResponse
+	status := postVerify(t, server, verifyBody(t, challenge.Challenge, result), &response)
+	assert.Equal(t, http.StatusGone, status)
+	assert.Equal(t, "unknown or expired challenge", response.Error)
+}
+
+func TestHandler_too_many_challenges(t *testing.T) {
+	h, err := NewHandler(quoteService{quote: "quote"}, Settings{Complexity: 6, MinBits: 4, MaxChallenges: 1},
+		logger.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	challenge, result := getSolved(t, server)
+
+	resp, err := http.Get(server.URL + PathChallenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// once the challenge is redeemed, another one is issued
+	var quote VerifyResponse
+	status := postVerify(t, server, verifyBody(t, challenge.Challenge, result), &quote)
+	assert.Equal(t, http.StatusOK, status)
+
+	getChallenge(t, server)
+}
+
+func TestHandler_method_not_allowed(t *testing.T) {
+	server := newTestServer(t, quoteService{quote: "quote"})
+
+	tests := []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{method: http.MethodPost, path: PathChallenge, allow: http.MethodGet},
+		{method: http.MethodGet, path: PathVerify, allow: http.MethodPost},
+	}
+
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, server.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode, "%s %s", test.method, test.path)
+		assert.Equal(t, test.allow, resp.Header.Get("Allow"))
+	}
+}
+
+func TestNewHandler_invalid_bits(t *testing.T) {
+	_, err := NewHandler(quoteService{}, Settings{Complexity: 10}, logger.NewNopLogger())
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "invalid Complexity 10: it must exceed MinBits 10", err.Error())
+	}
+}
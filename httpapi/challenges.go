@@ -0,0 +1,170 @@
+package httpapi
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// challengeRegistry holds issued challenges until they are redeemed or expired,
+// and the answers to the PoW results submitted for redeemed challenges until the challenges would have expired
+// (see take).
+//
+// Challenges are dropped in order of their expiry as they're due (see expire) rather than by a sweep,
+// and the number of outstanding ones (issued and neither redeemed nor expired) is bounded (see put).
+type challengeRegistry struct {
+	mu       sync.Mutex
+	entries  map[string]*challengeEntry
+	expiries expiryHeap
+	// outstanding is the number of entries which have not been redeemed
+	outstanding int
+	limit       int
+	now         func() time.Time
+}
+
+// challengeEntry is an issued challenge.
+type challengeEntry struct {
+	challenge string
+	expiry    time.Time
+	index     int // in the expiry heap
+	redeemed  bool
+	// result is the PoW result a challenge has been redeemed for, and answer is the answer to it
+	result string
+	answer *answer
+}
+
+// answer is an answer to a PoW result submitted for a challenge: it's pending until done is closed,
+// then it holds the quote served for the result, unless the result has been rejected.
+type answer struct {
+	done   chan struct{}
+	quote  string
+	served bool
+}
+
+// expiryHeap is a min-heap of challenges by their expiry (see heap.Interface).
+type expiryHeap []*challengeEntry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiry.Before(h[j].expiry) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *expiryHeap) Push(x any) {
+	e := x.(*challengeEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+
+	return e
+}
+
+// newChallengeRegistry returns a new instance of challengeRegistry holding up to limit outstanding challenges,
+// or any number of them if limit is not positive.
+func newChallengeRegistry(limit int) *challengeRegistry {
+	return &challengeRegistry{entries: make(map[string]*challengeEntry), limit: limit, now: time.Now}
+}
+
+// put registers a challenge valid for the ttl and returns its expiry.
+//
+// It returns false if the limit of outstanding challenges has been reached.
+func (r *challengeRegistry) put(challenge string, ttl time.Duration) (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	r.expire(now)
+
+	if e, ok := r.entries[challenge]; ok { // the same challenge issued again replaces the previous one
+		r.remove(e)
+	}
+	if r.limit > 0 && r.outstanding >= r.limit {
+		return time.Time{}, false
+	}
+
+	e := &challengeEntry{challenge: challenge, expiry: now.Add(ttl)}
+	r.entries[challenge] = e
+	heap.Push(&r.expiries, e)
+	r.outstanding++
+
+	return e.expiry, true
+}
+
+// expire drops the challenges which have expired by now, along with their answers.
+func (r *challengeRegistry) expire(now time.Time) {
+	for len(r.expiries) > 0 && now.After(r.expiries[0].expiry) {
+		r.remove(r.expiries[0])
+	}
+}
+
+// remove drops a challenge.
+func (r *challengeRegistry) remove(e *challengeEntry) {
+	heap.Remove(&r.expiries, e.index)
+	delete(r.entries, e.challenge)
+	if !e.redeemed {
+		r.outstanding--
+	}
+}
+
+// take redeems a challenge for a PoW result and returns a pending answer to it, which the caller must either resolve
+// (see resolve) or forget (see forget).
+//
+// If the result has been submitted for the challenge already, its answer is returned instead along with false:
+// it's resolved by the first submission, so a retry gets the same quote, even if it arrives before the quote does.
+// It returns nil if the challenge has never been registered, has been redeemed for another result, or has expired.
+func (r *challengeRegistry) take(challenge, result string) (*answer, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.entries[challenge]
+	if !ok {
+		return nil, false
+	}
+	if r.now().After(e.expiry) {
+		r.remove(e)
+		return nil, false
+	}
+	if e.redeemed {
+		if e.result == result {
+			return e.answer, false
+		}
+		return nil, false
+	}
+
+	e.redeemed = true
+	e.result = result
+	e.answer = &answer{done: make(chan struct{})}
+	r.outstanding--
+
+	return e.answer, true
+}
+
+// resolve serves a quote to a PoW result taken for a challenge (see take), so it's served to its retries as well.
+func (r *challengeRegistry) resolve(a *answer, quote string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a.quote = quote
+	a.served = true
+	close(a.done)
+}
+
+// forget rejects a PoW result taken for a challenge (see take) and drops the challenge,
+// so failed attempts don't pile up until it expires.
+func (r *challengeRegistry) forget(challenge string, a *answer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.entries[challenge]; ok && e.answer == a {
+		r.remove(e)
+	}
+	close(a.done)
+}
@@ -0,0 +1,107 @@
+package httpapi
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChallengeRegistry_limit(t *testing.T) {
+	r := newChallengeRegistry(2)
+	now := time.Unix(0, 0)
+	r.now = func() time.Time { return now }
+
+	_, ok := r.put("first", time.Minute)
+	assert.True(t, ok)
+	_, ok = r.put("second", 2*time.Minute)
+	assert.True(t, ok)
+
+	// the limit of outstanding challenges has been reached
+	_, ok = r.put("third", time.Minute)
+	assert.False(t, ok)
+
+	// a redeemed challenge is not outstanding anymore
+	a, taken := r.take("first", "result")
+	assert.True(t, taken)
+	_, ok = r.put("third", time.Minute)
+	assert.True(t, ok)
+
+	// and neither is an expired one, which is dropped along with its answer
+	r.resolve(a, "quote")
+	now = now.Add(time.Minute + time.Second)
+	_, ok = r.put("fourth", time.Minute)
+	assert.True(t, ok)
+
+	assert.ElementsMatch(t, []string{"second", "fourth"}, registered(r))
+	a, _ = r.take("first", "result")
+	assert.Nil(t, a)
+}
+
+func TestChallengeRegistry_take(t *testing.T) {
+	r := newChallengeRegistry(0)
+	_, ok := r.put("challenge", time.Minute)
+	assert.True(t, ok)
+
+	a, taken := r.take("challenge", "result")
+	assert.True(t, taken)
+
+	// a retry of the result gets the pending answer, while another result is rejected
+	retry, taken := r.take("challenge", "result")
+	assert.False(t, taken)
+	assert.Same(t, a, retry)
+	other, _ := r.take("challenge", "other")
+	assert.Nil(t, other)
+
+	r.resolve(a, "quote")
+	<-retry.done
+	assert.True(t, retry.served)
+	assert.Equal(t, "quote", retry.quote)
+}
+
+func TestChallengeRegistry_forget(t *testing.T) {
+	r := newChallengeRegistry(0)
+
+	answers := make([]*answer, 3)
+	for i := range answers {
+		_, ok := r.put(strconv.Itoa(i), time.Minute)
+		assert.True(t, ok)
+		answers[i], _ = r.take(strconv.Itoa(i), "result")
+	}
+	r.resolve(answers[0], "quote")
+
+	// a failed attempt is dropped right away, while an answered challenge is held until it expires
+	r.forget("1", answers[1])
+	assert.ElementsMatch(t, []string{"0", "2"}, registered(r))
+
+	a, _ := r.take("0", "result")
+	assert.True(t, a.served)
+	assert.Equal(t, "quote", a.quote)
+
+	// a retry waiting for a forgotten attempt learns it has been rejected
+	<-answers[1].done
+	assert.False(t, answers[1].served)
+
+	// a forgotten challenge cannot be taken again
+	a, _ = r.take("1", "result")
+	assert.Nil(t, a)
+}
+
+// registered returns the challenges held by a registry, checking they're consistent with its expiry heap.
+func registered(r *challengeRegistry) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var challenges []string
+	for i, e := range r.expiries {
+		if r.entries[e.challenge] == e && e.index == i {
+			challenges = append(challenges, e.challenge)
+		}
+	}
+	if len(challenges) != len(r.entries) {
+		return nil
+	}
+
+	return challenges
+}
@@ -0,0 +1,60 @@
+package logger
+
+// fieldsLogger is a Logger adding a fixed set of key-value pairs to every record.
+type fieldsLogger struct {
+	log    Logger
+	fields []any
+}
+
+// WithFields returns a Logger adding the given key-value pairs to every record logged by log.
+//
+// The pairs are appended after the ones of a particular call.
+// If there are no pairs, log itself is returned.
+func WithFields(log Logger, kvs ...any) Logger {
+	if len(kvs) == 0 {
+		return log
+	}
+
+	if l, ok := log.(*fieldsLogger); ok {
+		fields := make([]any, 0, len(l.fields)+len(kvs))
+		fields = append(fields, l.fields...)
+
+		return &fieldsLogger{log: l.log, fields: append(fields, kvs...)}
+	}
+
+	return &fieldsLogger{log: log, fields: kvs}
+}
+
+// Debug logs a message with the fields added.
+func (l *fieldsLogger) Debug(msg string, kvs ...any) {
+	l.log.Debug(msg, l.with(kvs...)...)
+}
+
+// Info logs a message with the fields added.
+func (l *fieldsLogger) Info(msg string, kvs ...any) {
+	l.log.Info(msg, l.with(kvs...)...)
+}
+
+// Warn logs a message with the fields added.
+func (l *fieldsLogger) Warn(msg string, kvs ...any) {
+	l.log.Warn(msg, l.with(kvs...)...)
+}
+
+// Error logs an error with the fields added.
+func (l *fieldsLogger) Error(err error, kvs ...any) {
+	l.log.Error(err, l.with(kvs...)...)
+}
+
+// SetLevel changes the level of the underlying logger, if it supports that (see LevelSetter).
+func (l *fieldsLogger) SetLevel(level Level) {
+	if s, ok := l.log.(LevelSetter); ok {
+		s.SetLevel(level)
+	}
+}
+
+func (l *fieldsLogger) with(kvs ...any) []any {
+	all := make([]any, 0, len(kvs)+len(l.fields))
+	all = append(all, kvs...)
+
+	return append(all, l.fields...)
+}
@@ -0,0 +1,84 @@
+package logger
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type record struct {
+	level Level
+	msg   string
+	kvs   []any
+}
+
+type recordingLogger struct {
+	records []record
+	level   Level
+}
+
+func (l *recordingLogger) Debug(msg string, kvs ...any) {
+	l.records = append(l.records, record{level: LevelDebug, msg: msg, kvs: kvs})
+}
+
+func (l *recordingLogger) Info(msg string, kvs ...any) {
+	l.records = append(l.records, record{level: LevelInfo, msg: msg, kvs: kvs})
+}
+
+func (l *recordingLogger) Warn(msg string, kvs ...any) {
+	l.records = append(l.records, record{level: LevelWarn, msg: msg, kvs: kvs})
+}
+
+func (l *recordingLogger) Error(err error, kvs ...any) {
+	l.records = append(l.records, record{level: LevelError, msg: err.Error(), kvs: kvs})
+}
+
+func (l *recordingLogger) SetLevel(level Level) {
+	l.level = level
+}
+
+func TestWithFields(t *testing.T) {
+	rec := &recordingLogger{}
+
+	log := WithFields(rec, "conn_id", "42")
+	log.Debug("debug", "key", "value")
+	log.Info("info")
+	log.Warn("warn", "key", "value")
+	log.Error(errors.New("error"), "action", "test")
+
+	assert.Equal(t, []record{
+		{level: LevelDebug, msg: "debug", kvs: []any{"key", "value", "conn_id", "42"}},
+		{level: LevelInfo, msg: "info", kvs: []any{"conn_id", "42"}},
+		{level: LevelWarn, msg: "warn", kvs: []any{"key", "value", "conn_id", "42"}},
+		{level: LevelError, msg: "error", kvs: []any{"action", "test", "conn_id", "42"}},
+	}, rec.records)
+}
+
+func TestWithFields_nested(t *testing.T) {
+	rec := &recordingLogger{}
+
+	parent := WithFields(rec, "conn_id", "42")
+	WithFields(parent, "remote", "127.0.0.1").Info("child")
+	parent.Info("parent")
+
+	assert.Equal(t, []record{
+		{level: LevelInfo, msg: "child", kvs: []any{"conn_id", "42", "remote", "127.0.0.1"}},
+		{level: LevelInfo, msg: "parent", kvs: []any{"conn_id", "42"}},
+	}, rec.records)
+}
+
+func TestWithFields_no_fields(t *testing.T) {
+	rec := &recordingLogger{}
+
+	assert.Same(t, rec, WithFields(rec))
+}
+
+func TestWithFields_SetLevel(t *testing.T) {
+	rec := &recordingLogger{}
+
+	log := WithFields(rec, "conn_id", "42")
+	log.(LevelSetter).SetLevel(LevelDebug)
+
+	assert.Equal(t, LevelDebug, rec.level)
+}
@@ -0,0 +1,10 @@
+//go:build !go1.21
+
+package logger
+
+// New returns a Logger of the backend.
+//
+// SlogLogger requires Go 1.21, so ZapLogger is returned regardless of the backend.
+func New(_ string, level Level, encoding Encoding) Logger {
+	return NewZapLogger(level, encoding)
+}
@@ -0,0 +1,23 @@
+package logger
+
+// NopLogger is an implementation of Logger discarding all records.
+//
+// It's meant for tests and embedders which don't want any logging output.
+type NopLogger struct{}
+
+// NewNopLogger returns a new NopLogger instance.
+func NewNopLogger() *NopLogger {
+	return &NopLogger{}
+}
+
+// Info discards a message.
+func (NopLogger) Info(_ string, _ ...any) {}
+
+// Error discards an error, which might be nil.
+func (NopLogger) Error(_ error, _ ...any) {}
+
+// Warn discards a message.
+func (NopLogger) Warn(_ string, _ ...any) {}
+
+// Debug discards a message.
+func (NopLogger) Debug(_ string, _ ...any) {}
@@ -0,0 +1,20 @@
+package logger
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNopLogger(t *testing.T) {
+	var log Logger = NewNopLogger()
+
+	assert.NotPanics(t, func() {
+		log.Debug("message", "key", "value")
+		log.Info("message", "key", "value")
+		log.Warn("message", "key")
+		log.Error(errors.New("error"), "action", "test")
+		log.Error(nil)
+	})
+}
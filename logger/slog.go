@@ -0,0 +1,96 @@
+//go:build go1.21
+
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"os"
+)
+
+// SlogLogger is an implementation of Logger wrapping slog.Logger.
+type SlogLogger struct {
+	slog *slog.Logger
+	// level is the level of the handler, if it's been created along with the logger (see New)
+	level *slog.LevelVar
+}
+
+// NewSlogLogger returns a new SlogLogger instance logging to the handler.
+//
+// Logging level is defined by the handler (see SlogLevel).
+func NewSlogLogger(handler slog.Handler) *SlogLogger {
+	return &SlogLogger{
+		slog: slog.New(handler),
+	}
+}
+
+// Info logs a message with some additional context.
+func (s SlogLogger) Info(msg string, kvs ...any) {
+	s.slog.Log(context.Background(), slog.LevelInfo, msg, kvs...)
+}
+
+// Error logs a message with some additional context.
+func (s SlogLogger) Error(err error, kvs ...any) {
+	if caller, ok := err.(interface{ Caller() string }); ok {
+		kvs = append(kvs, "caller", caller.Caller())
+	}
+	if kver, ok := err.(interface{ KeyValues() []interface{} }); ok {
+		kvs = append(kvs, kver.KeyValues()...)
+	}
+	s.slog.Log(context.Background(), slog.LevelError, err.Error(), kvs...)
+}
+
+// Warn logs a message with some additional context.
+func (s SlogLogger) Warn(msg string, kvs ...any) {
+	s.slog.Log(context.Background(), slog.LevelWarn, msg, kvs...)
+}
+
+// Debug logs a message with some additional context.
+func (s SlogLogger) Debug(msg string, kvs ...any) {
+	s.slog.Log(context.Background(), slog.LevelDebug, msg, kvs...)
+}
+
+// SetLevel changes the logging level of a SlogLogger created with New.
+//
+// The level of a handler passed to NewSlogLogger is defined by the handler, so it's not changed.
+func (s SlogLogger) SetLevel(level Level) {
+	if s.level != nil {
+		s.level.Set(SlogLevel(level))
+	}
+}
+
+// SlogLevel returns a slog.Level corresponding to a Level.
+func SlogLevel(level Level) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelWarn:
+		return slog.LevelWarn
+	case LevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// New returns a Logger of the backend writing log records of the encoding to stderr:
+// "slog" for SlogLogger (writing text records for EncodingConsole), ZapLogger otherwise.
+func New(backend string, level Level, encoding Encoding) Logger {
+	if backend == BackendSlog {
+		slogLevel := new(slog.LevelVar)
+		slogLevel.Set(SlogLevel(level))
+
+		options := &slog.HandlerOptions{Level: slogLevel}
+
+		var handler slog.Handler = slog.NewJSONHandler(os.Stderr, options)
+		if encoding == EncodingConsole {
+			handler = slog.NewTextHandler(os.Stderr, options)
+		}
+
+		logger := NewSlogLogger(handler)
+		logger.level = slogLevel
+		return logger
+	}
+
+	return NewZapLogger(level, encoding)
+}
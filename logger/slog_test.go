@@ -0,0 +1,85 @@
+//go:build go1.21
+
+package logger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLogger(t *testing.T) {
+	tests := []struct {
+		name  string
+		level Level
+		want  []string
+	}{
+		{
+			name:  "debug",
+			level: LevelDebug,
+			want: []string{
+				`level=DEBUG msg="debug message" key=value`,
+				`level=INFO msg="info message" key=value`,
+				`level=WARN msg="warn message" key=value`,
+				`level=ERROR msg=failure action="do something"`,
+			},
+		},
+		{
+			name:  "warn",
+			level: LevelOf("WARN"),
+			want: []string{
+				`level=WARN msg="warn message" key=value`,
+				`level=ERROR msg=failure action="do something"`,
+			},
+		},
+		{
+			name:  "error",
+			level: LevelOf("error"),
+			want: []string{
+				`level=ERROR msg=failure action="do something"`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			log := NewSlogLogger(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+				Level: SlogLevel(test.level),
+				// drop the time, so the output is deterministic
+				ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+					if a.Key == slog.TimeKey {
+						return slog.Attr{}
+					}
+					return a
+				},
+			}))
+
+			log.Debug("debug message", "key", "value")
+			log.Info("info message", "key", "value")
+			log.Warn("warn message", "key", "value")
+			log.Error(errors.New("failure"), "action", "do something")
+
+			assert.Equal(t, test.want, strings.Split(strings.TrimSpace(buf.String()), "\n"))
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	assert.IsType(t, &SlogLogger{}, New(BackendSlog, LevelInfo, EncodingJSON))
+	assert.IsType(t, &ZapLogger{}, New("zap", LevelInfo, EncodingJSON))
+}
+
+func TestSlogLogger_SetLevel(t *testing.T) {
+	log := New(BackendSlog, LevelError, EncodingJSON).(*SlogLogger)
+	assert.False(t, log.slog.Enabled(context.Background(), slog.LevelDebug))
+
+	log.SetLevel(LevelDebug)
+	assert.True(t, log.slog.Enabled(context.Background(), slog.LevelDebug))
+}
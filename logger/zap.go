@@ -15,6 +15,11 @@ type Logger interface {
 	Error(err error, kvs ...any)
 }
 
+// LevelSetter is implemented by loggers which level might be changed at runtime.
+type LevelSetter interface {
+	SetLevel(level Level)
+}
+
 // Level is a logging Level.
 type Level int
 
@@ -29,6 +34,42 @@ const (
 	LevelDebug Level = iota
 )
 
+// BackendSlog is a name of the slog logging backend (see New), while zap is the default one.
+const BackendSlog = "slog"
+
+// Encoding is a format of log records.
+type Encoding string
+
+const (
+	// EncodingJSON is a format of structured JSON log records, one per line.
+	EncodingJSON Encoding = "json"
+	// EncodingConsole is a human-readable format of log records meant for local development.
+	EncodingConsole Encoding = "console"
+)
+
+// EncodingOf returns an Encoding corresponding to an argument string; it's EncodingJSON unless it's "console".
+func EncodingOf(encoding string) Encoding {
+	if strings.EqualFold(encoding, string(EncodingConsole)) {
+		return EncodingConsole
+	}
+
+	return EncodingJSON
+}
+
+// String returns a name of the Level.
+func (l Level) String() string {
+	switch l {
+	case LevelError:
+		return "error"
+	case LevelWarn:
+		return "warn"
+	case LevelDebug:
+		return "debug"
+	default:
+		return "info"
+	}
+}
+
 // LevelOf returns a Level corresponding to an argument string.
 func LevelOf(level string) Level {
 	tmp := strings.ToLower(level)
@@ -46,28 +87,52 @@ func LevelOf(level string) Level {
 }
 
 // ZapLogger is an implementation of Logger wrapping zap.SugaredLogger.
+//
+// Its level might be changed at runtime (see SetLevel).
 type ZapLogger struct {
-	zap *zap.SugaredLogger
+	zap   *zap.SugaredLogger
+	level zap.AtomicLevel
+}
+
+// NewZapLogger returns new NewZapLogger instance writing log records of the encoding to stderr.
+func NewZapLogger(level Level, encoding Encoding) *ZapLogger {
+	return newZapLogger(zapConfig(level, encoding))
 }
 
-// NewZapLogger returns new NewZapLogger instance.
-func NewZapLogger(level Level) *ZapLogger {
+// zapConfig returns a production zap config with ISO8601 timestamps and no caller key.
+func zapConfig(level Level, encoding Encoding) zap.Config {
 	cfg := zap.NewProductionConfig()
-	cfg.Level = zapLevel(level)
+	cfg.Level = zap.NewAtomicLevel()
+	setZapLevel(cfg.Level, level)
 	cfg.EncoderConfig = zap.NewProductionEncoderConfig()
 	cfg.EncoderConfig.CallerKey = zapcore.OmitKey
 	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
 
+	if encoding == EncodingConsole {
+		cfg.Encoding = string(EncodingConsole)
+		cfg.EncoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+	}
+
+	return cfg
+}
+
+func newZapLogger(cfg zap.Config) *ZapLogger {
 	logger, err := cfg.Build()
 	if err != nil {
 		panic(err)
 	}
 
 	return &ZapLogger{
-		zap: logger.Sugar(),
+		zap:   logger.Sugar(),
+		level: cfg.Level,
 	}
 }
 
+// SetLevel changes the logging level, it's safe for concurrent use along with logging.
+func (z ZapLogger) SetLevel(level Level) {
+	setZapLevel(z.level, level)
+}
+
 // Info logs a message with some additional context.
 func (z ZapLogger) Info(msg string, kvs ...interface{}) {
 	z.zap.Infow(msg, kvs...)
@@ -94,8 +159,7 @@ func (z ZapLogger) Debug(msg string, kvs ...interface{}) {
 	z.zap.Debugw(msg, kvs...)
 }
 
-func zapLevel(level Level) zap.AtomicLevel {
-	al := zap.NewAtomicLevel()
+func setZapLevel(al zap.AtomicLevel, level Level) {
 	switch level {
 	case LevelDebug:
 		al.SetLevel(zap.DebugLevel)
@@ -106,5 +170,4 @@ func zapLevel(level Level) zap.AtomicLevel {
 	case LevelWarn:
 		al.SetLevel(zap.WarnLevel)
 	}
-	return al
 }
@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestZapLogger_SetLevel(t *testing.T) {
+	level := zap.NewAtomicLevel()
+	core, logs := observer.New(level)
+
+	var log LevelSetter = &ZapLogger{zap: zap.New(core).Sugar(), level: level}
+
+	log.SetLevel(LevelError)
+	log.(Logger).Debug("suppressed", "key", "value")
+	assert.Zero(t, logs.Len())
+
+	log.SetLevel(LevelDebug)
+	log.(Logger).Debug("debug message", "key", "value")
+	if assert.Equal(t, 1, logs.Len()) {
+		entry := logs.All()[0]
+		assert.Equal(t, "debug message", entry.Message)
+		assert.Equal(t, map[string]interface{}{"key": "value"}, entry.ContextMap())
+	}
+}
+
+func TestLevel_String(t *testing.T) {
+	for _, level := range []Level{LevelError, LevelWarn, LevelInfo, LevelDebug} {
+		assert.Equal(t, level, LevelOf(level.String()))
+	}
+}
+
+// logLine logs a message with a logger of the encoding and returns the log record written.
+func logLine(t *testing.T, encoding Encoding) string {
+	path := filepath.Join(t.TempDir(), "log")
+
+	cfg := zapConfig(LevelInfo, encoding)
+	cfg.OutputPaths = []string{path}
+
+	log := newZapLogger(cfg)
+	log.Info("message", "key", "value")
+	_ = log.zap.Sync()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+func TestNewZapLogger_encoding(t *testing.T) {
+	var record map[string]interface{}
+	if assert.Nil(t, json.Unmarshal([]byte(logLine(t, EncodingJSON)), &record)) {
+		assert.Equal(t, "message", record["msg"])
+		assert.Equal(t, "value", record["key"])
+		assert.Equal(t, "info", record["level"])
+		// ISO8601 timestamps, no caller
+		assert.Regexp(t, `^\d{4}-\d{2}-\d{2}T`, record["ts"])
+		assert.NotContains(t, record, "caller")
+	}
+
+	line := logLine(t, EncodingConsole)
+	assert.False(t, json.Valid([]byte(line)), "console record %q is JSON", line)
+	assert.Contains(t, line, "INFO")
+	assert.Contains(t, line, "message")
+	assert.Contains(t, line, `{"key": "value"}`)
+}
+
+func TestEncodingOf(t *testing.T) {
+	assert.Equal(t, EncodingConsole, EncodingOf("Console"))
+	assert.Equal(t, EncodingJSON, EncodingOf("json"))
+	assert.Equal(t, EncodingJSON, EncodingOf(""))
+}
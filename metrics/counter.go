@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// Counter is a monotonically increasing total (e.g. a number of served clients).
+//
+// It implements expvar.Var, so it can be published along with other exported variables.
+type Counter struct {
+	value uint64
+}
+
+// NewCounter returns a new instance of Counter.
+func NewCounter() *Counter {
+	return &Counter{}
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add increments the counter by delta.
+func (c *Counter) Add(delta uint64) {
+	atomic.AddUint64(&c.value, delta)
+}
+
+// Value returns the current total.
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// String returns the current total as a JSON number.
+func (c *Counter) String() string {
+	return strconv.FormatUint(c.Value(), 10)
+}
@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Histogram counts occurrences of discrete integer values (e.g. issued challenge bits).
+//
+// It implements expvar.Var, so it can be published along with other exported variables.
+type Histogram struct {
+	mu     sync.Mutex
+	counts map[int]uint64
+}
+
+// NewHistogram returns a new instance of Histogram.
+func NewHistogram() *Histogram {
+	return &Histogram{counts: make(map[int]uint64)}
+}
+
+// Observe records an occurrence of a value.
+func (h *Histogram) Observe(value int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.counts[value]++
+}
+
+// Snapshot returns a copy of recorded counts by value.
+func (h *Histogram) Snapshot() map[int]uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	snapshot := make(map[int]uint64, len(h.counts))
+	for value, count := range h.counts {
+		snapshot[value] = count
+	}
+
+	return snapshot
+}
+
+// String returns a JSON object of recorded counts by value, e.g. {"10":3,"11":5}.
+func (h *Histogram) String() string {
+	snapshot := h.Snapshot()
+
+	values := make([]int, 0, len(snapshot))
+	for value := range snapshot {
+		values = append(values, value)
+	}
+	sort.Ints(values)
+
+	// values are sorted to keep the output stable
+	b := []byte{'{'}
+	for i, value := range values {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendQuote(b, strconv.Itoa(value))
+		b = append(b, ':')
+		b = strconv.AppendUint(b, snapshot[value], 10)
+	}
+	b = append(b, '}')
+
+	return string(b)
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram()
+	assert.Equal(t, "{}", h.String())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			h.Observe(10 + i%3)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, map[int]uint64{10: 4, 11: 3, 12: 3}, h.Snapshot())
+	assert.Equal(t, `{"10":4,"11":3,"12":3}`, h.String())
+
+	var decoded map[string]uint64
+	assert.Nil(t, json.Unmarshal([]byte(h.String()), &decoded))
+}
@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// PoW verification outcomes (see Prometheus).
+const (
+	OutcomePassed   = "passed"
+	OutcomeFailed   = "failed"
+	OutcomeTimedOut = "timed_out"
+)
+
+// Client versions substituted for the ones declared by clients (see Prometheus.ClientVersion).
+const (
+	// UnknownClientVersion stands for a version a client hasn't declared.
+	UnknownClientVersion = "unknown"
+	// OtherClientVersion stands for a version over MaxClientVersions or longer than MaxClientVersionLength.
+	OtherClientVersion = "other"
+)
+
+// MaxClientVersions is the most distinct client versions counted apart, so a client cannot blow up label cardinality
+// declaring random versions; MaxClientVersionLength is the longest client version counted as is.
+const (
+	MaxClientVersions      = 50
+	MaxClientVersionLength = 64
+)
+
+// Prometheus holds Prometheus collectors of the server: accepted connections, PoW verification outcomes,
+// the time clients take to send a PoW result, and the versions of client software.
+//
+// Collectors are registered in a dedicated registry served by Handler.
+// All the methods are safe to call on a nil Prometheus, so collecting is optional for the callers.
+type Prometheus struct {
+	registry *prometheus.Registry
+
+	// Connections counts accepted connections.
+	Connections prometheus.Counter
+	// Verifications counts PoW verifications by the "outcome" label.
+	Verifications *prometheus.CounterVec
+	// WaitTime observes the time from sending a PoW challenge to receiving its result, in seconds.
+	WaitTime prometheus.Histogram
+	// ClientVersions counts requests by the "client_version" label (see ClientVersion).
+	ClientVersions *prometheus.CounterVec
+
+	mu sync.Mutex
+	// versions holds the client versions counted apart
+	versions map[string]bool
+}
+
+// NewPrometheus returns a new instance of Prometheus with the collectors registered.
+func NewPrometheus() *Prometheus {
+	p := &Prometheus{
+		registry: prometheus.NewRegistry(),
+		Connections: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pow_connections_accepted_total",
+			Help: "Number of accepted TCP connections.",
+		}),
+		Verifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pow_verifications_total",
+			Help: "Number of PoW verifications by outcome: passed, failed or timed_out.",
+		}, []string{"outcome"}),
+		WaitTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "pow_verification_wait_seconds",
+			Help:    "Time from sending a PoW challenge to receiving its result.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+		}),
+		ClientVersions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pow_client_versions_total",
+			Help: "Number of requests by the declared version of client software.",
+		}, []string{"client_version"}),
+		versions: make(map[string]bool),
+	}
+
+	// outcomes are initialised, so each of them is exported before it occurs for the first time
+	for _, outcome := range []string{OutcomePassed, OutcomeFailed, OutcomeTimedOut} {
+		p.Verifications.WithLabelValues(outcome)
+	}
+
+	p.registry.MustRegister(p.Connections, p.Verifications, p.WaitTime, p.ClientVersions)
+
+	return p
+}
+
+// Accepted counts an accepted connection.
+func (p *Prometheus) Accepted() {
+	if p != nil {
+		p.Connections.Inc()
+	}
+}
+
+// Outcome counts a PoW verification outcome (OutcomePassed, OutcomeFailed or OutcomeTimedOut).
+func (p *Prometheus) Outcome(outcome string) {
+	if p != nil {
+		p.Verifications.WithLabelValues(outcome).Inc()
+	}
+}
+
+// Wait observes the time a client has taken to send a PoW result.
+func (p *Prometheus) Wait(d time.Duration) {
+	if p != nil {
+		p.WaitTime.Observe(d.Seconds())
+	}
+}
+
+// ClientVersion counts a request of a client declaring its software version.
+//
+// An empty version is counted as UnknownClientVersion. Once MaxClientVersions distinct versions have been counted,
+// new ones are counted as OtherClientVersion, as well as the ones longer than MaxClientVersionLength.
+func (p *Prometheus) ClientVersion(version string) {
+	if p != nil {
+		p.ClientVersions.WithLabelValues(p.versionLabel(version)).Inc()
+	}
+}
+
+// versionLabel returns the label a client version is counted by.
+func (p *Prometheus) versionLabel(version string) string {
+	switch {
+	case version == "" || version == UnknownClientVersion:
+		return UnknownClientVersion
+	case len(version) > MaxClientVersionLength:
+		return OtherClientVersion
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.versions[version] {
+		if len(p.versions) >= MaxClientVersions {
+			return OtherClientVersion
+		}
+		p.versions[version] = true
+	}
+
+	return version
+}
+
+// Handler returns an HTTP handler serving the collected metrics in the Prometheus exposition format.
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheus(t *testing.T) {
+	p := NewPrometheus()
+
+	p.Accepted()
+	p.Accepted()
+	p.Outcome(OutcomePassed)
+	p.Outcome(OutcomeFailed)
+	p.Outcome(OutcomeFailed)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(p.Connections))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.Verifications.WithLabelValues(OutcomePassed)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(p.Verifications.WithLabelValues(OutcomeFailed)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(p.Verifications.WithLabelValues(OutcomeTimedOut)))
+}
+
+func TestPrometheus_Handler(t *testing.T) {
+	p := NewPrometheus()
+	p.Accepted()
+	p.Wait(50 * time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := io.ReadAll(rec.Body)
+	assert.Nil(t, err)
+	assert.Contains(t, string(body), "pow_connections_accepted_total 1")
+	// outcomes are exported before they occur
+	assert.Contains(t, string(body), `pow_verifications_total{outcome="timed_out"} 0`)
+	assert.Contains(t, string(body), "pow_verification_wait_seconds_count 1")
+	assert.Contains(t, string(body), `pow_verification_wait_seconds_bucket{le="0.16"} 1`)
+}
+
+func TestPrometheus_nil(t *testing.T) {
+	var p *Prometheus
+
+	assert.NotPanics(t, func() {
+		p.Accepted()
+		p.Outcome(OutcomePassed)
+		p.Wait(time.Second)
+		p.ClientVersion("pow-client/1.0.0")
+	})
+}
+
+func TestPrometheus_ClientVersion(t *testing.T) {
+	p := NewPrometheus()
+
+	p.ClientVersion("pow-client/1.0.0")
+	p.ClientVersion("pow-client/1.0.0")
+	p.ClientVersion("")
+	p.ClientVersion(string(make([]byte, MaxClientVersionLength+1)))
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(p.ClientVersions.WithLabelValues("pow-client/1.0.0")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.ClientVersions.WithLabelValues(UnknownClientVersion)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.ClientVersions.WithLabelValues(OtherClientVersion)))
+
+	// versions over the limit are counted together, so the label cardinality is bounded
+	for i := 0; i < 2*MaxClientVersions; i++ {
+		p.ClientVersion(fmt.Sprintf("random/%d", i))
+	}
+	assert.Equal(t, MaxClientVersions+2, testutil.CollectAndCount(p.ClientVersions))
+	assert.Equal(t, float64(1+MaxClientVersions+1), testutil.ToFloat64(p.ClientVersions.WithLabelValues(OtherClientVersion)))
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// State is a set of named counters persisted to a state file, so their totals survive restarts.
+//
+// Counters are restored with Load on startup and stored with Save on shutdown.
+// The state file is a JSON object of totals by counter name, e.g. {"served":42,"failures":3}.
+type State struct {
+	path string
+
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+// NewState returns a new instance of State stored at the path.
+func NewState(path string) *State {
+	return &State{path: path, counters: make(map[string]*Counter)}
+}
+
+// Counter returns a persisted counter of the name, it's created on the first call.
+func (s *State) Counter(name string) *Counter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counters[name]
+	if !ok {
+		c = NewCounter()
+		s.counters[name] = c
+	}
+
+	return c
+}
+
+// Load adds totals stored in the state file to the counters.
+//
+// A missing state file is not an error: there's nothing to restore on the first start.
+// Totals of unknown counters are kept, so they're stored back on Save.
+func (s *State) Load() error {
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read state file: %w", err)
+	}
+
+	var totals map[string]uint64
+	if err := json.Unmarshal(b, &totals); err != nil {
+		return fmt.Errorf("unmarshal state: %w", err)
+	}
+
+	for name, total := range totals {
+		s.Counter(name).Add(total)
+	}
+
+	return nil
+}
+
+// Save stores the counters totals to the state file.
+//
+// The state is written to a temporary file first and then renamed,
+// so a crash in the middle of writing doesn't corrupt the previous state.
+func (s *State) Save() error {
+	s.mu.Lock()
+	totals := make(map[string]uint64, len(s.counters))
+	for name, c := range s.counters {
+		totals[name] = c.Value()
+	}
+	s.mu.Unlock()
+
+	b, err := json.Marshal(totals)
+	if err != nil {
+		return fmt.Errorf("marshal state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temporary state file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close state file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("rename state file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestState_restart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	// nothing to restore on the first start
+	state := NewState(path)
+	assert.Nil(t, state.Load())
+
+	served, failures := state.Counter("served"), state.Counter("failures")
+	assert.Zero(t, served.Value())
+
+	for i := 0; i < 5; i++ {
+		served.Inc()
+	}
+	failures.Inc()
+	assert.Nil(t, state.Save())
+
+	// a restarted server keeps counting from the saved totals
+	restarted := NewState(path)
+	served, failures = restarted.Counter("served"), restarted.Counter("failures")
+	assert.Nil(t, restarted.Load())
+
+	assert.EqualValues(t, 5, served.Value())
+	assert.EqualValues(t, 1, failures.Value())
+
+	served.Inc()
+	assert.Nil(t, restarted.Save())
+
+	b, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"served":6,"failures":1}`, string(b))
+}
+
+func TestState_Load_malformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	assert.Nil(t, os.WriteFile(path, []byte("not a state"), 0o600))
+
+	state := NewState(path)
+	assert.NotNil(t, state.Load())
+	assert.Zero(t, state.Counter("served").Value())
+}
@@ -0,0 +1,89 @@
+package pow
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Algorithm is a hash function a PoW result is calculated with.
+type Algorithm int
+
+const (
+	// SHA256 is the default Algorithm:
+	// it's cryptographically strong and doesn't allow collisions to be practically generated.
+	SHA256 Algorithm = iota
+	// SHA1 is an Algorithm of the canonical Hashcash stamp format, meant for interoperability with Hashcash tooling.
+	// A header declares it within the "alg=sha1" extension.
+	SHA1
+)
+
+// extAlgorithm is a name of the header extension declaring a non-default algorithm.
+const extAlgorithm = "alg"
+
+// AlgorithmOf returns an Algorithm corresponding to an argument string: "sha1" for SHA1, SHA256 otherwise.
+func AlgorithmOf(algorithm string) Algorithm {
+	if strings.ToLower(algorithm) == "sha1" {
+		return SHA1
+	}
+
+	return SHA256
+}
+
+// String returns a name of the Algorithm.
+func (a Algorithm) String() string {
+	if a == SHA1 {
+		return "sha1"
+	}
+
+	return "sha256"
+}
+
+// New returns a new hasher of the Algorithm.
+//
+// A hasher is not safe for concurrent use, so every calculation or verification must get its own one.
+func (a Algorithm) New() hash.Hash {
+	if a == SHA1 {
+		return sha1.New()
+	}
+
+	return sha256.New()
+}
+
+// bits returns a size of a hash of the Algorithm in bits.
+func (a Algorithm) bits() int {
+	if a == SHA1 {
+		return sha1.Size * 8
+	}
+
+	return sha256.Size * 8
+}
+
+// parseAlgorithm returns an Algorithm declared within header extensions.
+func parseAlgorithm(extensions map[string]string) (Algorithm, error) {
+	name, ok := extensions[extAlgorithm]
+	if !ok {
+		return SHA256, nil
+	}
+	if name != SHA1.String() {
+		return 0, fmt.Errorf("unsupported algorithm [%s]", name)
+	}
+
+	return SHA1, nil
+}
+
+// setAlgorithm declares the algorithm within the header extensions.
+func (h *Header) setAlgorithm(algorithm Algorithm) {
+	h.algorithm = algorithm
+
+	if algorithm == SHA256 {
+		delete(h.extensions, extAlgorithm)
+		return
+	}
+	if h.extensions == nil {
+		h.extensions = make(map[string]string)
+	}
+	h.extensions[extAlgorithm] = algorithm.String()
+}
@@ -0,0 +1,67 @@
+package pow
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlgorithmOf(t *testing.T) {
+	assert.Equal(t, SHA1, AlgorithmOf("sha1"))
+	assert.Equal(t, SHA1, AlgorithmOf("SHA1"))
+	assert.Equal(t, SHA256, AlgorithmOf("sha256"))
+	assert.Equal(t, SHA256, AlgorithmOf(""))
+}
+
+func TestCalculate_sha1(t *testing.T) {
+	challenge := "1:16:2208082121:resource:alg=sha1:cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	calculated, err := Calculate(challenge)
+	assert.Nil(t, err)
+
+	// the result verifies in SHA-1 mode
+	ok, err := Verify(calculated, challenge)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	header, err := ParseHeaderString(calculated)
+	assert.Nil(t, err)
+	assert.True(t, checkBits(getHash(calculated, SHA1.New()), header.bits))
+
+	// while it doesn't satisfy the bits in SHA-256 mode
+	assert.False(t, checkBits(getHash(calculated, SHA256.New()), header.bits))
+
+	// and it doesn't match a SHA-256 challenge
+	sha256Challenge := strings.Replace(challenge, "alg=sha1", "", 1)
+	ok, err = Verify(strings.Replace(calculated, "alg=sha1", "", 1), sha256Challenge)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = Verify(calculated, sha256Challenge)
+	assert.NotNil(t, err)
+	assert.False(t, ok)
+}
+
+func TestChallengeWithAlgorithm(t *testing.T) {
+	challenge, err := ChallengeWithAlgorithm(12, "resource", SHA1)
+	assert.Nil(t, err)
+
+	header, err := ParseHeaderString(challenge)
+	assert.Nil(t, err)
+	assert.Equal(t, SHA1, header.algorithm)
+	assert.Contains(t, challenge, ":alg=sha1:")
+
+	challenge, err = ChallengeWithAlgorithm(12, "resource", SHA256)
+	assert.Nil(t, err)
+	assert.NotContains(t, challenge, "alg=")
+}
+
+func TestParseHeaderString_algorithm_error(t *testing.T) {
+	_, err := ParseHeaderString("1:12:2208082121:resource:alg=md5:cRvZdlXCCIrWoQ==:MTAwMA==")
+	assert.EqualError(t, err, "unsupported algorithm [md5]")
+
+	// a SHA-1 hash holds 160 bits only
+	_, err = ParseHeaderString("1:161:2208082121:resource:alg=sha1:cRvZdlXCCIrWoQ==:MTAwMA==")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,187 @@
+package pow
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Encoding is an encoding of the header random and counter fields.
+type Encoding int
+
+const (
+	// EncodingBase64 is the default Encoding:
+	// random bytes and a decimal string of the counter are base-64 encoded.
+	//
+	// The random field is accepted in the URL-safe alphabet as well, since it's kept as is,
+	// while a padded counter is the same in both alphabets.
+	EncodingBase64 Encoding = iota
+	// EncodingHex is an Encoding of random bytes and the counter as hexadecimal strings.
+	// A header declares it within the "enc=hex" extension.
+	EncodingHex
+	// EncodingBase64URL is an Encoding of random bytes and a decimal string of the counter
+	// with the URL-safe base-64 alphabet without padding (see base64.RawURLEncoding).
+	// A header declares it within the "enc=base64url" extension.
+	EncodingBase64URL
+	// EncodingRaw is an Encoding of the counter as a plain decimal string, while random bytes are base-64 encoded.
+	// A header declares it within the "enc=raw" extension.
+	EncodingRaw
+)
+
+// encodingNames holds the names of non-default encodings declared within the header extension.
+var encodingNames = map[Encoding]string{
+	EncodingHex:       "hex",
+	EncodingBase64URL: "base64url",
+	EncodingRaw:       "raw",
+}
+
+// extEncoding is a name of the header extension declaring a non-default encoding.
+const extEncoding = "enc"
+
+// EncodingOf returns an Encoding corresponding to an argument string:
+// "hex" for EncodingHex, "base64url" for EncodingBase64URL, "raw" for EncodingRaw, EncodingBase64 otherwise.
+func EncodingOf(encoding string) Encoding {
+	encoding = strings.ToLower(encoding)
+	for e, name := range encodingNames {
+		if name == encoding {
+			return e
+		}
+	}
+
+	return EncodingBase64
+}
+
+// String returns a name of the Encoding.
+func (e Encoding) String() string {
+	if name, ok := encodingNames[e]; ok {
+		return name
+	}
+
+	return "base64"
+}
+
+// parseEncoding returns an Encoding declared within header extensions.
+func parseEncoding(extensions map[string]string) (Encoding, error) {
+	name, ok := extensions[extEncoding]
+	if !ok {
+		return EncodingBase64, nil
+	}
+	for e, n := range encodingNames {
+		if n == name {
+			return e, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unsupported encoding [%s]", name)
+}
+
+func (e Encoding) encodeRandom(b []byte) string {
+	switch e {
+	case EncodingHex:
+		return hex.EncodeToString(b)
+	case EncodingBase64URL:
+		return base64.RawURLEncoding.EncodeToString(b)
+	default:
+		return base64.StdEncoding.EncodeToString(b)
+	}
+}
+
+func (e Encoding) decodeRandom(random string) ([]byte, error) {
+	switch e {
+	case EncodingHex:
+		return hex.DecodeString(random)
+	case EncodingBase64URL:
+		return base64.RawURLEncoding.DecodeString(random)
+	case EncodingBase64:
+		b, err := base64.StdEncoding.DecodeString(random)
+		if err == nil {
+			return b, nil
+		}
+		// the random field is kept as is, so the URL-safe alphabet is accepted for interop
+		if b, urlErr := base64.URLEncoding.DecodeString(random); urlErr == nil {
+			return b, nil
+		}
+		return nil, err
+	default:
+		return base64.StdEncoding.DecodeString(random)
+	}
+}
+
+func (e Encoding) encodeCounter(counter int64) string {
+	switch e {
+	case EncodingHex:
+		return strconv.FormatInt(counter, 16)
+	case EncodingBase64URL:
+		return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(counter, 10)))
+	case EncodingRaw:
+		return strconv.FormatInt(counter, 10)
+	default:
+		return base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(counter, 10)))
+	}
+}
+
+func (e Encoding) decodeCounter(counter string) (int64, error) {
+	base := 10
+	switch e {
+	case EncodingHex:
+		base = 16
+	case EncodingBase64, EncodingBase64URL:
+		// a base-64 encoded decimal string never holds characters the alphabets differ in,
+		// so a padded counter is decoded the same regardless of the alphabet
+		decoding := base64.StdEncoding
+		if e == EncodingBase64URL {
+			decoding = base64.RawURLEncoding
+		}
+
+		b, err := decoding.DecodeString(counter)
+		if err != nil {
+			return 0, fmt.Errorf("decode counter: %w", err)
+		}
+		counter = string(b)
+	}
+
+	n, err := strconv.ParseInt(counter, base, 64)
+	if err != nil {
+		return 0, fmt.Errorf("convert counter to int: %w", err)
+	}
+
+	return n, nil
+}
+
+// setEncoding re-encodes the header random field and declares the encoding within the header extensions.
+func (h *Header) setEncoding(encoding Encoding) error {
+	b, err := h.encoding.decodeRandom(h.random)
+	if err != nil {
+		return fmt.Errorf("decode random: %w", err)
+	}
+
+	h.random = encoding.encodeRandom(b)
+	h.encoding = encoding
+
+	if encoding == EncodingBase64 {
+		delete(h.extensions, extEncoding)
+		return nil
+	}
+	if h.extensions == nil {
+		h.extensions = make(map[string]string)
+	}
+	h.extensions[extEncoding] = encoding.String()
+
+	return nil
+}
+
+// EncodeChallenge returns a challenge header string with the random and counter fields re-encoded with the encoding.
+func EncodeChallenge(challenge string, encoding Encoding) (string, error) {
+	header, err := ParseHeaderString(challenge)
+	if err != nil {
+		return "", fmt.Errorf("parse header string: %w", err)
+	}
+
+	if err := header.setEncoding(encoding); err != nil {
+		return "", err
+	}
+
+	return header.String(), nil
+}
@@ -0,0 +1,158 @@
+package pow
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChallengeWithEncoding_hex(t *testing.T) {
+	challenge, err := ChallengeWithEncoding(12, "resource", EncodingHex)
+	assert.Nil(t, err)
+	assert.Contains(t, challenge, ":enc=hex:")
+
+	// the header is round-tripped as is
+	header, err := ParseHeaderString(challenge)
+	assert.Nil(t, err)
+	assert.Equal(t, EncodingHex, header.encoding)
+	assert.Len(t, header.random, 20)
+	assert.Equal(t, challenge, header.String())
+
+	// a hex result is calculated and verified like a base-64 one
+	calculated, err := Calculate(challenge)
+	assert.Nil(t, err)
+
+	ok, err := Verify(calculated, challenge)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestEncodeChallenge(t *testing.T) {
+	challenge := "1:12:2208082121:resource::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	hexChallenge, err := EncodeChallenge(challenge, EncodingHex)
+	assert.Nil(t, err)
+	assert.Equal(t, "1:12:2208082121:resource:enc=hex:711bd97655c2088ad6a1:378d7517063be12a", hexChallenge)
+
+	back, err := EncodeChallenge(hexChallenge, EncodingBase64)
+	assert.Nil(t, err)
+	assert.Equal(t, challenge, back)
+}
+
+func TestVerify_cross_encoding(t *testing.T) {
+	challenge, err := ChallengeWithEncoding(12, "resource", EncodingHex)
+	assert.Nil(t, err)
+
+	calculated, err := Calculate(challenge)
+	assert.Nil(t, err)
+
+	// the same result encoded in base-64 doesn't match the hex challenge
+	base64Calculated, err := EncodeChallenge(calculated, EncodingBase64)
+	assert.Nil(t, err)
+
+	ok, err := Verify(base64Calculated, challenge)
+	assert.NotNil(t, err)
+	assert.False(t, ok)
+
+	// fields encoded other than declared are rejected
+	mismatches := []string{
+		// base-64 random and counter declared as hex
+		"1:12:2208082121:resource:enc=hex:cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA==",
+		// hex counter not declared
+		"1:12:2208082121:resource::cRvZdlXCCIrWoQ==:378d7517063be12a",
+	}
+	for _, header := range mismatches {
+		_, err := ParseHeaderString(header)
+		assert.NotNil(t, err, header)
+	}
+}
+
+func TestChallengeWithEncoding_round_trip(t *testing.T) {
+	for _, encoding := range []Encoding{EncodingBase64URL, EncodingRaw} {
+		t.Run(encoding.String(), func(t *testing.T) {
+			challenge, err := ChallengeWithEncoding(12, "resource", encoding)
+			assert.Nil(t, err)
+			assert.Contains(t, challenge, ":enc="+encoding.String()+":")
+
+			header, err := ParseHeaderString(challenge)
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, encoding, header.encoding)
+			assert.Equal(t, challenge, header.String())
+
+			calculated, err := Calculate(challenge)
+			assert.Nil(t, err)
+
+			ok, err := Verify(calculated, challenge)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestEncoding_counter_round_trip(t *testing.T) {
+	encodings := []Encoding{EncodingBase64, EncodingHex, EncodingBase64URL, EncodingRaw}
+	counters := []int64{0, 7, 42, 4002984385551524138, 1<<63 - 1}
+
+	for _, encoding := range encodings {
+		for _, counter := range counters {
+			encoded := encoding.encodeCounter(counter)
+
+			decoded, err := encoding.decodeCounter(encoded)
+			assert.Nil(t, err, "%s %d", encoding, counter)
+			assert.Equal(t, counter, decoded, "%s %d", encoding, counter)
+		}
+	}
+
+	// an unpadded URL-safe counter is declared, a padded one is the same as a standard one
+	assert.Equal(t, "NDI", EncodingBase64URL.encodeCounter(42))
+	assert.Equal(t, "42", EncodingRaw.encodeCounter(42))
+	decoded, err := EncodingBase64.decodeCounter(base64.URLEncoding.EncodeToString([]byte("42")))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 42, decoded)
+}
+
+func TestParseHeaderString_url_safe_random(t *testing.T) {
+	// random bytes encoded with the characters the standard and URL-safe alphabets differ in
+	random := base64.URLEncoding.EncodeToString([]byte{0xfb, 0xff, 0xbf, 0xfe, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06})
+	assert.True(t, strings.ContainsAny(random, "-_"))
+
+	challenge := "1:12:2208082121:resource::" + random + ":NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	// the random field is kept as is, so the header is round-tripped
+	header, err := ParseHeaderString(challenge)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, EncodingBase64, header.encoding)
+	assert.Equal(t, challenge, header.String())
+
+	calculated, err := Calculate(challenge)
+	assert.Nil(t, err)
+
+	ok, err := Verify(calculated, challenge)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// it's re-encoded in the standard alphabet, if asked
+	hexChallenge, err := EncodeChallenge(challenge, EncodingHex)
+	assert.Nil(t, err)
+	back, err := EncodeChallenge(hexChallenge, EncodingBase64)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.NewReplacer("-", "+", "_", "/").Replace(challenge), back)
+
+	// an unpadded counter is rejected unless base64url is declared
+	_, err = ParseHeaderString("1:12:2208082121:resource::" + random + ":NDI")
+	assert.NotNil(t, err)
+}
+
+func TestEncodingOf(t *testing.T) {
+	assert.Equal(t, EncodingHex, EncodingOf("HEX"))
+	assert.Equal(t, EncodingBase64URL, EncodingOf("base64url"))
+	assert.Equal(t, EncodingRaw, EncodingOf("raw"))
+	assert.Equal(t, EncodingBase64, EncodingOf("base64"))
+	assert.Equal(t, EncodingBase64, EncodingOf(""))
+}
@@ -0,0 +1,45 @@
+package pow
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrMalformedHeader is returned when a header string cannot be parsed (see ParseHeaderString).
+	ErrMalformedHeader = errors.New("malformed header")
+	// ErrUnsupportedVersion is returned when a header string declares a version other than Version.
+	ErrUnsupportedVersion = errors.New("unsupported version")
+	// ErrHeaderMismatch is returned when a PoW result header doesn't correspond to the challenge header
+	// (e.g. it differs not in the counter field only).
+	ErrHeaderMismatch = errors.New("calculated header doesn't match the challenge")
+	// ErrBitsMismatch is returned when a PoW result header declares bits other than the challenge ones.
+	// It matches ErrHeaderMismatch as well.
+	ErrBitsMismatch = fmt.Errorf("%w: bits differ", ErrHeaderMismatch)
+	// ErrBitsOutOfRange is returned when a header string declares bits out of interval [1, hash size]
+	// (zero bits are allowed along with a target only). It's a cause of ErrMalformedHeader (see ParseHeaderString).
+	ErrBitsOutOfRange = errors.New("bits out of range")
+	// ErrInsufficientBits is returned when a PoW result hash doesn't satisfy the challenge difficulty.
+	ErrInsufficientBits = errors.New("insufficient leading zero bits")
+)
+
+// HeaderError is an error of a header string which cannot be parsed.
+//
+// It matches its kind (ErrMalformedHeader or ErrUnsupportedVersion) with errors.Is,
+// while the underlying cause is available with errors.Unwrap.
+type HeaderError struct {
+	Kind error
+	Err  error
+}
+
+func (e *HeaderError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HeaderError) Unwrap() error {
+	return e.Err
+}
+
+func (e *HeaderError) Is(target error) bool {
+	return target == e.Kind
+}
@@ -0,0 +1,35 @@
+package pow
+
+import (
+	"math"
+	"time"
+)
+
+// EstimateSolveTime returns an expected time to calculate a PoW result for a challenge of the bits
+// by a client calculating hashesPerSecond hashes.
+//
+// A hash satisfies the bits with a probability of 2^-bits, so 2^bits hashes are expected to be calculated.
+// It helps to tune Complexity and WaitPOW: e.g. a client calculating a million hashes per second
+// needs about a second for 20 bits, and about 17 minutes for 30 bits.
+// If hashesPerSecond is not positive, the estimate is unbounded, and math.MaxInt64 duration is returned.
+func EstimateSolveTime(bits uint, hashesPerSecond float64) time.Duration {
+	if hashesPerSecond <= 0 {
+		return math.MaxInt64
+	}
+
+	seconds := math.Exp2(float64(bits)) / hashesPerSecond
+	if seconds >= float64(math.MaxInt64)/float64(time.Second) {
+		return math.MaxInt64
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// ExpectedAttempts returns the number of counter values a client is expected to try
+// to find a PoW result for a challenge of the bits, that is 2^(bits-1).
+//
+// It's half of the hashes EstimateSolveTime assumes, e.g. 20 bits take about half a million attempts,
+// so it must not be divided by a hash rate to get a solve time: use EstimateSolveTime for that.
+func ExpectedAttempts(bits uint) float64 {
+	return math.Exp2(float64(bits) - 1)
+}
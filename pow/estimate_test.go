@@ -0,0 +1,37 @@
+package pow
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateSolveTime(t *testing.T) {
+	assert.Equal(t, time.Second, EstimateSolveTime(20, 1<<20))
+	assert.Equal(t, time.Millisecond, EstimateSolveTime(10, 1024*1000))
+
+	// every extra bit doubles the expected time
+	for bits := uint(8); bits < 30; bits++ {
+		assert.Equal(t, 2*EstimateSolveTime(bits, 1e6), EstimateSolveTime(bits+1, 1e6), "bits %d", bits)
+	}
+	assert.Equal(t, 16*EstimateSolveTime(12, 1e6), EstimateSolveTime(16, 1e6))
+
+	// an unbounded estimate
+	assert.EqualValues(t, math.MaxInt64, EstimateSolveTime(20, 0))
+	assert.EqualValues(t, math.MaxInt64, EstimateSolveTime(255, 1e6))
+}
+
+func TestExpectedAttempts(t *testing.T) {
+	assert.Equal(t, 0.5, ExpectedAttempts(0))
+	assert.Equal(t, 1.0, ExpectedAttempts(1))
+	assert.Equal(t, 2.0, ExpectedAttempts(2))
+	assert.Equal(t, 512.0, ExpectedAttempts(10))
+	assert.Equal(t, 524288.0, ExpectedAttempts(20))
+
+	// the work grows with every extra bit
+	for bits := uint(0); bits < 64; bits++ {
+		assert.Greater(t, ExpectedAttempts(bits+1), ExpectedAttempts(bits))
+	}
+}
@@ -1,12 +1,15 @@
 package pow
 
 import (
+	crand "crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"hash"
-	"math/rand"
+	"math/big"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -17,7 +20,7 @@ import (
 const (
 	Version      = 1
 	FormatDate   = "%y%m%d%H%M"
-	FormatHeader = "%d:%d:%s:%s::%s:%s"
+	FormatHeader = "%d:%d:%s:%s:%s:%s:%s"
 )
 
 // Header holds attributes of a Hashcash PoW challenge header.
@@ -26,8 +29,13 @@ type Header struct {
 	bits     uint
 	date     string // see FormatDate
 	resource string
-	random   string // base-64 encoded sequence of 10 random bytes
+	random   string // encoded sequence of 10 random bytes read from crypto/rand
 	counter  int64
+
+	extensions map[string]string
+	target     *big.Int  // optional, see NewHeaderWithTarget
+	encoding   Encoding  // of random and counter, see Encoding
+	algorithm  Algorithm // a PoW result is calculated with, see Algorithm
 }
 
 // NewHeader returns a new instance of Header.
@@ -45,20 +53,113 @@ func NewHeader(bits uint, resource string) (*Header, error) {
 		date:     date,
 		resource: resource,
 		random:   random,
-		counter:  rand.Int63(),
+		counter:  randInt63(),
 	}, nil
 }
 
+// NewHeaderWithTarget returns a new instance of Header
+// which PoW result hash interpreted as a big-endian integer must not exceed the target.
+//
+// In comparison to whole bits a target allows to tune difficulty in fine-grained steps.
+// The target is passed within the header extension; header bits are set to the number of leading zero bits
+// guaranteed by the target.
+func NewHeaderWithTarget(target *big.Int, resource string) (*Header, error) {
+	if err := checkTargetRange(target); err != nil {
+		return nil, err
+	}
+
+	header, err := NewHeader(uint(hashBits-target.BitLen()), resource)
+	if err != nil {
+		return nil, err
+	}
+
+	header.target = new(big.Int).Set(target)
+	header.extensions = map[string]string{extTarget: target.Text(16)}
+
+	return header, nil
+}
+
 // String returns a string representation of Header.
 //
-// String format must be "%d:%d:%s:%s::%s:%s" (see FormatHeader).
+// String format must be "%d:%d:%s:%s:%s:%s:%s" (see FormatHeader).
 func (h *Header) String() string {
-	counter := base64.StdEncoding.EncodeToString([]byte(strconv.FormatInt(h.counter, 10)))
-	return fmt.Sprintf(FormatHeader, Version, h.bits, h.date, h.resource, h.random, counter)
+	counter := h.encoding.encodeCounter(h.counter)
+	return fmt.Sprintf(FormatHeader, Version, h.bits, h.date, h.resource, formatExtensions(h.extensions), h.random, counter)
+}
+
+// Bits returns the number of leading zero bits a PoW result hash must have.
+func (h *Header) Bits() uint {
+	return h.bits
+}
+
+// Date returns the time the header has been issued at (to a minute, see FormatDate).
+//
+// The zero time is returned if the header date is malformed, which never happens for a parsed header.
+func (h *Header) Date() time.Time {
+	date, err := timefmt.Parse(h.date, FormatDate)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return date
+}
+
+// Resource returns the header resource.
+func (h *Header) Resource() string {
+	return h.resource
+}
+
+// Counter returns the header counter value.
+func (h *Header) Counter() int64 {
+	return h.counter
+}
+
+// Random returns the encoded header random field as it's passed within the header string.
+func (h *Header) Random() string {
+	return h.random
+}
+
+// satisfiedBy checks if a hash satisfies the header difficulty.
+func (h *Header) satisfiedBy(hash []byte) bool {
+	if h.target != nil {
+		return checkTarget(hash, h.target)
+	}
+
+	return checkBits(hash, h.bits)
+}
+
+// verifiedBy checks if a hash satisfies the header difficulty like satisfiedBy,
+// but the leading zero bits are checked in constant time (see checkBitsConstantTime).
+//
+// It's meant to verify results of untrusted parties, while satisfiedBy is meant to calculate them.
+func (h *Header) verifiedBy(hash []byte) bool {
+	if h.target != nil {
+		return checkTarget(hash, h.target)
+	}
+
+	return checkBitsConstantTime(hash, h.bits)
 }
 
 // ParseHeaderString checks an argument header string and returns an instance of Header based on it.
+//
+// If the header string cannot be parsed, it returns a HeaderError
+// matching either ErrMalformedHeader or ErrUnsupportedVersion.
+// Bits out of interval [1, hash size] (or [0, hash size] along with a target) result in ErrMalformedHeader
+// matching ErrBitsOutOfRange as well.
 func ParseHeaderString(header string) (*Header, error) {
+	h, err := parseHeaderString(header)
+	if err != nil {
+		var headerErr *HeaderError
+		if errors.As(err, &headerErr) {
+			return nil, err
+		}
+		return nil, &HeaderError{Kind: ErrMalformedHeader, Err: err}
+	}
+
+	return h, nil
+}
+
+func parseHeaderString(header string) (*Header, error) {
 	split := strings.Split(header, ":")
 	if len(split) != 7 {
 		return nil, fmt.Errorf("malformed header string [%s]", header)
@@ -69,7 +170,7 @@ func ParseHeaderString(header string) (*Header, error) {
 		return nil, fmt.Errorf("convert version to int: %w", err)
 	}
 	if version != Version {
-		return nil, fmt.Errorf("unsupported version %d", version)
+		return nil, &HeaderError{Kind: ErrUnsupportedVersion, Err: fmt.Errorf("unsupported version %d", version)}
 	}
 
 	bits, err := strconv.Atoi(split[1])
@@ -84,17 +185,52 @@ func ParseHeaderString(header string) (*Header, error) {
 
 	resource := split[3]
 
-	// split[4] stands for omitted extensions
+	extensions, err := parseExtensions(split[4])
+	if err != nil {
+		return nil, fmt.Errorf("parse extensions: %w", err)
+	}
 
-	random := split[5]
+	var target *big.Int
+	if t, ok := extensions[extTarget]; ok {
+		target, ok = new(big.Int).SetString(t, 16)
+		if !ok {
+			return nil, fmt.Errorf("malformed target [%s]", t)
+		}
+		if err := checkTargetRange(target); err != nil {
+			return nil, err
+		}
+	}
 
-	b, err := base64.StdEncoding.DecodeString(split[6])
+	encoding, err := parseEncoding(extensions)
 	if err != nil {
-		return nil, fmt.Errorf("decode counter: %w", err)
+		return nil, err
 	}
-	counter, err := strconv.ParseInt(string(b), 10, 64)
+
+	algorithm, err := parseAlgorithm(extensions)
 	if err != nil {
-		return nil, fmt.Errorf("convert counter to int: %w", err)
+		return nil, err
+	}
+	// a zero bits header is trivial unless its target sets the difficulty (see NewHeaderWithTarget)
+	minBits := 1
+	if target != nil {
+		minBits = 0
+	}
+	if bits < minBits || bits > algorithm.bits() {
+		return nil, fmt.Errorf("%w: bits %d are out of [%d, %d] for %s hash",
+			ErrBitsOutOfRange, bits, minBits, algorithm.bits(), algorithm)
+	}
+	if target != nil && target.BitLen() > algorithm.bits() {
+		return nil, fmt.Errorf("target exceeds %s hash size", algorithm)
+	}
+
+	random := split[5]
+	if _, err := encoding.decodeRandom(random); err != nil {
+		return nil, fmt.Errorf("decode random: %w", err)
+	}
+
+	counter, err := encoding.decodeCounter(split[6])
+	if err != nil {
+		return nil, err
 	}
 
 	return &Header{
@@ -104,6 +240,11 @@ func ParseHeaderString(header string) (*Header, error) {
 		resource: resource,
 		random:   random,
 		counter:  counter,
+
+		extensions: extensions,
+		target:     target,
+		encoding:   encoding,
+		algorithm:  algorithm,
 	}, nil
 }
 
@@ -120,31 +261,88 @@ func Challenge(bits uint, resource string) (string, error) {
 	return header.String(), nil
 }
 
+// ChallengeWithEncoding generates a Hashcash PoW challenge header string
+// with the random and counter fields encoded with the encoding.
+func ChallengeWithEncoding(bits uint, resource string, encoding Encoding) (string, error) {
+	header, err := NewHeader(bits, resource)
+	if err != nil {
+		return "", fmt.Errorf("create new header: %w", err)
+	}
+
+	if err := header.setEncoding(encoding); err != nil {
+		return "", err
+	}
+
+	return header.String(), nil
+}
+
+// ChallengeWithAlgorithm generates a Hashcash PoW challenge header string
+// which PoW result must be calculated with the algorithm.
+func ChallengeWithAlgorithm(bits uint, resource string, algorithm Algorithm) (string, error) {
+	header, err := NewHeader(bits, resource)
+	if err != nil {
+		return "", fmt.Errorf("create new header: %w", err)
+	}
+
+	header.setAlgorithm(algorithm)
+
+	return header.String(), nil
+}
+
+// ChallengeWithTarget generates a Hashcash PoW challenge header string with a target difficulty
+// (see NewHeaderWithTarget).
+func ChallengeWithTarget(target *big.Int, resource string) (string, error) {
+	header, err := NewHeaderWithTarget(target, resource)
+	if err != nil {
+		return "", fmt.Errorf("create new header: %w", err)
+	}
+
+	return header.String(), nil
+}
+
+// FixedChallenge returns a ChallengeFunc which always yields the argument header string
+// regardless of requested bits and resource.
+//
+// It's meant to be used as a deterministic challenge source in tests.
+func FixedChallenge(header string) ChallengeFunc {
+	return func(_ uint, _ string) (string, error) {
+		return header, nil
+	}
+}
+
 // CalculateFunc is a type of function to calculate a Hashcash PoW result header string.
 type CalculateFunc func(headerStr string) (string, error)
 
-// we use SHA-256 instead of SHA-1 to keep calculations secured and avoid collisions
-var hasher = sha256.New()
-
 // Calculate returns PoW result header string.
 //
 // The result must have the number of zero leading bits declared in challenge header 'bits' field.
 // E.g. if the challenge header is "1:20:2201010000:resource::cmFuZG9t:MTAwMA=="
 // than the result must have 20 leading 0 bits.
+// If the challenge header declares a target (see NewHeaderWithTarget), the result hash must not exceed it.
+// The result hash is calculated with the algorithm declared by the challenge header (see Algorithm).
 func Calculate(headerStr string) (string, error) {
+	result, _, err := CalculateWithStats(headerStr)
+	return result, err
+}
+
+// CalculateWithStats returns PoW result header string (see Calculate)
+// along with the number of iterations performed, i.e. the number of hashes calculated.
+//
+// The search starts at the counter following the challenge one, since the challenge itself is never a valid result
+// (see Verify), even if it happens to satisfy its bits.
+func CalculateWithStats(headerStr string) (result string, iterations uint64, err error) {
 	header, err := ParseHeaderString(headerStr)
 	if err != nil {
-		return "", fmt.Errorf("parse header string: %w", err)
+		return "", 0, fmt.Errorf("parse header string: %w", err)
 	}
 
-	bits := header.bits
+	hasher := header.algorithm.New()
 	for {
+		header.counter++
+		iterations++
 		calculatedHash := getHash(header.String(), hasher)
-		if !checkBits(calculatedHash, bits) {
-			header.counter++
-			continue
-		} else {
-			return header.String(), nil
+		if header.satisfiedBy(calculatedHash) {
+			return header.String(), iterations, nil
 		}
 	}
 }
@@ -156,51 +354,91 @@ type VerifyFunc func(calculated, challenge string) (bool, error)
 // it must have the number of zero leading bits declared in challenge header 'bits' field,
 // and it must correspond to the challenge header
 // (e.g. the difference with the challenge must be in counter field only).
+// The challenge header itself is never a valid result.
+// If the challenge header declares a target (see NewHeaderWithTarget), the result hash must not exceed it.
+// The result hash is calculated with the algorithm declared by the challenge header (see Algorithm).
+//
+// An insufficient result is not an error: Verify returns false for it. See VerifyReason to tell failures apart.
 func Verify(calculated, challenge string) (bool, error) {
+	err := checkResult(calculated, challenge)
+	if errors.Is(err, ErrInsufficientBits) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// checkResult checks if the result of PoW calculation is valid (see Verify).
+//
+// It returns nil for a valid result, otherwise an error matching ErrInsufficientBits
+// (including the challenge header sent back as is), ErrHeaderMismatch (ErrBitsMismatch for different bits),
+// ErrMalformedHeader (ErrBitsOutOfRange for bits out of the hash size) or ErrUnsupportedVersion.
+func checkResult(calculated, challenge string) error {
 	calculatedHeader, err := ParseHeaderString(calculated)
 	if err != nil {
-		return false, fmt.Errorf("parse calculated header string: %w", err)
+		return fmt.Errorf("parse calculated header string: %w", err)
 	}
 
 	challengeHeader, err := ParseHeaderString(challenge)
 	if err != nil {
-		return false, fmt.Errorf("parse challenge header string: %w", err)
+		return fmt.Errorf("parse challenge header string: %w", err)
 	}
 
 	// check if the calculated PoW result corresponds to the challenge
+	if calculatedHeader.bits != challengeHeader.bits {
+		return fmt.Errorf("%w: calculated header bits %d, challenge bits %d",
+			ErrBitsMismatch, calculatedHeader.bits, challengeHeader.bits)
+	}
 	if calculatedHeader.version != challengeHeader.version ||
-		calculatedHeader.bits != challengeHeader.bits ||
 		calculatedHeader.date != challengeHeader.date ||
 		calculatedHeader.resource != challengeHeader.resource ||
+		formatExtensions(calculatedHeader.extensions) != formatExtensions(challengeHeader.extensions) ||
 		calculatedHeader.random != challengeHeader.random {
-		return false, errors.New("calculated header doesn't match the challenge")
+		return ErrHeaderMismatch
 	}
 
-	// check the number of leading zero bits
-	calculatedHash := getHash(calculatedHeader.String(), hasher)
-	if !checkBits(calculatedHash, calculatedHeader.bits) {
-		return false, nil
+	// the challenge counter sent back means no work has been performed,
+	// though an unchanged low-bits challenge header might happen to satisfy its bits;
+	// the parsed counters are compared, so the same counter encoded differently (e.g. "+N" or "0N") is caught as well
+	if calculatedHeader.counter == challengeHeader.counter {
+		return fmt.Errorf("%w: no work has been performed", ErrInsufficientBits)
 	}
 
-	return true, nil
+	// check the number of leading zero bits (or the target)
+	calculatedHash := getHash(calculatedHeader.String(), calculatedHeader.algorithm.New())
+	if !calculatedHeader.verifiedBy(calculatedHash) {
+		return ErrInsufficientBits
+	}
+
+	return nil
 }
 
+// getRandom returns a base64-encoded sequence of 10 random bytes read from crypto/rand.
+//
+// The random field makes a challenge unique and unpredictable, so it mustn't be produced by math/rand:
+// a predictable random lets a client forge challenges and precompute their results.
 func getRandom() (string, error) {
 	b := make([]byte, 10)
-	_, err := rand.Read(b)
-	if err != nil {
-		return "", fmt.Errorf("read random bytes: %w", err)
+	if _, err := crand.Read(b); err != nil {
+		return "", err
 	}
 
 	return base64.StdEncoding.EncodeToString(b), nil
 }
 
+// getHash returns a hash of the header string calculated with the hasher (see Algorithm.New).
 func getHash(header string, hasher hash.Hash) []byte {
 	hasher.Reset()
 	hasher.Write([]byte(header))
 	return hasher.Sum(nil)
 }
 
+// checkBits checks if the hash has the bits number of leading zero bits.
+//
+// It returns on the first non-zero byte, so it's fast but its duration depends on the hash (see checkBitsConstantTime).
 func checkBits(hash []byte, bits uint) bool {
 	modulo := bits % 8
 	quotient := bits / 8
@@ -254,3 +492,81 @@ func checkBits(hash []byte, bits uint) bool {
 
 	return true
 }
+
+// checkBitsConstantTime checks if the hash has the bits number of leading zero bits like checkBits,
+// but it always scans the whole prefix, so its duration doesn't tell how close the hash is to the difficulty.
+func checkBitsConstantTime(hash []byte, bits uint) bool {
+	modulo := bits % 8
+	quotient := bits / 8
+
+	var acc byte
+	for _, b := range hash[:quotient] {
+		acc |= b
+	}
+	if modulo > 0 {
+		// the leading modulo bits of the next byte must be zero
+		acc |= hash[quotient] >> (8 - modulo)
+	}
+
+	return subtle.ConstantTimeByteEq(acc, 0) == 1
+}
+
+// hashBits is a size of a SHA-256 hash in bits (see Algorithm).
+const hashBits = sha256.Size * 8
+
+// TargetFromBits returns a target equivalent to the number of leading zero bits,
+// i.e. the greatest hash value having the bits number of leading zero bits.
+func TargetFromBits(bits uint) *big.Int {
+	target := new(big.Int).Lsh(big.NewInt(1), hashBits-bits)
+	return target.Sub(target, big.NewInt(1))
+}
+
+// checkTarget checks if the hash interpreted as a big-endian integer doesn't exceed the target.
+func checkTarget(hash []byte, target *big.Int) bool {
+	return new(big.Int).SetBytes(hash).Cmp(target) <= 0
+}
+
+func checkTargetRange(target *big.Int) error {
+	if target == nil || target.Sign() <= 0 || target.BitLen() > hashBits {
+		return fmt.Errorf("target is out of range (0, 2^%d)", hashBits)
+	}
+
+	return nil
+}
+
+// extTarget is a name of the header extension holding a hex-encoded target.
+const extTarget = "target"
+
+// parseExtensions parses a header extension field of format "name1=value1;name2=value2".
+func parseExtensions(ext string) (map[string]string, error) {
+	if ext == "" {
+		return nil, nil
+	}
+
+	extensions := make(map[string]string)
+	for _, e := range strings.Split(ext, ";") {
+		name, value, _ := strings.Cut(e, "=")
+		if name == "" {
+			return nil, fmt.Errorf("malformed extension [%s]", e)
+		}
+		extensions[name] = value
+	}
+
+	return extensions, nil
+}
+
+// formatExtensions returns a header extension field with extensions sorted by name.
+func formatExtensions(extensions map[string]string) string {
+	names := make([]string, 0, len(extensions))
+	for name := range extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	split := make([]string, 0, len(names))
+	for _, name := range names {
+		split = append(split, name+"="+extensions[name])
+	}
+
+	return strings.Join(split, ";")
+}
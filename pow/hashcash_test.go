@@ -1,8 +1,10 @@
 package pow
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
+	"math/big"
 	"strconv"
 	"strings"
 	"testing"
@@ -47,6 +49,27 @@ func TestChallenge(t *testing.T) {
 	assertions.Nil(err)
 }
 
+func TestFixedChallenge(t *testing.T) {
+	header := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	challenge := FixedChallenge(header)
+
+	tests := []struct {
+		bits     uint
+		resource string
+	}{
+		{bits: 0, resource: ""},
+		{bits: 12, resource: "resource"},
+		{bits: 30, resource: "another resource"},
+	}
+
+	for _, test := range tests {
+		got, err := challenge(test.bits, test.resource)
+		assert.Nil(t, err)
+		assert.Equal(t, header, got)
+	}
+}
+
 func TestCalculate_correct(t *testing.T) {
 	challenge := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
 	expectedResult := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
@@ -56,12 +79,35 @@ func TestCalculate_correct(t *testing.T) {
 	assert.Equal(t, result, expectedResult)
 }
 
+func TestCalculateWithStats(t *testing.T) {
+	challenge := "1:12:2208082121:resource::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	calculated, iterations, err := CalculateWithStats(challenge)
+	assert.Nil(t, err)
+	assert.NotZero(t, iterations)
+
+	// every iteration increments the counter
+	challengeHeader, err := ParseHeaderString(challenge)
+	assert.Nil(t, err)
+	calculatedHeader, err := ParseHeaderString(calculated)
+	assert.Nil(t, err)
+	assert.EqualValues(t, iterations, calculatedHeader.counter-challengeHeader.counter)
+
+	ok, err := Verify(calculated, challenge)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// Calculate yields the same result
+	result, err := Calculate(challenge)
+	assert.Nil(t, err)
+	assert.Equal(t, calculated, result)
+}
+
 func TestCalculate_error(t *testing.T) {
 	challenge := "corrupted"
 
 	result, err := Calculate(challenge)
-	assert.NotNil(t, err)
-	assert.Regexp(t, "parse header string:*", err.Error())
+	assert.ErrorIs(t, err, ErrMalformedHeader)
 	assert.Empty(t, result)
 }
 
@@ -87,24 +133,131 @@ func TestVerify(t *testing.T) {
 			want:       false,
 			err:        nil,
 		},
+		{
+			name:       "unchanged challenge satisfying its bits",
+			challenge:  "1:1:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA==",
+			calculated: "1:1:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA==",
+			want:       false,
+			err:        nil,
+		},
+		{
+			// "+4002984385551524138"
+			name:       "challenge counter re-encoded with a sign",
+			challenge:  "1:1:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA==",
+			calculated: "1:1:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:KzQwMDI5ODQzODU1NTE1MjQxMzg=",
+			want:       false,
+			err:        nil,
+		},
+		{
+			// "04002984385551524138"
+			name:       "challenge counter re-encoded with a leading zero",
+			challenge:  "1:1:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA==",
+			calculated: "1:1:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:MDQwMDI5ODQzODU1NTE1MjQxMzg=",
+			want:       false,
+			err:        nil,
+		},
+		{
+			name:       "calculated result claims lower bits",
+			challenge:  "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA==",
+			calculated: "1:1:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA==",
+			want:       false,
+			err:        ErrBitsMismatch,
+		},
+		{
+			name:       "calculated result claims zero bits",
+			challenge:  "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA==",
+			calculated: "1:0:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA==",
+			want:       false,
+			err:        ErrBitsOutOfRange,
+		},
+		{
+			name:       "challenge with bits over hash size",
+			challenge:  "1:300:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA==",
+			calculated: "1:300:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA==",
+			want:       false,
+			err:        ErrBitsOutOfRange,
+		},
 		{
 			name:       "calculated result doesn't match the challenge",
 			challenge:  "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA==",
 			calculated: "1:12:2208082127:f1a5a003-27ce-4e62-8c48-14c250965b92::kUumfNZAqta03Q==:MTA4MDAyODM5MTgzMzgyMTg0OQ==",
 			want:       false,
-			err:        errors.New("calculated header doesn't match the challenge"),
+			err:        ErrHeaderMismatch,
 		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			got, err := Verify(test.calculated, test.challenge)
-			assert.Equal(t, err, test.err)
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+			} else {
+				assert.Nil(t, err)
+			}
 			assert.Equal(t, got, test.want)
 		})
 	}
 }
 
+func TestCheckResult(t *testing.T) {
+	challenge := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	tests := []struct {
+		name       string
+		calculated string
+		err        error
+	}{
+		{
+			name:       "valid result",
+			calculated: "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA==",
+			err:        nil,
+		},
+		{
+			name:       "insufficient bits",
+			calculated: "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyNw==",
+			err:        ErrInsufficientBits,
+		},
+		{
+			name:       "unchanged challenge",
+			calculated: challenge,
+			err:        ErrInsufficientBits,
+		},
+		{
+			name:       "header mismatch",
+			calculated: "1:12:2208082127:f1a5a003-27ce-4e62-8c48-14c250965b92::kUumfNZAqta03Q==:MTA4MDAyODM5MTgzMzgyMTg0OQ==",
+			err:        ErrHeaderMismatch,
+		},
+		{
+			name:       "malformed header",
+			calculated: "corrupted",
+			err:        ErrMalformedHeader,
+		},
+		{
+			name:       "unsupported version",
+			calculated: "2:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA==",
+			err:        ErrUnsupportedVersion,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkResult(test.calculated, challenge)
+			if test.err == nil {
+				assert.Nil(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, test.err)
+
+			// every failure mode is told apart from the others
+			for _, other := range []error{ErrInsufficientBits, ErrHeaderMismatch, ErrMalformedHeader, ErrUnsupportedVersion} {
+				if other != test.err {
+					assert.False(t, errors.Is(err, other), "%v is %v", err, other)
+				}
+			}
+		})
+	}
+}
+
 func TestParseHeaderString_correct(t *testing.T) {
 	assertions := assert.New(t)
 
@@ -123,57 +276,168 @@ func TestParseHeaderString_correct(t *testing.T) {
 
 func TestParseHeaderString_error(t *testing.T) {
 	tests := []struct {
-		name      string
-		header    string
-		errRegexp string
+		name   string
+		header string
+		err    error
 	}{
 		{
-			name:      "malformed header",
-			header:    "corrupted",
-			errRegexp: "malformed header string*",
+			name:   "malformed header",
+			header: "corrupted",
+			err:    ErrMalformedHeader,
+		},
+		{
+			name:   "incorrect version",
+			header: "duck:2:2201010000:resource::cmFuZG9t:MTAwMA==",
+			err:    ErrMalformedHeader,
+		},
+		{
+			name:   "unsupported version",
+			header: "3:2:2201010000:resource::cmFuZG9t:MTAwMA==",
+			err:    ErrUnsupportedVersion,
 		},
 		{
-			name:      "incorrect version",
-			header:    "duck:2:2201010000:resource::cmFuZG9t:MTAwMA==",
-			errRegexp: "convert version to int*",
+			name:   "incorrect bits",
+			header: "1:duck:2201010000:resource::cmFuZG9t:MTAwMA==",
+			err:    ErrMalformedHeader,
 		},
 		{
-			name:      "unsupported version",
-			header:    "3:2:2201010000:resource::cmFuZG9t:MTAwMA==",
-			errRegexp: "unsupported version*",
+			name:   "incorrect date format",
+			header: "1:2:2022-01-01T00-00:resource::cmFuZG9t:MTAwMA==",
+			err:    ErrMalformedHeader,
 		},
 		{
-			name:      "incorrect bits",
-			header:    "1:duck:2201010000:resource::cmFuZG9t:MTAwMA==",
-			errRegexp: "convert bits to int*",
+			name:   "undecodable counter",
+			header: "1:2:2201010000:resource::cmFuZG9t:*#$*",
+			err:    ErrMalformedHeader,
 		},
 		{
-			name:      "incorrect date format",
-			header:    "1:2:2022-01-01T00-00:resource::cmFuZG9t:MTAwMA==",
-			errRegexp: "parse date*",
+			name:   "incorrect counter",
+			header: "1:2:2201010000:resource::cmFuZG9t:duck",
+			err:    ErrMalformedHeader,
 		},
 		{
-			name:      "undecodable counter",
-			header:    "1:2:2201010000:resource::cmFuZG9t:*#$*",
-			errRegexp: "decode counter*",
+			name:   "undecodable random",
+			header: "1:2:2201010000:resource::*#$*:MTAwMA==",
+			err:    ErrMalformedHeader,
 		},
 		{
-			name:      "incorrect counter",
-			header:    "1:2:2201010000:resource::cmFuZG9t:duck",
-			errRegexp: "convert counter to int*",
+			name:   "unsupported encoding",
+			header: "1:2:2201010000:resource:enc=base32:cmFuZG9t:MTAwMA==",
+			err:    ErrMalformedHeader,
+		},
+		{
+			name:   "zero bits",
+			header: "1:0:2201010000:resource::cmFuZG9t:MTAwMA==",
+			err:    ErrBitsOutOfRange,
+		},
+		{
+			name:   "bits over hash size",
+			header: "1:300:2201010000:resource::cmFuZG9t:MTAwMA==",
+			err:    ErrBitsOutOfRange,
+		},
+		{
+			name:   "bits over sha1 hash size",
+			header: "1:200:2201010000:resource:alg=sha1:cmFuZG9t:MTAwMA==",
+			err:    ErrBitsOutOfRange,
+		},
+		{
+			name:   "negative bits",
+			header: "1:-1:2201010000:resource::cmFuZG9t:MTAwMA==",
+			err:    ErrBitsOutOfRange,
 		},
 	}
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			header, err := ParseHeaderString(test.header)
-			assert.NotNil(t, err)
-			assert.Regexp(t, test.errRegexp, err.Error())
+			assert.ErrorIs(t, err, test.err)
 			assert.Nil(t, header)
+
+			var headerErr *HeaderError
+			assert.ErrorAs(t, err, &headerErr)
 		})
 	}
 }
 
+func TestParseHeaderString_bits_bounds(t *testing.T) {
+	// the highest bits of the hash size are valid
+	header, err := ParseHeaderString("1:256:2201010000:resource::cmFuZG9t:MTAwMA==")
+	assert.Nil(t, err)
+	if assert.NotNil(t, header) {
+		assert.EqualValues(t, 256, header.Bits())
+	}
+
+	// zero bits are valid along with a target setting the difficulty
+	header, err = ParseHeaderString("1:0:2201010000:resource:target=" + strings.Repeat("f", 64) + ":cmFuZG9t:MTAwMA==")
+	assert.Nil(t, err)
+	if assert.NotNil(t, header) {
+		assert.EqualValues(t, 0, header.Bits())
+	}
+
+	// bits out of range are malformed
+	_, err = ParseHeaderString("1:300:2201010000:resource::cmFuZG9t:MTAwMA==")
+	assert.ErrorIs(t, err, ErrMalformedHeader)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "bits out of range: bits 300 are out of [1, 256] for sha256 hash", err.Error())
+	}
+}
+
+func FuzzParseHeaderString(f *testing.F) {
+	for _, seed := range []string{
+		"1:2:2201010000:resource::cmFuZG9t:MTAwMA==",
+		"1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA==",
+		"1:2:2201010000:resource:alg=sha1;enc=hex:72616e646f6d:3e8",
+		"1:0:2201010000:resource:target=ffff;sig=00:cmFuZG9t:MTAwMA==",
+		"1:2:2201010000:resource:enc=base32:cmFuZG9t:MTAwMA==",
+		"1:-1:2201010000:resource::cmFuZG9t:LTE=",
+		"1:2:2201010000:resource:=:cmFuZG9t:MTAwMA==",
+		"corrupted",
+		"::::::",
+		"",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		header, err := ParseHeaderString(s)
+		if err != nil {
+			if header != nil {
+				t.Fatalf("header %v returned along with error %v", header, err)
+			}
+
+			var headerErr *HeaderError
+			if !errors.As(err, &headerErr) {
+				t.Fatalf("error %v is not a HeaderError", err)
+			}
+			return
+		}
+		if header == nil {
+			t.Fatal("neither header nor error returned")
+		}
+
+		// a parsed header is formatted back into a valid header string
+		if _, err := ParseHeaderString(header.String()); err != nil {
+			t.Fatalf("formatted header %q cannot be parsed: %v", header.String(), err)
+		}
+	})
+}
+
+func TestHeader_accessors(t *testing.T) {
+	headerStr := "1:20:2208082121:resource::cmFuZG9t:MTAwMA=="
+
+	header, err := ParseHeaderString(headerStr)
+	assert.Nil(t, err)
+
+	assert.EqualValues(t, 20, header.Bits())
+	assert.Equal(t, time.Date(2022, time.August, 8, 21, 21, 0, 0, time.UTC), header.Date())
+	assert.Equal(t, "resource", header.Resource())
+	assert.EqualValues(t, 1000, header.Counter())
+	assert.Equal(t, "cmFuZG9t", header.Random())
+
+	// the wire format is kept
+	assert.Equal(t, headerStr, header.String())
+}
+
 func TestHeader_String(t *testing.T) {
 	header, err := NewHeader(2, "resource")
 	assert.Nil(t, err)
@@ -181,3 +445,166 @@ func TestHeader_String(t *testing.T) {
 
 	assert.Regexp(t, "1:2:"+timefmt.Format(time.Now(), FormatDate)+":resource::*:*", header.String())
 }
+
+func TestCheckTarget_agrees_with_checkBits(t *testing.T) {
+	for bits := uint(0); bits <= 24; bits++ {
+		target := TargetFromBits(bits)
+
+		// hashes right at the boundary of the bits number of leading zero bits
+		highest := make([]byte, sha256.Size)
+		target.FillBytes(highest)
+		lowestExceeding := new(big.Int).Add(target, big.NewInt(1))
+
+		hashes := [][]byte{highest, make([]byte, sha256.Size)}
+		if lowestExceeding.BitLen() <= hashBits {
+			hashes = append(hashes, lowestExceeding.FillBytes(make([]byte, sha256.Size)))
+		}
+		for i := 0; i < 100; i++ {
+			hashes = append(hashes, getHash(strconv.Itoa(i), sha256.New()))
+		}
+
+		for _, hash := range hashes {
+			assert.Equal(t, checkBits(hash, bits), checkTarget(hash, target), "bits %d, hash %x", bits, hash)
+		}
+	}
+}
+
+func TestCheckBitsConstantTime_agrees_with_checkBits(t *testing.T) {
+	for bits := uint(0); bits <= hashBits; bits++ {
+		// hashes right at the boundary of the bits number of leading zero bits
+		hashes := [][]byte{make([]byte, sha256.Size), TargetFromBits(bits).FillBytes(make([]byte, sha256.Size))}
+		if bits > 0 {
+			// only the last of the leading bits is set
+			lowestExceeding := new(big.Int).Lsh(big.NewInt(1), hashBits-bits)
+			hashes = append(hashes, lowestExceeding.FillBytes(make([]byte, sha256.Size)))
+		}
+		for i := 0; i < 20; i++ {
+			hashes = append(hashes, getHash(strconv.Itoa(i), sha256.New()))
+		}
+
+		for _, hash := range hashes {
+			assert.Equal(t, checkBits(hash, bits), checkBitsConstantTime(hash, bits), "bits %d, hash %x", bits, hash)
+		}
+	}
+}
+
+func TestNewHeaderWithTarget(t *testing.T) {
+	target := TargetFromBits(12)
+	// a target between 12 and 13 bits
+	target.Sub(target, new(big.Int).Rsh(target, 2))
+
+	header, err := NewHeaderWithTarget(target, "resource")
+	assert.Nil(t, err)
+	if assert.NotNil(t, header) {
+		assert.EqualValues(t, 12, header.bits)
+	}
+
+	parsed, err := ParseHeaderString(header.String())
+	assert.Nil(t, err)
+	if assert.NotNil(t, parsed) {
+		assert.Equal(t, 0, target.Cmp(parsed.target))
+		assert.Equal(t, header.String(), parsed.String())
+	}
+
+	_, err = NewHeaderWithTarget(big.NewInt(0), "resource")
+	assert.NotNil(t, err)
+}
+
+func TestCalculate_target(t *testing.T) {
+	target := TargetFromBits(10)
+	target.Sub(target, new(big.Int).Rsh(target, 1))
+
+	challenge, err := ChallengeWithTarget(target, "resource")
+	assert.Nil(t, err)
+
+	result, err := Calculate(challenge)
+	assert.Nil(t, err)
+
+	ok, err := Verify(result, challenge)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	calculatedHeader, err := ParseHeaderString(result)
+	assert.Nil(t, err)
+	assert.True(t, checkTarget(getHash(calculatedHeader.String(), sha256.New()), target))
+
+	// the target cannot be dropped from a calculated result
+	calculatedHeader.extensions = nil
+	calculatedHeader.target = nil
+	_, err = Verify(calculatedHeader.String(), challenge)
+	assert.NotNil(t, err)
+}
+
+func TestCalculate_challenge_satisfying_its_bits(t *testing.T) {
+	// the challenge satisfies its bits as is, though it's never a valid result
+	challenge := "1:1:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	for name, calculate := range map[string]CalculateFunc{
+		"serial": Calculate,
+		"parallel": func(headerStr string) (string, error) {
+			return CalculateParallel(headerStr, SolverSettings{Workers: 1})
+		},
+	} {
+		result, err := calculate(challenge)
+		assert.Nil(t, err, name)
+		assert.NotEqual(t, challenge, result, name)
+
+		ok, err := Verify(result, challenge)
+		assert.Nil(t, err, name)
+		assert.True(t, ok, name)
+	}
+}
+
+func TestVerify_unchanged_challenge(t *testing.T) {
+	// with 1 bit about a half of challenges satisfy their bits as is
+	for i := 0; i < 20; i++ {
+		challenge, err := Challenge(1, "resource")
+		assert.Nil(t, err)
+
+		header, err := ParseHeaderString(challenge)
+		assert.Nil(t, err)
+
+		if !checkBits(getHash(header.String(), sha256.New()), header.bits) {
+			continue
+		}
+
+		ok, err := Verify(challenge, challenge)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+}
+
+// BenchmarkCalculate reports the time to calculate a PoW result for several bit levels,
+// along with the number of hashes calculated per result and the solve time estimated from the hash rate.
+func BenchmarkCalculate(b *testing.B) {
+	for _, bits := range []uint{8, 12, 16, 20} {
+		b.Run("bits="+strconv.Itoa(int(bits)), func(b *testing.B) {
+			challenges := make([]*Header, b.N)
+			for i := range challenges {
+				header, err := NewHeader(bits, "resource")
+				if err != nil {
+					b.Fatal(err)
+				}
+				challenges[i] = header
+			}
+
+			var hashes int64
+			b.ResetTimer()
+			start := time.Now()
+			for _, challenge := range challenges {
+				calculated, err := Calculate(challenge.String())
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				header, _ := ParseHeaderString(calculated)
+				hashes += header.counter - challenge.counter + 1
+			}
+			b.StopTimer()
+
+			hashesPerSecond := float64(hashes) / time.Since(start).Seconds()
+			b.ReportMetric(float64(hashes)/float64(b.N), "hashes/op")
+			b.ReportMetric(float64(EstimateSolveTime(bits, hashesPerSecond).Nanoseconds()), "estimated-ns/op")
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package pow
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/itchyny/timefmt-go"
+)
+
+// extSignature is a name of the header extension holding a hex-encoded challenge signature (see IssueOptions.Secret).
+const extSignature = "sig"
+
+// IssueOptions holds parameters of a challenge issued with Issue.
+type IssueOptions struct {
+	// Bits is a number of leading zero bits a PoW result hash must have.
+	Bits uint
+	// Target replaces Bits with a threshold a PoW result hash must not exceed, if it's set (see NewHeaderWithTarget).
+	Target *big.Int
+	// Resource is the challenge resource. It mustn't contain colons, since they separate header fields.
+	Resource string
+	// Algorithm is a hash function a PoW result is calculated with (see Algorithm).
+	Algorithm Algorithm
+	// Encoding is an encoding of the random and counter fields (see Encoding).
+	Encoding Encoding
+	// Secret is a key to sign the challenge with, if it's set, so a stateless server recognizes challenges it has issued
+	// (see CheckOptions.Secret). A signature is passed within the "sig" extension.
+	Secret []byte
+}
+
+// Issue generates a Hashcash PoW challenge header string with all parameters specified by the options.
+//
+// It's a single entry point for custom flows combining the features of Challenge, ChallengeWithTarget,
+// ChallengeWithAlgorithm and ChallengeWithEncoding along with signing. See Check to verify its results.
+func Issue(opts IssueOptions) (string, error) {
+	if strings.Contains(opts.Resource, ":") {
+		return "", fmt.Errorf("invalid resource [%s]: it mustn't contain colons", opts.Resource)
+	}
+
+	var (
+		header *Header
+		err    error
+	)
+	if opts.Target != nil {
+		if opts.Target.BitLen() > opts.Algorithm.bits() {
+			return "", fmt.Errorf("target exceeds %s hash size", opts.Algorithm)
+		}
+		header, err = NewHeaderWithTarget(opts.Target, opts.Resource)
+	} else {
+		if int(opts.Bits) > opts.Algorithm.bits() {
+			return "", fmt.Errorf("bits %d are out of %s hash size", opts.Bits, opts.Algorithm)
+		}
+		header, err = NewHeader(opts.Bits, opts.Resource)
+	}
+	if err != nil {
+		return "", fmt.Errorf("create new header: %w", err)
+	}
+
+	header.setAlgorithm(opts.Algorithm)
+	if err := header.setEncoding(opts.Encoding); err != nil {
+		return "", err
+	}
+
+	if len(opts.Secret) > 0 {
+		if header.extensions == nil {
+			header.extensions = make(map[string]string)
+		}
+		header.extensions[extSignature] = header.signature(opts.Secret)
+	}
+
+	return header.String(), nil
+}
+
+// signature returns a hex-encoded HMAC-SHA256 of the header fields but the counter (and the signature itself),
+// so a PoW result carries the signature of its challenge.
+func (h *Header) signature(secret []byte) string {
+	extensions := make(map[string]string, len(h.extensions))
+	for name, value := range h.extensions {
+		if name != extSignature {
+			extensions[name] = value
+		}
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%d:%d:%s:%s:%s:%s", h.version, h.bits, h.date, h.resource, formatExtensions(extensions), h.random)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedWith checks if the header holds a valid signature made with the secret.
+func (h *Header) signedWith(secret []byte) bool {
+	signature, ok := h.extensions[extSignature]
+	if !ok {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(h.signature(secret)))
+}
+
+// CheckOptions holds parameters of a PoW result verification with Check.
+type CheckOptions struct {
+	// MinBits and MaxBits bound the challenge difficulty, if they're set,
+	// so a challenge presented by a client (e.g. to a stateless server) cannot be trivially easy or unreasonably hard.
+	MinBits uint
+	MaxBits uint
+	// MaxAge is the longest time since the challenge has been issued, if it's set.
+	// A challenge date is precise to a minute (see FormatDate), so is the expiry.
+	MaxAge time.Duration
+	// Secret is a key the challenge must be signed with, if it's set (see IssueOptions.Secret).
+	Secret []byte
+	// Now returns the current time to check the expiry against, time.Now is used if it's nil.
+	Now func() time.Time
+}
+
+// issued returns the time the header has been issued at by this host:
+// NewHeader formats the date in the local time zone, so it's parsed back in the same one.
+func (h *Header) issued() time.Time {
+	date, err := timefmt.ParseInLocation(h.date, FormatDate, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return date
+}
+
+// expired checks if the header has been issued longer than maxAge before now.
+func (h *Header) expired(maxAge time.Duration, now time.Time) bool {
+	return now.Sub(h.issued()) > maxAge
+}
+
+// VerifyReport is an outcome of a PoW result verification with Check.
+type VerifyReport struct {
+	// Reason tells if the result is valid or why it has failed the verification.
+	Reason Reason
+	// Bits is the challenge difficulty.
+	Bits uint
+	// Algorithm is a hash function the result has been verified with.
+	Algorithm Algorithm
+	// Issued is the time the challenge has been issued at.
+	Issued time.Time
+}
+
+// Valid checks if the result has passed the verification.
+func (r VerifyReport) Valid() bool {
+	return r.Reason == ReasonValid
+}
+
+// Check verifies a PoW result for the challenge with all parameters specified by the options (see Issue):
+// the challenge signature, expiry and difficulty bounds are checked before the result itself (see VerifyReason).
+//
+// Only a challenge header which cannot be parsed results in an error,
+// while a client's fault (e.g. an expired challenge) results in a VerifyReport Reason.
+func Check(solved, challenge string, opts CheckOptions) (VerifyReport, error) {
+	header, err := ParseHeaderString(challenge)
+	if err != nil {
+		return VerifyReport{Reason: ReasonFailed}, fmt.Errorf("parse challenge header string: %w", err)
+	}
+
+	report := VerifyReport{
+		Bits:      header.bits,
+		Algorithm: header.algorithm,
+		Issued:    header.issued(),
+	}
+
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+
+	switch {
+	case len(opts.Secret) > 0 && !header.signedWith(opts.Secret):
+		report.Reason = ReasonInvalidSignature
+	case opts.MaxAge > 0 && header.expired(opts.MaxAge, now()):
+		report.Reason = ReasonExpired
+	case opts.MinBits > 0 && header.bits < opts.MinBits, opts.MaxBits > 0 && header.bits > opts.MaxBits:
+		report.Reason = ReasonBitsOutOfBounds
+	default:
+		report.Reason, err = reasonOf(checkResult(solved, challenge))
+	}
+
+	return report, err
+}
@@ -0,0 +1,177 @@
+package pow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIssue(t *testing.T) {
+	tests := []struct {
+		name string
+		opts IssueOptions
+	}{
+		{
+			name: "bits",
+			opts: IssueOptions{Bits: 8, Resource: "resource"},
+		},
+		{
+			name: "target",
+			opts: IssueOptions{Target: TargetFromBits(8), Resource: "resource"},
+		},
+		{
+			name: "algorithm and encoding",
+			opts: IssueOptions{Bits: 8, Resource: "resource", Algorithm: SHA1, Encoding: EncodingHex},
+		},
+		{
+			name: "signed",
+			opts: IssueOptions{Bits: 8, Resource: "resource", Secret: []byte("secret")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			challenge, err := Issue(test.opts)
+			assert.Nil(t, err)
+
+			header, err := ParseHeaderString(challenge)
+			if assert.Nil(t, err) {
+				assert.EqualValues(t, 8, header.Bits())
+				assert.Equal(t, "resource", header.Resource())
+				assert.Equal(t, test.opts.Algorithm, header.algorithm)
+				assert.Equal(t, test.opts.Encoding, header.encoding)
+			}
+
+			solved, err := Calculate(challenge)
+			assert.Nil(t, err)
+
+			report, err := Check(solved, challenge, CheckOptions{Secret: test.opts.Secret})
+			assert.Nil(t, err)
+			assert.True(t, report.Valid())
+			assert.EqualValues(t, 8, report.Bits)
+			assert.Equal(t, test.opts.Algorithm, report.Algorithm)
+		})
+	}
+}
+
+func TestIssue_invalid(t *testing.T) {
+	_, err := Issue(IssueOptions{Bits: 8, Resource: "::1"})
+	assert.EqualError(t, err, "invalid resource [::1]: it mustn't contain colons")
+
+	_, err = Issue(IssueOptions{Bits: 200, Algorithm: SHA1})
+	assert.EqualError(t, err, "bits 200 are out of sha1 hash size")
+}
+
+func TestCheck(t *testing.T) {
+	secret := []byte("secret")
+
+	challenge, err := Issue(IssueOptions{Bits: 8, Resource: "resource", Secret: secret})
+	assert.Nil(t, err)
+	solved, err := Calculate(challenge)
+	assert.Nil(t, err)
+
+	unsigned, err := Issue(IssueOptions{Bits: 8, Resource: "resource"})
+	assert.Nil(t, err)
+	solvedUnsigned, err := Calculate(unsigned)
+	assert.Nil(t, err)
+
+	// a challenge signed with another secret, e.g. forged by a client
+	forged, err := Issue(IssueOptions{Bits: 8, Resource: "resource", Secret: []byte("forged")})
+	assert.Nil(t, err)
+	solvedForged, err := Calculate(forged)
+	assert.Nil(t, err)
+
+	issued := time.Now()
+	later := func(d time.Duration) func() time.Time {
+		return func() time.Time { return issued.Add(d) }
+	}
+
+	tests := []struct {
+		name      string
+		solved    string
+		challenge string
+		opts      CheckOptions
+		want      Reason
+	}{
+		{
+			name:      "valid",
+			solved:    solved,
+			challenge: challenge,
+			opts:      CheckOptions{MinBits: 8, MaxBits: 8, MaxAge: 5 * time.Minute, Secret: secret},
+			want:      ReasonValid,
+		},
+		{
+			name:      "no options",
+			solved:    solvedUnsigned,
+			challenge: unsigned,
+			want:      ReasonValid,
+		},
+		{
+			name:      "unsigned challenge",
+			solved:    solvedUnsigned,
+			challenge: unsigned,
+			opts:      CheckOptions{Secret: secret},
+			want:      ReasonInvalidSignature,
+		},
+		{
+			name:      "forged signature",
+			solved:    solvedForged,
+			challenge: forged,
+			opts:      CheckOptions{Secret: secret},
+			want:      ReasonInvalidSignature,
+		},
+		{
+			name:      "not expired yet",
+			solved:    solved,
+			challenge: challenge,
+			opts:      CheckOptions{MaxAge: 5 * time.Minute, Now: later(4 * time.Minute)},
+			want:      ReasonValid,
+		},
+		{
+			name:      "expired",
+			solved:    solved,
+			challenge: challenge,
+			opts:      CheckOptions{MaxAge: 5 * time.Minute, Now: later(10 * time.Minute)},
+			want:      ReasonExpired,
+		},
+		{
+			name:      "too easy",
+			solved:    solved,
+			challenge: challenge,
+			opts:      CheckOptions{MinBits: 10},
+			want:      ReasonBitsOutOfBounds,
+		},
+		{
+			name:      "too hard",
+			solved:    solved,
+			challenge: challenge,
+			opts:      CheckOptions{MaxBits: 6},
+			want:      ReasonBitsOutOfBounds,
+		},
+		{
+			name:      "unchanged challenge",
+			solved:    challenge,
+			challenge: challenge,
+			opts:      CheckOptions{Secret: secret},
+			want:      ReasonInsufficientBits,
+		},
+		{
+			name:      "wrong challenge",
+			solved:    solvedUnsigned,
+			challenge: challenge,
+			want:      ReasonWrongChallenge,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report, err := Check(test.solved, test.challenge, test.opts)
+			assert.Nil(t, err)
+			assert.Equal(t, test.want, report.Reason)
+		})
+	}
+
+	_, err = Check(solved, "corrupted", CheckOptions{})
+	assert.ErrorIs(t, err, ErrMalformedHeader)
+}
@@ -0,0 +1,113 @@
+package pow
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// SolverSettings holds settings of the parallel PoW solver (see CalculateParallel).
+type SolverSettings struct {
+	// Workers is a number of goroutines calculating PoW result.
+	// If it's not set, runtime.GOMAXPROCS(0) is used.
+	Workers int
+	// LockOSThread flags to wire every worker goroutine to its own OS thread
+	// (see runtime.LockOSThread), so workers are not rescheduled between threads along with other work.
+	LockOSThread bool
+}
+
+// workers returns a number of workers to run.
+func (s SolverSettings) workers() int {
+	if s.Workers > 0 {
+		return s.Workers
+	}
+
+	return runtime.GOMAXPROCS(0)
+}
+
+// CalculateParallel returns PoW result header string calculated by several workers.
+//
+// The result satisfies the same conditions as the one returned by Calculate,
+// though it might differ from it as workers search the counter values interleaved:
+// the i-th worker checks the initial counter increased by i+1, i+1+workers, i+1+2*workers, and so on,
+// so the challenge itself is never checked (see CalculateWithStats).
+func CalculateParallel(headerStr string, settings SolverSettings) (string, error) {
+	return CalculateParallelContext(context.Background(), headerStr, settings)
+}
+
+// CalculateParallelContext returns PoW result header string calculated by several workers (see CalculateParallel)
+// unless the context is done first: then the workers are stopped, and the context error is returned.
+//
+// The result found by a worker is verified against the challenge before it's returned.
+func CalculateParallelContext(ctx context.Context, headerStr string, settings SolverSettings) (string, error) {
+	header, err := ParseHeaderString(headerStr)
+	if err != nil {
+		return "", fmt.Errorf("parse header string: %w", err)
+	}
+
+	workers := settings.workers()
+
+	var (
+		// done is set once a result is found or the context is done, so all the workers stop
+		done   int32
+		once   sync.Once
+		result string
+		wg     sync.WaitGroup
+	)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			atomic.StoreInt32(&done, 1)
+		case <-stop:
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		// every worker calculates over its own copy of the header
+		h := *header
+		h.counter += int64(i) + 1
+
+		go func(h *Header) {
+			defer wg.Done()
+
+			if settings.LockOSThread {
+				runtime.LockOSThread()
+				defer runtime.UnlockOSThread()
+			}
+
+			// a hasher is not safe for concurrent use, so every worker has its own one
+			hasher := h.algorithm.New()
+
+			for atomic.LoadInt32(&done) == 0 {
+				if h.satisfiedBy(getHash(h.String(), hasher)) {
+					once.Do(func() {
+						result = h.String()
+						atomic.StoreInt32(&done, 1)
+					})
+					return
+				}
+				h.counter += int64(workers)
+			}
+		}(&h)
+	}
+
+	wg.Wait()
+
+	if result == "" {
+		return "", ctx.Err()
+	}
+
+	if err := checkResult(result, headerStr); err != nil {
+		return "", fmt.Errorf("verify calculated result: %w", err)
+	}
+
+	return result, nil
+}
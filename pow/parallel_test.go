@@ -0,0 +1,148 @@
+package pow
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateParallel(t *testing.T) {
+	challenge := "1:16:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	tests := []SolverSettings{
+		{},
+		{Workers: 1},
+		{Workers: 3},
+		{Workers: 4, LockOSThread: true},
+	}
+
+	for _, settings := range tests {
+		t.Run(fmt.Sprintf("%d workers, locked %v", settings.Workers, settings.LockOSThread), func(t *testing.T) {
+			result, err := CalculateParallel(challenge, settings)
+			assert.Nil(t, err)
+
+			ok, err := Verify(result, challenge)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestCalculateParallel_single_worker_agrees_with_Calculate(t *testing.T) {
+	challenge := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	expected, err := Calculate(challenge)
+	assert.Nil(t, err)
+
+	result, err := CalculateParallel(challenge, SolverSettings{Workers: 1, LockOSThread: true})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, result)
+}
+
+func TestCalculateParallel_error(t *testing.T) {
+	result, err := CalculateParallel("corrupted", SolverSettings{})
+	assert.ErrorIs(t, err, ErrMalformedHeader)
+	assert.Empty(t, result)
+}
+
+func TestCalculateParallelContext(t *testing.T) {
+	challenge := "1:16:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	result, err := CalculateParallelContext(context.Background(), challenge, SolverSettings{Workers: 4})
+	assert.Nil(t, err)
+
+	ok, err := Verify(result, challenge)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestCalculateParallelContext_cancelled(t *testing.T) {
+	// a challenge of 60 bits is not to be solved within the test
+	challenge, err := Challenge(60, "resource")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result, err := CalculateParallelContext(ctx, challenge, SolverSettings{Workers: 2})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Empty(t, result)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestCalculateParallelContext_done_in_advance(t *testing.T) {
+	challenge := "1:16:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// workers might find a result before they notice the context is done, so either outcome is correct
+	result, err := CalculateParallelContext(ctx, challenge, SolverSettings{})
+	if err != nil {
+		assert.ErrorIs(t, err, context.Canceled)
+		return
+	}
+
+	ok, err := Verify(result, challenge)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+// BenchmarkCalculate_serial_vs_parallel compares the serial solver with the parallel one running a worker per CPU.
+func BenchmarkCalculate_serial_vs_parallel(b *testing.B) {
+	solvers := []struct {
+		name      string
+		calculate CalculateFunc
+	}{
+		{name: "serial", calculate: Calculate},
+		{name: "parallel", calculate: func(challenge string) (string, error) {
+			return CalculateParallel(challenge, SolverSettings{})
+		}},
+	}
+
+	for _, solver := range solvers {
+		b.Run(solver.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				// the same challenges for both solvers, so they do comparable work
+				challenge, err := Challenge(18, fmt.Sprintf("resource-%d", i))
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				if _, err := solver.calculate(challenge); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCalculateParallel(b *testing.B) {
+	for _, locked := range []bool{false, true} {
+		b.Run(fmt.Sprintf("locked %v", locked), func(b *testing.B) {
+			settings := SolverSettings{LockOSThread: locked}
+
+			for i := 0; i < b.N; i++ {
+				// a fresh challenge per iteration, so the benchmark doesn't depend on a single lucky counter
+				challenge, err := Challenge(18, fmt.Sprintf("resource-%d", i))
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				result, err := CalculateParallel(challenge, settings)
+				if err != nil {
+					b.Fatal(err)
+				}
+				header, _ := ParseHeaderString(result)
+				if !header.satisfiedBy(getHash(header.String(), sha256.New())) {
+					b.Fatalf("invalid result %s", result)
+				}
+			}
+		})
+	}
+}
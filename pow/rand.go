@@ -0,0 +1,38 @@
+package pow
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// sources is a pool of math/rand sources dedicated to pow.
+//
+// Unlike the global math/rand source, pooled sources are not guarded by a single lock,
+// so concurrent challenge generation doesn't contend for it,
+// and every source is seeded on its own, so pow doesn't rely on rand.Seed called by an application.
+var sources = sync.Pool{
+	New: func() any {
+		return rand.New(rand.NewSource(seed()))
+	},
+}
+
+// seed returns a seed for a new source read from crypto/rand, or based on the current time if it fails.
+func seed() int64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+
+	return int64(binary.BigEndian.Uint64(b[:]))
+}
+
+// randInt63 returns a non-negative pseudo-random 63-bit integer.
+func randInt63() int64 {
+	r := sources.Get().(*rand.Rand)
+	defer sources.Put(r)
+
+	return r.Int63()
+}
@@ -0,0 +1,83 @@
+package pow
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHeader_distinct_randoms(t *testing.T) {
+	first, err := NewHeader(10, "resource")
+	assert.Nil(t, err)
+	second, err := NewHeader(10, "resource")
+	assert.Nil(t, err)
+
+	// 80 random bits collide with a negligible probability
+	assert.NotEqual(t, first.random, second.random)
+}
+
+func TestNewHeader_distinct_counters(t *testing.T) {
+	const (
+		goroutines = 8
+		headers    = 1000
+	)
+
+	counters := make(chan int64, goroutines*headers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < headers; j++ {
+				header, err := NewHeader(10, "resource")
+				if assert.Nil(t, err) {
+					counters <- header.counter
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(counters)
+
+	seen := make(map[int64]bool, goroutines*headers)
+	for counter := range counters {
+		assert.GreaterOrEqual(t, counter, int64(0))
+		assert.False(t, seen[counter], "counter %d issued twice", counter)
+		seen[counter] = true
+	}
+	assert.Len(t, seen, goroutines*headers)
+}
+
+// BenchmarkCounter compares counter generation under concurrency
+// backed by the global math/rand source guarded by a single lock and by the pooled pow sources.
+func BenchmarkCounter(b *testing.B) {
+	b.Run("global", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_ = rand.Int63()
+			}
+		})
+	})
+
+	b.Run("pooled", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_ = randInt63()
+			}
+		})
+	})
+}
+
+func BenchmarkNewHeader(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := NewHeader(20, "resource"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
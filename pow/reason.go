@@ -0,0 +1,127 @@
+package pow
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Reason is an outcome of a PoW result verification telling why the result has failed it, if it has.
+type Reason int
+
+const (
+	// ReasonValid is a Reason of a result which has passed the verification.
+	ReasonValid Reason = iota
+	// ReasonFailed is a Reason of a result which has failed the verification for an unspecified reason
+	// (e.g. it's been verified with a VerifyFunc, see ReasonFuncOf).
+	ReasonFailed
+	// ReasonMalformed is a Reason of a result which is not a valid header string.
+	ReasonMalformed
+	// ReasonWrongChallenge is a Reason of a result which doesn't correspond to the challenge (see ErrHeaderMismatch).
+	ReasonWrongChallenge
+	// ReasonInsufficientBits is a Reason of a result which doesn't satisfy the challenge difficulty
+	// (see ErrInsufficientBits).
+	ReasonInsufficientBits
+	// ReasonInvalidSignature is a Reason of a result for a challenge which hasn't been signed with the secret
+	// (see CheckOptions.Secret).
+	ReasonInvalidSignature
+	// ReasonExpired is a Reason of a result for a challenge issued earlier than allowed (see CheckOptions.MaxAge).
+	ReasonExpired
+	// ReasonBitsOutOfBounds is a Reason of a result for a challenge which difficulty is out of the allowed bounds
+	// (see CheckOptions.MinBits and CheckOptions.MaxBits).
+	ReasonBitsOutOfBounds
+)
+
+// String returns a human-readable description of the Reason.
+func (r Reason) String() string {
+	switch r {
+	case ReasonValid:
+		return "valid"
+	case ReasonMalformed:
+		return "malformed result"
+	case ReasonWrongChallenge:
+		return "wrong challenge"
+	case ReasonInsufficientBits:
+		return "not enough leading zeros"
+	case ReasonInvalidSignature:
+		return "invalid signature"
+	case ReasonExpired:
+		return "expired challenge"
+	case ReasonBitsOutOfBounds:
+		return "difficulty out of bounds"
+	default:
+		return "verification failed"
+	}
+}
+
+// ReasonFunc is a type of function to verify a Hashcash PoW result header string telling the Reason of a failure.
+type ReasonFunc func(calculated, challenge string) (Reason, error)
+
+// VerifyReason checks if the result of PoW calculation is valid (see Verify) and returns the Reason of a failure.
+//
+// Only a challenge header which cannot be parsed results in an error,
+// while a client's fault (e.g. a malformed result) results in a Reason.
+func VerifyReason(calculated, challenge string) (Reason, error) {
+	if _, err := ParseHeaderString(challenge); err != nil {
+		return ReasonFailed, fmt.Errorf("parse challenge header string: %w", err)
+	}
+
+	return reasonOf(checkResult(calculated, challenge))
+}
+
+// reasonOf returns the Reason corresponding to an error of checkResult.
+//
+// An error which is not a client's fault is returned as is.
+func reasonOf(err error) (Reason, error) {
+	switch {
+	case err == nil:
+		return ReasonValid, nil
+	case errors.Is(err, ErrInsufficientBits):
+		return ReasonInsufficientBits, nil
+	case errors.Is(err, ErrHeaderMismatch):
+		return ReasonWrongChallenge, nil
+	case errors.Is(err, ErrMalformedHeader), errors.Is(err, ErrUnsupportedVersion):
+		return ReasonMalformed, nil
+	default:
+		return ReasonFailed, err
+	}
+}
+
+// ReasonFuncOf returns a ReasonFunc verifying a result with the VerifyFunc:
+// a failure is reported as ReasonFailed, since a VerifyFunc doesn't tell its reason.
+func ReasonFuncOf(verify VerifyFunc) ReasonFunc {
+	return func(calculated, challenge string) (Reason, error) {
+		ok, err := verify(calculated, challenge)
+		if err != nil {
+			return ReasonFailed, err
+		}
+		if !ok {
+			return ReasonFailed, nil
+		}
+
+		return ReasonValid, nil
+	}
+}
+
+// ReasonFuncWithMaxAge returns a ReasonFunc failing a result for a challenge issued longer than maxAge ago
+// with ReasonExpired and verifying other results with the ReasonFunc (see CheckOptions.MaxAge).
+//
+// A challenge date is precise to a minute (see FormatDate), so is the expiry.
+// now returns the current time to check the expiry against, time.Now is used if it's nil.
+func ReasonFuncWithMaxAge(verify ReasonFunc, maxAge time.Duration, now func() time.Time) ReasonFunc {
+	if now == nil {
+		now = time.Now
+	}
+
+	return func(calculated, challenge string) (Reason, error) {
+		header, err := ParseHeaderString(challenge)
+		if err != nil {
+			return ReasonFailed, fmt.Errorf("parse challenge header string: %w", err)
+		}
+		if header.expired(maxAge, now()) {
+			return ReasonExpired, nil
+		}
+
+		return verify(calculated, challenge)
+	}
+}
@@ -0,0 +1,187 @@
+package pow
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyReason(t *testing.T) {
+	challenge := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	tests := []struct {
+		name       string
+		challenge  string
+		calculated string
+		want       Reason
+		wantErr    bool
+	}{
+		{
+			name:       "valid",
+			challenge:  challenge,
+			calculated: "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA==",
+			want:       ReasonValid,
+		},
+		{
+			name:       "not enough leading zeros",
+			challenge:  challenge,
+			calculated: "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyNw==",
+			want:       ReasonInsufficientBits,
+		},
+		{
+			name:       "unchanged challenge",
+			challenge:  challenge,
+			calculated: challenge,
+			want:       ReasonInsufficientBits,
+		},
+		{
+			name:       "wrong challenge",
+			challenge:  challenge,
+			calculated: "1:12:2208082127:f1a5a003-27ce-4e62-8c48-14c250965b92::kUumfNZAqta03Q==:MTA4MDAyODM5MTgzMzgyMTg0OQ==",
+			want:       ReasonWrongChallenge,
+		},
+		{
+			name:       "mismatched bits",
+			challenge:  challenge,
+			calculated: "1:1:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA==",
+			want:       ReasonWrongChallenge,
+		},
+		{
+			name:       "malformed result",
+			challenge:  challenge,
+			calculated: "not a header",
+			want:       ReasonMalformed,
+		},
+		{
+			name:       "result bits out of range",
+			challenge:  challenge,
+			calculated: "1:300:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA==",
+			want:       ReasonMalformed,
+		},
+		{
+			name:       "unsupported result version",
+			challenge:  challenge,
+			calculated: "2:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA==",
+			want:       ReasonMalformed,
+		},
+		{
+			name:       "malformed challenge",
+			challenge:  "not a header",
+			calculated: challenge,
+			want:       ReasonFailed,
+			wantErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := VerifyReason(test.calculated, test.challenge)
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrMalformedHeader)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestReasonFuncOf(t *testing.T) {
+	verifyErr := errors.New("verify")
+
+	tests := []struct {
+		name   string
+		verify VerifyFunc
+		want   Reason
+		err    error
+	}{
+		{
+			name:   "valid",
+			verify: func(_, _ string) (bool, error) { return true, nil },
+			want:   ReasonValid,
+		},
+		{
+			name:   "failed",
+			verify: func(_, _ string) (bool, error) { return false, nil },
+			want:   ReasonFailed,
+		},
+		{
+			name:   "error",
+			verify: func(_, _ string) (bool, error) { return false, verifyErr },
+			want:   ReasonFailed,
+			err:    verifyErr,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ReasonFuncOf(test.verify)("calculated", "challenge")
+			assert.Equal(t, test.err, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestReasonFuncWithMaxAge(t *testing.T) {
+	challenge := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+	calculated := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyODcyOA=="
+	issued := time.Date(2022, time.August, 8, 21, 21, 0, 0, time.Local)
+	later := func(d time.Duration) func() time.Time {
+		return func() time.Time { return issued.Add(d) }
+	}
+
+	tests := []struct {
+		name       string
+		challenge  string
+		calculated string
+		now        func() time.Time
+		want       Reason
+		wantErr    bool
+	}{
+		{
+			name:       "not expired yet",
+			challenge:  challenge,
+			calculated: calculated,
+			now:        later(5 * time.Minute),
+			want:       ReasonValid,
+		},
+		{
+			name:       "not expired yet but invalid",
+			challenge:  challenge,
+			calculated: "not a header",
+			now:        later(time.Minute),
+			want:       ReasonMalformed,
+		},
+		{
+			name:       "expired",
+			challenge:  challenge,
+			calculated: calculated,
+			now:        later(5*time.Minute + time.Second),
+			want:       ReasonExpired,
+		},
+		{
+			name:       "malformed challenge",
+			challenge:  "not a header",
+			calculated: calculated,
+			now:        later(0),
+			want:       ReasonFailed,
+			wantErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verify := ReasonFuncWithMaxAge(VerifyReason, 5*time.Minute, test.now)
+
+			got, err := verify(test.calculated, test.challenge)
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrMalformedHeader)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
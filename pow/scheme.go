@@ -0,0 +1,34 @@
+package pow
+
+// Scheme is a contract of a PoW algorithm: how challenges are issued, solved and verified.
+//
+// Hashcash is the default implementation, others (e.g. a memory-hard scheme) may be plugged in its place.
+type Scheme interface {
+	// Challenge generates a challenge string of the difficulty for the resource.
+	Challenge(bits uint, resource string) (string, error)
+	// Verify checks if a calculated result solves the challenge.
+	// An insufficient result is not an error: Verify returns false for it.
+	Verify(calculated, challenge string) (bool, error)
+	// Solve calculates a result for the challenge.
+	Solve(challenge string) (string, error)
+}
+
+// Hashcash is the Hashcash implementation of Scheme (see Challenge, Verify and Calculate).
+type Hashcash struct{}
+
+var _ Scheme = Hashcash{}
+
+// Challenge generates a Hashcash PoW challenge header string (see Challenge).
+func (Hashcash) Challenge(bits uint, resource string) (string, error) {
+	return Challenge(bits, resource)
+}
+
+// Verify checks if the result of PoW calculation is valid (see Verify).
+func (Hashcash) Verify(calculated, challenge string) (bool, error) {
+	return Verify(calculated, challenge)
+}
+
+// Solve returns PoW result header string (see Calculate).
+func (Hashcash) Solve(challenge string) (string, error) {
+	return Calculate(challenge)
+}
@@ -0,0 +1,25 @@
+package pow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashcash(t *testing.T) {
+	var scheme Scheme = Hashcash{}
+
+	challenge, err := scheme.Challenge(8, "resource")
+	assert.Nil(t, err)
+
+	result, err := scheme.Solve(challenge)
+	assert.Nil(t, err)
+
+	ok, err := scheme.Verify(result, challenge)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// the challenge itself is never a valid result
+	ok, _ = scheme.Verify(challenge, challenge)
+	assert.False(t, ok)
+}
@@ -0,0 +1,404 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Version is the version of the wire protocol, bumped on changes old peers can't handle.
+const Version = 1
+
+// Capability is a name of an optional protocol feature a client declares to support.
+type Capability string
+
+// CapabilityNextChallenge flags that a client accepts a next PoW challenge delivered along with a quote
+// and is able to submit its result in advance on the next request.
+const CapabilityNextChallenge Capability = "next-challenge"
+
+// CapabilityDifficultyToken flags that a client accepts a difficulty token delivered along with a quote
+// and presents it on the next request to get a reduced-difficulty challenge.
+const CapabilityDifficultyToken Capability = "difficulty-token"
+
+// CapabilityBatch flags that a client requests several quotes over a single connection:
+// it solves a batch of challenges (see BatchChallenge) and submits their results at once (see BatchProof).
+const CapabilityBatch Capability = "batch"
+
+// CapabilityNegotiation flags that a client proposes challenge difficulty (see Request.Bits)
+// and accepts a challenge delivered as NegotiatedChallenge.
+const CapabilityNegotiation Capability = "negotiation"
+
+// CapabilityHex flags that a client accepts challenges with the random and counter fields hex-encoded
+// instead of base64-encoded, and submits its results the same way.
+const CapabilityHex Capability = "hex"
+
+// CapabilityCatalog flags that a client requests the server catalog (see Catalog):
+// the capabilities the server supports and the quote categories it serves.
+const CapabilityCatalog Capability = "catalog"
+
+// CapabilityHello flags that a client validates the protocol version of the server:
+// the server sends Hello before anything else.
+const CapabilityHello Capability = "hello"
+
+// CapabilityEnvelope flags that a client accepts every message of the server wrapped in a Response,
+// so it tells challenges, quotes and errors apart without matching the text of messages.
+const CapabilityEnvelope Capability = "envelope"
+
+// CapabilitySession flags that a client requests more quotes over the same connection once PoW has been passed:
+// it sends a Request for every next quote (e.g. selecting another category), and closes the connection once it's done.
+// The server closes the connection once it has served as many quotes as it allows.
+const CapabilitySession Capability = "session"
+
+// CapabilityEstimate flags that a client accepts a challenge delivered as EstimatedChallenge,
+// so it learns the challenge difficulty and its estimated cost without parsing the challenge header,
+// and decides whether to proceed or abort.
+const CapabilityEstimate Capability = "estimate"
+
+// Request is an initial message sent by a client to initiate the flow.
+type Request struct {
+	Capabilities []Capability `json:"capabilities,omitempty"`
+
+	// Version is the protocol version spoken by a client (see Version); it's not declared if it's zero.
+	// A server speaking another version rejects the client.
+	Version int `json:"version,omitempty"`
+
+	// Challenge is a challenge header received along with a previous quote.
+	Challenge string `json:"challenge,omitempty"`
+	// Proof is a PoW calculation result for Challenge.
+	Proof string `json:"proof,omitempty"`
+
+	// Token is a difficulty token received along with a previous quote.
+	Token string `json:"token,omitempty"`
+
+	// Count is a number of quotes requested in batch mode (see CapabilityBatch).
+	Count int `json:"count,omitempty"`
+
+	// Bits is a challenge difficulty proposed by a client (see CapabilityNegotiation).
+	Bits int `json:"bits,omitempty"`
+
+	// APIKey is a key authenticating a client, so it may skip PoW or get a reduced challenge.
+	APIKey string `json:"api_key,omitempty"`
+
+	// Category is a category of quotes requested by a client; quotes of any category are served if it's empty.
+	Category string `json:"category,omitempty"`
+
+	// ClientVersion is the version of a client software, e.g. "pow-client/1.2.0", recorded for analytics and
+	// compatibility tracking; it doesn't affect the flow.
+	ClientVersion string `json:"client_version,omitempty"`
+}
+
+// LegacyPing is the initial message of clients predating Request: it stands for an empty Request.
+//
+// Deprecated: it's accepted for backward compatibility until the next release; send a Request instead.
+const LegacyPing = "ping"
+
+// ErrInvalidRequest is returned on parsing an initial message which is neither a Request nor LegacyPing.
+var ErrInvalidRequest = errors.New("invalid request")
+
+// Has checks if the request declares an argument capability.
+func (r Request) Has(capability Capability) bool {
+	for _, c := range r.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseRequest returns a Request based on an initial message.
+//
+// LegacyPing results in an empty Request, so legacy clients keep working.
+// Any other message which is not a JSON request fails with ErrInvalidRequest.
+func ParseRequest(message []byte) (Request, error) {
+	if string(message) == LegacyPing {
+		return Request{}, nil
+	}
+
+	var r Request
+	if err := json.Unmarshal(message, &r); err != nil {
+		return Request{}, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	return r, nil
+}
+
+// RedactedAPIKey stands for the API key of a request logged by RedactedMessage.
+const RedactedAPIKey = "REDACTED"
+
+// RedactedMessage returns an initial message fit for logging, so client credentials never end up in logs.
+//
+// A request is logged with its API key (if any) replaced with RedactedAPIKey. A message which is not a request
+// (except LegacyPing) might hold a key as well, so only its size is logged.
+func RedactedMessage(message []byte) string {
+	if string(message) == LegacyPing {
+		return LegacyPing
+	}
+
+	r, err := ParseRequest(message)
+	if err != nil {
+		return fmt.Sprintf("invalid request of %d bytes", len(message))
+	}
+	if r.APIKey != "" {
+		r.APIKey = RedactedAPIKey
+	}
+
+	redacted, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Sprintf("request of %d bytes", len(message))
+	}
+
+	return string(redacted)
+}
+
+// Hello is the first message sent to a client declaring CapabilityHello.
+type Hello struct {
+	// Version is the protocol version spoken by the server.
+	Version int `json:"version"`
+	// Algorithm is the hash algorithm of challenges issued by the server (see pow.Algorithm).
+	Algorithm string `json:"algorithm"`
+}
+
+// ParseHello returns a Hello based on a message.
+//
+// It returns false if the message is not a Hello (e.g. it's a challenge header sent by a server predating Hello).
+func ParseHello(message []byte) (Hello, bool) {
+	var h Hello
+	if err := json.Unmarshal(message, &h); err != nil || h.Version == 0 {
+		return Hello{}, false
+	}
+
+	return h, true
+}
+
+// NegotiatedChallenge is a challenge message sent to a client proposing challenge difficulty.
+type NegotiatedChallenge struct {
+	Challenge string `json:"challenge"`
+	// Bits is the agreed difficulty of the challenge.
+	Bits int `json:"bits"`
+	// Accepted flags that the proposed difficulty has been accepted as is.
+	// Otherwise, the server has countered with the closest difficulty it allows.
+	Accepted bool `json:"accepted"`
+	// EstimatedSolveMillis and Hint are set for a client declaring CapabilityEstimate as well (see EstimatedChallenge).
+	EstimatedSolveMillis int64  `json:"estimated_solve_ms,omitempty"`
+	Hint                 string `json:"hint,omitempty"`
+}
+
+// ParseNegotiatedChallenge returns a NegotiatedChallenge based on a challenge message.
+//
+// It returns false if the message is not a NegotiatedChallenge (e.g. it's a plain challenge header).
+func ParseNegotiatedChallenge(message []byte) (NegotiatedChallenge, bool) {
+	var c NegotiatedChallenge
+	if err := json.Unmarshal(message, &c); err != nil || c.Challenge == "" {
+		return NegotiatedChallenge{}, false
+	}
+
+	return c, true
+}
+
+// EstimatedChallenge is a challenge message sent to a client declaring CapabilityEstimate.
+type EstimatedChallenge struct {
+	Challenge string `json:"challenge"`
+	// Bits is the difficulty of the challenge, the same as declared by the challenge header.
+	Bits int `json:"bits"`
+	// EstimatedSolveMillis is the time in milliseconds a reference client is expected to solve the challenge in.
+	EstimatedSolveMillis int64 `json:"estimated_solve_ms"`
+	// Hint is a human-readable message of the server, if it's set.
+	Hint string `json:"hint,omitempty"`
+}
+
+// ParseEstimatedChallenge returns an EstimatedChallenge based on a challenge message.
+//
+// It returns false if the message is not an EstimatedChallenge (e.g. it's a plain challenge header
+// sent by a server not supporting CapabilityEstimate).
+func ParseEstimatedChallenge(message []byte) (EstimatedChallenge, bool) {
+	var c EstimatedChallenge
+	if err := json.Unmarshal(message, &c); err != nil || c.Challenge == "" {
+		return EstimatedChallenge{}, false
+	}
+
+	return c, true
+}
+
+// QuoteResponse is a quote message sent to a client declaring CapabilityNextChallenge, CapabilityDifficultyToken,
+// or CapabilityCatalog.
+type QuoteResponse struct {
+	Quote string `json:"quote"`
+	// NextChallenge is a challenge header to be solved in advance for the next request.
+	NextChallenge string `json:"next_challenge,omitempty"`
+	// Token is a difficulty token to be presented on the next request.
+	Token string `json:"token,omitempty"`
+	// Catalog is the server catalog requested by a client declaring CapabilityCatalog.
+	Catalog *Catalog `json:"catalog,omitempty"`
+}
+
+// ParseQuoteResponse returns a QuoteResponse based on a quote message.
+//
+// It returns false if the message is not a QuoteResponse (e.g. it's a plain quote or an error message).
+func ParseQuoteResponse(message []byte) (QuoteResponse, bool) {
+	var r QuoteResponse
+	if err := json.Unmarshal(message, &r); err != nil || r.Quote == "" {
+		return QuoteResponse{}, false
+	}
+
+	return r, true
+}
+
+// BatchChallenge is a message holding a batch of challenge headers sent to a client requesting batch mode.
+//
+// A server may issue fewer challenges than requested.
+type BatchChallenge struct {
+	Challenges []string `json:"challenges"`
+}
+
+// ParseBatchChallenge returns a BatchChallenge based on a challenge message.
+//
+// It returns false if the message is not a BatchChallenge (e.g. it's a single challenge header
+// sent by a server not supporting batch mode).
+func ParseBatchChallenge(message []byte) (BatchChallenge, bool) {
+	var c BatchChallenge
+	if err := json.Unmarshal(message, &c); err != nil || len(c.Challenges) == 0 {
+		return BatchChallenge{}, false
+	}
+
+	return c, true
+}
+
+// BatchProof is a message holding PoW calculation results for a BatchChallenge in the same order.
+type BatchProof struct {
+	Proofs []string `json:"proofs"`
+}
+
+// ParseBatchProof returns a BatchProof based on a result message.
+//
+// It returns false if the message is not a BatchProof.
+func ParseBatchProof(message []byte) (BatchProof, bool) {
+	var p BatchProof
+	if err := json.Unmarshal(message, &p); err != nil || len(p.Proofs) == 0 {
+		return BatchProof{}, false
+	}
+
+	return p, true
+}
+
+// BatchResponse is a quotes message sent to a client once a BatchProof has been verified.
+type BatchResponse struct {
+	Quotes []string `json:"quotes"`
+	// Catalog is the server catalog requested by a client declaring CapabilityCatalog.
+	Catalog *Catalog `json:"catalog,omitempty"`
+}
+
+// ParseBatchResponse returns a BatchResponse based on a quotes message.
+//
+// It returns false if the message is not a BatchResponse (e.g. it's an error message).
+func ParseBatchResponse(message []byte) (BatchResponse, bool) {
+	var r BatchResponse
+	if err := json.Unmarshal(message, &r); err != nil || len(r.Quotes) == 0 {
+		return BatchResponse{}, false
+	}
+
+	return r, true
+}
+
+// Catalog is a message describing the server: the capabilities it supports and the quote categories it serves.
+//
+// It's sent to a client declaring CapabilityCatalog either right away instead of a challenge,
+// or along with a quote once PoW has been passed (see QuoteResponse), depending on the server settings.
+type Catalog struct {
+	Capabilities []Capability `json:"capabilities"`
+	Categories   []string     `json:"categories"`
+}
+
+// ParseCatalog returns a Catalog based on a message.
+//
+// It returns false if the message is not a Catalog (e.g. it's a challenge header).
+func ParseCatalog(message []byte) (Catalog, bool) {
+	var c Catalog
+	if err := json.Unmarshal(message, &c); err != nil || c.Capabilities == nil {
+		return Catalog{}, false
+	}
+
+	return c, true
+}
+
+// ResponseType is a type of Response.
+type ResponseType string
+
+// Response types.
+const (
+	ResponseHello     ResponseType = "hello"
+	ResponseCatalog   ResponseType = "catalog"
+	ResponseChallenge ResponseType = "challenge"
+	ResponseQuote     ResponseType = "quote"
+	ResponseError     ResponseType = "error"
+)
+
+// ErrorCode is a machine-readable code of an error Response.
+type ErrorCode string
+
+// Error codes.
+const (
+	CodeInvalidRequest       ErrorCode = "invalid_request"
+	CodeUnsupportedVersion   ErrorCode = "unsupported_version"
+	CodeAuthenticationFailed ErrorCode = "authentication_failed"
+	CodeRateLimited          ErrorCode = "rate_limited"
+	CodeShuttingDown         ErrorCode = "shutting_down"
+	CodeTimeout              ErrorCode = "timeout"
+	CodeMessageTooLarge      ErrorCode = "message_too_large"
+	CodeUnknownChallenge     ErrorCode = "unknown_challenge"
+	CodeVerificationFailed   ErrorCode = "verification_failed"
+	CodeBudgetExceeded       ErrorCode = "budget_exceeded"
+	CodeUnknownCategory      ErrorCode = "unknown_category"
+	CodeQuoteUnavailable     ErrorCode = "quote_unavailable"
+	CodeQuoteTimeout         ErrorCode = "quote_timeout"
+	CodeNoQuotes             ErrorCode = "no_quotes"
+	CodeInternal             ErrorCode = "internal_error"
+)
+
+// Response is an envelope of a message sent to a client declaring CapabilityEnvelope.
+//
+// The payload of a message is either a text (e.g. a challenge header, a quote or an error message) held by Message,
+// or a JSON document (e.g. Hello, NegotiatedChallenge or QuoteResponse) held by Data.
+// An error response holds Code along with the error message.
+type Response struct {
+	Type    ResponseType    `json:"type"`
+	Code    ErrorCode       `json:"code,omitempty"`
+	Message string          `json:"message,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// NewMessage returns a Response of a type holding a text payload.
+func NewMessage(typ ResponseType, message string) Response {
+	return Response{Type: typ, Message: message}
+}
+
+// NewData returns a Response of a type holding a JSON document payload.
+func NewData(typ ResponseType, data []byte) Response {
+	return Response{Type: typ, Data: data}
+}
+
+// NewError returns an error Response.
+func NewError(code ErrorCode, message string) Response {
+	return Response{Type: ResponseError, Code: code, Message: message}
+}
+
+// Payload returns the payload of the response as it's sent to a client which hasn't declared CapabilityEnvelope.
+func (r Response) Payload() []byte {
+	if len(r.Data) > 0 {
+		return r.Data
+	}
+
+	return []byte(r.Message)
+}
+
+// ParseResponse returns a Response based on a message.
+//
+// It returns false if the message is not a Response (e.g. it's sent by a server predating CapabilityEnvelope).
+func ParseResponse(message []byte) (Response, bool) {
+	var r Response
+	if err := json.Unmarshal(message, &r); err != nil || r.Type == "" {
+		return Response{}, false
+	}
+
+	return r, true
+}
@@ -0,0 +1,208 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    Request
+	}{
+		{
+			name:    "legacy ping",
+			message: "ping",
+			want:    Request{},
+		},
+		{
+			name:    "capabilities",
+			message: `{"capabilities":["next-challenge"]}`,
+			want:    Request{Capabilities: []Capability{CapabilityNextChallenge}},
+		},
+		{
+			name:    "solved in advance",
+			message: `{"challenge":"challenge","proof":"proof"}`,
+			want:    Request{Challenge: "challenge", Proof: "proof"},
+		},
+		{
+			name:    "batch",
+			message: `{"capabilities":["batch"],"count":3}`,
+			want:    Request{Capabilities: []Capability{CapabilityBatch}, Count: 3},
+		},
+		{
+			name:    "negotiation",
+			message: `{"capabilities":["negotiation"],"bits":12}`,
+			want:    Request{Capabilities: []Capability{CapabilityNegotiation}, Bits: 12},
+		},
+		{
+			name:    "version",
+			message: `{"capabilities":["hello"],"version":1}`,
+			want:    Request{Capabilities: []Capability{CapabilityHello}, Version: 1},
+		},
+		{
+			name:    "category",
+			message: `{"version":1,"category":"life"}`,
+			want:    Request{Version: 1, Category: "life"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseRequest([]byte(test.message))
+			assert.Nil(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestParseRequest_invalid(t *testing.T) {
+	for _, message := range []string{"", "pong", `{"capabilities":"batch"}`, `["ping"]`} {
+		_, err := ParseRequest([]byte(message))
+		assert.ErrorIs(t, err, ErrInvalidRequest, message)
+	}
+}
+
+func TestRedactedMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{name: "legacy ping", message: "ping", want: "ping"},
+		{name: "no key", message: `{"version":1,"category":"life"}`, want: `{"version":1,"category":"life"}`},
+		{name: "key", message: `{"version":1,"api_key":"secret"}`, want: `{"version":1,"api_key":"REDACTED"}`},
+		{name: "duplicate key", message: `{"api_key":"secret","API_KEY":""}`, want: `{}`},
+		{name: "invalid request", message: `{"api_key":"secret"`, want: "invalid request of 19 bytes"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, RedactedMessage([]byte(test.message)))
+		})
+	}
+}
+
+func TestParseQuoteResponse(t *testing.T) {
+	response, ok := ParseQuoteResponse([]byte(`{"quote":"quote","next_challenge":"challenge"}`))
+	assert.True(t, ok)
+	assert.Equal(t, QuoteResponse{Quote: "quote", NextChallenge: "challenge"}, response)
+
+	_, ok = ParseQuoteResponse([]byte("plain quote"))
+	assert.False(t, ok)
+}
+
+func TestParseBatch(t *testing.T) {
+	challenge, ok := ParseBatchChallenge([]byte(`{"challenges":["a","b"]}`))
+	assert.True(t, ok)
+	assert.Equal(t, BatchChallenge{Challenges: []string{"a", "b"}}, challenge)
+
+	// a single challenge header sent by a server not supporting batch mode
+	_, ok = ParseBatchChallenge([]byte("1:12:2208082121:resource::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="))
+	assert.False(t, ok)
+
+	proof, ok := ParseBatchProof([]byte(`{"proofs":["a","b"]}`))
+	assert.True(t, ok)
+	assert.Equal(t, BatchProof{Proofs: []string{"a", "b"}}, proof)
+
+	_, ok = ParseBatchProof([]byte(`{"proofs":[]}`))
+	assert.False(t, ok)
+
+	response, ok := ParseBatchResponse([]byte(`{"quotes":["a","b"]}`))
+	assert.True(t, ok)
+	assert.Equal(t, BatchResponse{Quotes: []string{"a", "b"}}, response)
+
+	_, ok = ParseBatchResponse([]byte("PoW verification failed"))
+	assert.False(t, ok)
+}
+
+func TestParseNegotiatedChallenge(t *testing.T) {
+	challenge, ok := ParseNegotiatedChallenge([]byte(`{"challenge":"challenge","bits":14,"accepted":false}`))
+	assert.True(t, ok)
+	assert.Equal(t, NegotiatedChallenge{Challenge: "challenge", Bits: 14}, challenge)
+
+	// a plain challenge header sent by a server not supporting negotiation
+	_, ok = ParseNegotiatedChallenge([]byte("1:12:2208082121:resource::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="))
+	assert.False(t, ok)
+}
+
+func TestParseEstimatedChallenge(t *testing.T) {
+	challenge, ok := ParseEstimatedChallenge([]byte(`{"challenge":"challenge","bits":20,"estimated_solve_ms":1048,"hint":"be patient"}`))
+	assert.True(t, ok)
+	assert.Equal(t, EstimatedChallenge{Challenge: "challenge", Bits: 20, EstimatedSolveMillis: 1048, Hint: "be patient"}, challenge)
+
+	// a plain challenge header sent by a server not supporting estimates
+	_, ok = ParseEstimatedChallenge([]byte("1:12:2208082121:resource::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="))
+	assert.False(t, ok)
+}
+
+func TestParseCatalog(t *testing.T) {
+	catalog, ok := ParseCatalog([]byte(`{"capabilities":["catalog"],"categories":["life","wit"]}`))
+	assert.True(t, ok)
+	assert.Equal(t, Catalog{Capabilities: []Capability{CapabilityCatalog}, Categories: []string{"life", "wit"}}, catalog)
+
+	// a challenge header sent instead of the catalog
+	_, ok = ParseCatalog([]byte("1:12:2208082121:resource::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="))
+	assert.False(t, ok)
+}
+
+func TestParseHello(t *testing.T) {
+	hello, ok := ParseHello([]byte(`{"version":1,"algorithm":"sha256"}`))
+	assert.True(t, ok)
+	assert.Equal(t, Hello{Version: 1, Algorithm: "sha256"}, hello)
+
+	// a challenge header sent by a server predating Hello
+	_, ok = ParseHello([]byte("1:12:2208082121:resource::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="))
+	assert.False(t, ok)
+
+	// a catalog isn't a Hello either
+	_, ok = ParseHello([]byte(`{"capabilities":["catalog"],"categories":[]}`))
+	assert.False(t, ok)
+}
+
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		want     Response
+		wantBody string
+	}{
+		{
+			name:     "challenge",
+			message:  `{"type":"challenge","message":"1:12:2208082121:resource::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="}`,
+			want:     NewMessage(ResponseChallenge, "1:12:2208082121:resource::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="),
+			wantBody: "1:12:2208082121:resource::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA==",
+		},
+		{
+			name:     "quote response",
+			message:  `{"type":"quote","data":{"quote":"quote","token":"token"}}`,
+			want:     NewData(ResponseQuote, []byte(`{"quote":"quote","token":"token"}`)),
+			wantBody: `{"quote":"quote","token":"token"}`,
+		},
+		{
+			name:     "error",
+			message:  `{"type":"error","code":"verification_failed","message":"PoW verification failed"}`,
+			want:     NewError(CodeVerificationFailed, "PoW verification failed"),
+			wantBody: "PoW verification failed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := ParseResponse([]byte(test.message))
+			assert.True(t, ok)
+			assert.Equal(t, test.want, got)
+			assert.Equal(t, test.wantBody, string(got.Payload()))
+		})
+	}
+
+	// a plain quote sent by a server predating Response
+	_, ok := ParseResponse([]byte("quote"))
+	assert.False(t, ok)
+
+	// a quote response isn't a Response either
+	_, ok = ParseResponse([]byte(`{"quote":"quote"}`))
+	assert.False(t, ok)
+}
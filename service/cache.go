@@ -0,0 +1,240 @@
+package service
+
+import (
+	"container/list"
+	"io"
+	"sync"
+	"time"
+)
+
+// CacheSettings holds CachedGetter settings.
+type CacheSettings struct {
+	// Size is the most quotes cached at once: the least recently used quote is evicted to cache a new one.
+	// It's 1000 if it's not positive.
+	Size int
+	// TTL is a time a quote is cached for; it's 1 minute if it's not positive.
+	TTL time.Duration
+	// RefreshInterval is a time quotes ids and categories are cached for; it's 1 minute if it's not positive.
+	RefreshInterval time.Duration
+	// RetryInterval is a time a failed refresh of quotes ids or categories is retried after;
+	// it's 5 seconds (or RefreshInterval, if it's shorter) if it's not positive.
+	RetryInterval time.Duration
+}
+
+// CachedGetter is a Getter decorator caching the results of a Getter hitting a backend on every call
+// (e.g. SQLGetter).
+//
+// Quotes are cached by ids in an LRU cache for TTL, while quotes ids and categories are cached as snapshots
+// refreshed once RefreshInterval has passed. A quote missing in the underlying Getter is not cached.
+// A refresh resulting in an empty list is taken for a failure (e.g. SQLGetter returns nil on a query error):
+// the previous snapshot is served on, and the refresh is retried once RetryInterval has passed.
+//
+// The underlying Getter is called without holding a lock, so a slow backend call doesn't block other lookups,
+// while concurrent misses of the same quote (or list) share a single backend call.
+type CachedGetter struct {
+	getter Getter
+
+	size            int
+	ttl             time.Duration
+	refreshInterval time.Duration
+	retryInterval   time.Duration
+
+	mu sync.Mutex
+	// quotes holds cached quotes ordered from the most to the least recently used one
+	quotes *list.List
+	byID   map[string]*list.Element
+	lists  map[listKey]snapshot
+	// backend calls in progress
+	getting map[string]*flight[string]
+	listing map[listKey]*flight[[]string]
+
+	now func() time.Time
+}
+
+// cachedQuote is an entry of the quotes cache.
+type cachedQuote struct {
+	id      string
+	text    string
+	expires time.Time
+}
+
+// listKey identifies a cached list: quotes ids (of a category, if it's set) or categories.
+type listKey struct {
+	categories bool
+	category   string
+}
+
+// snapshot is a cached list.
+type snapshot struct {
+	values  []string
+	expires time.Time
+}
+
+// flight is a backend call in progress, shared by concurrent misses of the same key.
+type flight[T any] struct {
+	done  chan struct{} // closed once the call is over
+	value T
+	err   error
+}
+
+// NewCachedGetter returns a new instance of CachedGetter caching the results of the getter.
+func NewCachedGetter(getter Getter, settings CacheSettings) *CachedGetter {
+	g := &CachedGetter{
+		getter:          getter,
+		size:            settings.Size,
+		ttl:             settings.TTL,
+		refreshInterval: settings.RefreshInterval,
+		retryInterval:   settings.RetryInterval,
+		quotes:          list.New(),
+		byID:            make(map[string]*list.Element),
+		lists:           make(map[listKey]snapshot),
+		getting:         make(map[string]*flight[string]),
+		listing:         make(map[listKey]*flight[[]string]),
+		now:             time.Now,
+	}
+
+	if g.size <= 0 {
+		g.size = 1000
+	}
+	if g.ttl <= 0 {
+		g.ttl = time.Minute
+	}
+	if g.refreshInterval <= 0 {
+		g.refreshInterval = time.Minute
+	}
+	if g.retryInterval <= 0 {
+		g.retryInterval = 5 * time.Second
+		if g.retryInterval > g.refreshInterval {
+			g.retryInterval = g.refreshInterval
+		}
+	}
+
+	return g
+}
+
+// Get returns a quote string by its id; it's empty if there's no such quote (see Lookup).
+func (g *CachedGetter) Get(id string) string {
+	text, _ := g.Lookup(id)
+	return text
+}
+
+// Lookup returns a quote string by its id.
+//
+// It returns the error of the underlying Getter (see LookupGetter), or an error matching ErrQuoteNotFound
+// if there's no such quote; errors are not cached.
+func (g *CachedGetter) Lookup(id string) (string, error) {
+	g.mu.Lock()
+	if e, ok := g.byID[id]; ok {
+		q := e.Value.(*cachedQuote)
+		if g.now().Before(q.expires) {
+			g.quotes.MoveToFront(e)
+			g.mu.Unlock()
+			return q.text, nil
+		}
+		g.quotes.Remove(e)
+		delete(g.byID, id)
+	}
+
+	// a concurrent miss of the same id is retrieving the quote already
+	if f, ok := g.getting[id]; ok {
+		g.mu.Unlock()
+		<-f.done
+		return f.value, f.err
+	}
+	f := &flight[string]{done: make(chan struct{})}
+	g.getting[id] = f
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.getting, id)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+
+	f.value, f.err = lookup(g.getter, id)
+	if f.err != nil {
+		return "", f.err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.byID[id] = g.quotes.PushFront(&cachedQuote{id: id, text: f.value, expires: g.now().Add(g.ttl)})
+	if g.quotes.Len() > g.size {
+		oldest := g.quotes.Back()
+		g.quotes.Remove(oldest)
+		delete(g.byID, oldest.Value.(*cachedQuote).id)
+	}
+
+	return f.value, nil
+}
+
+// GetIds returns a set of quotes ids.
+func (g *CachedGetter) GetIds() []string {
+	return g.list(listKey{}, g.getter.GetIds)
+}
+
+// GetIdsByCategory returns a set of quotes ids of a category.
+func (g *CachedGetter) GetIdsByCategory(category string) []string {
+	return g.list(listKey{category: category}, func() []string { return g.getter.GetIdsByCategory(category) })
+}
+
+// Categories returns a sorted set of quotes categories.
+func (g *CachedGetter) Categories() []string {
+	return g.list(listKey{categories: true}, g.getter.Categories)
+}
+
+// Close closes the underlying Getter, if it implements io.Closer.
+func (g *CachedGetter) Close() error {
+	if closer, ok := g.getter.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// list returns a copy of a cached list, which is refreshed with get once it's expired.
+//
+// A failed (empty) refresh keeps the previous list, if there's one, and expires sooner (see RetryInterval).
+func (g *CachedGetter) list(key listKey, get func() []string) []string {
+	g.mu.Lock()
+	if s, ok := g.lists[key]; ok && g.now().Before(s.expires) {
+		g.mu.Unlock()
+		return append([]string(nil), s.values...)
+	}
+
+	// a concurrent miss of the same list is refreshing it already
+	if f, ok := g.listing[key]; ok {
+		g.mu.Unlock()
+		<-f.done
+		return append([]string(nil), f.value...)
+	}
+	f := &flight[[]string]{done: make(chan struct{})}
+	g.listing[key] = f
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.listing, key)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+
+	values := get()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	expires := g.now().Add(g.refreshInterval)
+	if len(values) == 0 {
+		if previous, ok := g.lists[key]; ok {
+			values = previous.values
+		}
+		expires = g.now().Add(g.retryInterval)
+	}
+	f.value = values
+	g.lists[key] = snapshot{values: values, expires: expires}
+
+	return append([]string(nil), f.value...)
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingGetter is a Getter counting the calls of its methods.
+type countingGetter struct {
+	quotes map[string]string
+	ids    []string
+
+	gets   map[string]int
+	idsHit int
+}
+
+func newCountingGetter() *countingGetter {
+	return &countingGetter{
+		quotes: map[string]string{"id_1": "quote_1", "id_2": "quote_2", "id_3": "quote_3"},
+		ids:    []string{"id_1", "id_2", "id_3"},
+		gets:   make(map[string]int),
+	}
+}
+
+func (g *countingGetter) Get(id string) string {
+	g.gets[id]++
+	return g.quotes[id]
+}
+
+func (g *countingGetter) GetIds() []string {
+	g.idsHit++
+	return g.ids
+}
+
+func (g *countingGetter) GetIdsByCategory(string) []string { return nil }
+
+func (g *countingGetter) Categories() []string { return nil }
+
+// newCachedTestGetter returns a CachedGetter with a fake clock, which is advanced by the returned function.
+func newCachedTestGetter(getter Getter, settings CacheSettings) (*CachedGetter, func(time.Duration)) {
+	now := time.Unix(0, 0)
+
+	g := NewCachedGetter(getter, settings)
+	g.now = func() time.Time { return now }
+
+	return g, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCachedGetter_Get(t *testing.T) {
+	counting := newCountingGetter()
+	g, advance := newCachedTestGetter(counting, CacheSettings{TTL: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "quote_1", g.Get("id_1"))
+		assert.Equal(t, "quote_2", g.Get("id_2"))
+		advance(10 * time.Second)
+	}
+	assert.Equal(t, map[string]int{"id_1": 1, "id_2": 1}, counting.gets)
+
+	// an expired quote is retrieved again
+	advance(time.Minute)
+	assert.Equal(t, "quote_1", g.Get("id_1"))
+	assert.Equal(t, 2, counting.gets["id_1"])
+
+	// a missing quote is not cached
+	assert.Equal(t, "", g.Get("id_4"))
+	_, err := g.Lookup("id_4")
+	assert.ErrorIs(t, err, ErrQuoteNotFound)
+	assert.Equal(t, 2, counting.gets["id_4"])
+}
+
+func TestCachedGetter_Get_eviction(t *testing.T) {
+	counting := newCountingGetter()
+	g, _ := newCachedTestGetter(counting, CacheSettings{Size: 2})
+
+	g.Get("id_1")
+	g.Get("id_2")
+	g.Get("id_1") // id_2 becomes the least recently used quote
+	g.Get("id_3") // id_2 is evicted
+
+	g.Get("id_1")
+	g.Get("id_3")
+	assert.Equal(t, map[string]int{"id_1": 1, "id_2": 1, "id_3": 1}, counting.gets)
+
+	g.Get("id_2")
+	assert.Equal(t, 2, counting.gets["id_2"])
+}
+
+func TestCachedGetter_GetIds(t *testing.T) {
+	counting := newCountingGetter()
+	g, advance := newCachedTestGetter(counting, CacheSettings{RefreshInterval: time.Minute})
+
+	assert.Equal(t, []string{"id_1", "id_2", "id_3"}, g.GetIds())
+	advance(30 * time.Second)
+
+	// the snapshot is a copy, so it's safe to modify
+	ids := g.GetIds()
+	ids[0] = "modified"
+	assert.Equal(t, []string{"id_1", "id_2", "id_3"}, g.GetIds())
+	assert.Equal(t, 1, counting.idsHit)
+
+	// the snapshot is refreshed once the interval has passed
+	counting.ids = []string{"id_1"}
+	advance(30 * time.Second)
+	assert.Equal(t, []string{"id_1"}, g.GetIds())
+	assert.Equal(t, 2, counting.idsHit)
+}
+
+func TestCachedGetter_GetIds_failed_refresh(t *testing.T) {
+	counting := newCountingGetter()
+	counting.ids = nil
+	g, advance := newCachedTestGetter(counting, CacheSettings{RefreshInterval: time.Minute, RetryInterval: time.Second})
+
+	// the getter fails at first (e.g. SQLGetter on a query error), so the refresh is retried soon
+	assert.Empty(t, g.GetIds())
+	counting.ids = []string{"id_1", "id_2"}
+	assert.Empty(t, g.GetIds())
+	advance(time.Second)
+	assert.Equal(t, []string{"id_1", "id_2"}, g.GetIds())
+	assert.Equal(t, 2, counting.idsHit)
+
+	// a failed refresh doesn't wipe the snapshot out: it's served on until a retry succeeds
+	counting.ids = nil
+	advance(time.Minute)
+	assert.Equal(t, []string{"id_1", "id_2"}, g.GetIds())
+	advance(time.Second)
+	assert.Equal(t, []string{"id_1", "id_2"}, g.GetIds())
+	assert.Equal(t, 4, counting.idsHit)
+
+	counting.ids = []string{"id_3"}
+	advance(time.Second)
+	assert.Equal(t, []string{"id_3"}, g.GetIds())
+	assert.Equal(t, 5, counting.idsHit)
+}
+
+// slowGetter is a countingGetter blocking the retrieval of "slow" quote until it's released.
+type slowGetter struct {
+	*countingGetter
+
+	mu       sync.Mutex
+	slowHits int32
+	started  chan struct{}
+	release  chan struct{}
+}
+
+func (g *slowGetter) Get(id string) string {
+	if id != "slow" {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		return g.countingGetter.Get(id)
+	}
+
+	atomic.AddInt32(&g.slowHits, 1)
+	select {
+	case g.started <- struct{}{}:
+	default:
+	}
+	<-g.release
+
+	return "slow quote"
+}
+
+func TestCachedGetter_Lookup_slow_backend(t *testing.T) {
+	getter := &slowGetter{countingGetter: newCountingGetter(), started: make(chan struct{}, 1), release: make(chan struct{})}
+	g := NewCachedGetter(getter, CacheSettings{})
+
+	const lookups = 5
+	quotes := make(chan string, lookups)
+	for i := 0; i < lookups; i++ {
+		go func() {
+			quote, _ := g.Lookup("slow")
+			quotes <- quote
+		}()
+	}
+	<-getter.started
+
+	// other quotes are retrieved while the slow one is in progress
+	assert.Equal(t, "quote_1", g.Get("id_1"))
+
+	close(getter.release)
+	for i := 0; i < lookups; i++ {
+		assert.Equal(t, "slow quote", <-quotes)
+	}
+
+	// concurrent misses share a single backend call
+	assert.EqualValues(t, 1, atomic.LoadInt32(&getter.slowHits))
+}
+
+func TestWordOfWisdomService_Quote_cached(t *testing.T) {
+	counting := newCountingGetter()
+	counting.ids = []string{"id_1"}
+	g, advance := newCachedTestGetter(counting, CacheSettings{RefreshInterval: time.Minute})
+
+	src := NewWordOfWisdomService(g, nil)
+
+	quote, err := src.Quote()
+	assert.Nil(t, err)
+	assert.Equal(t, "quote_1", quote)
+
+	// the service selects among the ids refreshed by the cache
+	counting.ids = []string{"id_2"}
+	advance(time.Minute)
+
+	quote, err = src.Quote()
+	assert.Nil(t, err)
+	assert.Equal(t, "quote_2", quote)
+}
+
+// closingGetter is a countingGetter recording whether it's closed.
+type closingGetter struct {
+	*countingGetter
+	closed bool
+}
+
+func (g *closingGetter) Close() error {
+	g.closed = true
+	return nil
+}
+
+func TestCachedGetter_Close(t *testing.T) {
+	getter := &closingGetter{countingGetter: newCountingGetter()}
+
+	assert.Nil(t, NewCachedGetter(getter, CacheSettings{}).Close())
+	assert.True(t, getter.closed)
+
+	// a Getter holding no resources is not closed
+	assert.Nil(t, NewCachedGetter(newCountingGetter(), CacheSettings{}).Close())
+}
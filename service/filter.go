@@ -0,0 +1,107 @@
+package service
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Reasons a quote is skipped by QuoteFilter.
+const (
+	// SkipEmpty is a reason to skip a quote with no text left once it's normalized.
+	SkipEmpty = "empty"
+	// SkipTooLong is a reason to skip a quote exceeding QuoteFilter.MaxLength once it's normalized.
+	SkipTooLong = "too long"
+)
+
+// QuoteFilter normalizes quotes and skips invalid ones, so they are never selected (see FileGetter.Filter).
+//
+// A quote is normalized as follows: whitespace control characters (e.g. "\n") are replaced with a space,
+// other control characters are stripped, and leading and trailing whitespace is trimmed.
+type QuoteFilter struct {
+	// MaxLength is the longest quote in bytes once it's normalized, if it's set: longer quotes are skipped
+	// (e.g. so a quote fits a client read buffer).
+	MaxLength int
+	// OnSkip is called with the id of every skipped quote and the reason (e.g. SkipTooLong), if it's set.
+	OnSkip func(id, reason string)
+}
+
+// normalize returns a quote with control characters stripped and whitespace trimmed (see QuoteFilter).
+func normalize(quote string) string {
+	quote = strings.Map(func(r rune) rune {
+		switch {
+		case !unicode.IsControl(r):
+			return r
+		case unicode.IsSpace(r):
+			return ' '
+		default:
+			return -1
+		}
+	}, quote)
+
+	return strings.TrimSpace(quote)
+}
+
+// skip returns the reason to skip a normalized quote, or an empty string if the quote is valid.
+func (f QuoteFilter) skip(quote string) string {
+	switch {
+	case quote == "":
+		return SkipEmpty
+	case f.MaxLength > 0 && len(quote) > f.MaxLength:
+		return SkipTooLong
+	default:
+		return ""
+	}
+}
+
+// apply returns a new set of the normalized quotes of a set, invalid quotes are skipped.
+//
+// Quotes are checked in order of their ids, so skipped quotes are reported in a stable order.
+func (f QuoteFilter) apply(set *quoteSet) *quoteSet {
+	filtered := &quoteSet{
+		quotes:     make(map[string]string, len(set.quotes)),
+		ids:        make([]string, 0, len(set.ids)),
+		categories: make(map[string][]string, len(set.categories)),
+	}
+
+	for _, id := range set.ids {
+		quote := normalize(set.quotes[id])
+		if reason := f.skip(quote); reason != "" {
+			if f.OnSkip != nil {
+				f.OnSkip(id, reason)
+			}
+			continue
+		}
+
+		filtered.quotes[id] = quote
+		filtered.ids = append(filtered.ids, id)
+	}
+
+	// category ids are sorted already, so they stay sorted
+	for category, ids := range set.categories {
+		for _, id := range ids {
+			if _, ok := filtered.quotes[id]; ok {
+				filtered.categories[category] = append(filtered.categories[category], id)
+			}
+		}
+	}
+
+	return filtered
+}
+
+// Filter normalizes the stored quotes and skips invalid ones with the filter (see QuoteFilter).
+func (g *FileGetter) Filter(filter QuoteFilter) {
+	g.rw.Lock()
+	defer g.rw.Unlock()
+
+	g.set = filter.apply(g.set)
+}
+
+// Filter normalizes the stored quotes and skips invalid ones with the filter (see QuoteFilter),
+// and so are the quotes loaded on every subsequent Reload.
+func (g *ReloadableFileGetter) Filter(filter QuoteFilter) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.filter = &filter
+	g.FileGetter.Filter(filter)
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	for quote, want := range map[string]string{
+		"  Know thyself.\n":          "Know thyself.",
+		"Line one\nline two":         "Line one line two",
+		"Be\x07 kind.\x00":           "Be kind.",
+		"\x1b[31mred\x1b[0m":         "[31mred[0m",
+		"\u00a0non-breaking space\t": "non-breaking space",
+		"\x00\x01\r\n":               "",
+	} {
+		assert.Equal(t, want, normalize(quote), "%q", quote)
+	}
+}
+
+func TestFileGetter_Filter(t *testing.T) {
+	set, err := parseQuotes([]byte(`{
+		"valid": {"category": "stoic", "text": "  The obstacle is the way.\n"},
+		"control": {"category": "stoic", "text": "Be\u0007 kind.\u0000"},
+		"blank": "\u0000\u001b\r\n",
+		"oversized": {"category": "wit", "text": "` + strings.Repeat("a", 1025) + `"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	getter := &FileGetter{set: set}
+
+	skipped := make(map[string]string)
+	getter.Filter(QuoteFilter{MaxLength: 1024, OnSkip: func(id, reason string) { skipped[id] = reason }})
+
+	assert.Equal(t, map[string]string{"blank": SkipEmpty, "oversized": SkipTooLong}, skipped)
+	assert.Equal(t, []string{"control", "valid"}, getter.GetIds())
+	assert.Equal(t, []string{"stoic"}, getter.Categories())
+
+	// the skipped quotes are never selected, and the rest are served normalized
+	srv := NewWordOfWisdomService(getter, nil)
+	selected := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		quote, err := srv.Quote()
+		assert.Nil(t, err)
+		selected[quote] = true
+	}
+	assert.Equal(t, map[string]bool{"The obstacle is the way.": true, "Be kind.": true}, selected)
+
+	_, err = srv.QuoteByCategory("wit")
+	assert.ErrorIs(t, err, ErrUnknownCategory)
+}
+
+func TestReloadableFileGetter_Filter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quote.json")
+	writeQuotes(t, path, `{"id_1":"quote_1","id_2":"too long quote"}`)
+
+	getter, err := NewReloadableFileGetter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getter.Filter(QuoteFilter{MaxLength: 10})
+	assert.Equal(t, []string{"id_1"}, getter.GetIds())
+
+	// reloaded quotes are filtered as well
+	writeQuotes(t, path, `{"id_2":"too long quote","id_3":" quote_3 "}`)
+	assert.Nil(t, getter.Reload())
+
+	assert.Equal(t, []string{"id_3"}, getter.GetIds())
+	assert.Equal(t, "quote_3", getter.Get("id_3"))
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxQuotesSize is an upper limit of a quotes document size fetched over HTTP.
+const maxQuotesSize = 10 << 20
+
+// HTTPGetter is an implementation of Getter to retrieve quotes from a remote corpus over HTTP.
+//
+// Quotes are fetched once at construction and served from memory afterwards.
+type HTTPGetter struct {
+	FileGetter
+
+	url string
+}
+
+// NewHTTPGetter returns a new instance of HTTPGetter with quotes fetched from a URL within the timeout.
+//
+// The URL must serve a JSON document of the same format as the embedded quotes file.
+// If fetching fails, the returned HTTPGetter falls back to the embedded quotes, and the failure is returned along with it.
+func NewHTTPGetter(url string, timeout time.Duration) (*HTTPGetter, error) {
+	g := &HTTPGetter{url: url}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	set, err := fetchQuotes(ctx, url)
+	if err != nil {
+		g.swap(NewFileGetter().current())
+
+		return g, err
+	}
+	g.swap(set)
+
+	return g, nil
+}
+
+// fetchQuotes returns a set of quotes fetched from a URL.
+func fetchQuotes(ctx context.Context, url string) (*quoteSet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create quotes request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch quotes: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch quotes: unexpected status %q", resp.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxQuotesSize))
+	if err != nil {
+		return nil, fmt.Errorf("read quotes: %w", err)
+	}
+
+	return parseQuotes(b)
+}
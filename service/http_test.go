@@ -0,0 +1,93 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewHTTPGetter(t *testing.T) {
+	embedded := NewFileGetter().GetIds()
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		wantErr bool
+		wantIds []string
+	}{
+		{
+			name: "success",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(`{"id_1":{"category":"stoic","text":"quote_1"},"id_2":"quote_2"}`))
+			},
+			wantIds: []string{"id_1", "id_2"},
+		},
+		{
+			name: "non-200 response",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			wantErr: true,
+			wantIds: embedded,
+		},
+		{
+			name: "malformed JSON",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(`{"id_1":`))
+			},
+			wantErr: true,
+			wantIds: embedded,
+		},
+		{
+			name: "timeout",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(time.Second):
+				}
+			},
+			wantErr: true,
+			wantIds: embedded,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(test.handler)
+			defer server.Close()
+
+			getter, err := NewHTTPGetter(server.URL, 100*time.Millisecond)
+			if test.wantErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+
+			// the getter is usable either way
+			assert.ElementsMatch(t, test.wantIds, getter.GetIds())
+			for _, id := range getter.GetIds() {
+				assert.NotEmpty(t, getter.Get(id))
+			}
+		})
+	}
+}
+
+func TestNewHTTPGetter_fallback(t *testing.T) {
+	// nothing listens on the URL
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	getter, err := NewHTTPGetter(url, time.Second)
+	assert.NotNil(t, err)
+
+	srv := NewWordOfWisdomService(getter, nil)
+	quote, err := srv.Quote()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, quote)
+
+	assert.Equal(t, NewFileGetter().Categories(), getter.Categories())
+}
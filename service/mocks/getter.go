@@ -9,6 +9,22 @@ type Getter struct {
 	mock.Mock
 }
 
+// Categories provides a mock function with given fields:
+func (_m *Getter) Categories() []string {
+	ret := _m.Called()
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func() []string); ok {
+		r0 = rf()
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	return r0
+}
+
 // Get provides a mock function with given fields: id
 func (_m *Getter) Get(id string) string {
 	ret := _m.Called(id)
@@ -39,6 +55,22 @@ func (_m *Getter) GetIds() []string {
 	return r0
 }
 
+// GetIdsByCategory provides a mock function with given fields: category
+func (_m *Getter) GetIdsByCategory(category string) []string {
+	ret := _m.Called(category)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(string) []string); ok {
+		r0 = rf(category)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	return r0
+}
+
 type mockConstructorTestingTNewGetter interface {
 	mock.TestingT
 	Cleanup(func())
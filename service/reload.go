@@ -0,0 +1,73 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ReloadableFileGetter is an implementation of Getter to retrieve quotes from a file on the filesystem.
+//
+// Unlike FileGetter, it's able to reload quotes at runtime without a restart (see Reload).
+type ReloadableFileGetter struct {
+	FileGetter
+
+	path string
+
+	// mu serializes reloads
+	mu       sync.Mutex
+	onReload []func()
+	// filter is applied to reloaded quotes, if it's set (see Filter)
+	filter *QuoteFilter
+}
+
+// NewReloadableFileGetter returns a new instance of ReloadableFileGetter with quotes loaded from a file.
+//
+// The file has the same format as the embedded quotes file.
+func NewReloadableFileGetter(path string) (*ReloadableFileGetter, error) {
+	g := &ReloadableFileGetter{path: path}
+	if err := g.Reload(); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// Reload reads quotes from the file again and replaces the stored ones at once.
+//
+// If the file cannot be read or parsed, the stored quotes are kept.
+// If a filter is set (see Filter), reloaded quotes are filtered before they replace the stored ones.
+// Once quotes are replaced, the callbacks registered with OnReload are called.
+func (g *ReloadableFileGetter) Reload() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	b, err := os.ReadFile(g.path)
+	if err != nil {
+		return fmt.Errorf("read quotes file: %w", err)
+	}
+
+	set, err := parseQuotes(b)
+	if err != nil {
+		return err
+	}
+	if g.filter != nil {
+		set = g.filter.apply(set)
+	}
+
+	g.swap(set)
+
+	for _, f := range g.onReload {
+		f()
+	}
+
+	return nil
+}
+
+// OnReload registers a callback to be called once quotes have been reloaded.
+func (g *ReloadableFileGetter) OnReload(f func()) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.onReload = append(g.onReload, f)
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeQuotes(t *testing.T, path, content string) {
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadableFileGetter_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quote.json")
+	writeQuotes(t, path, `{"id_1":{"category":"stoic","text":"quote_1"},"id_2":"quote_2"}`)
+
+	getter, err := NewReloadableFileGetter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewWordOfWisdomService(getter, nil)
+
+	assert.ElementsMatch(t, []string{"id_1", "id_2"}, getter.GetIds())
+	assert.Equal(t, []string{"stoic"}, getter.Categories())
+
+	// id_1 is removed, and id_3 is added
+	writeQuotes(t, path, `{"id_2":"quote_2","id_3":{"category":"wit","text":"quote_3"}}`)
+	assert.Nil(t, getter.Reload())
+
+	assert.ElementsMatch(t, []string{"id_2", "id_3"}, getter.GetIds())
+	assert.Empty(t, getter.Get("id_1"))
+	assert.Equal(t, "quote_3", getter.Get("id_3"))
+	assert.Equal(t, []string{"wit"}, getter.Categories())
+
+	// the service selects among the reloaded quotes only
+	selected := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		quote, err := srv.Quote()
+		assert.Nil(t, err)
+		selected[quote] = true
+	}
+	assert.Equal(t, map[string]bool{"quote_2": true, "quote_3": true}, selected)
+
+	quote, err := srv.QuoteByCategory("wit")
+	assert.Nil(t, err)
+	assert.Equal(t, "quote_3", quote)
+
+	_, err = srv.QuoteByCategory("stoic")
+	assert.ErrorIs(t, err, ErrUnknownCategory)
+}
+
+func TestReloadableFileGetter_Reload_error(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quote.json")
+
+	_, err := NewReloadableFileGetter(path)
+	assert.NotNil(t, err)
+
+	writeQuotes(t, path, `{"id_1":"quote_1"}`)
+	getter, err := NewReloadableFileGetter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a malformed file is rejected, and the stored quotes are kept
+	writeQuotes(t, path, `{"id_2":`)
+	assert.NotNil(t, getter.Reload())
+	assert.Equal(t, []string{"id_1"}, getter.GetIds())
+
+	// a removed file is rejected as well
+	assert.Nil(t, os.Remove(path))
+	assert.NotNil(t, getter.Reload())
+	assert.Equal(t, "quote_1", getter.Get("id_1"))
+}
+
+func TestReloadableFileGetter_Reload_concurrent_quotes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quote.json")
+
+	// every reload replaces all the quotes, so a selection out of sync with the stored quotes fails a lookup
+	contents := []string{
+		`{"id_1":{"category":"stoic","text":"quote_1"},"id_2":{"category":"stoic","text":"quote_2"}}`,
+		`{"id_3":{"category":"stoic","text":"quote_3"},"id_4":{"category":"stoic","text":"quote_4"},"id_5":"quote_5"}`,
+	}
+	writeQuotes(t, path, contents[0])
+
+	getter, err := NewReloadableFileGetter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewWordOfWisdomService(getter, nil)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				quote, err := srv.Quote()
+				assert.Nil(t, err)
+				assert.NotEmpty(t, quote)
+
+				quote, err = srv.QuoteByCategory("stoic")
+				assert.Nil(t, err)
+				assert.NotEmpty(t, quote)
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		writeQuotes(t, path, contents[i%2])
+		assert.Nil(t, getter.Reload())
+	}
+	close(done)
+	wg.Wait()
+}
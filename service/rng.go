@@ -0,0 +1,77 @@
+package service
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RNG is a contract to get random numbers to select quotes with.
+type RNG interface {
+	// Intn returns a random number of interval [0, n).
+	Intn(n int) (int, error)
+}
+
+// MathRNG is an implementation of RNG backed by the global math/rand source.
+//
+// It's fast, but its output is predictable once the seed is known.
+type MathRNG struct{}
+
+// Intn returns a random number of interval [0, n).
+func (MathRNG) Intn(n int) (int, error) {
+	return rand.Intn(n), nil
+}
+
+// SourceRNG is an implementation of RNG backed by a dedicated math/rand source,
+// so it doesn't rely on the global source seed, and it's isolated from other users of math/rand.
+//
+// A source with a fixed seed makes selection reproducible (e.g. in tests).
+type SourceRNG struct {
+	mu sync.Mutex // a math/rand source is not safe for concurrent use
+	r  *rand.Rand
+}
+
+// NewSourceRNG returns a new instance of SourceRNG backed by the source.
+func NewSourceRNG(src rand.Source) *SourceRNG {
+	return &SourceRNG{r: rand.New(src)}
+}
+
+// NewTimeSeededRNG returns a new instance of SourceRNG backed by a source seeded with the current time.
+func NewTimeSeededRNG() *SourceRNG {
+	return NewSourceRNG(rand.NewSource(time.Now().UnixNano()))
+}
+
+// Intn returns a random number of interval [0, n).
+func (s *SourceRNG) Intn(n int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.r.Intn(n), nil
+}
+
+// CryptoRNG is an implementation of RNG backed by the cryptographically secure crypto/rand source.
+type CryptoRNG struct{}
+
+// Intn returns a random number of interval [0, n).
+func (CryptoRNG) Intn(n int) (int, error) {
+	v, err := crand.Int(crand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("read crypto random number: %w", err)
+	}
+
+	return int(v.Int64()), nil
+}
+
+// RNGOf returns an RNG corresponding to an argument string: "crypto" for CryptoRNG,
+// a time-seeded SourceRNG otherwise.
+func RNGOf(source string) RNG {
+	if strings.ToLower(source) == "crypto" {
+		return CryptoRNG{}
+	}
+
+	return NewTimeSeededRNG()
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/maps"
+
+	"github.com/laonix/pow-word-of-wisdom/service/mocks"
+)
+
+func TestRNGOf(t *testing.T) {
+	assert.Equal(t, CryptoRNG{}, RNGOf("crypto"))
+	assert.Equal(t, CryptoRNG{}, RNGOf("Crypto"))
+	assert.IsType(t, &SourceRNG{}, RNGOf("math"))
+	assert.IsType(t, &SourceRNG{}, RNGOf(""))
+}
+
+func TestWordOfWisdomService_Quote_rng(t *testing.T) {
+	quotesSource := make(map[string]string, 5)
+	for i := 0; i < 5; i++ {
+		quotesSource[fmt.Sprintf("id_%d", i)] = fmt.Sprintf("quote_%d", i)
+	}
+
+	for _, rng := range []RNG{MathRNG{}, NewTimeSeededRNG(), CryptoRNG{}} {
+		t.Run(fmt.Sprintf("%T", rng), func(t *testing.T) {
+			getter := mocks.NewGetter(t)
+			for id, quote := range quotesSource {
+				getter.On("Get", id).Maybe().Return(quote)
+			}
+			getter.On("GetIds").Return(maps.Keys(quotesSource))
+
+			srv := NewWordOfWisdomService(getter, rng)
+
+			const samples = 10000
+			selected := make(map[string]int, len(quotesSource))
+			for i := 0; i < samples; i++ {
+				quote, err := srv.Quote()
+				assert.Nil(t, err)
+				selected[quote]++
+			}
+
+			// only valid quotes are selected, and every quote is selected about equally often
+			assert.ElementsMatch(t, maps.Values(quotesSource), maps.Keys(selected))
+
+			expected := samples / len(quotesSource)
+			for quote, n := range selected {
+				assert.InDelta(t, expected, n, float64(expected)*0.1, "quote %s", quote)
+			}
+		})
+	}
+}
+
+func TestSourceRNG_reproducible(t *testing.T) {
+	quotesSource := make(map[string]string, 5)
+	for i := 0; i < 5; i++ {
+		quotesSource[fmt.Sprintf("id_%d", i)] = fmt.Sprintf("quote_%d", i)
+	}
+	getter := &FileGetter{set: &quoteSet{quotes: quotesSource, ids: maps.Keys(quotesSource)}}
+	sort.Strings(getter.set.ids)
+
+	sequence := func(seed int64) []string {
+		srv := NewWordOfWisdomService(getter, NewSourceRNG(rand.NewSource(seed)))
+
+		quotes := make([]string, 0, 10)
+		for i := 0; i < 10; i++ {
+			quote, err := srv.Quote()
+			assert.Nil(t, err)
+			quotes = append(quotes, quote)
+		}
+
+		return quotes
+	}
+
+	// the same seed makes the same selection regardless of the global source
+	first := sequence(42)
+	assert.Equal(t, []string{"quote_0", "quote_2", "quote_3", "quote_0", "quote_3",
+		"quote_0", "quote_2", "quote_1", "quote_3", "quote_3"}, first)
+
+	rand.Seed(7)
+	assert.Equal(t, first, sequence(42))
+	assert.NotEqual(t, first, sequence(43))
+}
+
+func TestCryptoRNG_Intn(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		n, err := CryptoRNG{}.Intn(3)
+		assert.Nil(t, err)
+		assert.True(t, n >= 0 && n < 3)
+	}
+}
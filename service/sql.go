@@ -0,0 +1,160 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// SQLSettings holds SQLGetter settings.
+type SQLSettings struct {
+	// Table is a name of the quotes table; it's "quotes" if it's empty.
+	Table string
+	// Placeholder is a query parameter placeholder of the database driver:
+	// "?" (e.g. MySQL or SQLite, the default one) or "$1" (e.g. PostgreSQL).
+	Placeholder string
+	// OnError is called on query errors, if it's set, since Getter doesn't return errors:
+	// a failed query results in no quotes (see Lookup for a quote query returning them).
+	OnError func(err error)
+}
+
+// SQLGetter is an implementation of Getter to retrieve quotes from a SQL database with any database/sql driver.
+//
+// Quotes are stored in a table of the following schema (a quote of no category has an empty category):
+//
+//	CREATE TABLE quotes (
+//		id       VARCHAR(64)  PRIMARY KEY,
+//		category VARCHAR(64)  NOT NULL DEFAULT '',
+//		text     TEXT         NOT NULL
+//	);
+//
+// Quotes are queried on every call, so the table might be updated while the server is running
+// (see WordOfWisdomService.IdsRefreshInterval for quotes ids to be listed again).
+type SQLGetter struct {
+	get           *sql.Stmt
+	ids           *sql.Stmt
+	idsByCategory *sql.Stmt
+	categories    *sql.Stmt
+
+	onError func(err error)
+}
+
+// NewSQLGetter returns a new instance of SQLGetter with the queries prepared against the database.
+func NewSQLGetter(db *sql.DB, settings SQLSettings) (*SQLGetter, error) {
+	table, placeholder := settings.Table, settings.Placeholder
+	if table == "" {
+		table = "quotes"
+	}
+	if placeholder == "" {
+		placeholder = "?"
+	}
+
+	g := &SQLGetter{onError: settings.OnError}
+
+	queries := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{stmt: &g.get, query: fmt.Sprintf("SELECT text FROM %s WHERE id = %s", table, placeholder)},
+		{stmt: &g.ids, query: fmt.Sprintf("SELECT id FROM %s ORDER BY id", table)},
+		{stmt: &g.idsByCategory, query: fmt.Sprintf("SELECT id FROM %s WHERE category = %s ORDER BY id", table, placeholder)},
+		{stmt: &g.categories, query: fmt.Sprintf("SELECT DISTINCT category FROM %s WHERE category <> '' ORDER BY category", table)},
+	}
+	for _, q := range queries {
+		stmt, err := db.Prepare(q.query)
+		if err != nil {
+			_ = g.Close()
+			return nil, fmt.Errorf("prepare quotes query %q: %w", q.query, err)
+		}
+		*q.stmt = stmt
+	}
+
+	return g, nil
+}
+
+// Get returns a quote string by its id; it's empty if there's no such quote or the query fails.
+func (g *SQLGetter) Get(id string) string {
+	text, _ := g.Lookup(id)
+	return text
+}
+
+// Lookup returns a quote string by its id.
+//
+// It returns an error matching ErrQuoteNotFound if there's no such quote, or the query error.
+func (g *SQLGetter) Lookup(id string) (string, error) {
+	var text string
+	if err := g.get.QueryRow(id).Scan(&text); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("%w: %q", ErrQuoteNotFound, id)
+		}
+
+		err = fmt.Errorf("query quote %q: %w", id, err)
+		g.fail(err)
+		return "", err
+	}
+
+	return text, nil
+}
+
+// GetIds returns a set of stored quotes ids.
+func (g *SQLGetter) GetIds() []string {
+	return g.list(g.ids, "query quotes ids")
+}
+
+// GetIdsByCategory returns a set of stored quotes ids of a category.
+func (g *SQLGetter) GetIdsByCategory(category string) []string {
+	return g.list(g.idsByCategory, fmt.Sprintf("query quotes ids of category %q", category), category)
+}
+
+// Categories returns a sorted set of stored quotes categories.
+func (g *SQLGetter) Categories() []string {
+	return g.list(g.categories, "query quotes categories")
+}
+
+// Close closes the prepared statements.
+func (g *SQLGetter) Close() error {
+	var first error
+	for _, stmt := range []*sql.Stmt{g.get, g.ids, g.idsByCategory, g.categories} {
+		if stmt == nil {
+			continue
+		}
+		if err := stmt.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// list returns the values of a single column selected by a statement.
+func (g *SQLGetter) list(stmt *sql.Stmt, action string, args ...any) []string {
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		g.fail(fmt.Errorf("%s: %w", action, err))
+		return nil
+	}
+	defer rows.Close()
+
+	values := make([]string, 0)
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			g.fail(fmt.Errorf("%s: %w", action, err))
+			return nil
+		}
+		values = append(values, v)
+	}
+	if err := rows.Err(); err != nil {
+		g.fail(fmt.Errorf("%s: %w", action, err))
+		return nil
+	}
+
+	return values
+}
+
+// fail reports a query error, if OnError is set.
+func (g *SQLGetter) fail(err error) {
+	if g.onError != nil {
+		g.onError(err)
+	}
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// newSQLMockGetter returns an SQLGetter backed by a mocked database and the expectations of its prepared queries.
+func newSQLMockGetter(t *testing.T, settings SQLSettings) (*SQLGetter, sqlmock.Sqlmock, []*sqlmock.ExpectedPrepare) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	prepared := []*sqlmock.ExpectedPrepare{
+		mock.ExpectPrepare("SELECT text FROM quotes WHERE id = ?"),
+		mock.ExpectPrepare("SELECT id FROM quotes ORDER BY id"),
+		mock.ExpectPrepare("SELECT id FROM quotes WHERE category = ? ORDER BY id"),
+		mock.ExpectPrepare("SELECT DISTINCT category FROM quotes WHERE category <> '' ORDER BY category"),
+	}
+
+	g, err := NewSQLGetter(db, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return g, mock, prepared
+}
+
+func TestSQLGetter(t *testing.T) {
+	g, mock, prepared := newSQLMockGetter(t, SQLSettings{})
+	get, ids, idsByCategory, categories := prepared[0], prepared[1], prepared[2], prepared[3]
+
+	ids.ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("id_1").AddRow("id_2"))
+	assert.Equal(t, []string{"id_1", "id_2"}, g.GetIds())
+
+	get.ExpectQuery().WithArgs("id_1").WillReturnRows(sqlmock.NewRows([]string{"text"}).AddRow("quote_1"))
+	assert.Equal(t, "quote_1", g.Get("id_1"))
+
+	// a missing id results in an empty quote, or ErrQuoteNotFound with Lookup
+	get.ExpectQuery().WithArgs("id_3").WillReturnRows(sqlmock.NewRows([]string{"text"}))
+	assert.Equal(t, "", g.Get("id_3"))
+	get.ExpectQuery().WithArgs("id_3").WillReturnRows(sqlmock.NewRows([]string{"text"}))
+	_, err := g.Lookup("id_3")
+	assert.ErrorIs(t, err, ErrQuoteNotFound)
+
+	idsByCategory.ExpectQuery().WithArgs("stoic").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("id_2"))
+	assert.Equal(t, []string{"id_2"}, g.GetIdsByCategory("stoic"))
+
+	categories.ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"category"}).AddRow("stoic"))
+	assert.Equal(t, []string{"stoic"}, g.Categories())
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLGetter_errors(t *testing.T) {
+	var failures []error
+	g, mock, prepared := newSQLMockGetter(t, SQLSettings{OnError: func(err error) { failures = append(failures, err) }})
+	get, ids := prepared[0], prepared[1]
+
+	// a failed query results in no quotes, and the error is reported
+	ids.ExpectQuery().WillReturnError(errors.New("connection refused"))
+	assert.Empty(t, g.GetIds())
+
+	get.ExpectQuery().WithArgs("id_1").WillReturnError(errors.New("connection refused"))
+	assert.Equal(t, "", g.Get("id_1"))
+
+	// Lookup tells a missing quote from a failed query
+	get.ExpectQuery().WithArgs("id_1").WillReturnError(errors.New("connection refused"))
+	_, err := g.Lookup("id_1")
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrQuoteNotFound))
+
+	assert.Len(t, failures, 3)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLGetter_settings(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectPrepare("SELECT text FROM wisdom WHERE id = $1").WillBeClosed()
+	mock.ExpectPrepare("SELECT id FROM wisdom ORDER BY id").WillBeClosed()
+	mock.ExpectPrepare("SELECT id FROM wisdom WHERE category = $1 ORDER BY id").
+		WillReturnError(errors.New("no such table"))
+
+	// the statements prepared so far are closed on failure
+	_, err = NewSQLGetter(db, SQLSettings{Table: "wisdom", Placeholder: "$1"})
+	assert.NotNil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestWordOfWisdomService_Quote_sql(t *testing.T) {
+	g, mock, prepared := newSQLMockGetter(t, SQLSettings{})
+	get, ids := prepared[0], prepared[1]
+
+	ids.ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	src := NewWordOfWisdomService(g, nil)
+
+	// quotes inserted after construction are selected, since the ids are listed again
+	ids.ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("id_1"))
+	get.ExpectQuery().WithArgs("id_1").WillReturnRows(sqlmock.NewRows([]string{"text"}).AddRow("quote_1"))
+
+	quote, err := src.Quote()
+	assert.Nil(t, err)
+	assert.Equal(t, "quote_1", quote)
+
+	// a quote deleted since its id was listed is an error rather than an empty quote
+	ids.ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("id_1"))
+	get.ExpectQuery().WithArgs("id_1").WillReturnRows(sqlmock.NewRows([]string{"text"}))
+
+	_, err = src.Quote()
+	assert.ErrorIs(t, err, ErrQuoteNotFound)
+
+	// and so is a failed query
+	ids.ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("id_1"))
+	get.ExpectQuery().WithArgs("id_1").WillReturnError(errors.New("connection refused"))
+
+	_, err = src.QuoteBySeed(42)
+	assert.NotNil(t, err)
+	assert.False(t, errors.Is(err, ErrQuoteNotFound))
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestWordOfWisdomService_IdsRefreshInterval(t *testing.T) {
+	g, mock, prepared := newSQLMockGetter(t, SQLSettings{})
+	get, ids := prepared[0], prepared[1]
+
+	ids.ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("id_1"))
+	src := NewWordOfWisdomService(g, nil)
+	src.IdsRefreshInterval = time.Minute
+
+	now := time.Now()
+	src.now = func() time.Time { return now }
+
+	// the ids listed at construction are held within the interval
+	get.ExpectQuery().WithArgs("id_1").WillReturnRows(sqlmock.NewRows([]string{"text"}).AddRow("quote_1"))
+	quote, err := src.Quote()
+	assert.Nil(t, err)
+	assert.Equal(t, "quote_1", quote)
+
+	// and listed again once it has passed
+	now = now.Add(time.Minute)
+	ids.ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("id_2"))
+	get.ExpectQuery().WithArgs("id_2").WillReturnRows(sqlmock.NewRows([]string{"text"}).AddRow("quote_2"))
+	quote, err = src.Quote()
+	assert.Nil(t, err)
+	assert.Equal(t, "quote_2", quote)
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -1,11 +1,16 @@
 package service
 
 import (
+	"bytes"
 	_ "embed"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"math/rand"
+	"sort"
 	"sync"
+	"time"
 
 	"golang.org/x/exp/maps"
 )
@@ -13,74 +18,392 @@ import (
 // WordOfWisdom is a contract to get a word of wisdom quote.
 type WordOfWisdom interface {
 	Quote() (string, error)
+	QuoteByCategory(category string) (string, error)
+	Categories() []string
 }
 
+// ErrNoQuotes is returned when there are no quotes to select from (e.g. a quotes source is empty or failed to parse).
+var ErrNoQuotes = errors.New("no quotes to select from")
+
+// ErrUnknownCategory is returned when a requested quote category holds no quotes.
+var ErrUnknownCategory = errors.New("unknown quote category")
+
+// ErrQuoteNotFound is returned when a selected quote cannot be found in a quotes source
+// (e.g. it has been deleted since its id was listed).
+var ErrQuoteNotFound = errors.New("quote not found")
+
 // WordOfWisdomService is an implementation of WordOfWisdom.
 //
 // It returns a random quote from a quotes source.
 type WordOfWisdomService struct {
 	getter Getter
-	ids    *IdsHolder
+	ids    *IdsHolder // held for getters not storing quotes as a whole set, see quoteSets
+	rng    RNG
+
+	// NoRepeat flags to avoid serving the same quote twice in a row, provided there's more than one quote to select from.
+	NoRepeat bool
+	// IdsRefreshInterval is a time quotes ids of a getter not storing quotes as a whole set (e.g. SQLGetter)
+	// are held for before they're listed again, so quotes added to the source get selected as well.
+	// They're listed on every selection if it's not positive (e.g. for CachedGetter caching them on its own).
+	IdsRefreshInterval time.Duration
+
+	mu   sync.Mutex
+	last string // id of the last served quote, see NoRepeat
+
+	idsMu  sync.Mutex
+	listed time.Time // when the held ids have been listed, see IdsRefreshInterval
+	now    func() time.Time
 }
 
-// NewWordOfWisdomService returns a new instance of WordOfWisdomService.
-func NewWordOfWisdomService(getter Getter) *WordOfWisdomService {
+// NewWordOfWisdomService returns a new instance of WordOfWisdomService selecting quotes with the RNG.
+//
+// If the RNG is nil, a time-seeded SourceRNG is used.
+func NewWordOfWisdomService(getter Getter, rng RNG) *WordOfWisdomService {
+	if rng == nil {
+		rng = NewTimeSeededRNG()
+	}
+
 	return &WordOfWisdomService{
 		getter: getter,
 		ids:    &IdsHolder{ids: getter.GetIds()},
+		rng:    rng,
+		listed: time.Now(),
+		now:    time.Now,
 	}
 }
 
+// quoteSets is implemented by getters storing quotes as a whole set (see FileGetter):
+// a quote is selected and looked up within the same set,
+// so a concurrent reload never makes a selected id missing.
+type quoteSets interface {
+	current() *quoteSet
+}
+
+// currentIds returns the quotes ids of a getter not storing quotes as a whole set,
+// listed again once IdsRefreshInterval has passed.
+//
+// The ids are listed without holding a lock, so a slow quotes source doesn't block concurrent selections:
+// they select among the held ids meanwhile.
+func (src *WordOfWisdomService) currentIds() []string {
+	src.idsMu.Lock()
+	now := src.now()
+	due := src.IdsRefreshInterval <= 0 || !now.Before(src.listed.Add(src.IdsRefreshInterval))
+	if due {
+		src.listed = now
+	}
+	src.idsMu.Unlock()
+
+	if !due {
+		return src.ids.all()
+	}
+
+	ids := src.getter.GetIds()
+	src.ids.Set(ids)
+
+	return ids
+}
+
 // Quote returns a random word of wisdom quote.
+//
+// If there are no quotes at all, it returns ErrNoQuotes.
+// If the selected quote cannot be retrieved, it returns the error (see LookupGetter).
 func (src *WordOfWisdomService) Quote() (string, error) {
-	n := rand.Intn(src.ids.Len())
-	id, ok := src.ids.Get(n)
+	if sets, ok := src.getter.(quoteSets); ok {
+		set := sets.current()
+		if len(set.ids) == 0 {
+			return "", ErrNoQuotes
+		}
+
+		id, err := src.pick(len(set.ids), indexed(set.ids))
+		if err != nil {
+			return "", err
+		}
+
+		return set.quotes[id], nil
+	}
+
+	ids := src.currentIds()
+	if len(ids) == 0 {
+		return "", ErrNoQuotes
+	}
+
+	id, err := src.pick(len(ids), indexed(ids))
+	if err != nil {
+		return "", err
+	}
+
+	return lookup(src.getter, id)
+}
+
+// SeededWordOfWisdom is implemented by services able to select a quote deterministically (see QuoteBySeed).
+type SeededWordOfWisdom interface {
+	QuoteBySeed(seed int64) (string, error)
+}
+
+// QuoteBySeed returns a word of wisdom quote selected by a seed rather than at random:
+// the same seed yields the same quote as long as the quotes are not reloaded
+// (e.g. a client retrying a request with the same PoW result gets the same quote).
+//
+// NoRepeat doesn't apply to it. If there are no quotes at all, it returns ErrNoQuotes.
+func (src *WordOfWisdomService) QuoteBySeed(seed int64) (string, error) {
+	rng := NewSourceRNG(rand.NewSource(seed))
+
+	if sets, ok := src.getter.(quoteSets); ok {
+		set := sets.current()
+		if len(set.ids) == 0 {
+			return "", ErrNoQuotes
+		}
+
+		n, _ := rng.Intn(len(set.ids))
+		return set.quotes[set.ids[n]], nil
+	}
+
+	ids := src.currentIds()
+	if len(ids) == 0 {
+		return "", ErrNoQuotes
+	}
+
+	n, _ := rng.Intn(len(ids))
+	return lookup(src.getter, ids[n])
+}
+
+// QuoteByCategory returns a random word of wisdom quote of a category.
+//
+// An empty category stands for any category (see Quote).
+// If the category is unknown, it returns ErrUnknownCategory.
+func (src *WordOfWisdomService) QuoteByCategory(category string) (string, error) {
+	if category == "" {
+		return src.Quote()
+	}
+
+	ids := src.getter.GetIdsByCategory
+	get := func(id string) (string, error) { return lookup(src.getter, id) }
+	if sets, ok := src.getter.(quoteSets); ok {
+		set := sets.current()
+		ids = func(category string) []string { return set.categories[category] }
+		get = func(id string) (string, error) { return set.quotes[id], nil }
+	}
+
+	categoryIds := ids(category)
+	if len(categoryIds) == 0 {
+		return "", fmt.Errorf("%w: %q", ErrUnknownCategory, category)
+	}
+
+	id, err := src.pick(len(categoryIds), indexed(categoryIds))
+	if err != nil {
+		return "", err
+	}
+
+	return get(id)
+}
+
+// indexed returns a function to get a quote id by its index in ids.
+func indexed(ids []string) func(n int) (string, bool) {
+	return func(n int) (string, bool) {
+		if n < 0 || n >= len(ids) {
+			return "", false
+		}
+		return ids[n], true
+	}
+}
+
+// pick selects a random quote id out of count ones, while get returns a quote id by its index.
+func (src *WordOfWisdomService) pick(count int, get func(n int) (string, bool)) (string, error) {
+	n, err := src.rng.Intn(count)
+	if err != nil {
+		return "", err
+	}
+	id, ok := get(n)
 	if !ok {
 		return "", errors.New("get random quote id")
 	}
 
-	return src.getter.Get(id), nil
+	if !src.NoRepeat || count < 2 {
+		return id, nil
+	}
+
+	src.mu.Lock()
+	defer src.mu.Unlock()
+
+	if id == src.last {
+		// reselect among the other quotes, so each of them is equally likely to be served
+		m, err := src.rng.Intn(count - 1)
+		if err != nil {
+			return "", err
+		}
+		if m >= n {
+			m++
+		}
+		if id, ok = get(m); !ok {
+			return "", errors.New("get random quote id")
+		}
+	}
+	src.last = id
+
+	return id, nil
+}
+
+// Categories returns a sorted set of quote categories.
+func (src *WordOfWisdomService) Categories() []string {
+	return src.getter.Categories()
+}
+
+// Close releases the resources held by the quotes source, if it holds any (i.e. it implements io.Closer,
+// e.g. SQLGetter). It's called once the service is no longer used, e.g. on a server shutdown.
+func (src *WordOfWisdomService) Close() error {
+	if closer, ok := src.getter.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
 }
 
 // Getter is a contract to get a quote from some source.
+//
+// A Getter holding resources (e.g. connections or tickers) implements io.Closer to release them
+// (see WordOfWisdomService.Close).
 type Getter interface {
 	Get(id string) string
 	GetIds() []string
+	GetIdsByCategory(category string) []string
+	Categories() []string
+}
+
+// LookupGetter is implemented by getters able to tell why a quote cannot be retrieved (e.g. SQLGetter),
+// so WordOfWisdomService returns the failure rather than an empty quote.
+type LookupGetter interface {
+	// Lookup returns a quote string by its id, or an error matching ErrQuoteNotFound if there's no such quote.
+	Lookup(id string) (string, error)
+}
+
+// lookup returns a quote string by its id with a getter, see LookupGetter.
+// An empty quote string returned by a Getter means there's no such quote.
+func lookup(getter Getter, id string) (string, error) {
+	if l, ok := getter.(LookupGetter); ok {
+		return l.Lookup(id)
+	}
+
+	if text := getter.Get(id); text != "" {
+		return text, nil
+	}
+
+	return "", fmt.Errorf("%w: %q", ErrQuoteNotFound, id)
 }
 
 // FileGetter is an implementation of Getter to retrieve quotes from file.
 type FileGetter struct {
-	rw     sync.RWMutex
+	rw  sync.RWMutex
+	set *quoteSet
+}
+
+// quoteSet is an immutable set of quotes: stored quotes are replaced as a whole set at once (see FileGetter.swap).
+type quoteSet struct {
 	quotes map[string]string
+	// ids are sorted ids of quotes
+	ids []string
+	// categories indexes quotes ids by category
+	categories map[string][]string
 }
 
 //go:embed recource/quote.json
 var quoteBytes []byte
 
+// quote is an entry of a quotes file: {"<id>": {"category": "<category>", "text": "<quote>"}}.
+//
+// An entry might be a plain quote string as well: {"<id>": "<quote>"}, such a quote has no category.
+type quote struct {
+	Category string `json:"category"`
+	Text     string `json:"text"`
+}
+
+func (q *quote) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte(`"`)) {
+		*q = quote{}
+		return json.Unmarshal(b, &q.Text)
+	}
+
+	type plain quote // to avoid recursion
+	return json.Unmarshal(b, (*plain)(q))
+}
+
 // NewFileGetter returns a new instance of FileGetter.
 func NewFileGetter() *FileGetter {
-	var tmp map[string]string
-	if err := json.Unmarshal(quoteBytes, &tmp); err != nil {
-		return &FileGetter{quotes: make(map[string]string, 0)}
+	set, err := parseQuotes(quoteBytes)
+	if err != nil {
+		return &FileGetter{set: &quoteSet{quotes: make(map[string]string, 0), categories: make(map[string][]string, 0)}}
 	}
 
-	return &FileGetter{quotes: tmp}
+	return &FileGetter{set: set}
 }
 
-// Get returns a quote string by its id.
-func (g *FileGetter) Get(id string) string {
+// parseQuotes returns a set of quotes based on a quotes file content.
+func parseQuotes(b []byte) (*quoteSet, error) {
+	var tmp map[string]quote
+	if err := json.Unmarshal(b, &tmp); err != nil {
+		return nil, fmt.Errorf("unmarshal quotes: %w", err)
+	}
+
+	set := &quoteSet{
+		quotes:     make(map[string]string, len(tmp)),
+		ids:        make([]string, 0, len(tmp)),
+		categories: make(map[string][]string),
+	}
+	for id, q := range tmp {
+		set.quotes[id] = q.Text
+		set.ids = append(set.ids, id)
+		if q.Category != "" {
+			set.categories[q.Category] = append(set.categories[q.Category], id)
+		}
+	}
+
+	// ids are sorted to keep a selection by index stable for the same quotes
+	sort.Strings(set.ids)
+	for _, ids := range set.categories {
+		sort.Strings(ids)
+	}
+
+	return set, nil
+}
+
+// swap replaces stored quotes at once.
+func (g *FileGetter) swap(set *quoteSet) {
+	g.rw.Lock()
+	defer g.rw.Unlock()
+
+	g.set = set
+}
+
+// current returns the stored set of quotes.
+//
+// The set is never modified, so it's safe to use after a swap.
+func (g *FileGetter) current() *quoteSet {
 	g.rw.RLock()
 	defer g.rw.RUnlock()
 
-	return g.quotes[id]
+	return g.set
+}
+
+// Get returns a quote string by its id.
+func (g *FileGetter) Get(id string) string {
+	return g.current().quotes[id]
 }
 
 // GetIds returns a set of stored quotes ids.
 func (g *FileGetter) GetIds() []string {
-	g.rw.RLock()
-	defer g.rw.RUnlock()
+	return append([]string(nil), g.current().ids...)
+}
+
+// GetIdsByCategory returns a set of stored quotes ids of a category.
+func (g *FileGetter) GetIdsByCategory(category string) []string {
+	return append([]string(nil), g.current().categories[category]...)
+}
+
+// Categories returns a sorted set of stored quotes categories.
+func (g *FileGetter) Categories() []string {
+	categories := maps.Keys(g.current().categories)
+	sort.Strings(categories)
 
-	return maps.Keys(g.quotes)
+	return categories
 }
 
 // IdsHolder holds a set of quotes ids.
@@ -103,5 +426,24 @@ func (ih *IdsHolder) Get(idKey int) (string, bool) {
 
 // Len returns a count of held quotes (quotes' ids).
 func (ih *IdsHolder) Len() int {
+	ih.rw.RLock()
+	defer ih.rw.RUnlock()
+
 	return len(ih.ids)
 }
+
+// all returns the held quotes ids. Set replaces them rather than modifies, so they're safe to read afterwards.
+func (ih *IdsHolder) all() []string {
+	ih.rw.RLock()
+	defer ih.rw.RUnlock()
+
+	return ih.ids
+}
+
+// Set replaces held quotes ids.
+func (ih *IdsHolder) Set(ids []string) {
+	ih.rw.Lock()
+	defer ih.rw.Unlock()
+
+	ih.ids = ids
+}
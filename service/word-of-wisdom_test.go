@@ -3,9 +3,11 @@ package service
 //go:generate mockery --name=Getter --case underscore
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"golang.org/x/exp/maps"
 
 	"github.com/laonix/pow-word-of-wisdom/service/mocks"
@@ -26,7 +28,7 @@ func TestWordOfWisdomService_Quote(t *testing.T) {
 
 	getter.On("GetIds").Return(maps.Keys(quotesSource))
 
-	srv := NewWordOfWisdomService(getter)
+	srv := NewWordOfWisdomService(getter, nil)
 
 	quote, err := srv.Quote()
 	assert.Nil(t, err)
@@ -42,3 +44,177 @@ func TestWordOfWisdomService_Quote(t *testing.T) {
 	})
 
 }
+
+func TestWordOfWisdomService_Quote_no_repeat(t *testing.T) {
+	quotesSource := map[string]string{
+		"id_1": "quote_1",
+		"id_2": "quote_2",
+	}
+
+	getter := mocks.NewGetter(t)
+	for _, id := range maps.Keys(quotesSource) {
+		getter.On("Get", id).Return(quotesSource[id])
+	}
+	getter.On("GetIds").Return(maps.Keys(quotesSource))
+
+	srv := NewWordOfWisdomService(getter, nil)
+	srv.NoRepeat = true
+
+	// with two quotes they must alternate
+	previous, err := srv.Quote()
+	assert.Nil(t, err)
+	for i := 0; i < 20; i++ {
+		quote, err := srv.Quote()
+		assert.Nil(t, err)
+		assert.NotEqual(t, previous, quote)
+		previous = quote
+	}
+}
+
+func TestWordOfWisdomService_QuoteByCategory(t *testing.T) {
+	quotesSource := map[string]string{
+		"id_1": "quote_1",
+		"id_2": "quote_2",
+		"id_3": "quote_3",
+		"id_4": "quote_4",
+	}
+	categories := map[string][]string{
+		"stoic": {"id_1", "id_2", "id_3"},
+		"wit":   {"id_4"},
+	}
+
+	getter := mocks.NewGetter(t)
+	for _, id := range maps.Keys(quotesSource) {
+		getter.On("Get", id).Maybe().Return(quotesSource[id])
+	}
+	getter.On("GetIds").Return(maps.Keys(quotesSource))
+	getter.On("GetIdsByCategory", mock.AnythingOfType("string")).Maybe().Return(func(category string) []string {
+		return categories[category]
+	})
+
+	srv := NewWordOfWisdomService(getter, nil)
+
+	t.Run("unknown category", func(t *testing.T) {
+		quote, err := srv.QuoteByCategory("unknown")
+		assert.ErrorIs(t, err, ErrUnknownCategory)
+		assert.Empty(t, quote)
+	})
+
+	t.Run("empty category", func(t *testing.T) {
+		quote, err := srv.QuoteByCategory("")
+		assert.Nil(t, err)
+		assert.Contains(t, maps.Values(quotesSource), quote)
+	})
+
+	t.Run("single quote category", func(t *testing.T) {
+		quote, err := srv.QuoteByCategory("wit")
+		assert.Nil(t, err)
+		assert.Equal(t, "quote_4", quote)
+	})
+
+	t.Run("even distribution within category", func(t *testing.T) {
+		const samples = 9000
+		selected := make(map[string]int)
+		for i := 0; i < samples; i++ {
+			quote, err := srv.QuoteByCategory("stoic")
+			assert.Nil(t, err)
+			selected[quote]++
+		}
+
+		assert.ElementsMatch(t, []string{"quote_1", "quote_2", "quote_3"}, maps.Keys(selected))
+		for quote, n := range selected {
+			assert.InDelta(t, samples/3, n, samples/3*0.1, "quote %s", quote)
+		}
+	})
+}
+
+func TestFileGetter_categories(t *testing.T) {
+	getter := NewFileGetter()
+
+	assert.Equal(t, []string{"imagination", "life", "wisdom", "wit"}, getter.Categories())
+
+	var total int
+	for _, category := range getter.Categories() {
+		ids := getter.GetIdsByCategory(category)
+		assert.NotEmpty(t, ids)
+		for _, id := range ids {
+			assert.NotEmpty(t, getter.Get(id))
+		}
+		total += len(ids)
+	}
+	assert.Len(t, getter.GetIds(), total)
+
+	assert.Empty(t, getter.GetIdsByCategory("unknown"))
+}
+
+func TestQuote_UnmarshalJSON(t *testing.T) {
+	var quotes map[string]quote
+	err := json.Unmarshal([]byte(`{"id_1":{"category":"stoic","text":"quote_1"},"id_2":"quote_2"}`), &quotes)
+	assert.Nil(t, err)
+
+	assert.Equal(t, quote{Category: "stoic", Text: "quote_1"}, quotes["id_1"])
+	assert.Equal(t, quote{Text: "quote_2"}, quotes["id_2"])
+}
+
+func TestWordOfWisdomService_Quote_no_quotes(t *testing.T) {
+	// a getter holding quotes as a whole set, e.g. an embedded corpus failed to unmarshal
+	empty := &FileGetter{set: &quoteSet{quotes: map[string]string{}, categories: map[string][]string{}}}
+
+	// a getter holding quotes by ids
+	getter := mocks.NewGetter(t)
+	getter.On("GetIds").Return([]string{})
+
+	for _, g := range []Getter{empty, getter} {
+		srv := NewWordOfWisdomService(g, nil)
+
+		assert.NotPanics(t, func() {
+			_, err := srv.Quote()
+			assert.ErrorIs(t, err, ErrNoQuotes)
+		})
+	}
+}
+
+func TestWordOfWisdomService_QuoteBySeed(t *testing.T) {
+	srv := NewWordOfWisdomService(NewFileGetter(), nil)
+	srv.NoRepeat = true
+
+	// the same seed yields the same quote every time, while different seeds spread over the quotes
+	selected := make(map[string]bool)
+	for seed := int64(0); seed < 50; seed++ {
+		quote, err := srv.QuoteBySeed(seed)
+		assert.Nil(t, err)
+
+		again, err := srv.QuoteBySeed(seed)
+		assert.Nil(t, err)
+		assert.Equal(t, quote, again, "seed %d", seed)
+
+		selected[quote] = true
+	}
+	assert.Greater(t, len(selected), 1)
+
+	// a getter holding quotes by ids
+	getter := mocks.NewGetter(t)
+	getter.On("GetIds").Return([]string{"id_1", "id_2", "id_3"})
+	getter.On("Get", mock.Anything).Return(func(id string) string { return "quote of " + id })
+
+	byIds := NewWordOfWisdomService(getter, nil)
+	quote, err := byIds.QuoteBySeed(42)
+	assert.Nil(t, err)
+	again, err := byIds.QuoteBySeed(42)
+	assert.Nil(t, err)
+	assert.Equal(t, quote, again)
+
+	_, err = NewWordOfWisdomService(&FileGetter{set: &quoteSet{}}, nil).QuoteBySeed(42)
+	assert.ErrorIs(t, err, ErrNoQuotes)
+}
+
+func TestWordOfWisdomService_Close(t *testing.T) {
+	getter := &closingGetter{countingGetter: newCountingGetter()}
+	src := NewWordOfWisdomService(NewCachedGetter(getter, CacheSettings{}), nil)
+
+	assert.Nil(t, src.Close())
+	assert.True(t, getter.closed)
+
+	// FileGetter holds no resources
+	assert.Nil(t, NewWordOfWisdomService(NewFileGetter(), nil).Close())
+}
@@ -0,0 +1,38 @@
+package tcp
+
+import "sync"
+
+// ReadBufferSize is the size of the read buffers pooled across connections (see ConnWrapper):
+// a frame fitting the buffer is read by ReadFrameBuffered without allocating a payload of its own.
+const ReadBufferSize = 1024
+
+// readBuffers pools read buffers, so high connection churn doesn't put pressure on GC.
+var readBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, ReadBufferSize)
+		return &b
+	},
+}
+
+// getReadBuffer takes a zeroed read buffer of ReadBufferSize bytes out of the pool.
+func getReadBuffer() *[]byte {
+	return readBuffers.Get().(*[]byte)
+}
+
+// putReadBuffer clears a read buffer and returns it to the pool,
+// so the data of a connection never leaks into another one.
+func putReadBuffer(b *[]byte) {
+	buf := *b
+	for i := range buf {
+		buf[i] = 0
+	}
+
+	readBuffers.Put(b)
+}
+
+// readBufferer is implemented by connections holding a pooled read buffer (see ConnWrapper).
+type readBufferer interface {
+	// acquireReadBuffer returns the read buffer of the connection along with a function flagging
+	// it's not used anymore, or nil if the connection is closed or the buffer is in use.
+	acquireReadBuffer() (buf []byte, release func())
+}
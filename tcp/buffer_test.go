@@ -0,0 +1,162 @@
+package tcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// replayConn is a net.Conn reading the data it's been given, over and over again if it loops.
+type replayConn struct {
+	data []byte
+	loop bool
+	pos  int
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	if c.pos == len(c.data) {
+		if !c.loop {
+			return 0, net.ErrClosed
+		}
+		c.pos = 0
+	}
+
+	n := copy(b, c.data[c.pos:])
+	c.pos += n
+
+	return n, nil
+}
+
+func (c *replayConn) Write(b []byte) (int, error)      { return len(b), nil }
+func (c *replayConn) Close() error                     { return nil }
+func (c *replayConn) LocalAddr() net.Addr              { return &net.TCPAddr{} }
+func (c *replayConn) RemoteAddr() net.Addr             { return &net.TCPAddr{Port: 80} }
+func (c *replayConn) SetDeadline(time.Time) error      { return nil }
+func (c *replayConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *replayConn) SetWriteDeadline(time.Time) error { return nil }
+
+// frames returns payloads framed as they're written to a connection.
+func frames(payloads ...string) []byte {
+	var b bytes.Buffer
+	for _, payload := range payloads {
+		header := make([]byte, FrameHeaderSize)
+		binary.BigEndian.PutUint32(header, uint32(len(payload)))
+		b.Write(header)
+		b.WriteString(payload)
+	}
+
+	return b.Bytes()
+}
+
+func TestReadFrameBuffered(t *testing.T) {
+	long := strings.Repeat("secret", 100)
+
+	// a short frame following a long one over the same connection doesn't get the bytes of the long one
+	conn := NewConnWrapper(&replayConn{data: frames(long, "short", strings.Repeat("x", 2*ReadBufferSize))})
+
+	for _, want := range []string{long, "short", strings.Repeat("x", 2*ReadBufferSize)} {
+		payload, release, err := ReadFrameBuffered(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, want, string(payload))
+		release()
+	}
+
+	// a payload fitting the buffer is a slice of it, so the buffer is not taken by another read until it's released
+	conn = NewConnWrapper(&replayConn{data: frames("first", "second", "third")})
+
+	first, release, err := ReadFrameBuffered(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, &(*conn.buf)[0], &first[0])
+
+	second, releaseSecond, err := ReadFrameBuffered(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, "second", string(second))
+	assert.Equal(t, "first", string(first))
+	releaseSecond()
+	release()
+
+	// once the connection is closed, its buffer is returned to the pool after the payload read into it is released
+	third, release, err := ReadFrameBuffered(conn)
+	assert.Nil(t, err)
+	buf := conn.buf
+	assert.Nil(t, conn.Close())
+	assert.Equal(t, "third", string(third))
+	release()
+	assert.Nil(t, conn.buf)
+	assert.Equal(t, make([]byte, ReadBufferSize), *buf)
+
+	// a closed connection doesn't take a buffer anymore
+	_, release, err = ReadFrameBuffered(conn)
+	assert.ErrorIs(t, err, ErrConnClosed)
+	assert.Nil(t, conn.buf)
+	release()
+}
+
+func TestReadFrame_caller_owned(t *testing.T) {
+	conn := NewConnWrapper(&replayConn{data: frames("first", "second")})
+
+	// the payload is owned by the caller, so it's not overwritten by the next read
+	first, err := ReadFrame(conn)
+	assert.Nil(t, err)
+	_, err = ReadFrame(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, "first", string(first))
+	assert.Nil(t, conn.buf)
+}
+
+func TestReadFrameBuffered_across_connections(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		conn := NewConnWrapper(&replayConn{data: frames(strings.Repeat("secret", 100))})
+		_, release, err := ReadFrameBuffered(conn)
+		assert.Nil(t, err)
+		release()
+		assert.Nil(t, conn.Close())
+
+		// a buffer reused by the next connection holds none of the bytes of the previous one
+		next := NewConnWrapper(&replayConn{data: frames("short")})
+		buf, release := next.acquireReadBuffer()
+		assert.Equal(t, make([]byte, ReadBufferSize), buf)
+		release()
+
+		payload, release, err := ReadFrameBuffered(next)
+		assert.Nil(t, err)
+		assert.Equal(t, "short", string(payload))
+		release()
+		assert.Nil(t, next.Close())
+	}
+}
+
+func BenchmarkReadFrame(b *testing.B) {
+	data := frames(`{"version":1,"capabilities":["hello","envelope"],"category":"life"}`)
+
+	b.Run("ReadFrame", func(b *testing.B) {
+		conn := NewConnWrapper(&replayConn{data: data, loop: true})
+		defer conn.Close()
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := ReadFrame(conn); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("ReadFrameBuffered", func(b *testing.B) {
+		conn := NewConnWrapper(&replayConn{data: data, loop: true})
+		defer conn.Close()
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, release, err := ReadFrameBuffered(conn)
+			if err != nil {
+				b.Fatal(err)
+			}
+			release()
+		}
+	})
+}
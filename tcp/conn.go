@@ -1,6 +1,55 @@
 package tcp
 
-import "net"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrConnClosed is returned when a connection is closed by either end or reset by the peer,
+// so callers detect it with errors.Is(err, ErrConnClosed) regardless of the underlying error.
+//
+// The underlying error is kept as well, e.g. errors.Is(err, io.EOF) holds for a connection closed by the peer.
+var ErrConnClosed = errors.New("connection closed")
+
+// connClosedError is an error of a closed connection: it matches both ErrConnClosed and the underlying error.
+type connClosedError struct {
+	err error
+}
+
+func (e *connClosedError) Error() string {
+	return ErrConnClosed.Error() + ": " + e.err.Error()
+}
+
+func (e *connClosedError) Unwrap() error {
+	return e.err
+}
+
+func (e *connClosedError) Is(target error) bool {
+	return target == ErrConnClosed
+}
+
+// normalizeClosed turns an error of a closed connection (EOF, use of a closed connection, reset or broken pipe)
+// into ErrConnClosed, other errors (e.g. timeouts) are returned as is.
+func normalizeClosed(err error) error {
+	if err == nil || errors.Is(err, ErrConnClosed) {
+		return err
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return &connClosedError{err: err}
+	}
+
+	return err
+}
 
 // Conn is a contract to work with a generic stream-oriented network connection.
 type Conn interface {
@@ -8,34 +57,258 @@ type Conn interface {
 	Write(b []byte) (n int, err error)
 	Close() error
 	RemoteAddr() net.Addr
+	SetDeadline(t time.Time) error
 }
 
 // ConnWrapper is an implementation of Conn.
 // It's a wrapper over net.Conn.
 type ConnWrapper struct {
 	conn net.Conn
+	id   string
+
+	maxMessageSize int
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	// readDeadline is the latest time a read may complete at, if it's set (see SetReadDeadline)
+	deadlineMu   sync.Mutex
+	readDeadline time.Time
+
+	// buf is a read buffer taken out of the pool on the first ReadFrameBuffered and returned to it on Close,
+	// release is releaseReadBuffer bound once, so releasing the buffer doesn't allocate on every frame
+	bufMu   sync.Mutex
+	buf     *[]byte
+	bufBusy bool
+	closed  bool
+	release func()
+}
+
+// NewConnWrapper returns a new instance of ConnWrapper with a unique id (see ID).
+func NewConnWrapper(conn net.Conn) *ConnWrapper {
+	return &ConnWrapper{conn: conn, id: uuid.NewString()}
+}
+
+// ID returns the unique id of the connection, meant to correlate the log records of a single connection.
+func (w *ConnWrapper) ID() string {
+	return w.id
 }
 
 // Read returns the result of reading from the connection.
 //
 // It returns read bytes slice instead of the number of read bytes.
+// Like net.Conn#Read, it may return fewer bytes than a message sent by the peer:
+// message-oriented callers should use ReadFull or ReadFrame instead.
+// If a read timeout is set, a read exceeding it fails with a timeout error (see os.IsTimeout).
+// So does a read not completed by the read deadline, if it's set (see SetReadDeadline).
+// If the connection is closed, it fails with ErrConnClosed.
 func (w *ConnWrapper) Read(b []byte) (read []byte, err error) {
+	if deadline := w.nextReadDeadline(); !deadline.IsZero() {
+		if err := w.conn.SetReadDeadline(deadline); err != nil {
+			return b[:0], normalizeClosed(err)
+		}
+	}
+
 	n, err := w.conn.Read(b)
 
-	return b[:n], err
+	return b[:n], normalizeClosed(err)
+}
+
+// ReadFull reads exactly min bytes from the connection,
+// so a message delivered in several packets is returned as a whole.
+//
+// If the connection is closed after reading some but not all the bytes, it returns io.ErrUnexpectedEOF
+// (matching ErrConnClosed as well).
+// The read timeout (see SetTimeouts) applies to every single read rather than to the whole message.
+func (w *ConnWrapper) ReadFull(min int) ([]byte, error) {
+	if min < 0 {
+		return nil, fmt.Errorf("negative number of bytes to read: %d", min)
+	}
+
+	b := make([]byte, min)
+	if err := readFull(w, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
 }
 
 // Write performs net.Conn#Write.
+//
+// If a write timeout is set, a write exceeding it fails with a timeout error (see os.IsTimeout).
+// If the connection is closed, it fails with ErrConnClosed.
 func (w *ConnWrapper) Write(b []byte) (n int, err error) {
-	return w.conn.Write(b)
+	if w.writeTimeout > 0 {
+		if err := w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout)); err != nil {
+			return 0, normalizeClosed(err)
+		}
+	}
+
+	n, err = w.conn.Write(b)
+
+	return n, normalizeClosed(err)
+}
+
+// SetDeadline performs net.Conn#SetDeadline.
+//
+// Mind that read and write timeouts (see SetTimeouts) renew the deadline on every read and write respectively.
+func (w *ConnWrapper) SetDeadline(t time.Time) error {
+	return w.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the latest time reads may complete at, a zero time means no deadline.
+//
+// Unlike net.Conn#SetReadDeadline, the deadline isn't renewed by the read timeout (see SetTimeouts):
+// every read ends by the earlier of the two.
+func (w *ConnWrapper) SetReadDeadline(t time.Time) error {
+	w.deadlineMu.Lock()
+	w.readDeadline = t
+	w.deadlineMu.Unlock()
+
+	return w.conn.SetReadDeadline(t)
+}
+
+// nextReadDeadline returns the deadline of the next read: the earlier of the read timeout and the read deadline,
+// or the zero time if neither is set.
+func (w *ConnWrapper) nextReadDeadline() time.Time {
+	w.deadlineMu.Lock()
+	deadline := w.readDeadline
+	w.deadlineMu.Unlock()
+
+	if w.readTimeout > 0 {
+		if timeout := time.Now().Add(w.readTimeout); deadline.IsZero() || timeout.Before(deadline) {
+			return timeout
+		}
+	}
+
+	return deadline
+}
+
+// SetTimeouts sets the longest duration of a single read from and write to the connection,
+// so a stalled peer doesn't hold the connection forever.
+//
+// A zero duration means no timeout.
+func (w *ConnWrapper) SetTimeouts(read, write time.Duration) {
+	w.readTimeout = read
+	w.writeTimeout = write
 }
 
 // Close performs net.Conn#Close.
+//
+// The read buffer of the connection is returned to the pool, once the payload read into it (if any) is released
+// (see ReadFrameBuffered).
 func (w *ConnWrapper) Close() error {
+	w.bufMu.Lock()
+	w.closed = true
+	if !w.bufBusy {
+		w.recycleReadBuffer()
+	}
+	w.bufMu.Unlock()
+
 	return w.conn.Close()
 }
 
+func (w *ConnWrapper) acquireReadBuffer() ([]byte, func()) {
+	w.bufMu.Lock()
+	defer w.bufMu.Unlock()
+
+	if w.closed || w.bufBusy {
+		return nil, nil
+	}
+	if w.buf == nil {
+		w.buf = getReadBuffer()
+	}
+	if w.release == nil {
+		w.release = w.releaseReadBuffer
+	}
+	w.bufBusy = true
+
+	return *w.buf, w.release
+}
+
+func (w *ConnWrapper) releaseReadBuffer() {
+	w.bufMu.Lock()
+	defer w.bufMu.Unlock()
+
+	w.bufBusy = false
+	if w.closed {
+		w.recycleReadBuffer()
+	}
+}
+
+// recycleReadBuffer returns the read buffer to the pool; bufMu must be held.
+func (w *ConnWrapper) recycleReadBuffer() {
+	if w.buf != nil {
+		putReadBuffer(w.buf)
+		w.buf = nil
+	}
+}
+
+// MaxMessageSize returns an upper limit of a message size to read from the connection (see ReadFrame).
+//
+// If the limit is not set, DefaultMaxMessageSize is used.
+func (w *ConnWrapper) MaxMessageSize() int {
+	if w.maxMessageSize <= 0 {
+		return DefaultMaxMessageSize
+	}
+
+	return w.maxMessageSize
+}
+
+// SetMaxMessageSize sets an upper limit of a message size to read from the connection.
+func (w *ConnWrapper) SetMaxMessageSize(size int) {
+	w.maxMessageSize = size
+}
+
 // RemoteAddr performs net.Conn#RemoteAddr.
 func (w *ConnWrapper) RemoteAddr() net.Addr {
 	return w.conn.RemoteAddr()
 }
+
+// SetReadDeadline sets the latest time reads from a connection may complete at, if the connection supports that
+// (see ConnWrapper#SetReadDeadline); it does nothing otherwise.
+func SetReadDeadline(conn Conn, t time.Time) error {
+	if c, ok := conn.(interface{ SetReadDeadline(t time.Time) error }); ok {
+		return c.SetReadDeadline(t)
+	}
+
+	return nil
+}
+
+// ConnID returns the id of a connection if it has one (see ConnWrapper#ID), or an empty string otherwise.
+func ConnID(conn Conn) string {
+	if c, ok := conn.(interface{ ID() string }); ok {
+		return c.ID()
+	}
+
+	return ""
+}
+
+// UnknownRemote is substituted for the remote address of a connection which doesn't know it.
+const UnknownRemote = "unknown"
+
+// RemoteAddr returns the remote address of a connection as a string.
+//
+// If the connection doesn't know its remote address (RemoteAddr returns nil), UnknownRemote is returned.
+func RemoteAddr(conn Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return UnknownRemote
+	}
+
+	return addr.String()
+}
+
+// RemoteIP returns the IP address of the remote end of a connection.
+//
+// If the remote address has no port (e.g. it's not a TCP address), it's returned as is (see RemoteAddr).
+func RemoteIP(conn Conn) string {
+	addr := RemoteAddr(conn)
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
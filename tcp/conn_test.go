@@ -0,0 +1,229 @@
+package tcp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnWrapper_read_timeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := NewConnWrapper(server)
+	conn.SetTimeouts(10*time.Millisecond, 0)
+
+	// the client never writes, so the read stalls until the timeout
+	_, err := ReadFrame(conn)
+	assert.True(t, os.IsTimeout(err))
+}
+
+func TestConnWrapper_write_timeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := NewConnWrapper(server)
+	conn.SetTimeouts(0, 10*time.Millisecond)
+
+	// the client never reads, so the write stalls until the timeout
+	err := WriteFrame(conn, []byte("a word of wisdom"))
+	assert.True(t, os.IsTimeout(err))
+}
+
+func TestConnWrapper_timeout_renewed(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := NewConnWrapper(server)
+	conn.SetTimeouts(50*time.Millisecond, 0)
+
+	go func() {
+		writer := NewConnWrapper(client)
+		for i := 0; i < 3; i++ {
+			time.Sleep(20 * time.Millisecond)
+			_ = WriteFrame(writer, []byte("ping"))
+		}
+	}()
+
+	// every read gets its own timeout, so the total time of reads may exceed a single timeout
+	for i := 0; i < 3; i++ {
+		got, err := ReadFrame(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, "ping", string(got))
+	}
+}
+
+func TestConnWrapper_ReadFull(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	message := "1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA=="
+
+	// the message is delivered in several packets
+	go func() {
+		for _, packet := range chunks([]byte(message), 7) {
+			_, _ = client.Write(packet)
+		}
+	}()
+
+	conn := NewConnWrapper(server)
+
+	// a single read gets the first packet only
+	read, err := conn.Read(make([]byte, len(message)))
+	assert.Nil(t, err)
+	assert.Equal(t, message[:7], string(read))
+
+	got, err := conn.ReadFull(len(message) - 7)
+	assert.Nil(t, err)
+	assert.Equal(t, message[7:], string(got))
+}
+
+func TestConnWrapper_ReadFull_unexpected_eof(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		_, _ = client.Write([]byte("a word"))
+		_ = client.Close()
+	}()
+
+	_, err := NewConnWrapper(server).ReadFull(len("a word of wisdom"))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.ErrorIs(t, err, ErrConnClosed)
+}
+
+func TestNormalizeClosed(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		closed bool
+	}{
+		{name: "EOF", err: io.EOF, closed: true},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, closed: true},
+		{name: "use of closed connection", err: &net.OpError{Op: "read", Net: "tcp", Err: net.ErrClosed}, closed: true},
+		{name: "closed pipe", err: io.ErrClosedPipe, closed: true},
+		{name: "reset by peer", err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, closed: true},
+		{name: "broken pipe", err: &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}, closed: true},
+		{name: "timeout", err: os.ErrDeadlineExceeded, closed: false},
+		{name: "other error", err: errors.New("some error"), closed: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := normalizeClosed(test.err)
+
+			assert.Equal(t, test.closed, errors.Is(err, ErrConnClosed))
+			// the underlying error is kept
+			assert.ErrorIs(t, err, test.err)
+		})
+	}
+
+	assert.Nil(t, normalizeClosed(nil))
+}
+
+func TestConnWrapper_closed(t *testing.T) {
+	client, server := net.Pipe()
+
+	conn := NewConnWrapper(server)
+
+	// the peer has closed the connection
+	assert.Nil(t, client.Close())
+	_, err := ReadFrame(conn)
+	assert.ErrorIs(t, err, ErrConnClosed)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// the connection has been closed on our side
+	assert.Nil(t, conn.Close())
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, ErrConnClosed)
+	assert.ErrorIs(t, WriteFrame(conn, []byte("ping")), ErrConnClosed)
+}
+
+// addrConn is a Conn with a predefined remote address.
+type addrConn struct {
+	chunkedConn
+	addr net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func TestRemoteIP(t *testing.T) {
+	tests := []struct {
+		name string
+		addr net.Addr
+		want string
+	}{
+		{name: "TCP address", addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4242}, want: "10.0.0.1"},
+		{name: "no port", addr: &net.UnixAddr{Name: "/tmp/pow.sock", Net: "unix"}, want: "/tmp/pow.sock"},
+		{name: "nil address", addr: nil, want: UnknownRemote},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := &addrConn{addr: test.addr}
+
+			assert.NotPanics(t, func() {
+				assert.Equal(t, test.want, RemoteIP(conn))
+			})
+		})
+	}
+}
+
+func TestRemoteAddr_nil(t *testing.T) {
+	conn := &addrConn{}
+
+	assert.NotPanics(t, func() {
+		assert.Equal(t, UnknownRemote, RemoteAddr(conn))
+	})
+}
+
+func TestConnID(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	first := NewConnWrapper(server)
+	second := NewConnWrapper(client)
+
+	assert.NotEmpty(t, ConnID(first))
+	assert.Equal(t, first.ID(), ConnID(first))
+	assert.NotEqual(t, ConnID(first), ConnID(second))
+
+	// a connection without an id
+	assert.Equal(t, "", ConnID(&addrConn{}))
+}
+
+func TestConnWrapper_SetReadDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := NewConnWrapper(server)
+	// the read timeout is renewed by every read, but it doesn't extend the read deadline
+	conn.SetTimeouts(time.Second, 0)
+	assert.Nil(t, SetReadDeadline(conn, time.Now().Add(20*time.Millisecond)))
+
+	start := time.Now()
+	_, err := ReadFrame(conn)
+	assert.True(t, os.IsTimeout(err))
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	// once the deadline is lifted, the read timeout applies again
+	assert.Nil(t, SetReadDeadline(conn, time.Time{}))
+	conn.SetTimeouts(10*time.Millisecond, 0)
+
+	_, err = ReadFrame(conn)
+	assert.True(t, os.IsTimeout(err))
+}
@@ -0,0 +1,106 @@
+package tcp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// IPFilter decides whether connections are accepted from a remote IP by allow and deny lists of CIDR ranges.
+//
+// A remote IP within a denied range is rejected. If there are allowed ranges, a remote IP must be within one of them
+// to be accepted. A remote address which is not an IP address is rejected only if there are allowed ranges.
+// All the methods are safe to call on a nil IPFilter, which accepts every connection.
+type IPFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewIPFilter returns a new instance of IPFilter with allowed and denied CIDR ranges (e.g. "10.0.0.0/8").
+// A single IP address is accepted as a range of that address only.
+func NewIPFilter(allow, deny []string) (*IPFilter, error) {
+	allowNets, err := parseNets(allow)
+	if err != nil {
+		return nil, fmt.Errorf("parse allowed ranges: %w", err)
+	}
+
+	denyNets, err := parseNets(deny)
+	if err != nil {
+		return nil, fmt.Errorf("parse denied ranges: %w", err)
+	}
+
+	return &IPFilter{allow: allowNets, deny: denyNets}, nil
+}
+
+// parseNets parses CIDR ranges or single IP addresses.
+func parseNets(ranges []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(ranges))
+	for _, r := range ranges {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+
+		if ip := net.ParseIP(r); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+// Allowed checks if a connection is accepted from a remote address.
+func (f *IPFilter) Allowed(addr net.Addr) bool {
+	if f == nil || (len(f.allow) == 0 && len(f.deny) == 0) {
+		return true
+	}
+
+	ip := ipOf(addr)
+	if ip == nil {
+		return len(f.allow) == 0
+	}
+
+	if contains(f.deny, ip) {
+		return false
+	}
+
+	return len(f.allow) == 0 || contains(f.allow, ip)
+}
+
+// ipOf returns the IP address of a remote address, or nil if it's not an IP address.
+func ipOf(addr net.Addr) net.IP {
+	if addr == nil {
+		return nil
+	}
+	if a, ok := addr.(*net.TCPAddr); ok && a != nil {
+		return a.IP
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+
+	return net.ParseIP(host)
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
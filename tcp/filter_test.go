@@ -0,0 +1,137 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/laonix/pow-word-of-wisdom/logger"
+)
+
+// pipeAddr is a remote address which is not an IP address.
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func tcpAddr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}
+}
+
+func TestIPFilter_Allowed(t *testing.T) {
+	tests := []struct {
+		name        string
+		allow, deny []string
+		addr        net.Addr
+		want        bool
+	}{
+		{name: "no lists", addr: tcpAddr("203.0.113.1"), want: true},
+		{name: "allowed range", allow: []string{"10.0.0.0/8"}, addr: tcpAddr("10.1.2.3"), want: true},
+		{name: "out of allowed ranges", allow: []string{"10.0.0.0/8"}, addr: tcpAddr("203.0.113.1"), want: false},
+		{name: "denied range", deny: []string{"203.0.113.0/24"}, addr: tcpAddr("203.0.113.1"), want: false},
+		{name: "out of denied ranges", deny: []string{"203.0.113.0/24"}, addr: tcpAddr("198.51.100.1"), want: true},
+		{
+			name:  "denied within allowed range",
+			allow: []string{"10.0.0.0/8"},
+			deny:  []string{"10.0.0.1"},
+			addr:  tcpAddr("10.0.0.1"),
+			want:  false,
+		},
+		{name: "single IP", allow: []string{"10.0.0.1"}, addr: tcpAddr("10.0.0.2"), want: false},
+		{name: "IPv6 range", allow: []string{"2001:db8::/32"}, addr: tcpAddr("2001:db8::1"), want: true},
+		{name: "IPv4-mapped IPv6 address", deny: []string{"10.0.0.0/8"}, addr: tcpAddr("::ffff:10.0.0.1"), want: false},
+		{name: "string address", deny: []string{"10.0.0.0/8"}, addr: &net.UnixAddr{Name: "10.0.0.1:80"}, want: false},
+		{name: "not an IP with allow list", allow: []string{"10.0.0.0/8"}, addr: pipeAddr{}, want: false},
+		{name: "not an IP with deny list", deny: []string{"10.0.0.0/8"}, addr: pipeAddr{}, want: true},
+		{name: "unknown address", allow: []string{"10.0.0.0/8"}, addr: nil, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := NewIPFilter(test.allow, test.deny)
+			if assert.Nil(t, err) {
+				assert.Equal(t, test.want, f.Allowed(test.addr))
+			}
+		})
+	}
+
+	var nilFilter *IPFilter
+	assert.True(t, nilFilter.Allowed(tcpAddr("203.0.113.1")))
+}
+
+func TestNewIPFilter_invalid(t *testing.T) {
+	_, err := NewIPFilter([]string{"10.0.0.0/8"}, []string{"not-a-range"})
+	assert.NotNil(t, err)
+}
+
+func TestServer_ListenAndServe_ip_filter(t *testing.T) {
+	tests := []struct {
+		name    string
+		allow   []string
+		deny    []string
+		message string
+		want    string
+	}{
+		{name: "allowed", allow: []string{"127.0.0.0/8"}, want: "served"},
+		{name: "denied", deny: []string{"127.0.0.1"}, message: "access denied", want: "access denied"},
+		{name: "out of allowed ranges", allow: []string{"10.0.0.0/8"}, message: "access denied", want: "access denied"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr := freeAddr(t)
+			handler := &blockingHandler{release: make(chan struct{})}
+			defer close(handler.release)
+
+			filter, err := NewIPFilter(test.allow, test.deny)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			server := NewServer(addr, handler, logger.NewNopLogger())
+			server.IPFilter = filter
+			server.DeniedMessage = test.message
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go func() {
+				_ = server.ListenAndServe(ctx)
+			}()
+
+			conn := dial(t, addr)
+			defer conn.Close()
+
+			message, err := ReadFrame(conn)
+			assert.Nil(t, err)
+			assert.Equal(t, test.want, string(message))
+		})
+	}
+
+	t.Run("denied without message", func(t *testing.T) {
+		addr := freeAddr(t)
+		handler := &blockingHandler{release: make(chan struct{})}
+		defer close(handler.release)
+
+		server := NewServer(addr, handler, logger.NewNopLogger())
+		server.IPFilter, _ = NewIPFilter(nil, []string{"127.0.0.0/8"})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go func() {
+			_ = server.ListenAndServe(ctx)
+		}()
+
+		conn := dial(t, addr)
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+
+		// the connection is closed without a message
+		_, err := ReadFrame(conn)
+		assert.NotNil(t, err)
+	})
+}
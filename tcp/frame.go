@@ -0,0 +1,141 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// FrameHeaderSize is a size of a frame header holding a big-endian payload length.
+	FrameHeaderSize = 4
+	// DefaultMaxMessageSize is an upper limit of a frame payload size
+	// for connections not declaring their own limit (see ConnWrapper.MaxMessageSize).
+	DefaultMaxMessageSize = 64 * 1024
+)
+
+// ErrMessageTooLarge is returned when a frame payload exceeds the maximum message size.
+var ErrMessageTooLarge = errors.New("message exceeds maximum size")
+
+// WriteFrame writes a payload to the connection prefixed with its length (see FrameHeaderSize).
+func WriteFrame(conn Conn, payload []byte) error {
+	if len(payload) > DefaultMaxMessageSize {
+		return fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(payload))
+	}
+
+	frame := make([]byte, FrameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[FrameHeaderSize:], payload)
+
+	if _, err := conn.Write(frame); err != nil {
+		return normalizeClosed(err)
+	}
+
+	return nil
+}
+
+// ReadFrame reads a length-prefixed payload from the connection (see WriteFrame).
+//
+// It keeps reading until the whole frame is received,
+// so a payload split into several reads is reassembled, and adjacent frames are not merged.
+// A payload exceeding the connection maximum message size is rejected with ErrMessageTooLarge
+// before it's read, so a peer cannot force large allocations.
+// If the connection is closed before the whole frame is received, it returns ErrConnClosed.
+// The returned payload is owned by the caller (see ReadFrameBuffered for a payload which is not).
+func ReadFrame(conn Conn) ([]byte, error) {
+	return readFrame(conn, nil)
+}
+
+// ReadFrameBuffered reads a length-prefixed payload from the connection like ReadFrame does,
+// except that a connection holding a pooled read buffer (see ConnWrapper) reads a frame fitting it into the buffer,
+// so no payload is allocated.
+//
+// The returned payload is then a slice of the buffer, valid until release is called:
+// release must be called once the payload is not used anymore, whether it's a slice of the buffer or not,
+// and the payload must not be retained after that.
+func ReadFrameBuffered(conn Conn) (payload []byte, release func(), err error) {
+	if buffered, ok := conn.(readBufferer); ok {
+		if buf, release := buffered.acquireReadBuffer(); buf != nil {
+			payload, err := readFrame(conn, buf)
+			if err != nil || len(payload) > len(buf) {
+				release()
+				return payload, noRelease, err
+			}
+
+			return payload, release, nil
+		}
+	}
+
+	payload, err = readFrame(conn, nil)
+
+	return payload, noRelease, err
+}
+
+// noRelease releases a payload which is not a slice of a pooled read buffer.
+func noRelease() {}
+
+// readFrame reads a frame into a buffer if it fits, or allocating its payload otherwise.
+//
+// A payload read into the buffer is a slice of it holding only the bytes of the frame,
+// so the ones of a previous frame never leak.
+func readFrame(conn Conn, buf []byte) ([]byte, error) {
+	var header []byte
+	if len(buf) >= FrameHeaderSize {
+		header = buf[:FrameHeaderSize]
+	} else {
+		header = make([]byte, FrameHeaderSize)
+	}
+	if err := readFull(conn, header); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if limit := maxMessageSize(conn); uint64(size) > uint64(limit) {
+		return nil, fmt.Errorf("%w: %d bytes exceed %d bytes", ErrMessageTooLarge, size, limit)
+	}
+
+	var payload []byte
+	if uint64(size) > uint64(len(buf)) {
+		payload = make([]byte, size)
+	} else {
+		payload = buf[:size]
+	}
+	if err := readFull(conn, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// maxMessageSize returns the maximum message size declared by a connection or DefaultMaxMessageSize.
+func maxMessageSize(conn Conn) int {
+	if limited, ok := conn.(interface{ MaxMessageSize() int }); ok && limited.MaxMessageSize() > 0 {
+		return limited.MaxMessageSize()
+	}
+
+	return DefaultMaxMessageSize
+}
+
+// readFull reads exactly len(b) bytes from the connection.
+//
+// If the connection is closed after reading some but not all the bytes, it returns io.ErrUnexpectedEOF.
+func readFull(conn Conn, b []byte) error {
+	for n := 0; n < len(b); {
+		// read bytes are copied, since a Conn implementation is not obliged to return a slice of b
+		read, err := conn.Read(b[n:])
+		n += copy(b[n:], read)
+
+		if n == len(b) {
+			return nil
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) && n > 0 {
+				return normalizeClosed(io.ErrUnexpectedEOF)
+			}
+			return normalizeClosed(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package tcp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// chunkedConn is a Conn returning inbound data in predefined chunks.
+type chunkedConn struct {
+	chunks  [][]byte
+	written bytes.Buffer
+}
+
+func (c *chunkedConn) Read(b []byte) ([]byte, error) {
+	if len(c.chunks) == 0 {
+		return nil, io.EOF
+	}
+
+	n := copy(b, c.chunks[0])
+	if n < len(c.chunks[0]) {
+		c.chunks[0] = c.chunks[0][n:]
+	} else {
+		c.chunks = c.chunks[1:]
+	}
+
+	return b[:n], nil
+}
+
+func (c *chunkedConn) Write(b []byte) (int, error) {
+	return c.written.Write(b)
+}
+
+func (c *chunkedConn) Close() error {
+	return nil
+}
+
+func (c *chunkedConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{Port: 80}
+}
+
+func (c *chunkedConn) SetDeadline(_ time.Time) error {
+	return nil
+}
+
+// chunks splits data into chunks of a given size.
+func chunks(data []byte, size int) [][]byte {
+	var split [][]byte
+	for len(data) > size {
+		split = append(split, data[:size])
+		data = data[size:]
+	}
+
+	return append(split, data)
+}
+
+func TestWriteFrame_ReadFrame(t *testing.T) {
+	messages := []string{
+		"1:12:2208082121:d778f1e9-d0a8-485e-ab51-053a12e9b397::cRvZdlXCCIrWoQ==:NDAwMjk4NDM4NTU1MTUyNDEzOA==",
+		"",
+		"a word of wisdom",
+	}
+
+	// write all the messages at once, as if TCP has coalesced them
+	writer := &chunkedConn{}
+	for _, m := range messages {
+		assert.Nil(t, WriteFrame(writer, []byte(m)))
+	}
+	stream := writer.written.Bytes()
+
+	for _, size := range []int{1, 3, 7, len(stream)} {
+		reader := &chunkedConn{chunks: chunks(stream, size)}
+
+		for _, m := range messages {
+			got, err := ReadFrame(reader)
+			assert.Nil(t, err)
+			assert.Equal(t, m, string(got), "chunk size %d", size)
+		}
+
+		_, err := ReadFrame(reader)
+		assert.ErrorIs(t, err, io.EOF)
+	}
+}
+
+func TestReadFrame_truncated(t *testing.T) {
+	writer := &chunkedConn{}
+	assert.Nil(t, WriteFrame(writer, []byte("a word of wisdom")))
+	stream := writer.written.Bytes()
+
+	reader := &chunkedConn{chunks: [][]byte{stream[:len(stream)-1]}}
+
+	_, err := ReadFrame(reader)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestReadFrame_too_large(t *testing.T) {
+	reader := &chunkedConn{chunks: [][]byte{{0xff, 0xff, 0xff, 0xff}}}
+
+	_, err := ReadFrame(reader)
+	assert.True(t, errors.Is(err, ErrMessageTooLarge))
+
+	err = WriteFrame(&chunkedConn{}, make([]byte, DefaultMaxMessageSize+1))
+	assert.True(t, errors.Is(err, ErrMessageTooLarge))
+}
+
+func TestReadFrame_max_message_size(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := NewConnWrapper(server)
+	conn.SetMaxMessageSize(16)
+
+	go func() {
+		writer := NewConnWrapper(client)
+		_ = WriteFrame(writer, []byte("short message"))
+		_ = WriteFrame(writer, []byte("a message exceeding the limit"))
+	}()
+
+	got, err := ReadFrame(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, "short message", string(got))
+
+	got, err = ReadFrame(conn)
+	assert.True(t, errors.Is(err, ErrMessageTooLarge))
+	assert.Nil(t, got)
+}
@@ -0,0 +1,179 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidProxyHeader flags that a connection doesn't start with a valid PROXY protocol header.
+var ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+
+// proxyV2Signature starts a PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	// proxyV1MaxLength is the longest PROXY protocol v1 header including CRLF.
+	proxyV1MaxLength = 107
+	// proxyV2HeaderLength is the length of a PROXY protocol v2 header preceding addresses.
+	proxyV2HeaderLength = 16
+)
+
+// ProxyConn is a net.Conn decorator of a connection accepted from a load balancer speaking PROXY protocol
+// (https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt).
+//
+// It reports the address of the client the load balancer has accepted the connection from as RemoteAddr.
+type ProxyConn struct {
+	net.Conn
+
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+// ReadProxyHeader reads a PROXY protocol v1 or v2 header a connection starts with
+// and returns the connection reporting the client address the header holds.
+//
+// If the header holds no client address (e.g. it's a health check of the load balancer), the address of the peer
+// is reported. If the connection doesn't start with a valid header, it fails with ErrInvalidProxyHeader.
+// Mind to set a read deadline, so a stalled peer doesn't hold the caller.
+func ReadProxyHeader(conn net.Conn) (*ProxyConn, error) {
+	r := bufio.NewReader(conn)
+
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("read PROXY protocol header: %w", err)
+	}
+
+	var remote net.Addr
+	switch first[0] {
+	case 'P':
+		remote, err = readProxyV1(r)
+	case proxyV2Signature[0]:
+		remote, err = readProxyV2(r)
+	default:
+		err = fmt.Errorf("%w: unknown signature", ErrInvalidProxyHeader)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if remote == nil {
+		remote = conn.RemoteAddr()
+	}
+
+	return &ProxyConn{Conn: conn, r: r, remote: remote}, nil
+}
+
+// Read reads data following the PROXY protocol header.
+func (c *ProxyConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the address of the client the load balancer has accepted the connection from.
+func (c *ProxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// readProxyV1 reads a human-readable PROXY protocol v1 header, e.g. "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("read PROXY protocol header: %w", err)
+	}
+	if err != nil || len(line) > proxyV1MaxLength || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: malformed v1 line", ErrInvalidProxyHeader)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, fmt.Errorf("%w: malformed v1 line", ErrInvalidProxyHeader)
+	}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("%w: unknown v1 protocol %q", ErrInvalidProxyHeader, fields[1])
+	}
+
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("%w: malformed v1 line", ErrInvalidProxyHeader)
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("%w: invalid v1 source address %q", ErrInvalidProxyHeader, fields[2])
+	}
+
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid v1 source port %q", ErrInvalidProxyHeader, fields[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2 reads a binary PROXY protocol v2 header.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, proxyV2HeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("read PROXY protocol header: %w", err)
+	}
+	if !bytes.Equal(header[:len(proxyV2Signature)], proxyV2Signature) {
+		return nil, fmt.Errorf("%w: unknown signature", ErrInvalidProxyHeader)
+	}
+
+	versionCommand, family := header[12], header[13]
+	if versionCommand>>4 != 2 {
+		return nil, fmt.Errorf("%w: unknown version %d", ErrInvalidProxyHeader, versionCommand>>4)
+	}
+
+	// addresses are followed by optional TLVs, which are skipped
+	addresses := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, addresses); err != nil {
+		return nil, fmt.Errorf("read PROXY protocol header: %w", err)
+	}
+
+	const (
+		commandLocal = 0x0
+		commandProxy = 0x1
+
+		familyTCP4 = 0x11
+		familyTCP6 = 0x21
+	)
+
+	switch versionCommand & 0x0f {
+	case commandLocal: // a connection established by the load balancer itself
+		return nil, nil
+	case commandProxy:
+	default:
+		return nil, fmt.Errorf("%w: unknown v2 command %d", ErrInvalidProxyHeader, versionCommand&0x0f)
+	}
+
+	var ipLength int
+	switch family {
+	case familyTCP4:
+		ipLength = net.IPv4len
+	case familyTCP6:
+		ipLength = net.IPv6len
+	default: // an unsupported protocol, whose address is not a TCP one
+		return nil, nil
+	}
+
+	if len(addresses) < 2*ipLength+4 {
+		return nil, fmt.Errorf("%w: v2 addresses are truncated", ErrInvalidProxyHeader)
+	}
+
+	ip := make(net.IP, ipLength)
+	copy(ip, addresses[:ipLength])
+	port := binary.BigEndian.Uint16(addresses[2*ipLength:])
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
@@ -0,0 +1,229 @@
+package tcp
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/laonix/pow-word-of-wisdom/logger"
+)
+
+// proxyV2Header returns a PROXY protocol v2 header of the PROXY command with TCP addresses and a trailing TLV.
+func proxyV2Header(family byte, src, dst net.IP, srcPort, dstPort uint16) []byte {
+	addresses := append(append([]byte{}, src...), dst...)
+	addresses = append(addresses, uint16Bytes(srcPort)...)
+	addresses = append(addresses, uint16Bytes(dstPort)...)
+	addresses = append(addresses, 0x04, 0x00, 0x01, 0x00) // PP2_TYPE_NOOP TLV
+
+	header := append(append([]byte{}, proxyV2Signature...), 0x21, family)
+	header = append(header, uint16Bytes(uint16(len(addresses)))...)
+
+	return append(header, addresses...)
+}
+
+func uint16Bytes(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+
+	return b
+}
+
+// readProxied feeds a header followed by a payload to ReadProxyHeader
+// and returns the connection it has returned along with the payload read from it.
+func readProxied(t *testing.T, header []byte) (*ProxyConn, string, error) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = client.Close()
+	})
+
+	go func() {
+		_, _ = client.Write(append(header, "payload"...))
+	}()
+
+	conn, err := ReadProxyHeader(server)
+	if err != nil {
+		return nil, "", err
+	}
+
+	payload := make([]byte, len("payload"))
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	return conn, string(payload), nil
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{
+			name:   "v1 TCP4",
+			header: []byte("PROXY TCP4 203.0.113.7 192.0.2.1 56324 443\r\n"),
+			want:   "203.0.113.7:56324",
+		},
+		{
+			name:   "v1 TCP6",
+			header: []byte("PROXY TCP6 2001:db8::7 2001:db8::1 56324 443\r\n"),
+			want:   "[2001:db8::7]:56324",
+		},
+		{
+			name:   "v1 unknown",
+			header: []byte("PROXY UNKNOWN\r\n"),
+			want:   "pipe",
+		},
+		{
+			name:   "v2 TCP4",
+			header: proxyV2Header(0x11, net.IPv4(203, 0, 113, 7).To4(), net.IPv4(192, 0, 2, 1).To4(), 56324, 443),
+			want:   "203.0.113.7:56324",
+		},
+		{
+			name:   "v2 TCP6",
+			header: proxyV2Header(0x21, net.ParseIP("2001:db8::7"), net.ParseIP("2001:db8::1"), 56324, 443),
+			want:   "[2001:db8::7]:56324",
+		},
+		{
+			name:   "v2 local",
+			header: append(append([]byte{}, proxyV2Signature...), 0x20, 0x00, 0x00, 0x00),
+			want:   "pipe",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, payload, err := readProxied(t, test.header)
+			if assert.Nil(t, err) {
+				assert.Equal(t, test.want, conn.RemoteAddr().String())
+				// the data following the header is read as is
+				assert.Equal(t, "payload", payload)
+			}
+		})
+	}
+}
+
+func TestReadProxyHeader_invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+	}{
+		{name: "no header", header: []byte("ping")},
+		{name: "v1 unknown protocol", header: []byte("PROXY UDP4 203.0.113.7 192.0.2.1 56324 443\r\n")},
+		{name: "v1 address family mismatch", header: []byte("PROXY TCP4 2001:db8::7 192.0.2.1 56324 443\r\n")},
+		{name: "v1 invalid port", header: []byte("PROXY TCP4 203.0.113.7 192.0.2.1 port 443\r\n")},
+		{name: "v1 missing CR", header: []byte("PROXY TCP4 203.0.113.7 192.0.2.1 56324 443\n")},
+		{name: "v2 unknown version", header: append(append([]byte{}, proxyV2Signature...), 0x11, 0x11, 0x00, 0x00)},
+		{name: "v2 truncated addresses", header: append(append([]byte{}, proxyV2Signature...), 0x21, 0x11, 0x00, 0x04, 1, 2, 3, 4)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := readProxied(t, test.header)
+			assert.ErrorIs(t, err, ErrInvalidProxyHeader)
+		})
+	}
+}
+
+// remoteHandler reports the remote address of every served connection.
+type remoteHandler struct {
+	remotes chan string
+}
+
+func (h *remoteHandler) ServeTCP(_ context.Context, conn Conn) {
+	defer conn.Close()
+
+	h.remotes <- RemoteAddr(conn)
+}
+
+func TestServer_ListenAndServe_proxy_protocol(t *testing.T) {
+	addr := freeAddr(t)
+	handler := &remoteHandler{remotes: make(chan string, 1)}
+
+	server := NewServer(addr, handler, logger.NewNopLogger())
+	server.ProxyProtocol = true
+	// the load balancer address is denied, while the client address is not
+	server.IPFilter, _ = NewIPFilter(nil, []string{"127.0.0.0/8"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = server.ListenAndServe(ctx)
+	}()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	_, err := conn.Write([]byte("PROXY TCP4 203.0.113.7 192.0.2.1 56324 443\r\n"))
+	assert.Nil(t, err)
+
+	select {
+	case remote := <-handler.remotes:
+		assert.Equal(t, "203.0.113.7:56324", remote)
+	case <-time.After(time.Second):
+		t.Fatal("connection is not served")
+	}
+
+	// a connection without a header is closed
+	plain := dial(t, addr)
+	defer plain.Close()
+
+	_, err = plain.Write([]byte("ping"))
+	assert.Nil(t, err)
+	_ = plain.SetDeadline(time.Now().Add(time.Second))
+
+	_, err = ReadFrame(plain)
+	assert.ErrorIs(t, err, ErrConnClosed)
+}
+
+func TestServer_ListenAndServe_proxy_protocol_stalled_header(t *testing.T) {
+	addr := freeAddr(t)
+	handler := &blockingHandler{release: make(chan struct{})}
+	defer close(handler.release)
+
+	server := NewServer(addr, handler, logger.NewNopLogger())
+	server.ProxyProtocol = true
+	server.ProxyHeaderTimeout = 200 * time.Millisecond
+	server.MaxConcurrentConns = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = server.ListenAndServe(ctx)
+	}()
+
+	// a peer stalling on the header takes the only slot
+	stalled := dial(t, addr)
+	defer stalled.Close()
+
+	assert.Eventually(t, func() bool {
+		conn := dial(t, addr)
+		defer conn.Close()
+
+		message, err := ReadFrame(conn)
+		return err == nil && string(message) == "server is busy"
+	}, time.Second, 10*time.Millisecond)
+
+	// the stalled peer is dropped once the header timeout is over, freeing the slot
+	_ = stalled.SetDeadline(time.Now().Add(time.Second))
+	_, err := ReadFrame(stalled)
+	assert.ErrorIs(t, err, ErrConnClosed)
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("PROXY TCP4 203.0.113.7 192.0.2.1 56324 443\r\n"))
+	assert.Nil(t, err)
+
+	message, err := ReadFrame(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, "served", string(message))
+}
@@ -2,88 +2,611 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/laonix/pow-word-of-wisdom/logger"
+	"github.com/laonix/pow-word-of-wisdom/metrics"
 )
 
-const NetworkTcp = "tcp"
+// Networks a Server listens on (see Server.Network).
+const (
+	// NetworkTcp listens on both IPv4 and IPv6 addresses.
+	NetworkTcp = "tcp"
+	// NetworkTcp4 listens on IPv4 addresses only.
+	NetworkTcp4 = "tcp4"
+	// NetworkTcp6 listens on IPv6 addresses only.
+	NetworkTcp6 = "tcp6"
+	// NetworkUnix listens on Unix domain sockets for local inter-process communication.
+	NetworkUnix = "unix"
+)
+
+// DefaultProxyHeaderTimeout is the longest duration of reading a PROXY protocol header
+// for a Server not declaring its own limit (see Server.ProxyHeaderTimeout).
+// A load balancer sends the header right away, so it's short regardless of ReadTimeout.
+const DefaultProxyHeaderTimeout = 5 * time.Second
 
 // Handler is a contract to serve a TCP connection.
 type Handler interface {
 	ServeTCP(ctx context.Context, conn Conn)
 }
 
+type connIDKey struct{}
+
+// WithConnID returns a copy of the context carrying the id of the connection being served.
+//
+// Server passes such a context to the Handler, so the handler and everything it calls can correlate their logs.
+func WithConnID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, connIDKey{}, id)
+}
+
+// ConnIDFrom returns the id of the connection being served, if the context carries one (see WithConnID).
+func ConnIDFrom(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(connIDKey{}).(string)
+	return id, ok
+}
+
 // Server holds settings and handler to serve accepted TCP connections.
 type Server struct {
-	addr    string
+	addrs   []string
 	handler Handler
 	log     logger.Logger
+
+	// Network is either NetworkTcp (default), NetworkTcp4, NetworkTcp6 or NetworkUnix network to listen on.
+	// TCP addresses are "host:port" pairs, where the host is an IPv4 or IPv6 literal (e.g. "[::1]:8080")
+	// or a hostname, and an empty host (e.g. ":8080") or "[::]" stands for all the addresses of the network.
+	// Unix addresses are socket file paths; a socket file is removed once its listener is closed.
+	Network string
+
+	// MaxMessageSize is an upper limit of a message size to read from accepted connections.
+	// If it's not set, DefaultMaxMessageSize is used.
+	MaxMessageSize int
+
+	// ReadTimeout is the longest duration of a single read from an accepted connection.
+	// It must exceed the time a client is allowed to spend on PoW calculation.
+	// A zero ReadTimeout means no timeout.
+	ReadTimeout time.Duration
+	// WriteTimeout is the longest duration of a single write to an accepted connection.
+	// A zero WriteTimeout means no timeout.
+	WriteTimeout time.Duration
+
+	// MaxConcurrentConns is an upper limit of connections served at the same time.
+	// A connection accepted over the limit is informed that the server is busy and closed right away.
+	// A zero MaxConcurrentConns means no limit.
+	MaxConcurrentConns int
+
+	// IPFilter restricts remote IPs connections are accepted from, if it's set.
+	// A denied connection is closed right away, before it's handed over to the Handler.
+	IPFilter *IPFilter
+	// DeniedMessage is written to a denied connection before it's closed, if it's set.
+	DeniedMessage string
+
+	// ProxyProtocol flags that connections are accepted from a load balancer speaking PROXY protocol v1 or v2,
+	// so every accepted connection must start with a PROXY protocol header holding the client address (see ProxyConn).
+	// A connection without a valid header is closed.
+	ProxyProtocol bool
+	// ProxyHeaderTimeout is the longest duration of reading the PROXY protocol header of an accepted connection.
+	// If it's not set, DefaultProxyHeaderTimeout is used.
+	ProxyHeaderTimeout time.Duration
+
+	// TLSConfig enables TLS on accepted connections if it's set.
+	// It must hold at least one certificate (or GetCertificate callback).
+	TLSConfig *tls.Config
+
+	// Prometheus counts accepted connections, if it's set.
+	Prometheus *metrics.Prometheus
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	// shutdown is closed once Shutdown has been called
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+	// active tracks connections being served; it's only added to under mu before shutdown is closed (see track),
+	// so an Add never races with the Wait of Shutdown
+	active sync.WaitGroup
+	// served is the number of connections being served by the Handler (see ActiveConns)
+	served int64
 }
 
 // NewServer returns a new instance of Server.
 func NewServer(addr string, handler Handler, log logger.Logger) *Server {
 	return &Server{
-		addr:    addr,
-		handler: handler,
-		log:     log,
+		addrs:    []string{addr},
+		handler:  handler,
+		log:      log,
+		shutdown: make(chan struct{}),
 	}
 }
 
-// ListenAndServe listens for a new TCP connections on a declared port.
+// AddListener adds an address to listen for TCP connections on along with the address passed to NewServer.
+//
+// It must be called before ListenAndServe.
+func (s *Server) AddListener(addr string) {
+	s.addrs = append(s.addrs, addr)
+}
+
+// ListenAndServe listens for a new TCP connections on declared addresses.
 //
 // Once the connection accepted control hands over to the underlying Handler.
-// If the context is cancelled, TCP connections listener closes.
+// If the context is cancelled or Shutdown is called, TCP connections listeners close.
+// If some listeners fail, ListenAndServe returns their aggregated errors once all the listeners are closed.
 func (s *Server) ListenAndServe(ctx context.Context) error {
-	addr, err := net.ResolveTCPAddr(NetworkTcp, s.addr)
-	if err != nil {
-		return fmt.Errorf("resolve TCP address: %w", err)
+	network := s.network()
+	if err := ValidateNetwork(network); err != nil {
+		return err
 	}
 
-	l, err := net.ListenTCP(NetworkTcp, addr)
-	if err != nil {
-		return fmt.Errorf("listen TCP: %w", err)
+	listeners := make([]deadlineListener, 0, len(s.addrs))
+	for _, a := range s.addrs {
+		l, err := listen(network, a)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, l)
 	}
 
-	host, port, err := net.SplitHostPort(l.Addr().String())
+	// slots is a semaphore limiting the number of connections served at the same time over all the listeners
+	var slots chan struct{}
+	if s.MaxConcurrentConns > 0 {
+		slots = make(chan struct{}, s.MaxConcurrentConns)
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l deadlineListener) {
+			errs <- s.acceptLoop(ctx, l, slots)
+		}(l)
+	}
+
+	var failed listenersError
+	for range listeners {
+		if err := <-errs; err != nil {
+			s.log.Error(err, "action", "accept TCP connections")
+			failed = append(failed, err)
+		}
+	}
+
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return failed[0]
+	default:
+		return failed
+	}
+}
+
+// network returns the network to listen on: Network or NetworkTcp if it's not set.
+func (s *Server) network() string {
+	if s.Network == "" {
+		return NetworkTcp
+	}
+
+	return s.Network
+}
+
+// ValidateNetwork checks if a network is either NetworkTcp, NetworkTcp4, NetworkTcp6 or NetworkUnix.
+func ValidateNetwork(network string) error {
+	switch network {
+	case NetworkTcp, NetworkTcp4, NetworkTcp6, NetworkUnix:
+		return nil
+	default:
+		return fmt.Errorf("unsupported network %q: it must be either %s, %s, %s or %s",
+			network, NetworkTcp, NetworkTcp4, NetworkTcp6, NetworkUnix)
+	}
+}
+
+// deadlineListener is a listener which Accept may be bounded with a deadline,
+// such as *net.TCPListener and *net.UnixListener.
+type deadlineListener interface {
+	net.Listener
+	SetDeadline(t time.Time) error
+}
+
+// listen listens for connections on an address of the network.
+//
+// A hostname is resolved to a single address (an IPv4 one is preferred for NetworkTcp).
+// A Unix socket file is removed once the listener is closed.
+func listen(network, addr string) (deadlineListener, error) {
+	l, err := net.Listen(network, addr)
 	if err != nil {
-		return fmt.Errorf("get listened host and port: %w", err)
+		return nil, fmt.Errorf("listen on %q: %w", addr, err)
+	}
+
+	if ul, ok := l.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(true)
+	}
+
+	dl, ok := l.(deadlineListener)
+	if !ok {
+		_ = l.Close()
+		return nil, fmt.Errorf("listen on %q: %s listener doesn't support deadlines", addr, network)
+	}
+
+	return dl, nil
+}
+
+// acceptLoop accepts connections on a listener until the context is cancelled or Shutdown is called.
+func (s *Server) acceptLoop(ctx context.Context, l deadlineListener, slots chan struct{}) error {
+	// a TLS handshake is performed on the first read from or write to an accepted connection;
+	// behind a load balancer speaking PROXY protocol, it's performed once the PROXY protocol header is read (see admit)
+	var listener net.Listener = l
+	if s.TLSConfig != nil && !s.ProxyProtocol {
+		listener = tls.NewListener(l, s.TLSConfig)
+	}
+
+	if !s.trackListener(listener) { // Shutdown has been called already
+		return nil
+	}
+
+	if s.network() == NetworkUnix {
+		s.log.Info("listening for Unix socket connections", "path", l.Addr().String(), "tls", s.TLSConfig != nil)
+	} else {
+		host, port, err := net.SplitHostPort(l.Addr().String())
+		if err != nil {
+			s.closeListener(listener)
+			return fmt.Errorf("get listened host and port: %w", err)
+		}
+		s.log.Info("listening for TCP connections", "network", s.network(), "host", host, "port", port,
+			"tls", s.TLSConfig != nil)
 	}
-	s.log.Info("listening for TCP connections", "host", host, "port", port)
 
 	// while listening for accepting connections we might get context cancellation
 	for {
 		select {
+		case <-s.shutdown: // handle shutdown
+			{
+				s.closeListener(listener)
+				return nil
+			}
 		case <-ctx.Done(): // handle context cancellation
 			{
-				s.log.Debug("close TCP connections listener")
-				if err := l.Close(); err != nil {
-					s.log.Error(err, "action", "close TCP connections listener")
-				}
+				s.closeListener(listener)
 				return nil
 			}
 		default: // waiting for connections to accept
 			{
 				// to loop over we set a short deadline to the listener
 				if err := l.SetDeadline(time.Now().Add(time.Second)); err != nil {
-					return fmt.Errorf("set TCP listener deadline: %w", err)
+					if s.isShuttingDown() { // the listener has been closed by Shutdown
+						return nil
+					}
+					s.closeListener(listener)
+					return fmt.Errorf("set listener deadline: %w", err)
 				}
 
-				conn, err := l.Accept()
+				conn, err := listener.Accept()
 				if err != nil {
 					if os.IsTimeout(err) { // if the error is received due to timeout keep looping
 						continue
 					}
+					if s.isShuttingDown() { // the listener has been closed by Shutdown
+						return nil
+					}
+					s.closeListener(listener)
 					return fmt.Errorf("accept connection: %w", err)
 				}
 
-				wrapped := &ConnWrapper{conn: conn}
+				s.Prometheus.Accepted()
 
-				go s.handler.ServeTCP(ctx, wrapped)
+				if s.ProxyProtocol {
+					s.admitProxied(ctx, conn, slots)
+				} else {
+					s.admit(ctx, conn, slots)
+				}
 			}
 		}
 	}
 }
+
+// Shutdown gracefully shuts down the server: it closes the listener, so no new connections are accepted,
+// and waits for the connections being served to complete.
+//
+// If the context is done before the connections complete, Shutdown returns the context error.
+// The connections being served are not interrupted: cancel the context passed to ListenAndServe to do so.
+// Shutdown can be called more than once, e.g. to wait for interrupted connections to wrap up.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		s.mu.Lock()
+		close(s.shutdown)
+		listeners := s.listeners
+		s.mu.Unlock()
+
+		for _, l := range listeners {
+			s.closeListener(l)
+		}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// admit hands over an accepted connection to the underlying Handler,
+// unless its remote IP is denied or the limit of concurrent connections has been reached.
+func (s *Server) admit(ctx context.Context, conn net.Conn, slots chan struct{}) {
+	wrapped := s.wrap(conn)
+
+	if !s.IPFilter.Allowed(wrapped.RemoteAddr()) {
+		s.rejectDenied(wrapped)
+		return
+	}
+
+	release, ok := acquire(slots)
+	if !ok {
+		s.rejectBusy(wrapped)
+		return
+	}
+
+	s.serve(ctx, wrapped, release)
+}
+
+// admitProxied hands over a connection accepted from a load balancer to the underlying Handler
+// once its PROXY protocol header is read, unless the client IP is denied (see admit).
+//
+// A slot of MaxConcurrentConns is taken before the header is read, so peers stalling on the header
+// count towards the limit rather than pile up in background.
+func (s *Server) admitProxied(ctx context.Context, conn net.Conn, slots chan struct{}) {
+	release, ok := acquire(slots)
+	if !ok {
+		s.rejectBusy(s.wrap(conn))
+		return
+	}
+
+	// the PROXY protocol header is read in background, so a stalled peer doesn't hold the listener
+	if !s.track() {
+		release()
+		s.rejectShuttingDown(s.wrap(conn))
+		return
+	}
+	go func() {
+		defer s.active.Done()
+
+		proxied, ok := s.readProxyHeader(conn)
+		if !ok {
+			release()
+			return
+		}
+
+		wrapped := s.wrap(proxied)
+		if !s.IPFilter.Allowed(wrapped.RemoteAddr()) {
+			release()
+			s.rejectDenied(wrapped)
+			return
+		}
+
+		s.serve(ctx, wrapped, release)
+	}()
+}
+
+// wrap returns an accepted connection wrapped with the server limits of message size and timeouts.
+func (s *Server) wrap(conn net.Conn) *ConnWrapper {
+	wrapped := NewConnWrapper(conn)
+	wrapped.SetMaxMessageSize(s.MaxMessageSize)
+	wrapped.SetTimeouts(s.ReadTimeout, s.WriteTimeout)
+
+	return wrapped
+}
+
+// acquire takes one of the slots of concurrent connections and returns a function to release it,
+// or false if all of them are taken. There's no limit if slots is nil.
+func acquire(slots chan struct{}) (release func(), ok bool) {
+	if slots == nil {
+		return func() {}, true
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	default:
+		return nil, false
+	}
+}
+
+// readProxyHeader reads the PROXY protocol header of an accepted connection within ProxyHeaderTimeout
+// and returns the connection reporting the client address (see ProxyConn), wrapped in TLS if it's enabled.
+//
+// If the header cannot be read, the connection is closed, and false is returned.
+func (s *Server) readProxyHeader(conn net.Conn) (net.Conn, bool) {
+	timeout := s.ProxyHeaderTimeout
+	if timeout <= 0 {
+		timeout = DefaultProxyHeaderTimeout
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+
+	proxied, err := ReadProxyHeader(conn)
+	if err != nil {
+		s.log.Error(err, "action", "read PROXY protocol header", "remote", conn.RemoteAddr().String())
+		if err := conn.Close(); err != nil {
+			s.log.Error(err, "action", "close TCP connection", "remote", conn.RemoteAddr().String())
+		}
+		return nil, false
+	}
+
+	_ = conn.SetReadDeadline(time.Time{})
+
+	if s.TLSConfig != nil {
+		return tls.Server(proxied, s.TLSConfig), true
+	}
+
+	return proxied, true
+}
+
+// serve hands over control to the underlying Handler in a separate goroutine and tracks it until it returns.
+//
+// A panic of the Handler is recovered (see recoverHandler), so it doesn't crash the server.
+// A connection admitted once Shutdown has been called is closed rather than served.
+func (s *Server) serve(ctx context.Context, conn Conn, release func()) {
+	if !s.track() {
+		release()
+		s.rejectShuttingDown(conn)
+		return
+	}
+	atomic.AddInt64(&s.served, 1)
+
+	go func() {
+		defer s.active.Done()
+		defer atomic.AddInt64(&s.served, -1)
+		defer release()
+		defer s.recoverHandler(conn)
+
+		s.handler.ServeTCP(WithConnID(ctx, ConnID(conn)), conn)
+	}()
+}
+
+// track registers a goroutine serving a connection to be waited for by Shutdown.
+//
+// It returns false if Shutdown has been called already. The shutdown is flagged under the same lock,
+// so no goroutine is registered once Shutdown may be waiting.
+func (s *Server) track() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isShuttingDown() {
+		return false
+	}
+	s.active.Add(1)
+
+	return true
+}
+
+// ActiveConns returns the number of connections being served by the Handler at the moment.
+func (s *Server) ActiveConns() int {
+	return int(atomic.LoadInt64(&s.served))
+}
+
+// recoverHandler recovers from a panic of the Handler serving a connection:
+// the panic is logged along with the connection id and the stack trace, and the connection is closed,
+// while the server keeps serving other connections.
+func (s *Server) recoverHandler(conn Conn) {
+	p := recover()
+	if p == nil {
+		return
+	}
+
+	s.log.Error(fmt.Errorf("handler panic: %v", p), "action", "serve TCP connection",
+		"conn_id", ConnID(conn), "remote", RemoteAddr(conn), "stack", string(debug.Stack()))
+
+	if err := conn.Close(); err != nil {
+		s.log.Debug("close TCP connection after handler panic", "conn_id", ConnID(conn), "err", err)
+	}
+}
+
+// trackListener registers a listener to be closed by Shutdown.
+//
+// It returns false and closes the listener if Shutdown has been called already.
+func (s *Server) trackListener(l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.shutdown:
+		_ = l.Close()
+		return false
+	default:
+		s.listeners = append(s.listeners, l)
+		return true
+	}
+}
+
+// closeListener closes a listener, if it's not closed yet.
+func (s *Server) closeListener(l net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, tracked := range s.listeners {
+		if tracked != l {
+			continue
+		}
+
+		s.log.Debug("close TCP connections listener", "address", l.Addr().String())
+		if err := l.Close(); err != nil {
+			s.log.Error(err, "action", "close TCP connections listener", "address", l.Addr().String())
+		}
+		s.listeners = append(s.listeners[:i], s.listeners[i+1:]...)
+		return
+	}
+}
+
+func (s *Server) isShuttingDown() bool {
+	select {
+	case <-s.shutdown:
+		return true
+	default:
+		return false
+	}
+}
+
+// rejectBusy informs the client that the server has reached the limit of concurrent connections
+// and closes the connection.
+func (s *Server) rejectBusy(conn Conn) {
+	log := logger.WithFields(s.log, "conn_id", ConnID(conn))
+
+	log.Warn("too many concurrent connections", "limit", s.MaxConcurrentConns, "remote", RemoteAddr(conn))
+
+	if err := WriteFrame(conn, []byte("server is busy")); err != nil {
+		log.Error(err, "action", "write message", "remote", RemoteAddr(conn))
+	}
+	if err := conn.Close(); err != nil {
+		log.Error(err, "action", "close TCP connection", "remote", RemoteAddr(conn))
+	}
+}
+
+// rejectShuttingDown closes a connection accepted while the server was shutting down.
+func (s *Server) rejectShuttingDown(conn Conn) {
+	log := logger.WithFields(s.log, "conn_id", ConnID(conn))
+
+	log.Debug("server is shutting down, connection rejected", "remote", RemoteAddr(conn))
+
+	if err := conn.Close(); err != nil {
+		log.Error(err, "action", "close TCP connection", "remote", RemoteAddr(conn))
+	}
+}
+
+// rejectDenied closes a connection from a remote IP denied by IPFilter,
+// informing the client with DeniedMessage if it's set.
+func (s *Server) rejectDenied(conn Conn) {
+	log := logger.WithFields(s.log, "conn_id", ConnID(conn))
+
+	log.Debug("connection denied", "remote", RemoteAddr(conn))
+
+	if s.DeniedMessage != "" {
+		if err := WriteFrame(conn, []byte(s.DeniedMessage)); err != nil {
+			log.Error(err, "action", "write message", "remote", RemoteAddr(conn))
+		}
+	}
+	if err := conn.Close(); err != nil {
+		log.Error(err, "action", "close TCP connection", "remote", RemoteAddr(conn))
+	}
+}
+
+// listenersError aggregates errors of several listeners.
+type listenersError []error
+
+func (e listenersError) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return fmt.Sprintf("%d listeners failed: %s", len(e), strings.Join(messages, "; "))
+}
@@ -0,0 +1,523 @@
+package tcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/laonix/pow-word-of-wisdom/logger"
+	"github.com/laonix/pow-word-of-wisdom/metrics"
+)
+
+// blockingHandler greets every served connection and holds it until released.
+type blockingHandler struct {
+	release chan struct{}
+}
+
+func (h *blockingHandler) ServeTCP(_ context.Context, conn Conn) {
+	defer conn.Close()
+
+	_ = WriteFrame(conn, []byte("served"))
+	<-h.release
+}
+
+// connIDHandler reports whether the context passed to it carries the id of the connection served.
+type connIDHandler struct {
+	same chan bool
+}
+
+func (h *connIDHandler) ServeTCP(ctx context.Context, conn Conn) {
+	defer conn.Close()
+
+	id, ok := ConnIDFrom(ctx)
+	h.same <- ok && id != "" && id == ConnID(conn)
+}
+
+// panickingHandler panics serving the first connection and greets the rest of them.
+type panickingHandler struct {
+	served int32
+}
+
+func (h *panickingHandler) ServeTCP(_ context.Context, conn Conn) {
+	if atomic.AddInt32(&h.served, 1) == 1 {
+		var m map[string]int
+		m["panic"]++ // assignment to entry in nil map
+	}
+
+	defer conn.Close()
+	_ = WriteFrame(conn, []byte("served"))
+}
+
+// errorLogger records the key-value pairs logged along with errors.
+type errorLogger struct {
+	logger.Logger
+
+	mu     sync.Mutex
+	errors []map[string]any
+}
+
+func (l *errorLogger) Error(err error, kvs ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	record := map[string]any{"err": err.Error()}
+	for i := 0; i+1 < len(kvs); i += 2 {
+		record[fmt.Sprint(kvs[i])] = kvs[i+1]
+	}
+	l.errors = append(l.errors, record)
+}
+
+func (l *errorLogger) records() []map[string]any {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]map[string]any(nil), l.errors...)
+}
+
+// freeAddr returns a loopback address with a port free to listen on.
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen(NetworkTcp, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	return l.Addr().String()
+}
+
+// freePort returns a port free to listen on at a loopback address of the network,
+// skipping the test if the network is not available.
+func freePort(t *testing.T, network string) string {
+	host := "127.0.0.1"
+	if network == NetworkTcp6 {
+		host = "::1"
+	}
+
+	l, err := net.Listen(network, net.JoinHostPort(host, "0"))
+	if err != nil {
+		t.Skipf("%s is not available: %v", network, err)
+	}
+	defer l.Close()
+
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return port
+}
+
+// dial connects to the server, retrying while it's starting up.
+func dial(t *testing.T, addr string) Conn {
+	var err error
+	for i := 0; i < 50; i++ {
+		var conn net.Conn
+		if conn, err = net.Dial(NetworkTcp, addr); err == nil {
+			return NewConnWrapper(conn)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(err)
+
+	return nil
+}
+
+func TestServer_ListenAndServe_max_concurrent_conns(t *testing.T) {
+	addr := freeAddr(t)
+	handler := &blockingHandler{release: make(chan struct{})}
+
+	server := NewServer(addr, handler, logger.NewNopLogger())
+	server.MaxConcurrentConns = 2
+	server.Prometheus = metrics.NewPrometheus()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = server.ListenAndServe(ctx)
+	}()
+
+	// the first two connections occupy all the slots
+	for i := 0; i < 2; i++ {
+		conn := dial(t, addr)
+		defer conn.Close()
+
+		message, err := ReadFrame(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, "served", string(message))
+	}
+
+	// the third one is rejected
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	message, err := ReadFrame(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, "server is busy", string(message))
+
+	// a rejected connection has been accepted as well
+	assert.Equal(t, float64(3), testutil.ToFloat64(server.Prometheus.Connections))
+
+	// once a slot is freed, a new connection is served again
+	handler.release <- struct{}{}
+
+	assert.Eventually(t, func() bool {
+		conn := dial(t, addr)
+		defer conn.Close()
+
+		message, err := ReadFrame(conn)
+		return err == nil && string(message) == "served"
+	}, time.Second, 10*time.Millisecond)
+
+	close(handler.release)
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	addr := freeAddr(t)
+	handler := &blockingHandler{release: make(chan struct{})}
+
+	server := NewServer(addr, handler, logger.NewNopLogger())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe(ctx)
+	}()
+
+	// a slow connection is being served
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	message, err := ReadFrame(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, "served", string(message))
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- server.Shutdown(context.Background())
+	}()
+
+	// the listener is closed, while Shutdown blocks until the handler completes
+	assert.Nil(t, <-served)
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown completed with a connection in flight: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_, err = net.Dial(NetworkTcp, addr)
+	assert.NotNil(t, err)
+
+	close(handler.release)
+
+	select {
+	case err := <-shutdown:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown hasn't completed after the handler returned")
+	}
+}
+
+func TestServer_serve_after_Shutdown(t *testing.T) {
+	server := NewServer(freeAddr(t), &blockingHandler{release: make(chan struct{})}, logger.NewNopLogger())
+	assert.Nil(t, server.Shutdown(context.Background()))
+
+	// a connection admitted once Shutdown has been called is closed without being served,
+	// so Shutdown never waits for a connection tracked after it has started waiting
+	client, conn := net.Pipe()
+	defer client.Close()
+
+	released := false
+	server.serve(context.Background(), NewConnWrapper(conn), func() { released = true })
+
+	assert.True(t, released)
+	assert.Equal(t, 0, server.ActiveConns())
+	_, err := ReadFrame(NewConnWrapper(client))
+	assert.ErrorIs(t, err, ErrConnClosed)
+	assert.Nil(t, server.Shutdown(context.Background()))
+}
+
+func TestServer_ActiveConns(t *testing.T) {
+	addr := freeAddr(t)
+	handler := &blockingHandler{release: make(chan struct{})}
+
+	server := NewServer(addr, handler, logger.NewNopLogger())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = server.ListenAndServe(ctx)
+	}()
+
+	for i := 0; i < 2; i++ {
+		conn := dial(t, addr)
+		defer conn.Close()
+
+		_, err := ReadFrame(conn)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 2, server.ActiveConns())
+
+	close(handler.release)
+	assert.Nil(t, server.Shutdown(context.Background()))
+	assert.Equal(t, 0, server.ActiveConns())
+}
+
+func TestServer_Shutdown_deadline(t *testing.T) {
+	addr := freeAddr(t)
+	handler := &blockingHandler{release: make(chan struct{})}
+	defer close(handler.release)
+
+	server := NewServer(addr, handler, logger.NewNopLogger())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = server.ListenAndServe(ctx)
+	}()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	_, err := ReadFrame(conn)
+	assert.Nil(t, err)
+
+	timeout, timeoutCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer timeoutCancel()
+
+	assert.ErrorIs(t, server.Shutdown(timeout), context.DeadlineExceeded)
+}
+
+func TestServer_ListenAndServe_listen_error(t *testing.T) {
+	addr := freeAddr(t)
+
+	server := NewServer(addr, &blockingHandler{}, logger.NewNopLogger())
+	server.AddListener("unresolvable:address")
+
+	err := server.ListenAndServe(context.Background())
+	assert.NotNil(t, err)
+
+	// the listener opened before the failure is closed
+	l, err := net.Listen(NetworkTcp, addr)
+	if assert.Nil(t, err) {
+		_ = l.Close()
+	}
+}
+
+func TestServer_ListenAndServe_conn_id(t *testing.T) {
+	addr := freeAddr(t)
+	handler := &connIDHandler{same: make(chan bool, 1)}
+
+	server := NewServer(addr, handler, logger.NewNopLogger())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = server.ListenAndServe(ctx)
+	}()
+
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	select {
+	case same := <-handler.same:
+		assert.True(t, same)
+	case <-time.After(time.Second):
+		t.Fatal("connection is not served")
+	}
+}
+
+func TestServer_ListenAndServe_network(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		// host is the host to listen on and dialHost is the one to connect to
+		host     string
+		dialHost string
+	}{
+		{name: "IPv4 literal", network: NetworkTcp4, host: "127.0.0.1", dialHost: "127.0.0.1"},
+		{name: "IPv4 any", network: NetworkTcp4, host: "", dialHost: "127.0.0.1"},
+		{name: "hostname", network: NetworkTcp, host: "localhost", dialHost: "127.0.0.1"},
+		{name: "default network", host: "", dialHost: "127.0.0.1"},
+		{name: "IPv6 literal", network: NetworkTcp6, host: "::1", dialHost: "::1"},
+		{name: "IPv6 any", network: NetworkTcp6, host: "::", dialHost: "::1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			network := test.network
+			if network == "" {
+				network = NetworkTcp4
+			}
+			port := freePort(t, network)
+			handler := &blockingHandler{release: make(chan struct{})}
+			defer close(handler.release)
+
+			server := NewServer(net.JoinHostPort(test.host, port), handler, logger.NewNopLogger())
+			server.Network = test.network
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go func() {
+				_ = server.ListenAndServe(ctx)
+			}()
+
+			conn := dial(t, net.JoinHostPort(test.dialHost, port))
+			defer conn.Close()
+
+			message, err := ReadFrame(conn)
+			assert.Nil(t, err)
+			assert.Equal(t, "served", string(message))
+		})
+	}
+}
+
+func TestServer_ListenAndServe_network_error(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		addr    string
+		want    string
+	}{
+		{
+			name:    "unsupported network",
+			network: "udp",
+			addr:    "127.0.0.1:0",
+			want:    `unsupported network "udp": it must be either tcp, tcp4, tcp6 or unix`,
+		},
+		{
+			name:    "IPv4 address on IPv6 network",
+			network: NetworkTcp6,
+			addr:    "127.0.0.1:0",
+			want:    `listen on "127.0.0.1:0"`,
+		},
+		{
+			name:    "IPv6 address on IPv4 network",
+			network: NetworkTcp4,
+			addr:    "[::1]:0",
+			want:    `listen on "[::1]:0"`,
+		},
+		{
+			name:    "IPv6 literal without brackets",
+			network: NetworkTcp,
+			addr:    "::1:0",
+			want:    `listen on "::1:0"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := NewServer(test.addr, &blockingHandler{}, logger.NewNopLogger())
+			server.Network = test.network
+
+			err := server.ListenAndServe(context.Background())
+			if assert.NotNil(t, err) {
+				assert.Contains(t, err.Error(), test.want)
+			}
+		})
+	}
+}
+
+func TestServer_ListenAndServe_handler_panic(t *testing.T) {
+	addr := freeAddr(t)
+	log := &errorLogger{Logger: logger.NewNopLogger()}
+
+	server := NewServer(addr, &panickingHandler{}, log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = server.ListenAndServe(ctx)
+	}()
+
+	// the connection of the panicking handler is closed
+	conn := dial(t, addr)
+	defer conn.Close()
+
+	_, err := ReadFrame(conn)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// and the panic is logged along with the connection id
+	records := log.records()
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "handler panic: assignment to entry in nil map", records[0]["err"])
+		assert.NotEmpty(t, records[0]["conn_id"])
+		assert.True(t, strings.Contains(fmt.Sprint(records[0]["stack"]), "panickingHandler"))
+	}
+
+	// the server keeps serving new connections
+	for i := 0; i < 2; i++ {
+		conn := dial(t, addr)
+		defer conn.Close()
+
+		message, err := ReadFrame(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, "served", string(message))
+	}
+}
+
+func TestServer_ListenAndServe_unix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pow.sock")
+	handler := &blockingHandler{release: make(chan struct{})}
+
+	server := NewServer(path, handler, logger.NewNopLogger())
+	server.Network = NetworkUnix
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe(ctx)
+	}()
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial(NetworkUnix, path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	message, err := ReadFrame(NewConnWrapper(conn))
+	assert.Nil(t, err)
+	assert.Equal(t, "served", string(message))
+
+	close(handler.release)
+
+	// the socket file is removed on shutdown
+	assert.Nil(t, server.Shutdown(context.Background()))
+	select {
+	case err := <-served:
+		assert.Nil(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("server hasn't stopped")
+	}
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "socket file is left: %v", err)
+}
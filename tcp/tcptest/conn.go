@@ -0,0 +1,180 @@
+// Package tcptest provides utilities for testing tcp.Handler implementations without a network.
+package tcptest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/laonix/pow-word-of-wisdom/tcp"
+)
+
+// Conn is a fake tcp.Conn: it serves a script of inbound messages and captures outbound ones.
+//
+// Messages are framed as with tcp.WriteFrame, so a handler reads them with tcp.ReadFrame.
+// Once the inbound messages are over, a read fails as if the peer has closed the connection,
+// so a handler never blocks on it. Once the connection is closed, reads and writes fail with net.ErrClosed.
+//
+// It's safe for concurrent use.
+type Conn struct {
+	// OnWrite is called with every message written to the connection, if it's set.
+	// It may push inbound messages replying to the written one (see Push), e.g. a result for a PoW challenge.
+	OnWrite func(message string)
+
+	mu       sync.Mutex
+	inbound  bytes.Buffer
+	outbound bytes.Buffer
+	written  []string
+	closed   bool
+	deadline time.Time
+	remote   net.Addr
+}
+
+var _ tcp.Conn = (*Conn)(nil)
+
+// NewConn returns a new instance of Conn serving the inbound messages in order.
+func NewConn(messages ...string) *Conn {
+	c := &Conn{remote: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4242}}
+	c.Push(messages...)
+
+	return c
+}
+
+// Push appends messages to the inbound ones.
+func (c *Conn) Push(messages ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, message := range messages {
+		var header [tcp.FrameHeaderSize]byte
+		binary.BigEndian.PutUint32(header[:], uint32(len(message)))
+		c.inbound.Write(header[:])
+		c.inbound.WriteString(message)
+	}
+}
+
+// Read reads the inbound messages.
+//
+// It returns io.EOF once they are over, or net.ErrClosed if the connection is closed.
+func (c *Conn) Read(b []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return b[:0], net.ErrClosed
+	}
+	if c.inbound.Len() == 0 {
+		return b[:0], io.EOF
+	}
+
+	n, _ := c.inbound.Read(b)
+
+	return b[:n], nil
+}
+
+// Write captures outbound messages (see Written).
+//
+// It returns net.ErrClosed if the connection is closed.
+func (c *Conn) Write(b []byte) (int, error) {
+	messages, err := c.write(b)
+	if err != nil {
+		return 0, err
+	}
+
+	if c.OnWrite != nil {
+		for _, message := range messages {
+			c.OnWrite(message)
+		}
+	}
+
+	return len(b), nil
+}
+
+// write captures written bytes and returns the messages they have completed.
+func (c *Conn) write(b []byte) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, net.ErrClosed
+	}
+
+	c.outbound.Write(b)
+
+	var messages []string
+	for c.outbound.Len() >= tcp.FrameHeaderSize {
+		size := int(binary.BigEndian.Uint32(c.outbound.Bytes()))
+		if c.outbound.Len() < tcp.FrameHeaderSize+size {
+			break
+		}
+
+		c.outbound.Next(tcp.FrameHeaderSize)
+		messages = append(messages, string(c.outbound.Next(size)))
+	}
+	c.written = append(c.written, messages...)
+
+	return messages, nil
+}
+
+// Written returns the messages written to the connection so far, in order.
+func (c *Conn) Written() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.written...)
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+
+	return nil
+}
+
+// Closed checks if the connection has been closed.
+func (c *Conn) Closed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.closed
+}
+
+// RemoteAddr returns the remote address of the connection, 127.0.0.1:4242 unless it's set with SetRemoteAddr.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.remote
+}
+
+// SetRemoteAddr sets the remote address of the connection.
+func (c *Conn) SetRemoteAddr(addr net.Addr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remote = addr
+}
+
+// SetDeadline records the deadline, it doesn't affect reads and writes.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadline = t
+
+	return nil
+}
+
+// Deadline returns the deadline last set with SetDeadline.
+func (c *Conn) Deadline() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.deadline
+}
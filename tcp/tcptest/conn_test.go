@@ -0,0 +1,56 @@
+package tcptest
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/laonix/pow-word-of-wisdom/tcp"
+)
+
+func TestConn(t *testing.T) {
+	conn := NewConn("ping", "pong")
+
+	for _, want := range []string{"ping", "pong"} {
+		message, err := tcp.ReadFrame(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, want, string(message))
+	}
+
+	// the script is over: the peer has gone
+	_, err := tcp.ReadFrame(conn)
+	assert.True(t, errors.Is(err, tcp.ErrConnClosed))
+
+	assert.Nil(t, tcp.WriteFrame(conn, []byte("first")))
+	assert.Nil(t, tcp.WriteFrame(conn, []byte("second")))
+	assert.Equal(t, []string{"first", "second"}, conn.Written())
+
+	assert.Nil(t, conn.Close())
+	assert.True(t, conn.Closed())
+
+	_, err = conn.Read(make([]byte, 1))
+	assert.True(t, errors.Is(err, net.ErrClosed))
+	_, err = conn.Write([]byte("late"))
+	assert.True(t, errors.Is(err, net.ErrClosed))
+}
+
+func TestConn_OnWrite(t *testing.T) {
+	conn := NewConn()
+	conn.OnWrite = func(message string) {
+		conn.Push("re: " + message)
+	}
+
+	// a message written in chunks is handled once it's complete
+	frame := []byte{0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'}
+	for _, chunk := range [][]byte{frame[:2], frame[2:6], frame[6:]} {
+		_, err := conn.Write(chunk)
+		assert.Nil(t, err)
+	}
+
+	message, err := tcp.ReadFrame(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, "re: hello", string(message))
+	assert.Equal(t, []string{"hello"}, conn.Written())
+}